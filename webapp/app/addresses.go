@@ -0,0 +1,92 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+)
+
+// addressFormFields are the names of the inputs of the address form.
+var addressFormFields = []string{"name", "line1", "line2", "city", "postal_code", "country"}
+
+// parseAddressForm reads the address form. Country codes are case
+// insensitive for users.
+func parseAddressForm(c *gin.Context) database.Address {
+	return database.Address{
+		UserID:     getUserID(),
+		Name:       strings.TrimSpace(c.PostForm("name")),
+		Line1:      strings.TrimSpace(c.PostForm("line1")),
+		Line2:      strings.TrimSpace(c.PostForm("line2")),
+		City:       strings.TrimSpace(c.PostForm("city")),
+		PostalCode: strings.TrimSpace(c.PostForm("postal_code")),
+		Country:    strings.ToUpper(strings.TrimSpace(c.PostForm("country"))),
+	}
+}
+
+// findAddress returns the saved address of the user by id.
+func findAddress(c *gin.Context, userID int, addressID string) (database.Address, bool, error) {
+	addresses, err := databaseHandler(c).GetAddresses(userID)
+	if err != nil {
+		return database.Address{}, false, err
+	}
+
+	for _, address := range addresses {
+		if address.ID == addressID {
+			return address, true, nil
+		}
+	}
+
+	return database.Address{}, false, nil
+}
+
+func renderAddresses(c *gin.Context, status int, form gin.H, fields map[string]string) {
+	addresses, err := databaseHandler(c).GetAddresses(getUserID())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.HTML(status, "addresses.html", gin.H{
+		"title":     "Addresses",
+		"addresses": addresses,
+		"form":      form,
+		"errors":    fields,
+		"csrfToken": csrfToken(c),
+	})
+}
+
+func getAddressesEndpoint(c *gin.Context) {
+	renderAddresses(c, http.StatusOK, gin.H{}, map[string]string{})
+}
+
+func postAddressesEndpoint(c *gin.Context) {
+	_, err := databaseHandler(c).CreateAddress(parseAddressForm(c))
+	if err == nil {
+		c.Redirect(http.StatusSeeOther, "/addresses")
+		return
+	}
+
+	var dbErr *database.Error
+	if !errors.As(err, &dbErr) || dbErr.Fields == nil || c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.Error(err)
+		return
+	}
+
+	form := gin.H{}
+	for _, name := range addressFormFields {
+		form[name] = c.PostForm(name)
+	}
+	renderAddresses(c, http.StatusBadRequest, form, dbErr.Fields)
+}
+
+func postAddressDeleteEndpoint(c *gin.Context) {
+	if err := databaseHandler(c).DeleteAddress(getUserID(), c.Param("address_id")); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/addresses")
+}
@@ -0,0 +1,44 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAddressesEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/addresses", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "1 Main St")
+	assert.Contains(t, w.Body.String(), `action="/addresses/address1/delete"`)
+}
+
+func TestPostAddressesEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	form := url.Values{"name": {"Office"}, "line1": {"2 Main St"}, "city": {"Springfield"}, "postal_code": {"12345"}, "country": {"us"}}
+
+	w := postForm(t, router, "/addresses", form, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/addresses", w.Header().Get("Location"))
+
+	form.Set("country", "USA")
+	w = postForm(t, router, "/addresses", form, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Country must be a two letter code like US.")
+	assert.Contains(t, w.Body.String(), `value="2 Main St"`)
+
+	w = postForm(t, router, "/addresses", form, "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "country")
+
+	w = postForm(t, router, "/addresses/address1/delete", url.Values{}, "")
+	assert.Equal(t, 303, w.Code)
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The admin credentials of ADMIN_USERNAME and ADMIN_PASSWORD. The admin
+// pages are open and /debug/vars is disabled while the password is empty.
+var (
+	adminUsername string
+	adminPassword string
+)
+
+// isAdmin reports whether the request has the admin credentials with HTTP
+// Basic authentication.
+func isAdmin(r *http.Request) bool {
+	if adminPassword == "" {
+		return false
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	// The hashes have the same length, so the comparisons take the same
+	// time whatever the lengths of the credentials.
+	usernameHash, wantUsernameHash := sha256.Sum256([]byte(username)), sha256.Sum256([]byte(adminUsername))
+	passwordHash, wantPasswordHash := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(adminPassword))
+	usernameOK := subtle.ConstantTimeCompare(usernameHash[:], wantUsernameHash[:]) == 1
+	passwordOK := subtle.ConstantTimeCompare(passwordHash[:], wantPasswordHash[:]) == 1
+
+	return usernameOK && passwordOK
+}
+
+// adminAuth answers the requests without the admin credentials with 401,
+// which makes browsers ask for them, or 403 while the admin pages are
+// disabled.
+func adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminPassword == "" {
+			c.Error(&httpError{status: http.StatusForbidden, message: "The admin pages are disabled. Set ADMIN_PASSWORD to enable them."})
+			c.Abort()
+			return
+		}
+
+		if !isAdmin(c.Request) {
+			c.Header("WWW-Authenticate", `Basic realm="scstore admin", charset="UTF-8"`)
+			c.Error(&httpError{status: http.StatusUnauthorized, message: "The admin credentials are required."})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// adminPagesAuth is adminAuth for the pages under /admin, which are open to
+// everyone while the password is empty, as the scoring server resets the
+// shop with POST /admin/init without credentials.
+func adminPagesAuth() gin.HandlerFunc {
+	auth := adminAuth()
+	return func(c *gin.Context) {
+		if adminPassword == "" {
+			c.Next()
+			return
+		}
+
+		auth(c)
+	}
+}
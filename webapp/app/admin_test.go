@@ -0,0 +1,86 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testAdminPassword is set as ADMIN_PASSWORD by TestMain.
+const testAdminPassword = "admin-secret"
+
+// newAdminRequest returns a request with the admin credentials.
+func newAdminRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth("admin", testAdminPassword)
+
+	return req, nil
+}
+
+// postAdminForm is postForm with the admin credentials.
+func postAdminForm(t *testing.T, router http.Handler, path string, values url.Values, accept string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := newAdminRequest("POST", path, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	get := func(router http.Handler, username, password string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/debug/vars", nil)
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Without a password, the counters are disabled while the admin pages
+	// are open to the scoring server, which sends no credentials.
+	t.Setenv("ADMIN_PASSWORD", "")
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	assert.Equal(t, 403, get(router, "admin", "").Code)
+	assert.Equal(t, 202, postForm(t, router, "/admin/init", url.Values{}, "").Code)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/admin/coupons", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	router = SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w = get(router, "", "")
+	assert.Equal(t, 401, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+	assert.NotContains(t, w.Body.String(), "in_flight_requests")
+	assert.Equal(t, 401, get(router, "admin", "wrong").Code)
+	assert.Equal(t, 401, get(router, "root", testAdminPassword).Code)
+
+	w = get(router, "admin", testAdminPassword)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "in_flight_requests")
+
+	// Every admin page requires them.
+	w = postForm(t, router, "/admin/init", url.Values{}, "")
+	assert.Equal(t, 401, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/coupons", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+	assert.NotContains(t, w.Body.String(), "WELCOME")
+}
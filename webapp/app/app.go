@@ -2,17 +2,29 @@ package app
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"expvar"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
 	"github.com/gin-gonic/gin"
 	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/mittz/role-play-webapp/webapp/notify"
+	"github.com/mittz/role-play-webapp/webapp/utils"
+	"github.com/mittz/role-play-webapp/webapp/webhook"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
@@ -25,8 +37,8 @@ func getUserID() int {
 }
 
 func postInitEndpoint(c *gin.Context) {
-	if err := dbHandler.InitDatabase(); err != nil {
-		c.String(http.StatusInternalServerError, "%v", err)
+	if err := databaseHandler(c).InitDatabase(); err != nil {
+		c.Error(err)
 		return
 	}
 
@@ -35,9 +47,9 @@ func postInitEndpoint(c *gin.Context) {
 
 func getCheckoutsEndpoint(c *gin.Context) {
 	userID := getUserID()
-	checkouts, err := dbHandler.GetCheckouts(userID)
+	checkouts, err := databaseHandler(c).GetCheckouts(userID)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "%v", err)
+		c.Error(err)
 		return
 	}
 
@@ -50,27 +62,75 @@ func getCheckoutsEndpoint(c *gin.Context) {
 func postCheckoutEndpoint(c *gin.Context) {
 	userID := getUserID()
 
-	productID, err := strconv.Atoi(c.PostForm("product_id"))
+	productID, productQuantity, fields := validateCheckoutForm(c.PostForm("product_id"), c.PostForm("product_quantity"))
+	if _, ok := fields["product_id"]; ok {
+		c.Error(database.NewFieldValidationError(fields))
+		return
+	}
+
+	product, err := databaseHandler(c).GetProduct(productID)
+	if errors.Is(err, database.ErrNotFound) {
+		fields["product_id"] = "The product does not exist."
+		c.Error(database.NewFieldValidationError(fields))
+		return
+	}
 	if err != nil {
-		c.String(http.StatusInternalServerError, "%v", err)
+		c.Error(err)
 		return
 	}
 
-	productQuantity, err := strconv.Atoi(c.PostForm("product_quantity"))
+	taxRegion := c.DefaultPostForm("tax_region", defaultTaxRegion)
+	taxRate, message := validateTaxRegion(taxRegion)
+	if message != "" {
+		fields["tax_region"] = message
+	}
+
+	address, shippingRate, err := checkoutShipping(c, userID, c.PostForm("address_id"), fields)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "%v", err)
+		c.Error(err)
 		return
 	}
 
-	checkoutID, err := dbHandler.CreateCheckout(userID, productID, productQuantity)
+	if len(fields) > 0 {
+		renderCheckoutFormErrors(c, product, fields)
+		return
+	}
+
+	order := database.CheckoutRequest{
+		UserID:          userID,
+		ProductID:       productID,
+		ProductQuantity: productQuantity,
+		VariantSKU:      strings.TrimSpace(c.PostForm("variant_sku")),
+		CouponCode:      normalizeCouponCode(c.PostForm("coupon_code")),
+		TaxRegion:       taxRegion,
+		TaxRate:         taxRate,
+		ShippingAddress: address,
+		ShippingRate:    shippingRate,
+	}
+	if checkoutAsync {
+		if enqueueCheckout(c, order) {
+			removeBoughtFromWishlist(c, userID, productID)
+		}
+		return
+	}
+
+	checkoutID, err := databaseHandler(c).CreateCheckout(order)
+	var dbErr *database.Error
+	if errors.As(err, &dbErr) && dbErr.Fields != nil {
+		renderCheckoutFormErrors(c, product, dbErr.Fields)
+		return
+	}
 	if err != nil {
-		c.String(http.StatusInternalServerError, "%v", err)
+		c.Error(err)
 		return
 	}
 
-	checkout, err := dbHandler.GetCheckout(checkoutID)
+	rememberUserWrite(c, userID)
+	removeBoughtFromWishlist(c, userID, productID)
+
+	checkout, err := processCheckout(c.Request.Context(), databaseHandler(c), requestLoggerFrom(c), checkoutID)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "%v", err)
+		c.Error(err)
 		return
 	}
 
@@ -80,40 +140,221 @@ func postCheckoutEndpoint(c *gin.Context) {
 	})
 }
 
+// renderCheckoutFormErrors shows the form again with the messages next to
+// the fields. JSON clients get the messages in the error body.
+func renderCheckoutFormErrors(c *gin.Context, product database.Product, fields map[string]string) {
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.Error(database.NewFieldValidationError(fields))
+		return
+	}
+
+	renderProduct(c, http.StatusBadRequest, product, fields)
+}
+
+// renderProduct shows the product page. The checkout and review forms are
+// filled with the posted values when they are shown again with errors.
+func renderProduct(c *gin.Context, status int, product database.Product, fields map[string]string) {
+	addresses, err := databaseHandler(c).GetAddresses(getUserID())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	reviews, err := databaseHandler(c).GetProductReviews(product.ID, productReviewsLimit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	saved, err := onWishlist(c, getUserID(), product.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	variants, err := databaseHandler(c).GetVariants(product.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// The page shows the price and the image of the selected variant.
+	variant, ok := selectVariant(variants, c.DefaultPostForm("variant_sku", c.Query("variant")))
+	if ok {
+		product.Price = variant.Price
+		product.Image = variant.Image
+	}
+
+	c.HTML(status, "product.html", gin.H{
+		"title":           "Product",
+		"product":         product,
+		"variants":        variants,
+		"variant":         variant,
+		"maxQuantity":     checkoutMaxQuantity,
+		"productQuantity": c.PostForm("product_quantity"),
+		"couponCode":      c.PostForm("coupon_code"),
+		"addresses":       addresses,
+		"addressID":       c.PostForm("address_id"),
+		"taxRegions":      taxRegions(),
+		"taxRegion":       c.DefaultPostForm("tax_region", defaultTaxRegion),
+		"displayCurrency": displayCurrency(c),
+		"currencies":      currencyRates.Currencies(),
+		"reviews":         reviews,
+		"onWishlist":      saved,
+		"recommendations": productRecommendations(product.ID),
+		"reviewRating":    c.PostForm("rating"),
+		"reviewBody":      c.PostForm("body"),
+		"errors":          fields,
+		"csrfToken":       csrfToken(c),
+	})
+}
+
 func getProductEndpoint(c *gin.Context) {
 	productID, err := strconv.Atoi(c.Param("product_id"))
 	if err != nil {
-		c.String(http.StatusInternalServerError, "%v", err)
+		c.Error(database.NewValidationError("product_id must be an integer"))
 		return
 	}
 
-	product, err := dbHandler.GetProduct(productID)
+	product, err := databaseHandler(c).GetProduct(productID)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "%v", err)
+		c.Error(err)
 		return
 	}
 
-	c.HTML(http.StatusOK, "product.html", gin.H{
-		"title":   "Product",
-		"product": product,
-	})
+	renderProduct(c, http.StatusOK, product, map[string]string{})
 }
 
 func getProductsEndpoint(c *gin.Context) {
-	products, err := dbHandler.GetProducts()
+	products, err := databaseHandler(c).GetProducts()
 	if err != nil {
-		c.String(http.StatusInternalServerError, "%v", err)
+		c.Error(err)
 		return
 	}
 
+	order := c.DefaultQuery("sort", productSortFeatured)
+	sortProducts(products, order)
+
 	c.HTML(http.StatusOK, "products.html", gin.H{
-		"title":    "Products",
-		"products": products,
+		"title":           "Products",
+		"products":        products,
+		"sort":            order,
+		"bestSellers":     popularProducts(),
+		"displayCurrency": displayCurrency(c),
+		"currencies":      currencyRates.Currencies(),
 	})
 }
 
+// csrfSecret returns CSRF_SECRET or a random key. With a random key the
+// tokens are invalidated by a restart and not shared between instances.
+func csrfSecret() []byte {
+	if secret := utils.GetEnvCSRFSecret(); secret != "" {
+		return []byte(secret)
+	}
+
+	log.Printf("CSRF_SECRET is not set, using a random key")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Fatalf("Failed to generate CSRF secret: %v", err)
+	}
+
+	return secret
+}
+
+// CheckConfig validates the store configuration of the environment, so that
+// a mistake is found before the app is deployed.
+func CheckConfig() error {
+	_, err := loadConfig()
+	return err
+}
+
+// loadConfig sets the currency, tax, shipping, payment and email
+// configuration from the environment and returns the mailer it configures.
+func loadConfig() (notify.Mailer, error) {
+	// The user ID is the same for every client until the users sign in, so
+	// they would all share one bucket.
+	if key := utils.GetEnvRateLimitKey(); key != "ip" {
+		return nil, fmt.Errorf("RATE_LIMIT_KEY %s is not supported, only ip is", key)
+	}
+	for _, proxy := range utils.GetEnvTrustedProxies() {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES has %s which is not an IP address or a CIDR", proxy)
+		}
+	}
+
+	storeCurrency = utils.GetEnvStoreCurrency()
+	if !money.IsSupported(storeCurrency) {
+		return nil, fmt.Errorf("STORE_CURRENCY %s is not supported", storeCurrency)
+	}
+	currencyRates = money.Rates{Base: storeCurrency, Rates: utils.GetEnvCurrencyRates()}
+	for currency := range currencyRates.Rates {
+		if !money.IsSupported(currency) {
+			return nil, fmt.Errorf("CURRENCY_RATES has %s which is not supported", currency)
+		}
+	}
+	taxRates = utils.GetEnvTaxRates()
+	defaultTaxRegion = utils.GetEnvDefaultTaxRegion()
+	if _, ok := taxRates[defaultTaxRegion]; defaultTaxRegion != "" && !ok {
+		return nil, fmt.Errorf("DEFAULT_TAX_REGION %s is not in TAX_RATES", defaultTaxRegion)
+	}
+
+	rates, err := parseShippingRates(utils.GetEnvShippingRates(), storeCurrency)
+	if err != nil {
+		return nil, fmt.Errorf("SHIPPING_RATES is invalid: %w", err)
+	}
+	shippingRates = rates
+
+	gateway, err := newPaymentGateway(utils.GetEnvPaymentProvider(), utils.GetEnvPaymentFakeMode(), utils.GetEnvPaymentWebhookSecret())
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up the payment provider: %w", err)
+	}
+	paymentGateway = gateway
+
+	templates, err := parseEmailTemplates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the email templates: %w", err)
+	}
+	emailTemplates = templates
+	smtpMailer := notify.SMTPMailer{Addr: utils.GetEnvSMTPAddr(), Username: utils.GetEnvSMTPUsername(), Password: utils.GetEnvSMTPPassword()}
+	mailer, err := newMailer(utils.GetEnvMailer(), utils.GetEnvMailFrom(), utils.GetEnvMailFilePath(), smtpMailer, slog.Default())
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up the mailer: %w", err)
+	}
+
+	return mailer, nil
+}
+
 func SetupRouter(dbh database.DatabaseHandler, assetsDir string, templatesDirMatch string) *gin.Engine {
 	dbHandler = dbh
+	checkoutMaxQuantity = utils.GetEnvCheckoutMaxQuantity()
+	dbSlowQueryThreshold = utils.GetEnvDBSlowQueryThreshold()
+	readYourWritesWindow = utils.GetEnvDBReplicaReadYourWrites()
+	webhookGuard = webhook.NewGuard(utils.GetEnvWebhookAllowedHosts())
+	adminUsername = utils.GetEnvAdminUsername()
+	adminPassword = utils.GetEnvAdminPassword()
+	if adminPassword == "" {
+		log.Printf("ADMIN_PASSWORD is not set, the admin pages are open to everyone and /debug/vars is disabled")
+	}
+
+	mailer, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	checkoutAsync = utils.GetEnvCheckoutAsync()
+	checkoutQueueSize = utils.GetEnvCheckoutQueueSize()
+	checkoutEventsKeepAliveInterval = utils.GetEnvCheckoutEventsKeepAlive()
+	checkoutEventsMaxStreams = int64(utils.GetEnvCheckoutEventsMaxStreams())
+	recommendations = newRecommendations(dbh)
+	paymentTimeout = utils.GetEnvPaymentTimeout()
+
+	notifier = notify.NewNotifier(mailer, notify.Options{
+		Workers:     utils.GetEnvMailWorkers(),
+		QueueSize:   utils.GetEnvMailQueueSize(),
+		MaxAttempts: utils.GetEnvMailMaxAttempts(),
+		Backoff:     utils.GetEnvMailRetryBackoff(),
+		Timeout:     utils.GetEnvMailTimeout(),
+	}, slog.Default())
 
 	// Create exporter.
 	ctx := context.Background()
@@ -134,21 +375,89 @@ func SetupRouter(dbh database.DatabaseHandler, assetsDir string, templatesDirMat
 	defer tp.ForceFlush(ctx) // flushes any pending spans
 	otel.SetTracerProvider(tp)
 
-	router := gin.Default()
+	// Accept the trace context of the caller so that the logs and traces of
+	// a request can be matched with the caller's.
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	router := gin.New()
+	// Without trusted proxies, X-Forwarded-For is ignored and the client IP
+	// of the rate limits and the logs is the address of the connection.
+	if err := router.SetTrustedProxies(utils.GetEnvTrustedProxies()); err != nil {
+		log.Fatalf("router.SetTrustedProxies: %v", err)
+	}
 	router.Use(otelgin.Middleware("scstore"))
+	router.Use(requestLogger())
+	router.Use(gin.Recovery())
+	router.Use(errorHandler())
+	router.Use(loadShedder(int64(utils.GetEnvMaxInFlightRequests()), checkoutEventsPath, userCheckoutEventsPath))
+	router.Use(securityHeaders(securityHeadersConfig{
+		ContentSecurityPolicy: utils.GetEnvContentSecurityPolicy(),
+		FrameOptions:          utils.GetEnvFrameOptions(),
+		HSTSMaxAge:            utils.GetEnvHSTSMaxAge(),
+	}))
+	router.Use(rateLimiter(rateLimitConfig{
+		Routes: map[string]rateLimit{
+			"POST /checkout":   {RPS: utils.GetEnvRateLimitCheckoutRPS(), Burst: utils.GetEnvRateLimitCheckoutBurst()},
+			"POST /admin/init": {RPS: utils.GetEnvRateLimitAdminRPS(), Burst: utils.GetEnvRateLimitAdminBurst()},
+		},
+		Default: rateLimit{RPS: utils.GetEnvRateLimitRPS(), Burst: utils.GetEnvRateLimitBurst()},
+	}))
+	// The webhooks of the payment provider are authenticated by their signature.
+	router.Use(csrfProtection(csrfSecret(), utils.GetEnvCSRFStrict(), paymentWebhookPath))
+	router.Use(readYourWrites())
 
 	router.Static("/assets", assetsDir)
 	router.StaticFile("/favicon.ico", filepath.Join(assetsDir, "favicon.ico"))
+	router.SetFuncMap(templateFuncs())
 	router.LoadHTMLGlob(templatesDirMatch)
 
 	router.GET("/", getProductsEndpoint)
 
-	router.POST("/admin/init", postInitEndpoint)
+	// The admin pages change the shop and show the orders of every customer.
+	admin := router.Group("/admin", adminPagesAuth())
+	admin.POST("/init", postInitEndpoint)
+	admin.GET("/coupons", getAdminCouponsEndpoint)
+	admin.POST("/coupons", postAdminCouponsEndpoint)
+	admin.POST("/coupons/:code/deactivate", postAdminCouponDeactivateEndpoint)
+	admin.GET("/webhooks", getAdminWebhooksEndpoint)
+	admin.POST("/webhooks", postAdminWebhooksEndpoint)
+	admin.POST("/webhooks/:webhook_id/deactivate", postAdminWebhookDeactivateEndpoint)
+	admin.GET("/webhooks/:webhook_id/deliveries", getAdminWebhookDeliveriesEndpoint)
+	admin.GET("/checkouts.csv", getAdminCheckoutsExportEndpoint)
+	admin.GET("/checkouts.ndjson", getAdminCheckoutsExportEndpoint)
+	admin.POST("/checkouts/:checkout_id/refund", postAdminRefundEndpoint)
+	admin.GET("/products", getAdminProductsEndpoint)
+	admin.POST("/products/:product_id/price", postAdminProductPriceEndpoint)
+	admin.GET("/products/:product_id/variants", getAdminVariantsEndpoint)
+	admin.POST("/products/:product_id/variants", postAdminVariantsEndpoint)
+	admin.POST("/products/:product_id/variants/:sku/stock", postAdminVariantStockEndpoint)
+	admin.GET("/sales", getAdminSalesEndpoint)
+	admin.GET("/sales.csv", getAdminSalesCSVEndpoint)
+	admin.GET("/reviews", getAdminReviewsEndpoint)
+	admin.POST("/reviews/:review_id/:action", postAdminReviewStatusEndpoint)
 
 	router.GET("/product/:product_id", getProductEndpoint)
+	router.POST("/product/:product_id/reviews", postProductReviewEndpoint)
 	router.GET("/products", getProductsEndpoint)
 	router.GET("/checkouts", getCheckoutsEndpoint)
+	router.GET("/checkouts.csv", getCheckoutsExportEndpoint)
+	router.GET("/checkouts.ndjson", getCheckoutsExportEndpoint)
 	router.POST("/checkout", postCheckoutEndpoint)
+	router.GET("/checkout/jobs/:job_id", getCheckoutJobEndpoint)
+	router.GET(userCheckoutEventsPath, getUserCheckoutEventsEndpoint)
+	router.GET(checkoutEventsPath, getCheckoutEventsEndpoint)
+	router.GET("/addresses", getAddressesEndpoint)
+	router.POST("/addresses", postAddressesEndpoint)
+	router.POST("/addresses/:address_id/delete", postAddressDeleteEndpoint)
+	router.GET("/wishlist", getWishlistEndpoint)
+	router.POST("/wishlist", postWishlistEndpoint)
+	router.POST("/wishlist/:product_id/delete", postWishlistDeleteEndpoint)
+	router.POST(paymentWebhookPath, postPaymentWebhookEndpoint)
+
+	// The counters show the internals of the app, like the database pool.
+	router.GET("/debug/vars", adminAuth(), gin.WrapH(expvar.Handler()))
+
+	router.NoRoute(noRouteEndpoint)
 
 	return router
 }
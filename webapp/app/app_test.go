@@ -82,6 +82,28 @@ func TestPostCheckoutEndpoint(t *testing.T) {
 	assert.Contains(t, w.Body.String(), productQuantity)
 }
 
+func TestCheckConfig(t *testing.T) {
+	assert.Nil(t, CheckConfig())
+
+	for _, tt := range []struct {
+		key   string
+		value string
+		err   string
+	}{
+		{"STORE_CURRENCY", "XYZ", "STORE_CURRENCY XYZ is not supported"},
+		{"DEFAULT_TAX_REGION", "US-CA", "DEFAULT_TAX_REGION US-CA is not in TAX_RATES"},
+		{"SHIPPING_RATES", "US=free", "SHIPPING_RATES is invalid"},
+		{"PAYMENT_PROVIDER", "cash", "payment provider cash is not supported"},
+		{"MAILER", "pigeon", "mailer pigeon is not supported"},
+		{"RATE_LIMIT_KEY", "user", "RATE_LIMIT_KEY user is not supported"},
+		{"TRUSTED_PROXIES", "10.0.0.0/8,lb", "TRUSTED_PROXIES has lb which is not an IP address or a CIDR"},
+	} {
+		os.Setenv(tt.key, tt.value)
+		assert.ErrorContains(t, CheckConfig(), tt.err, tt.key)
+		os.Unsetenv(tt.key)
+	}
+}
+
 func TestMain(m *testing.M) {
 	conn, err := database.InitializeDevDBConn()
 	if err != nil {
@@ -94,6 +116,7 @@ func TestMain(m *testing.M) {
 	}
 
 	dbDevHandler = dbHandler
+	os.Setenv("ADMIN_PASSWORD", testAdminPassword)
 
 	os.Exit(m.Run())
 }
@@ -0,0 +1,43 @@
+// Keeps the payment status of the orders on the page up to date with the
+// Server-Sent Events of the shop. The checkout page follows its order and
+// the order history follows every order of the user. EventSource reconnects
+// by itself when the stream ends.
+(function () {
+    'use strict';
+
+    function paymentText(update) {
+        return update.payment_status + (update.payment_error ? ' (' + update.payment_error + ')' : '');
+    }
+
+    var card = document.querySelector('[data-checkout-events]');
+    if (card) {
+        var checkoutEvents = new EventSource(card.getAttribute('data-checkout-events'));
+        checkoutEvents.addEventListener('checkout', function (event) {
+            var update = JSON.parse(event.data);
+            card.querySelector('.checkout-payment').textContent = ' Payment: ' + update.payment_status + ' ';
+        });
+    }
+
+    var table = document.querySelector('[data-checkouts-events]');
+    if (table) {
+        var userEvents = new EventSource(table.getAttribute('data-checkouts-events'));
+        var opened = false;
+        userEvents.addEventListener('open', function () {
+            // Changes may have been missed while the stream was closed.
+            if (opened) {
+                window.location.reload();
+            }
+            opened = true;
+        });
+        userEvents.addEventListener('checkout', function (event) {
+            var update = JSON.parse(event.data);
+            var row = table.querySelector('tr[data-checkout-id="' + CSS.escape(update.checkout_id) + '"]');
+            // A new order is shown by loading the page again.
+            if (!row) {
+                window.location.reload();
+                return;
+            }
+            row.querySelector('.checkout_payment').textContent = paymentText(update);
+        });
+    }
+})();
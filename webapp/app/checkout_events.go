@@ -0,0 +1,116 @@
+package app
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/pubsub"
+)
+
+// The Server-Sent Events streams of the order updates. They stay open while
+// the page is shown, so they are not counted by the load shedder and are
+// limited by checkoutEventsMaxStreams instead.
+const (
+	checkoutEventsPath      = "/events/checkouts/:checkout_id"
+	userCheckoutEventsPath  = "/events/checkouts"
+	checkoutEventName       = "checkout"
+	checkoutEventsKeepAlive = ": keep-alive\n\n"
+)
+
+// checkoutEventStreams is the number of open streams published on /debug/vars.
+var checkoutEventStreams = expvar.NewInt("checkout_event_streams")
+
+var (
+	checkoutEventsKeepAliveInterval time.Duration
+	checkoutEventsMaxStreams        int64
+	openCheckoutEventStreams        int64
+)
+
+// getCheckoutEventsEndpoint sends the current state of an order of the user
+// and then every change of it.
+func getCheckoutEventsEndpoint(c *gin.Context) {
+	checkoutID := c.Param("checkout_id")
+
+	// Subscribe before reading the order so that no change is missed in between.
+	sub := database.SubscribeCheckout(checkoutID)
+	defer sub.Close()
+
+	checkout, err := databaseHandler(c).GetCheckout(checkoutID)
+	if err == nil && checkout.User.ID != getUserID() {
+		err = database.NewNotFoundError("checkout", checkoutID)
+	}
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	current := database.CheckoutUpdate{
+		CheckoutID:    checkout.ID,
+		UserID:        checkout.User.ID,
+		PaymentStatus: checkout.Payment.Status,
+		PaymentError:  checkout.Payment.Error,
+	}
+	streamCheckoutUpdates(c, sub, current)
+}
+
+// getUserCheckoutEventsEndpoint sends the changes of every order of the user
+// for the order history. New orders are sent as well.
+func getUserCheckoutEventsEndpoint(c *gin.Context) {
+	sub := database.SubscribeUserCheckouts(getUserID())
+	defer sub.Close()
+
+	streamCheckoutUpdates(c, sub)
+}
+
+// streamCheckoutUpdates sends the current updates and then the updates of
+// the subscription until the client goes away. The stream ends when the
+// subscription is dropped for falling behind; the browser then reconnects
+// and gets the current state again.
+func streamCheckoutUpdates(c *gin.Context, sub *pubsub.Subscription[database.CheckoutUpdate], current ...database.CheckoutUpdate) {
+	if atomic.AddInt64(&openCheckoutEventStreams, 1) > checkoutEventsMaxStreams {
+		atomic.AddInt64(&openCheckoutEventStreams, -1)
+		rejectedRequests.Add("checkout_event_streams", 1)
+		c.Header("Retry-After", "5")
+		c.Error(&httpError{status: http.StatusServiceUnavailable, message: "Too many pages are following orders. Please try again in a moment."})
+		return
+	}
+	checkoutEventStreams.Add(1)
+	defer func() {
+		atomic.AddInt64(&openCheckoutEventStreams, -1)
+		checkoutEventStreams.Add(-1)
+	}()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// Proxies must not buffer the stream.
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for _, update := range current {
+		c.SSEvent(checkoutEventName, update)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(checkoutEventsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case update, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			c.SSEvent(checkoutEventName, update)
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, checkoutEventsKeepAlive)
+		}
+		c.Writer.Flush()
+	}
+}
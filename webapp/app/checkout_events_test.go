@@ -0,0 +1,103 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// readEvent returns the next event or comment of the stream without the
+// blank line which ends it.
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\n" {
+			return strings.Join(lines, "")
+		}
+		lines = append(lines, line)
+	}
+}
+
+func openEventStream(t *testing.T, ctx context.Context, url string) *http.Response {
+	t.Helper()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func TestGetCheckoutEventsEndpoint(t *testing.T) {
+	server := httptest.NewServer(SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp := openEventStream(t, ctx, server.URL+"/events/checkouts/checkout1")
+	defer resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+
+	// The current state comes first and the changes follow.
+	r := bufio.NewReader(resp.Body)
+	assert.Equal(t, "event:checkout\ndata:{\"checkout_id\":\"checkout1\",\"user_id\":2,\"payment_status\":\"paid\"}\n", readEvent(t, r))
+
+	assert.Nil(t, dbDevHandler.UpdateCheckoutPayment("checkout1", database.Payment{Status: database.PaymentRefunded}))
+	assert.Equal(t, "event:checkout\ndata:{\"checkout_id\":\"checkout1\",\"user_id\":2,\"payment_status\":\"refunded\"}\n", readEvent(t, r))
+}
+
+func TestGetUserCheckoutEventsEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	checkoutEventsKeepAliveInterval = 10 * time.Millisecond
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp := openEventStream(t, ctx, server.URL+"/events/checkouts")
+	defer resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Idle streams get comments so that proxies keep them open.
+	r := bufio.NewReader(resp.Body)
+	assert.Equal(t, ": keep-alive\n", readEvent(t, r))
+
+	assert.Nil(t, dbDevHandler.UpdateCheckoutPayment("checkout2", database.Payment{Status: database.PaymentFailed, Error: "payment declined"}))
+	event := readEvent(t, r)
+	for event == ": keep-alive\n" {
+		event = readEvent(t, r)
+	}
+	assert.Equal(t, "event:checkout\ndata:{\"checkout_id\":\"checkout2\",\"user_id\":2,\"payment_status\":\"failed\",\"payment_error\":\"payment declined\"}\n", event)
+}
+
+func TestGetCheckoutEventsEndpointMaxStreams(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	checkoutEventsMaxStreams = 0
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/events/checkouts", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+}
@@ -0,0 +1,246 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/utils"
+)
+
+// checkoutPendingRefreshSeconds is how often the pending page reloads
+// itself until the order is processed.
+const checkoutPendingRefreshSeconds = 2
+
+// Gauges and counters of the checkout queue published on /debug/vars.
+var (
+	checkoutQueueDepth = expvar.NewInt("checkout_queue_depth")
+	checkoutJobs       = expvar.NewMap("checkout_jobs")
+)
+
+var (
+	checkoutAsync     bool
+	checkoutQueueSize int
+)
+
+// checkoutWorkerOptions are the settings of the workers of the queue.
+type checkoutWorkerOptions struct {
+	pollInterval time.Duration
+	timeout      time.Duration
+	maxAttempts  int
+}
+
+// StartCheckoutWorkers processes the checkout queue in the background until
+// the app stops. It does nothing unless CHECKOUT_ASYNC is set.
+func StartCheckoutWorkers(dbh database.DatabaseHandler) {
+	if !checkoutAsync {
+		return
+	}
+
+	options := checkoutWorkerOptions{
+		pollInterval: utils.GetEnvCheckoutPollInterval(),
+		timeout:      utils.GetEnvCheckoutJobTimeout(),
+		maxAttempts:  utils.GetEnvCheckoutMaxAttempts(),
+	}
+	for i := 0; i < utils.GetEnvCheckoutWorkers(); i++ {
+		go runCheckoutWorker(context.Background(), dbh, options, slog.Default())
+	}
+	go monitorCheckoutQueue(context.Background(), dbh, options.pollInterval, slog.Default())
+}
+
+func runCheckoutWorker(ctx context.Context, dbh database.DatabaseHandler, options checkoutWorkerOptions, logger *slog.Logger) {
+	for {
+		processed, err := processNextCheckoutJob(ctx, dbh, options, logger)
+		if err != nil {
+			logger.Error("checkout queue failed", "error", err.Error())
+		}
+
+		// A worker which found a job looks for the next one right away.
+		wait := options.pollInterval
+		if processed {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// monitorCheckoutQueue keeps checkout_queue_depth up to date.
+func monitorCheckoutQueue(ctx context.Context, dbh database.DatabaseHandler, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		depth, err := dbh.GetCheckoutQueueDepth()
+		if err != nil {
+			logger.Error("checkout queue depth failed", "error", err.Error())
+		} else {
+			checkoutQueueDepth.Set(int64(depth))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNextCheckoutJob claims a job of the queue and processes it. It
+// reports whether there was a job.
+func processNextCheckoutJob(ctx context.Context, dbh database.DatabaseHandler, options checkoutWorkerOptions, logger *slog.Logger) (bool, error) {
+	jobs, err := dbh.ClaimCheckoutJobs(time.Now(), 2*options.timeout, 1)
+	if err != nil || len(jobs) == 0 {
+		return false, err
+	}
+	job := jobs[0]
+	logger = logger.With("job_id", job.ID, "attempt", job.Attempts)
+
+	// A job which stopped its workers every time is given up.
+	if job.Attempts > options.maxAttempts {
+		logger.Error("checkout job gave up")
+		checkoutJobs.Add(database.CheckoutJobFailed, 1)
+		return true, dbh.FinishCheckoutJob(job.ID, database.CheckoutJobFailed, "Something went wrong. Please try again later.")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, options.timeout)
+	defer cancel()
+
+	status, message, err := runCheckoutJob(ctx, dbh, logger, job)
+	if err != nil && job.Attempts < options.maxAttempts {
+		// The job is left to be claimed again when its lease ends.
+		checkoutJobs.Add("retried", 1)
+		logger.Warn("checkout job failed, retrying", "error", err.Error())
+		return true, nil
+	}
+	if err != nil {
+		logger.Error("checkout job failed", "error", err.Error())
+		status, message = database.CheckoutJobFailed, "Something went wrong. Please try again later."
+	}
+
+	checkoutJobs.Add(status, 1)
+	return true, dbh.FinishCheckoutJob(job.ID, status, message)
+}
+
+// runCheckoutJob creates the order of the job and charges it like the
+// synchronous checkout. It returns the final status of the job with the
+// message for the customer, or an error when the job should be tried again.
+func runCheckoutJob(ctx context.Context, dbh database.DatabaseHandler, logger *slog.Logger, job database.CheckoutJob) (string, string, error) {
+	// A job tried again may have created its order already.
+	_, err := dbh.CreateCheckout(job.Request)
+	var dbErr *database.Error
+	switch {
+	case err == nil, errors.Is(err, database.ErrConflict):
+	case errors.Is(err, database.ErrValidation) && errors.As(err, &dbErr):
+		return database.CheckoutJobFailed, validationMessage(dbErr), nil
+	default:
+		return "", "", err
+	}
+
+	_, err = processCheckout(ctx, dbh, logger, job.ID)
+	var httpErr *httpError
+	switch {
+	case err == nil:
+		return database.CheckoutJobDone, "", nil
+	case errors.As(err, &httpErr):
+		return database.CheckoutJobFailed, httpErr.message, nil
+	default:
+		return "", "", err
+	}
+}
+
+// validationMessage joins the messages of the fields in a stable order.
+func validationMessage(dbErr *database.Error) string {
+	if len(dbErr.Fields) == 0 {
+		return dbErr.Message
+	}
+
+	names := make([]string, 0, len(dbErr.Fields))
+	for name := range dbErr.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	messages := make([]string, 0, len(names))
+	for _, name := range names {
+		messages = append(messages, dbErr.Fields[name])
+	}
+
+	return strings.Join(messages, " ")
+}
+
+// enqueueCheckout accepts the order into the queue and shows the pending
+// page. A full queue is answered like a busy shop. It reports whether the
+// order was accepted.
+func enqueueCheckout(c *gin.Context, order database.CheckoutRequest) bool {
+	jobID, err := databaseHandler(c).EnqueueCheckout(order, checkoutQueueSize)
+	if errors.Is(err, database.ErrQueueFull) {
+		rejectedRequests.Add("checkout_queue_full", 1)
+		c.Header("Retry-After", "5")
+		c.Error(&httpError{status: http.StatusServiceUnavailable, message: "The shop is busy. Please try again in a moment."})
+		return false
+	}
+	if err != nil {
+		c.Error(err)
+		return false
+	}
+	checkoutJobs.Add("queued", 1)
+	rememberUserWrite(c, order.UserID)
+
+	renderCheckoutPending(c, http.StatusAccepted, jobID)
+	return true
+}
+
+func renderCheckoutPending(c *gin.Context, status int, jobID string) {
+	c.HTML(status, "checkout_pending.html", gin.H{
+		"title":          "Checkout",
+		"jobID":          jobID,
+		"refreshSeconds": checkoutPendingRefreshSeconds,
+	})
+}
+
+// getCheckoutJobEndpoint shows the pending page until the order of the job
+// is processed, and then the order like the synchronous checkout does.
+func getCheckoutJobEndpoint(c *gin.Context) {
+	jobID := c.Param("job_id")
+
+	job, err := databaseHandler(c).GetCheckoutJob(jobID)
+	if err == nil && job.Request.UserID != getUserID() {
+		err = database.NewNotFoundError("checkout job", jobID)
+	}
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	switch {
+	case job.Pending():
+		renderCheckoutPending(c, http.StatusOK, job.ID)
+	case job.Status == database.CheckoutJobFailed:
+		c.Error(&httpError{status: http.StatusUnprocessableEntity, message: "Your order could not be placed. " + job.Error})
+	default:
+		// The order may have been written by the worker of another instance.
+		rememberUserWrite(c, job.Request.UserID)
+		checkout, err := databaseHandler(c).GetCheckout(job.ID)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		c.HTML(http.StatusOK, "checkout.html", gin.H{
+			"title":    "Checkout",
+			"checkout": checkout,
+		})
+	}
+}
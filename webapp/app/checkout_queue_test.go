@@ -0,0 +1,167 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// queueDatabaseHandler is a checkout queue with a single job whose
+// processing fails with createErr.
+type queueDatabaseHandler struct {
+	database.DevDatabaseHandler
+	state *queueState
+}
+
+type queueState struct {
+	full      bool
+	enqueued  []database.CheckoutRequest
+	job       database.CheckoutJob
+	createErr error
+	finished  map[string]string
+	messages  map[string]string
+}
+
+func newQueueDatabaseHandler() queueDatabaseHandler {
+	return queueDatabaseHandler{state: &queueState{finished: make(map[string]string), messages: make(map[string]string)}}
+}
+
+func (dbh queueDatabaseHandler) EnqueueCheckout(order database.CheckoutRequest, maxDepth int) (string, error) {
+	if dbh.state.full {
+		return "", database.NewQueueFullError("checkout queue has 1000 jobs waiting")
+	}
+	dbh.state.enqueued = append(dbh.state.enqueued, order)
+
+	return "job1", nil
+}
+
+func (dbh queueDatabaseHandler) GetCheckoutJob(jobID string) (database.CheckoutJob, error) {
+	job := database.CheckoutJob{ID: jobID, Request: database.CheckoutRequest{ID: jobID, UserID: 2, ProductID: 1, ProductQuantity: 1}}
+	switch jobID {
+	case "queued-job":
+		job.Status = database.CheckoutJobQueued
+	case "done-job":
+		job.Status = database.CheckoutJobDone
+	case "failed-job":
+		job.Status, job.Error = database.CheckoutJobFailed, "The coupon code is not valid."
+	case "other-users-job":
+		job.Status, job.Request.UserID = database.CheckoutJobDone, 1
+	default:
+		return job, database.NewNotFoundError("checkout job", jobID)
+	}
+
+	return job, nil
+}
+
+func (dbh queueDatabaseHandler) ClaimCheckoutJobs(now time.Time, lease time.Duration, limit int) ([]database.CheckoutJob, error) {
+	return []database.CheckoutJob{dbh.state.job}, nil
+}
+
+func (dbh queueDatabaseHandler) CreateCheckout(order database.CheckoutRequest) (string, error) {
+	return order.ID, dbh.state.createErr
+}
+
+func (dbh queueDatabaseHandler) FinishCheckoutJob(jobID string, status string, message string) error {
+	dbh.state.finished[jobID] = status
+	dbh.state.messages[jobID] = message
+
+	return nil
+}
+
+func TestPostCheckoutEndpointAsync(t *testing.T) {
+	os.Setenv("CHECKOUT_ASYNC", "true")
+	defer os.Unsetenv("CHECKOUT_ASYNC")
+
+	dbh := newQueueDatabaseHandler()
+	router := SetupRouter(dbh, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"2"}}, "")
+	assert.Equal(t, 202, w.Code)
+	assert.Contains(t, w.Body.String(), `<meta http-equiv="refresh" content="2; url=/checkout/jobs/job1">`)
+	assert.Equal(t, 1, len(dbh.state.enqueued))
+	assert.Equal(t, 2, dbh.state.enqueued[0].ProductQuantity)
+
+	// The form is still validated during the request.
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"0"}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, 1, len(dbh.state.enqueued))
+
+	dbh.state.full = true
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"2"}}, "")
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+}
+
+func TestGetCheckoutJobEndpoint(t *testing.T) {
+	router := SetupRouter(newQueueDatabaseHandler(), testAssetsDir, testTemplatesDirMatch)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/checkout/jobs/queued-job")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `url=/checkout/jobs/queued-job`)
+
+	w = get("/checkout/jobs/done-job")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `<p class="card-text"> 111 x product1 </p>`)
+	assert.NotContains(t, w.Body.String(), `http-equiv="refresh"`)
+
+	w = get("/checkout/jobs/failed-job")
+	assert.Equal(t, 422, w.Code)
+	assert.Contains(t, w.Body.String(), "Your order could not be placed. The coupon code is not valid.")
+
+	assert.Equal(t, 404, get("/checkout/jobs/other-users-job").Code)
+	assert.Equal(t, 404, get("/checkout/jobs/unknown").Code)
+}
+
+func TestProcessNextCheckoutJob(t *testing.T) {
+	SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	options := checkoutWorkerOptions{pollInterval: time.Millisecond, timeout: time.Second, maxAttempts: 3}
+	ctx := context.Background()
+
+	dbh := newQueueDatabaseHandler()
+	job := database.CheckoutJob{ID: "job1", Request: database.CheckoutRequest{ID: "job1", UserID: 2, ProductID: 1, ProductQuantity: 1}, Attempts: 1}
+
+	// The order of a job tried again may exist already.
+	dbh.state.job, dbh.state.createErr = job, database.NewConflictError("checkout job1 already exists")
+	processed, err := processNextCheckoutJob(ctx, dbh, options, logger)
+	assert.True(t, processed)
+	assert.Nil(t, err)
+	assert.Equal(t, database.CheckoutJobDone, dbh.state.finished["job1"])
+
+	job.ID, job.Request.ID = "job2", "job2"
+	dbh.state.job, dbh.state.createErr = job, database.NewFieldValidationError(map[string]string{"coupon_code": "The coupon code is not valid."})
+	_, err = processNextCheckoutJob(ctx, dbh, options, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, database.CheckoutJobFailed, dbh.state.finished["job2"])
+	assert.Equal(t, "The coupon code is not valid.", dbh.state.messages["job2"])
+
+	// Other errors are retried until the last attempt.
+	job.ID, job.Request.ID = "job3", "job3"
+	dbh.state.job, dbh.state.createErr = job, errors.New("connection refused")
+	_, err = processNextCheckoutJob(ctx, dbh, options, logger)
+	assert.Nil(t, err)
+	assert.NotContains(t, dbh.state.finished, "job3")
+
+	job.Attempts = 3
+	dbh.state.job = job
+	_, err = processNextCheckoutJob(ctx, dbh, options, logger)
+	assert.Nil(t, err)
+	assert.Equal(t, database.CheckoutJobFailed, dbh.state.finished["job3"])
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+// couponDateLayout is the format of the date input of the coupon form.
+const couponDateLayout = "2006-01-02"
+
+// normalizeCouponCode makes the codes case insensitive for customers.
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// parseCouponForm reads the coupon form of the admin page. Amounts are in the
+// store currency like 5.00. Empty limits are 0 which means no limit. A coupon
+// expires at the end of its expiry date in UTC.
+func parseCouponForm(c *gin.Context) (database.Coupon, map[string]string) {
+	fields := make(map[string]string)
+	coupon := database.Coupon{
+		Code: normalizeCouponCode(c.PostForm("code")),
+		Kind: c.PostForm("kind"),
+	}
+
+	if coupon.Kind == database.CouponKindFixed {
+		amount, err := money.Parse(c.PostForm("value"), storeCurrency)
+		if err != nil {
+			fields["value"] = "Value must be an amount like 5.00."
+		}
+		coupon.Value = int(amount.Amount)
+	} else {
+		value, err := strconv.Atoi(strings.TrimSpace(c.PostForm("value")))
+		if err != nil {
+			fields["value"] = "Value must be a number."
+		}
+		coupon.Value = value
+	}
+
+	if val := strings.TrimSpace(c.PostForm("min_order")); val != "" {
+		amount, err := money.Parse(val, storeCurrency)
+		if err != nil {
+			fields["min_order"] = "Minimum order must be an amount like 50.00."
+		}
+		coupon.MinOrder = int(amount.Amount)
+	}
+
+	for _, field := range []struct {
+		name    string
+		message string
+		dest    *int
+	}{
+		{"max_uses", "Usage limit must be a number.", &coupon.MaxUses},
+		{"max_uses_per_user", "Usage limit per user must be a number.", &coupon.MaxUsesPerUser},
+	} {
+		val := strings.TrimSpace(c.PostForm(field.name))
+		if val == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			fields[field.name] = field.message
+			continue
+		}
+		*field.dest = n
+	}
+
+	if val := strings.TrimSpace(c.PostForm("expires_at")); val != "" {
+		date, err := time.Parse(couponDateLayout, val)
+		if err != nil {
+			fields["expires_at"] = "Expiry must be a date like 2006-01-02."
+		} else {
+			coupon.ExpiresAt = date.Add(24 * time.Hour)
+		}
+	}
+
+	return coupon, fields
+}
+
+func renderAdminCoupons(c *gin.Context, status int, form gin.H, fields map[string]string) {
+	coupons, err := databaseHandler(c).GetCoupons()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.HTML(status, "admin_coupons.html", gin.H{
+		"title":     "Coupons",
+		"coupons":   coupons,
+		"form":      form,
+		"errors":    fields,
+		"csrfToken": csrfToken(c),
+	})
+}
+
+func getAdminCouponsEndpoint(c *gin.Context) {
+	renderAdminCoupons(c, http.StatusOK, gin.H{"kind": database.CouponKindPercent}, map[string]string{})
+}
+
+func postAdminCouponsEndpoint(c *gin.Context) {
+	coupon, fields := parseCouponForm(c)
+	if len(fields) == 0 {
+		err := databaseHandler(c).CreateCoupon(coupon)
+		var dbErr *database.Error
+		switch {
+		case err == nil:
+			c.Redirect(http.StatusSeeOther, "/admin/coupons")
+			return
+		case errors.Is(err, database.ErrConflict):
+			fields["code"] = "The code is already in use."
+		case errors.As(err, &dbErr) && dbErr.Fields != nil:
+			fields = dbErr.Fields
+		default:
+			c.Error(err)
+			return
+		}
+	}
+
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.Error(database.NewFieldValidationError(fields))
+		return
+	}
+
+	form := gin.H{}
+	for _, name := range []string{"code", "kind", "value", "min_order", "expires_at", "max_uses", "max_uses_per_user"} {
+		form[name] = c.PostForm(name)
+	}
+	renderAdminCoupons(c, http.StatusBadRequest, form, fields)
+}
+
+func postAdminCouponDeactivateEndpoint(c *gin.Context) {
+	if err := databaseHandler(c).DeactivateCoupon(c.Param("code")); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin/coupons")
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// couponDatabaseHandler accepts only the SALE10 coupon.
+type couponDatabaseHandler struct {
+	database.DevDatabaseHandler
+}
+
+func (dbh couponDatabaseHandler) CreateCheckout(order database.CheckoutRequest) (string, error) {
+	if order.CouponCode != "" && order.CouponCode != "SALE10" {
+		return "", database.NewFieldValidationError(map[string]string{"coupon_code": "The coupon code is not valid."})
+	}
+
+	return "dummy-checkout", nil
+}
+
+func postForm(t *testing.T, router http.Handler, path string, values url.Values, accept string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestPostCheckoutEndpointCoupon(t *testing.T) {
+	router := SetupRouter(couponDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}, "coupon_code": {" sale10 "}}, "")
+	assert.Equal(t, 202, w.Code)
+
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}, "coupon_code": {"NOPE"}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `name="coupon_code"`)
+	assert.Contains(t, w.Body.String(), "The coupon code is not valid.")
+
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}, "coupon_code": {"NOPE"}}, "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"coupon_code": "The coupon code is not valid."}}}`, w.Body.String())
+}
+
+func TestGetAdminCouponsEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/coupons", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `<td class="coupon_code">SALE10</td>`)
+	assert.Contains(t, w.Body.String(), `<td class="coupon_discount">10%</td>`)
+	assert.Contains(t, w.Body.String(), `<td class="coupon_discount">$50.00</td>`)
+	assert.Contains(t, w.Body.String(), `action="/admin/coupons/WELCOME/deactivate"`)
+	assert.Contains(t, w.Body.String(), `name="csrf_token"`)
+}
+
+func TestPostAdminCouponsEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := postAdminForm(t, router, "/admin/coupons", url.Values{"code": {"summer"}, "kind": {"percent"}, "value": {"20"}, "expires_at": {"2030-08-31"}}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/admin/coupons", w.Header().Get("Location"))
+
+	w = postAdminForm(t, router, "/admin/coupons", url.Values{"code": {"summer"}, "kind": {"percent"}, "value": {"120"}, "max_uses": {"many"}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Usage limit must be a number.")
+
+	w = postAdminForm(t, router, "/admin/coupons", url.Values{"code": {"summer"}, "kind": {"percent"}, "value": {"120"}}, "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"value": "Percentage must be between 1 and 100."}}}`, w.Body.String())
+
+	w = postAdminForm(t, router, "/admin/coupons", url.Values{"code": {"summer"}, "kind": {"fixed"}, "value": {"10.005"}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Value must be an amount like 5.00.")
+
+	w = postAdminForm(t, router, "/admin/coupons", url.Values{"code": {"summer"}, "kind": {"fixed"}, "value": {"10"}, "expires_at": {"31/08/2030"}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Expiry must be a date like 2006-01-02.")
+}
+
+func TestPostAdminCouponDeactivateEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := postAdminForm(t, router, "/admin/coupons/SALE10/deactivate", url.Values{}, "")
+	assert.Equal(t, 303, w.Code)
+}
@@ -0,0 +1,91 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+)
+
+// errorHandler renders the last error added by c.Error as an HTML page or
+// JSON depending on the Accept header. Only the message of typed database
+// errors is shown to clients. Other errors are logged and answered with 500.
+func errorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		err := c.Errors.Last().Err
+		status, message := errorResponse(err)
+
+		var fields map[string]string
+		var dbErr *database.Error
+		if status != http.StatusInternalServerError && errors.As(err, &dbErr) {
+			fields = dbErr.Fields
+		}
+		if status == http.StatusInternalServerError {
+			requestLoggerFrom(c).Error("internal error", "error", err.Error())
+		}
+
+		switch c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) {
+		case gin.MIMEJSON:
+			body := gin.H{
+				"status":  status,
+				"message": message,
+			}
+			if fields != nil {
+				body["fields"] = fields
+			}
+			c.JSON(status, gin.H{"error": body})
+		default:
+			c.HTML(status, "error.html", gin.H{
+				"title":   http.StatusText(status),
+				"status":  status,
+				"message": message,
+				"fields":  fields,
+			})
+		}
+	}
+}
+
+// httpError is an error raised by the app itself with the status to answer.
+type httpError struct {
+	status  int
+	message string
+}
+
+func (e *httpError) Error() string {
+	return e.message
+}
+
+func errorResponse(err error) (int, string) {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		return httpErr.status, httpErr.message
+	}
+
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, database.ErrValidation):
+		status = http.StatusBadRequest
+	case errors.Is(err, database.ErrConflict):
+		status = http.StatusConflict
+	}
+
+	var dbErr *database.Error
+	if status == http.StatusInternalServerError || !errors.As(err, &dbErr) {
+		return status, "Something went wrong. Please try again later."
+	}
+
+	return status, dbErr.Message
+}
+
+func noRouteEndpoint(c *gin.Context) {
+	c.Error(database.NewNotFoundError("page", c.Request.URL.Path))
+}
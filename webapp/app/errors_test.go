@@ -0,0 +1,71 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingDatabaseHandler returns a raw driver error which must not reach clients.
+type failingDatabaseHandler struct {
+	database.DevDatabaseHandler
+}
+
+func (dbh failingDatabaseHandler) GetProducts() ([]database.Product, error) {
+	return nil, errors.New(`pq: relation "products" does not exist`)
+}
+
+func (dbh failingDatabaseHandler) GetProduct(id int) (database.Product, error) {
+	return database.Product{}, database.NewNotFoundError("product", id)
+}
+
+func TestErrorHandlerBadRequest(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/product/abc", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "product_id must be an integer")
+	assert.Contains(t, w.Body.String(), "<html>")
+}
+
+func TestErrorHandlerNotFoundJSON(t *testing.T) {
+	router := SetupRouter(failingDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/product/999", nil)
+	req.Header.Set("Accept", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 404, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 404, "message": "product 999 is not found"}}`, w.Body.String())
+}
+
+func TestErrorHandlerHidesInternalErrors(t *testing.T) {
+	router := SetupRouter(failingDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/products", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 500, w.Code)
+	assert.NotContains(t, w.Body.String(), "pq:")
+	assert.Contains(t, w.Body.String(), "Something went wrong")
+}
+
+func TestNoRoute(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/no-such-page", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 404, w.Code)
+	assert.Contains(t, w.Body.String(), "page /no-such-page is not found")
+}
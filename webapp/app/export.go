@@ -0,0 +1,278 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+)
+
+// exportFlushRows is the number of checkouts written between two flushes of
+// the response, so that large exports reach the client while they are read.
+const exportFlushRows = 100
+
+// exportedCheckout is a checkout as exported. The amounts are in minor units
+// of the currency, like in the webhook events.
+type exportedCheckout struct {
+	CheckoutID         string    `json:"checkout_id"`
+	CreatedAt          time.Time `json:"created_at"`
+	UserID             int       `json:"user_id"`
+	UserName           string    `json:"user_name"`
+	ProductID          int       `json:"product_id"`
+	ProductName        string    `json:"product_name"`
+	VariantSKU         string    `json:"variant_sku"`
+	VariantName        string    `json:"variant_name"`
+	ProductQuantity    int       `json:"product_quantity"`
+	CouponCode         string    `json:"coupon_code"`
+	TaxRegion          string    `json:"tax_region"`
+	Currency           string    `json:"currency"`
+	Subtotal           int64     `json:"subtotal"`
+	Discount           int64     `json:"discount"`
+	Tax                int64     `json:"tax"`
+	Shipping           int64     `json:"shipping"`
+	Total              int64     `json:"total"`
+	PaymentStatus      string    `json:"payment_status"`
+	PaymentID          string    `json:"payment_id"`
+	ShippingName       string    `json:"shipping_name"`
+	ShippingLine1      string    `json:"shipping_line1"`
+	ShippingLine2      string    `json:"shipping_line2"`
+	ShippingCity       string    `json:"shipping_city"`
+	ShippingPostalCode string    `json:"shipping_postal_code"`
+	ShippingCountry    string    `json:"shipping_country"`
+}
+
+func newExportedCheckout(checkout database.Checkout) exportedCheckout {
+	return exportedCheckout{
+		CheckoutID:         checkout.ID,
+		CreatedAt:          checkout.CreatedAt.UTC(),
+		UserID:             checkout.User.ID,
+		UserName:           checkout.User.Name,
+		ProductID:          checkout.Product.ID,
+		ProductName:        checkout.Product.Name,
+		VariantSKU:         checkout.Variant.SKU,
+		VariantName:        checkout.Variant.Name,
+		ProductQuantity:    checkout.ProductQuantity,
+		CouponCode:         checkout.CouponCode,
+		TaxRegion:          checkout.TaxRegion,
+		Currency:           checkout.Total.Currency,
+		Subtotal:           checkout.Subtotal.Amount,
+		Discount:           checkout.Discount.Amount,
+		Tax:                checkout.Tax.Amount,
+		Shipping:           checkout.Shipping.Amount,
+		Total:              checkout.Total.Amount,
+		PaymentStatus:      checkout.Payment.Status,
+		PaymentID:          checkout.Payment.ID,
+		ShippingName:       checkout.ShippingAddress.Name,
+		ShippingLine1:      checkout.ShippingAddress.Line1,
+		ShippingLine2:      checkout.ShippingAddress.Line2,
+		ShippingCity:       checkout.ShippingAddress.City,
+		ShippingPostalCode: checkout.ShippingAddress.PostalCode,
+		ShippingCountry:    checkout.ShippingAddress.Country,
+	}
+}
+
+// exportCSVHeader is the header row of the CSV exports, in the order of
+// csvRecord.
+var exportCSVHeader = []string{
+	"checkout_id", "created_at", "user_id", "user_name", "product_id", "product_name", "variant_sku", "variant_name",
+	"product_quantity", "coupon_code", "tax_region", "currency", "subtotal", "discount", "tax", "shipping", "total",
+	"payment_status", "payment_id", "shipping_name", "shipping_line1", "shipping_line2", "shipping_city",
+	"shipping_postal_code", "shipping_country",
+}
+
+// csvFormulaPrefixes are the first characters of the cells which the
+// spreadsheets read as formulas.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvText escapes the text of a cell with a quote when a spreadsheet would
+// run it as a formula, as the names and the addresses are typed by the
+// customers.
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune(csvFormulaPrefixes, rune(text[0])) {
+		return "'" + text
+	}
+
+	return text
+}
+
+func (checkout exportedCheckout) csvRecord() []string {
+	amount := func(n int64) string { return strconv.FormatInt(n, 10) }
+	return []string{
+		csvText(checkout.CheckoutID), checkout.CreatedAt.Format(time.RFC3339), strconv.Itoa(checkout.UserID), csvText(checkout.UserName),
+		strconv.Itoa(checkout.ProductID), csvText(checkout.ProductName), csvText(checkout.VariantSKU), csvText(checkout.VariantName),
+		strconv.Itoa(checkout.ProductQuantity), csvText(checkout.CouponCode), csvText(checkout.TaxRegion), csvText(checkout.Currency),
+		amount(checkout.Subtotal), amount(checkout.Discount), amount(checkout.Tax), amount(checkout.Shipping), amount(checkout.Total),
+		csvText(checkout.PaymentStatus), csvText(checkout.PaymentID), csvText(checkout.ShippingName), csvText(checkout.ShippingLine1), csvText(checkout.ShippingLine2),
+		csvText(checkout.ShippingCity), csvText(checkout.ShippingPostalCode), csvText(checkout.ShippingCountry),
+	}
+}
+
+// checkoutWriter writes the exported checkouts in a format.
+type checkoutWriter interface {
+	Write(checkout exportedCheckout) error
+	Flush() error
+}
+
+type csvCheckoutWriter struct {
+	w *csv.Writer
+}
+
+func newCSVCheckoutWriter(w *csv.Writer) (*csvCheckoutWriter, error) {
+	return &csvCheckoutWriter{w: w}, w.Write(exportCSVHeader)
+}
+
+func (cw *csvCheckoutWriter) Write(checkout exportedCheckout) error {
+	return cw.w.Write(checkout.csvRecord())
+}
+
+func (cw *csvCheckoutWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// ndjsonCheckoutWriter writes a JSON object per line.
+type ndjsonCheckoutWriter struct {
+	enc *json.Encoder
+}
+
+func (jw *ndjsonCheckoutWriter) Write(checkout exportedCheckout) error {
+	return jw.enc.Encode(checkout)
+}
+
+func (jw *ndjsonCheckoutWriter) Flush() error {
+	return nil
+}
+
+// exportContentTypes are the content types of the exports by the extension
+// of their path.
+var exportContentTypes = map[string]string{
+	".csv":    "text/csv; charset=utf-8",
+	".ndjson": "application/x-ndjson",
+}
+
+// streamCheckouts writes the checkouts of the filter as they are read, in
+// the format of the extension of the route. The response starts with the
+// first checkout, so that an error before it is rendered like any other.
+// An error after it can only cut the export short, and is logged.
+func streamCheckouts(c *gin.Context, filter database.CheckoutFilter, filename string) {
+	ext := path.Ext(c.FullPath())
+	contentType := exportContentTypes[ext]
+
+	var w checkoutWriter
+	start := func() error {
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, filename, ext))
+		c.Status(http.StatusOK)
+
+		if ext == ".csv" {
+			cw, err := newCSVCheckoutWriter(csv.NewWriter(c.Writer))
+			w = cw
+			return err
+		}
+		w = &ndjsonCheckoutWriter{enc: json.NewEncoder(c.Writer)}
+		return nil
+	}
+
+	rows := 0
+	err := databaseHandler(c).ExportCheckouts(filter, func(checkout database.Checkout) error {
+		if w == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+
+		if err := w.Write(newExportedCheckout(checkout)); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushRows == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil && w == nil {
+		c.Error(err)
+		return
+	}
+
+	// An export without checkouts still has its CSV header.
+	if err == nil && w == nil {
+		err = start()
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		requestLoggerFrom(c).Error("export interrupted", "error", err.Error(), "rows", rows)
+	}
+}
+
+// getCheckoutsExportEndpoint exports the order history of the user.
+func getCheckoutsExportEndpoint(c *gin.Context) {
+	streamCheckouts(c, database.CheckoutFilter{UserID: getUserID()}, "orders")
+}
+
+// parseExportFilter reads the optional user_id, from and to of the admin
+// export. Like on the sales dashboard, the to day is included.
+func parseExportFilter(c *gin.Context) (database.CheckoutFilter, map[string]string) {
+	fields := make(map[string]string)
+	var filter database.CheckoutFilter
+
+	if val := strings.TrimSpace(c.Query("user_id")); val != "" {
+		userID, err := strconv.Atoi(val)
+		if err != nil || userID < 1 {
+			fields["user_id"] = "User ID must be a number."
+		}
+		filter.UserID = userID
+	}
+
+	if val := strings.TrimSpace(c.Query("from")); val != "" {
+		day, err := time.Parse(database.SalesDayLayout, val)
+		if err != nil {
+			fields["from"] = "The start must be a date like 2006-01-02."
+		}
+		filter.From = day
+	}
+
+	if val := strings.TrimSpace(c.Query("to")); val != "" {
+		day, err := time.Parse(database.SalesDayLayout, val)
+		if err != nil {
+			fields["to"] = "The end must be a date like 2006-01-02."
+		} else {
+			filter.To = day.AddDate(0, 0, 1)
+		}
+	}
+
+	return filter, fields
+}
+
+// getAdminCheckoutsExportEndpoint exports the orders of every user, or of a
+// user, over a window of days.
+func getAdminCheckoutsExportEndpoint(c *gin.Context) {
+	filter, fields := parseExportFilter(c)
+	if len(fields) > 0 {
+		c.Error(database.NewFieldValidationError(fields))
+		return
+	}
+
+	filename := "orders"
+	if !filter.From.IsZero() {
+		filename += "-" + filter.From.Format(database.SalesDayLayout)
+	}
+	if !filter.To.IsZero() {
+		filename += "-" + filter.To.AddDate(0, 0, -1).Format(database.SalesDayLayout)
+	}
+
+	streamCheckouts(c, filter, filename)
+}
@@ -0,0 +1,144 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// exportDatabaseHandler exports n checkouts and then fails with err.
+type exportDatabaseHandler struct {
+	database.DevDatabaseHandler
+	n   int
+	err error
+}
+
+func (dbh exportDatabaseHandler) ExportCheckouts(filter database.CheckoutFilter, fn func(database.Checkout) error) error {
+	for i := 0; i < dbh.n; i++ {
+		if err := fn(database.Checkout{ID: fmt.Sprintf("checkout%d", i), ProductQuantity: 1}); err != nil {
+			return err
+		}
+	}
+
+	return dbh.err
+}
+
+func getExport(router http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("Accept", "application/json")
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+// getAdminExport is getExport with the admin credentials.
+func getAdminExport(router http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", path, nil)
+	req.Header.Set("Accept", "application/json")
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestGetCheckoutsExportEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := getExport(router, "/checkouts.csv")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="orders.csv"`, w.Header().Get("Content-Disposition"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	assert.Equal(t, strings.Join(exportCSVHeader, ","), lines[0])
+	assert.Equal(t, ",0001-01-01T00:00:00Z,2,,0,,,,111,,,,0,0,0,0,0,,,,,,,,", lines[1])
+
+	w = getExport(router, "/checkouts.ndjson")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	lines = strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+
+	var checkout exportedCheckout
+	assert.Nil(t, json.Unmarshal([]byte(lines[1]), &checkout))
+	assert.Equal(t, 2, checkout.UserID)
+	assert.Equal(t, 222, checkout.ProductQuantity)
+}
+
+func TestCSVRecordFormulas(t *testing.T) {
+	// The cells typed by the customers aren't run by the spreadsheets.
+	checkout := newExportedCheckout(database.Checkout{ShippingAddress: database.Address{
+		Name:       "=HYPERLINK(\"https://example.com\")",
+		Line1:      "+1 Main St",
+		Line2:      "-2",
+		City:       "@SUM(A1)",
+		PostalCode: "\t10001",
+		Country:    "\rUS",
+	}, User: database.User{Name: "user00002"}})
+	record := checkout.csvRecord()
+	assert.Equal(t, "user00002", record[3])
+	assert.Equal(t, []string{"'=HYPERLINK(\"https://example.com\")", "'+1 Main St", "'-2", "'@SUM(A1)", "'\t10001", "'\rUS"}, record[19:])
+	assert.Equal(t, "0", record[12])
+}
+
+func TestGetAdminCheckoutsExportEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	// The orders of every user are exported only to the admin.
+	for _, path := range []string{"/admin/checkouts.csv", "/admin/checkouts.ndjson"} {
+		w := getExport(router, path)
+		assert.Equal(t, 401, w.Code, path)
+		assert.NotContains(t, w.Body.String(), "scstore@example.com", path)
+	}
+
+	w := getAdminExport(router, "/admin/checkouts.ndjson?from=2026-10-01&to=2026-10-07")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `attachment; filename="orders-2026-10-01-2026-10-07.ndjson"`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, 2, strings.Count(w.Body.String(), "\n"))
+
+	w = getAdminExport(router, "/admin/checkouts.csv?from=2026-10-07&to=2026-10-01")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"to": "The end must be after the start."}}}`, w.Body.String())
+
+	w = getAdminExport(router, "/admin/checkouts.csv?user_id=me&from=today")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"user_id": "User ID must be a number.", "from": "The start must be a date like 2006-01-02."}}}`, w.Body.String())
+}
+
+func TestStreamCheckouts(t *testing.T) {
+	// Large exports are written in batches.
+	router := SetupRouter(exportDatabaseHandler{n: 2*exportFlushRows + 1}, testAssetsDir, testTemplatesDirMatch)
+	w := getAdminExport(router, "/admin/checkouts.csv")
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, w.Flushed)
+	assert.Equal(t, 2*exportFlushRows+2, strings.Count(w.Body.String(), "\n"))
+
+	// An export without checkouts has the CSV header only.
+	router = SetupRouter(exportDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+	w = getAdminExport(router, "/admin/checkouts.csv")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, strings.Join(exportCSVHeader, ",")+"\n", w.Body.String())
+
+	w = getAdminExport(router, "/admin/checkouts.ndjson")
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	// An error before the first checkout is rendered as usual.
+	router = SetupRouter(exportDatabaseHandler{err: database.NewValidationError("the window is invalid")}, testAssetsDir, testTemplatesDirMatch)
+	w = getAdminExport(router, "/admin/checkouts.csv")
+	assert.Equal(t, 400, w.Code)
+
+	// An error after it cuts the export short.
+	router = SetupRouter(exportDatabaseHandler{n: 1, err: errors.New("connection reset")}, testAssetsDir, testTemplatesDirMatch)
+	w = getAdminExport(router, "/admin/checkouts.ndjson")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "\n"))
+}
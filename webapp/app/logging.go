@@ -0,0 +1,86 @@
+package app
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+const (
+	contextKeyRequestID = "requestID"
+	contextKeyLogger    = "logger"
+)
+
+// requestIDPattern limits the request IDs taken from clients to what is safe
+// to put in logs and headers.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+var dbSlowQueryThreshold time.Duration
+
+// requestLogger takes the request ID from X-Request-ID or generates one, sends
+// it back in the response and writes one structured log line per request.
+// It has to run after otelgin so that the trace ID of the span is logged.
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(requestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+		c.Header(requestIDHeader, requestID)
+
+		logger := slog.Default().With("request_id", requestID)
+		if spanContext := trace.SpanContextFromContext(c.Request.Context()); spanContext.IsValid() {
+			logger = logger.With("trace_id", spanContext.TraceID().String(), "span_id", spanContext.SpanID().String())
+		}
+
+		c.Set(contextKeyRequestID, requestID)
+		c.Set(contextKeyLogger, logger)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		logger.LogAttrs(c.Request.Context(), level, "request",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		)
+	}
+}
+
+// requestLoggerFrom returns the logger of the request with its request ID.
+func requestLoggerFrom(c *gin.Context) *slog.Logger {
+	if logger, ok := c.Get(contextKeyLogger); ok {
+		return logger.(*slog.Logger)
+	}
+
+	return slog.Default()
+}
+
+// databaseHandler returns the database handler which logs the duration of
+// each call with the request ID.
+func databaseHandler(c *gin.Context) database.DatabaseHandler {
+	return database.NewLoggingDatabaseHandler(dbHandler, requestLoggerFrom(c), dbSlowQueryThreshold)
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	return &buf
+}
+
+func parseLogLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, raw := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var line map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("not a JSON log line: %s", raw)
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func TestRequestLoggerPropagatesRequestID(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	buf := captureLogs(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/product/1", nil)
+	req.Header.Set(requestIDHeader, "bench-123")
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "bench-123", w.Header().Get(requestIDHeader))
+
+	lines := parseLogLines(t, buf)
+	assert.Equal(t, 6, len(lines))
+
+	productLine, addressesLine, reviewsLine, wishlistLine, variantsLine, requestLine := lines[0], lines[1], lines[2], lines[3], lines[4], lines[5]
+	assert.Equal(t, "GetProduct", productLine["db_method"])
+	assert.Equal(t, "bench-123", productLine["request_id"])
+	assert.Equal(t, "GetAddresses", addressesLine["db_method"])
+	assert.Equal(t, "bench-123", addressesLine["request_id"])
+	assert.Equal(t, "GetProductReviews", reviewsLine["db_method"])
+	assert.Equal(t, "bench-123", reviewsLine["request_id"])
+	assert.Equal(t, "GetWishlist", wishlistLine["db_method"])
+	assert.Equal(t, "bench-123", wishlistLine["request_id"])
+	assert.Equal(t, "GetVariants", variantsLine["db_method"])
+	assert.Equal(t, "bench-123", variantsLine["request_id"])
+	assert.Equal(t, "request", requestLine["msg"])
+	assert.Equal(t, "bench-123", requestLine["request_id"])
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", requestLine["trace_id"])
+	assert.Equal(t, "/product/:product_id", requestLine["route"])
+	assert.Equal(t, float64(200), requestLine["status"])
+}
+
+func TestRequestLoggerGeneratesRequestID(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	captureLogs(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/products", nil)
+	req.Header.Set(requestIDHeader, "not a valid id\n")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Regexp(t, "^[0-9a-f-]{36}$", w.Header().Get(requestIDHeader))
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"log/slog"
+	"text/template"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/mittz/role-play-webapp/webapp/notify"
+)
+
+// The emails to the customers. Each template defines its subject and body.
+const (
+	emailOrderConfirmation = "order_confirmation"
+	emailOrderStatus       = "order_status"
+	emailPriceDrop         = "price_drop"
+)
+
+//go:embed emails/*.txt
+var emailFS embed.FS
+
+var (
+	notifier       *notify.Notifier
+	emailTemplates map[string]*template.Template
+)
+
+func parseEmailTemplates() (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+	for _, name := range []string{emailOrderConfirmation, emailOrderStatus, emailPriceDrop} {
+		tmpl, err := template.ParseFS(emailFS, "emails/"+name+".txt")
+		if err != nil {
+			return nil, err
+		}
+		templates[name] = tmpl
+	}
+
+	return templates, nil
+}
+
+func newMailer(kind string, from string, filePath string, smtpMailer notify.SMTPMailer, logger *slog.Logger) (notify.Mailer, error) {
+	switch kind {
+	case "log":
+		return notify.NewLogMailer(logger), nil
+	case "file":
+		return notify.NewFileMailer(filePath, from), nil
+	case "smtp":
+		smtpMailer.From = from
+		return &smtpMailer, nil
+	default:
+		return nil, fmt.Errorf("mailer %s is not supported", kind)
+	}
+}
+
+// renderEmail returns the email of the template about the checkout.
+func renderEmail(name string, checkout database.Checkout) (notify.Message, error) {
+	return renderMessage(name, checkout.User.Email, checkout)
+}
+
+// renderMessage returns the email of the template to the address.
+func renderMessage(name string, to string, data interface{}) (notify.Message, error) {
+	tmpl, ok := emailTemplates[name]
+	if !ok {
+		return notify.Message{}, fmt.Errorf("email template %s does not exist", name)
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return notify.Message{}, err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return notify.Message{}, err
+	}
+
+	return notify.Message{To: to, Subject: subject.String(), Body: body.String()}, nil
+}
+
+// notifyCustomer queues the email about the checkout. The order is already
+// stored, so failures are only logged and never change the response.
+func notifyCustomer(logger *slog.Logger, name string, checkout database.Checkout) {
+	if checkout.User.Email == "" {
+		return
+	}
+
+	msg, err := renderEmail(name, checkout)
+	if err == nil {
+		err = notifier.Notify(msg)
+	}
+	if err != nil {
+		logger.Error("notification failed", "checkout_id", checkout.ID, "email", name, "error", err.Error())
+	}
+}
+
+// priceDropEmail is the data of the price_drop template.
+type priceDropEmail struct {
+	User     database.User
+	Product  database.Product
+	OldPrice money.Money
+}
+
+// notifyPriceDrop queues the emails to the users with the product on their
+// wishlists. Like notifyCustomer, failures are only logged.
+func notifyPriceDrop(logger *slog.Logger, change database.PriceChange) {
+	for _, user := range change.Watchers {
+		if user.Email == "" {
+			continue
+		}
+
+		msg, err := renderMessage(emailPriceDrop, user.Email, priceDropEmail{User: user, Product: change.Product, OldPrice: change.OldPrice})
+		if err == nil {
+			err = notifier.Notify(msg)
+		}
+		if err != nil {
+			logger.Error("notification failed", "product_id", change.Product.ID, "user_id", user.ID, "email", emailPriceDrop, "error", err.Error())
+		}
+	}
+}
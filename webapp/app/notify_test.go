@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/mittz/role-play-webapp/webapp/notify"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingMailer keeps the sent messages.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []notify.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg notify.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// useRecordingMailer replaces the notifier set up by SetupRouter.
+func useRecordingMailer() *recordingMailer {
+	mailer := &recordingMailer{}
+	notifier = notify.NewNotifier(mailer, notify.Options{QueueSize: 10}, slog.Default())
+
+	return mailer
+}
+
+func TestRenderEmail(t *testing.T) {
+	templates, err := parseEmailTemplates()
+	assert.Nil(t, err)
+	emailTemplates = templates
+
+	checkout := database.Checkout{
+		ID:              "checkout-1",
+		User:            database.User{ID: 2, Name: "scstore", Email: "scstore@example.com"},
+		Product:         database.Product{Name: "Product00001"},
+		ProductQuantity: 2,
+		CouponCode:      "SALE10",
+		Subtotal:        money.New(20000, "USD"),
+		Discount:        money.New(2000, "USD"),
+		Shipping:        money.New(500, "USD"),
+		Total:           money.New(18500, "USD"),
+		Payment:         database.Payment{Status: database.PaymentPaid},
+		ShippingAddress: database.Address{Name: "Home", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"},
+	}
+
+	msg, err := renderEmail(emailOrderConfirmation, checkout)
+	assert.Nil(t, err)
+	assert.Equal(t, "scstore@example.com", msg.To)
+	assert.Equal(t, "Your order at The Watch Shop", msg.Subject)
+	assert.Contains(t, msg.Body, "2 x Product00001\n")
+	assert.Contains(t, msg.Body, "Coupon SALE10: -$20.00\n")
+	assert.NotContains(t, msg.Body, "Tax")
+	assert.Contains(t, msg.Body, "Total: $185.00\n")
+	assert.Contains(t, msg.Body, "Ship to:\nHome\n1 Main St\nSpringfield 12345\nUS\n")
+
+	checkout.Payment = database.Payment{Status: database.PaymentRefunded}
+	msg, err = renderEmail(emailOrderStatus, checkout)
+	assert.Nil(t, err)
+	assert.Equal(t, "Your order at The Watch Shop is refunded", msg.Subject)
+	assert.Contains(t, msg.Body, "The total will be returned to your card")
+
+	_, err = renderEmail("unknown", checkout)
+	assert.NotNil(t, err)
+}
+
+func TestPostCheckoutEndpointNotifies(t *testing.T) {
+	dbh := newPaymentDatabaseHandler()
+	router := SetupRouter(dbh, testAssetsDir, testTemplatesDirMatch)
+	mailer := useRecordingMailer()
+
+	w := postForm(t, router, "/checkout", checkoutForm, "")
+	assert.Equal(t, 202, w.Code)
+
+	w = postAdminForm(t, router, "/admin/checkouts/dummy-checkout/refund", url.Values{}, "")
+	assert.Equal(t, 200, w.Code)
+
+	notifier.Close()
+	assert.Equal(t, 2, len(mailer.sent))
+	assert.Equal(t, "scstore@example.com", mailer.sent[0].To)
+	assert.Equal(t, "Your order at The Watch Shop", mailer.sent[0].Subject)
+	assert.Equal(t, "Your order at The Watch Shop is refunded", mailer.sent[1].Subject)
+}
+
+func TestPostCheckoutEndpointPaymentDeclinedDoesNotNotify(t *testing.T) {
+	os.Setenv("PAYMENT_FAKE_MODE", "decline")
+	defer os.Unsetenv("PAYMENT_FAKE_MODE")
+
+	router := SetupRouter(newPaymentDatabaseHandler(), testAssetsDir, testTemplatesDirMatch)
+	mailer := useRecordingMailer()
+
+	w := postForm(t, router, "/checkout", checkoutForm, "")
+	assert.Equal(t, 402, w.Code)
+
+	notifier.Close()
+	assert.Empty(t, mailer.sent)
+}
+
+func TestNewMailer(t *testing.T) {
+	_, err := newMailer("unknown", "", "", notify.SMTPMailer{}, slog.Default())
+	assert.NotNil(t, err)
+
+	mailer, err := newMailer("smtp", "noreply@example.com", "", notify.SMTPMailer{Addr: "smtp.example.com:587"}, slog.Default())
+	assert.Nil(t, err)
+	assert.Equal(t, &notify.SMTPMailer{Addr: "smtp.example.com:587", From: "noreply@example.com"}, mailer)
+}
@@ -0,0 +1,235 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/payment"
+)
+
+const (
+	paymentWebhookPath            = "/payment/webhook"
+	paymentSignatureHeaderName    = "X-Payment-Signature"
+	paymentWebhookMaxPayloadBytes = 64 << 10
+)
+
+var (
+	paymentGateway payment.Gateway
+	paymentTimeout time.Duration
+)
+
+func newPaymentGateway(provider string, fakeMode string, webhookSecret string) (payment.Gateway, error) {
+	switch provider {
+	case "fake":
+		return payment.NewFakeGateway(fakeMode, []byte(webhookSecret))
+	default:
+		return nil, fmt.Errorf("payment provider %s is not supported", provider)
+	}
+}
+
+// paymentRecordAttempts is how many times the result of a call to the
+// provider is written to the order before it is left to the webhook.
+const paymentRecordAttempts = 3
+
+// chargeCheckout claims the stored order as charging, authorizes and
+// captures its total and records the result on it. The claim fails with
+// ErrConflict when another request or worker charges the order already. The
+// returned error is the one to answer the customer with when the payment
+// failed.
+func chargeCheckout(ctx context.Context, dbh database.DatabaseHandler, logger *slog.Logger, checkout database.Checkout) (database.Payment, error) {
+	if err := dbh.UpdateCheckoutPayment(checkout.ID, database.Payment{Status: database.PaymentCharging}); err != nil {
+		return checkout.Payment, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, paymentTimeout)
+	defer cancel()
+
+	result := database.Payment{Status: database.PaymentPaid}
+	auth, err := paymentGateway.Authorize(ctx, payment.AuthorizeRequest{Reference: checkout.ID, Amount: checkout.Total})
+	if err == nil {
+		result.ID = auth.ID
+		err = paymentGateway.Capture(ctx, auth.ID, checkout.Total)
+	}
+	if err != nil {
+		result.Status = database.PaymentFailed
+		result.Error = err.Error()
+		logger.Warn("payment failed", "checkout_id", checkout.ID, "error", err.Error())
+	}
+
+	// The provider has been called, so the order isn't charged again when
+	// the result can't be recorded. It stays charging until the webhook of
+	// the provider reports the result.
+	if dbErr := recordPayment(dbh, checkout.ID, result); dbErr != nil {
+		logger.Error("failed to record the payment, waiting for the webhook of the provider", "checkout_id", checkout.ID, "payment_id", result.ID, "status", result.Status, "error", dbErr.Error())
+	}
+
+	return result, paymentError(err)
+}
+
+// recordPayment writes the result of a call to the provider to the claimed
+// order, trying again when the database fails.
+func recordPayment(dbh database.DatabaseHandler, checkoutID string, result database.Payment) error {
+	var err error
+	for attempt := 1; attempt <= paymentRecordAttempts; attempt++ {
+		err = dbh.UpdateCheckoutPayment(checkoutID, result)
+		if err == nil || errors.Is(err, database.ErrConflict) || errors.Is(err, database.ErrNotFound) {
+			return err
+		}
+		time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+	}
+
+	return err
+}
+
+// processCheckout charges the stored order and sends its confirmation. The
+// order is stored as pending first so that a failed payment is recorded on
+// it. An order which is not pending anymore has been claimed already.
+func processCheckout(ctx context.Context, dbh database.DatabaseHandler, logger *slog.Logger, checkoutID string) (database.Checkout, error) {
+	checkout, err := dbh.GetCheckout(checkoutID)
+	if err != nil {
+		return checkout, err
+	}
+	if checkout.Payment.Status != database.PaymentPending {
+		return checkout, nil
+	}
+
+	checkout.Payment, err = chargeCheckout(ctx, dbh, logger, checkout)
+	if errors.Is(err, database.ErrConflict) {
+		// Another request or worker claimed it between the reads.
+		return dbh.GetCheckout(checkoutID)
+	}
+	if err != nil {
+		return checkout, err
+	}
+	notifyCustomer(logger, emailOrderConfirmation, checkout)
+
+	return checkout, nil
+}
+
+// paymentError converts the errors of the provider into the answer to the
+// customer. The order is kept as failed in every case.
+func paymentError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, payment.ErrDeclined):
+		return &httpError{status: http.StatusPaymentRequired, message: "Your payment was declined. Please try another card."}
+	case errors.Is(err, payment.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return &httpError{status: http.StatusGatewayTimeout, message: "The payment provider did not respond. You have not been charged, please try again later."}
+	default:
+		return &httpError{status: http.StatusBadGateway, message: "The payment could not be processed. You have not been charged, please try again later."}
+	}
+}
+
+// postAdminRefundEndpoint refunds the total of a paid order. The order is
+// claimed as refunding before the provider is called, so that two
+// concurrent refunds can't both reach the provider. It is paid again when
+// the provider fails, and stays refunding until the webhook of the provider
+// when the refund can't be recorded.
+func postAdminRefundEndpoint(c *gin.Context) {
+	dbh := databaseHandler(c)
+	logger := requestLoggerFrom(c)
+
+	checkout, err := dbh.GetCheckout(c.Param("checkout_id"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if checkout.Payment.Status != database.PaymentPaid {
+		c.Error(&httpError{status: http.StatusConflict, message: fmt.Sprintf("The order is %s, only paid orders can be refunded.", checkout.Payment.Status)})
+		return
+	}
+
+	paid := checkout.Payment
+	if err := dbh.UpdateCheckoutPayment(checkout.ID, database.Payment{Status: database.PaymentRefunding, ID: paid.ID}); err != nil {
+		if errors.Is(err, database.ErrConflict) {
+			err = &httpError{status: http.StatusConflict, message: "The order is being refunded or changed meanwhile."}
+		}
+		c.Error(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), paymentTimeout)
+	defer cancel()
+
+	if err := paymentGateway.Refund(ctx, paid.ID, checkout.Total); err != nil {
+		if dbErr := recordPayment(dbh, checkout.ID, paid); dbErr != nil {
+			logger.Error("failed to record the failed refund, waiting for the webhook of the provider", "checkout_id", checkout.ID, "payment_id", paid.ID, "error", dbErr.Error())
+		}
+		c.Error(&httpError{status: http.StatusBadGateway, message: "The refund failed: " + err.Error()})
+		return
+	}
+
+	checkout.Payment = database.Payment{Status: database.PaymentRefunded, ID: paid.ID}
+	if err := recordPayment(dbh, checkout.ID, checkout.Payment); err != nil {
+		logger.Error("failed to record the refund, waiting for the webhook of the provider", "checkout_id", checkout.ID, "payment_id", paid.ID, "error", err.Error())
+		c.Error(err)
+		return
+	}
+	notifyCustomer(logger, emailOrderStatus, checkout)
+
+	c.String(http.StatusOK, "Refunded %s of checkout %s.", checkout.Total, checkout.ID)
+}
+
+// postPaymentWebhookEndpoint receives the notifications of the provider about
+// payments which changed outside the checkout, like a capture confirmed later
+// or a refund made on the provider's dashboard.
+func postPaymentWebhookEndpoint(c *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, paymentWebhookMaxPayloadBytes))
+	if err != nil {
+		c.Error(&httpError{status: http.StatusBadRequest, message: "The payload could not be read."})
+		return
+	}
+
+	event, err := paymentGateway.VerifyWebhook(payload, c.GetHeader(paymentSignatureHeaderName))
+	if errors.Is(err, payment.ErrInvalidSignature) {
+		c.Error(&httpError{status: http.StatusUnauthorized, message: "The signature is invalid."})
+		return
+	}
+	if err != nil {
+		c.Error(&httpError{status: http.StatusBadRequest, message: "The payload is invalid."})
+		return
+	}
+
+	checkout, err := databaseHandler(c).GetCheckout(event.Reference)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if next, ok := paymentAfterEvent(checkout.Payment, event); ok {
+		if err := databaseHandler(c).UpdateCheckoutPayment(checkout.ID, next); err != nil {
+			c.Error(err)
+			return
+		}
+		checkout.Payment = next
+		notifyCustomer(requestLoggerFrom(c), emailOrderStatus, checkout)
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// paymentAfterEvent returns the payment of an order after a webhook event.
+// Events may arrive late or twice, so an event which would move the order
+// back, like a failure of a paid order, is ignored. The events settle the
+// orders left charging or refunding when their result couldn't be recorded.
+func paymentAfterEvent(current database.Payment, event payment.Event) (database.Payment, bool) {
+	switch {
+	case event.Type == payment.EventCaptured && (current.Status == database.PaymentPending || current.Status == database.PaymentCharging || current.Status == database.PaymentFailed):
+		return database.Payment{Status: database.PaymentPaid, ID: event.AuthorizationID}, true
+	case event.Type == payment.EventFailed && (current.Status == database.PaymentPending || current.Status == database.PaymentCharging):
+		return database.Payment{Status: database.PaymentFailed, ID: event.AuthorizationID, Error: event.Message}, true
+	case event.Type == payment.EventRefunded && (current.Status == database.PaymentPaid || current.Status == database.PaymentRefunding):
+		return database.Payment{Status: database.PaymentRefunded, ID: event.AuthorizationID}, true
+	default:
+		return current, false
+	}
+}
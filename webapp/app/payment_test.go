@@ -0,0 +1,198 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/mittz/role-play-webapp/webapp/payment"
+	"github.com/stretchr/testify/assert"
+)
+
+// paymentDatabaseHandler keeps the payments recorded on the orders.
+type paymentDatabaseHandler struct {
+	database.DevDatabaseHandler
+	payments map[string]database.Payment
+}
+
+func newPaymentDatabaseHandler() paymentDatabaseHandler {
+	return paymentDatabaseHandler{payments: make(map[string]database.Payment)}
+}
+
+func (dbh paymentDatabaseHandler) CreateCheckout(order database.CheckoutRequest) (string, error) {
+	dbh.payments["dummy-checkout"] = database.Payment{Status: database.PaymentPending}
+	return "dummy-checkout", nil
+}
+
+func (dbh paymentDatabaseHandler) GetCheckout(checkoutID string) (database.Checkout, error) {
+	payment, ok := dbh.payments[checkoutID]
+	if !ok {
+		return database.Checkout{}, database.NewNotFoundError("checkout", checkoutID)
+	}
+
+	checkout, _ := dbh.DevDatabaseHandler.GetCheckout(checkoutID)
+	checkout.Total = money.New(10000, "USD")
+	checkout.Payment = payment
+
+	return checkout, nil
+}
+
+// UpdateCheckoutPayment claims the orders only once like the databases.
+func (dbh paymentDatabaseHandler) UpdateCheckoutPayment(checkoutID string, payment database.Payment) error {
+	previous := dbh.payments[checkoutID].Status
+	if (payment.Status == database.PaymentCharging && previous != database.PaymentPending) || (payment.Status == database.PaymentRefunding && previous != database.PaymentPaid) {
+		return database.NewConflictError("the payment of checkout " + checkoutID + " is " + previous)
+	}
+
+	dbh.payments[checkoutID] = payment
+	return nil
+}
+
+var checkoutForm = url.Values{"product_id": {"1"}, "product_quantity": {"1"}}
+
+func TestPostCheckoutEndpointPayment(t *testing.T) {
+	dbh := newPaymentDatabaseHandler()
+	router := SetupRouter(dbh, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/checkout", checkoutForm, "")
+	assert.Equal(t, 202, w.Code)
+	assert.Contains(t, w.Body.String(), "Payment: paid")
+	assert.Equal(t, database.PaymentPaid, dbh.payments["dummy-checkout"].Status)
+	assert.NotEmpty(t, dbh.payments["dummy-checkout"].ID)
+
+	// Only the admin can refund it.
+	w = postForm(t, router, "/admin/checkouts/dummy-checkout/refund", url.Values{}, "")
+	assert.Equal(t, 401, w.Code)
+	assert.Equal(t, database.PaymentPaid, dbh.payments["dummy-checkout"].Status)
+
+	// The captured payment can be refunded once.
+	w = postAdminForm(t, router, "/admin/checkouts/dummy-checkout/refund", url.Values{}, "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, database.PaymentRefunded, dbh.payments["dummy-checkout"].Status)
+
+	w = postAdminForm(t, router, "/admin/checkouts/dummy-checkout/refund", url.Values{}, "")
+	assert.Equal(t, 409, w.Code)
+}
+
+func TestPostAdminRefundEndpointClaim(t *testing.T) {
+	dbh := newPaymentDatabaseHandler()
+	router := SetupRouter(dbh, testAssetsDir, testTemplatesDirMatch)
+
+	// An order claimed by another refund isn't refunded twice.
+	dbh.payments["dummy-checkout"] = database.Payment{Status: database.PaymentRefunding, ID: "fake_1"}
+	w := postAdminForm(t, router, "/admin/checkouts/dummy-checkout/refund", url.Values{}, "")
+	assert.Equal(t, 409, w.Code)
+	assert.Equal(t, database.PaymentRefunding, dbh.payments["dummy-checkout"].Status)
+
+	// An order whose refund failed at the provider is paid again.
+	dbh.payments["dummy-checkout"] = database.Payment{Status: database.PaymentPaid, ID: "fake_unknown"}
+	w = postAdminForm(t, router, "/admin/checkouts/dummy-checkout/refund", url.Values{}, "")
+	assert.Equal(t, 502, w.Code)
+	assert.Equal(t, database.Payment{Status: database.PaymentPaid, ID: "fake_unknown"}, dbh.payments["dummy-checkout"])
+}
+
+func TestProcessCheckoutClaimed(t *testing.T) {
+	dbh := newPaymentDatabaseHandler()
+
+	// An order charged by another request or worker isn't charged again.
+	dbh.payments["dummy-checkout"] = database.Payment{Status: database.PaymentCharging}
+	checkout, err := processCheckout(context.Background(), dbh, slog.Default(), "dummy-checkout")
+	assert.Nil(t, err)
+	assert.Equal(t, database.PaymentCharging, checkout.Payment.Status)
+	assert.Equal(t, database.Payment{Status: database.PaymentCharging}, dbh.payments["dummy-checkout"])
+}
+
+func TestPostCheckoutEndpointPaymentDeclined(t *testing.T) {
+	os.Setenv("PAYMENT_FAKE_MODE", "decline")
+	defer os.Unsetenv("PAYMENT_FAKE_MODE")
+
+	dbh := newPaymentDatabaseHandler()
+	router := SetupRouter(dbh, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/checkout", checkoutForm, "")
+	assert.Equal(t, 402, w.Code)
+	assert.Contains(t, w.Body.String(), "Your payment was declined.")
+	assert.Equal(t, database.PaymentFailed, dbh.payments["dummy-checkout"].Status)
+	assert.Contains(t, dbh.payments["dummy-checkout"].Error, "declined")
+}
+
+func TestPostCheckoutEndpointPaymentTimeout(t *testing.T) {
+	os.Setenv("PAYMENT_FAKE_MODE", "timeout")
+	os.Setenv("PAYMENT_TIMEOUT", "10ms")
+	defer os.Unsetenv("PAYMENT_FAKE_MODE")
+	defer os.Unsetenv("PAYMENT_TIMEOUT")
+
+	dbh := newPaymentDatabaseHandler()
+	router := SetupRouter(dbh, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/checkout", checkoutForm, "application/json")
+	assert.Equal(t, 504, w.Code)
+	assert.Contains(t, w.Body.String(), "did not respond")
+	assert.Equal(t, database.PaymentFailed, dbh.payments["dummy-checkout"].Status)
+}
+
+func TestPostPaymentWebhookEndpoint(t *testing.T) {
+	secret := []byte("webhook-secret")
+	os.Setenv("PAYMENT_WEBHOOK_SECRET", string(secret))
+	os.Setenv("CSRF_STRICT", "true")
+	defer os.Unsetenv("PAYMENT_WEBHOOK_SECRET")
+	defer os.Unsetenv("CSRF_STRICT")
+
+	dbh := newPaymentDatabaseHandler()
+	dbh.payments["dummy-checkout"] = database.Payment{Status: database.PaymentPending}
+	router := SetupRouter(dbh, testAssetsDir, testTemplatesDirMatch)
+
+	postEvent := func(event payment.Event, signature string) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(event)
+		if signature == "" {
+			signature = payment.Sign(secret, payload)
+		}
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", paymentWebhookPath, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(paymentSignatureHeaderName, signature)
+		router.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := postEvent(payment.Event{Type: payment.EventCaptured, AuthorizationID: "fake_1", Reference: "dummy-checkout"}, "invalid")
+	assert.Equal(t, 401, w.Code)
+	assert.Equal(t, database.PaymentPending, dbh.payments["dummy-checkout"].Status)
+
+	w = postEvent(payment.Event{Type: payment.EventCaptured, AuthorizationID: "fake_1", Reference: "dummy-checkout"}, "")
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, database.Payment{Status: database.PaymentPaid, ID: "fake_1"}, dbh.payments["dummy-checkout"])
+
+	// A late failure doesn't move a paid order back.
+	w = postEvent(payment.Event{Type: payment.EventFailed, AuthorizationID: "fake_1", Reference: "dummy-checkout"}, "")
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, database.PaymentPaid, dbh.payments["dummy-checkout"].Status)
+
+	w = postEvent(payment.Event{Type: payment.EventRefunded, AuthorizationID: "fake_1", Reference: "dummy-checkout"}, "")
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, database.PaymentRefunded, dbh.payments["dummy-checkout"].Status)
+
+	// The orders left charging or refunding are settled by the events.
+	dbh.payments["dummy-checkout"] = database.Payment{Status: database.PaymentCharging}
+	w = postEvent(payment.Event{Type: payment.EventCaptured, AuthorizationID: "fake_1", Reference: "dummy-checkout"}, "")
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, database.PaymentPaid, dbh.payments["dummy-checkout"].Status)
+
+	dbh.payments["dummy-checkout"] = database.Payment{Status: database.PaymentRefunding, ID: "fake_1"}
+	w = postEvent(payment.Event{Type: payment.EventRefunded, AuthorizationID: "fake_1", Reference: "dummy-checkout"}, "")
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, database.PaymentRefunded, dbh.payments["dummy-checkout"].Status)
+
+	w = postEvent(payment.Event{Type: payment.EventCaptured, AuthorizationID: "fake_2", Reference: "unknown"}, "")
+	assert.Equal(t, 404, w.Code)
+}
@@ -0,0 +1,79 @@
+package app
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+const displayCurrencyCookieName = "currency"
+
+var (
+	storeCurrency    string
+	currencyRates    money.Rates
+	taxRates         map[string]float64
+	defaultTaxRegion string
+)
+
+// displayCurrency returns the currency the customer chose to see prices in.
+// A currency given by ?currency= is remembered in a cookie. Orders are still
+// charged in the currency of the catalog.
+func displayCurrency(c *gin.Context) string {
+	if currency, ok := c.GetQuery("currency"); ok {
+		if !isDisplayCurrency(currency) {
+			currency = storeCurrency
+		}
+		http.SetCookie(c.Writer, &http.Cookie{Name: displayCurrencyCookieName, Value: currency, Path: "/", SameSite: http.SameSiteLaxMode})
+
+		return currency
+	}
+
+	if currency, err := c.Cookie(displayCurrencyCookieName); err == nil && isDisplayCurrency(currency) {
+		return currency
+	}
+
+	return storeCurrency
+}
+
+func isDisplayCurrency(currency string) bool {
+	_, ok := currencyRates.Rates[currency]
+	return currency == storeCurrency || ok
+}
+
+// taxRegions returns the regions for the select box of the checkout form.
+func taxRegions() []string {
+	regions := make([]string, 0, len(taxRates))
+	for region := range taxRates {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	return regions
+}
+
+// templateFuncs are the helpers to show amounts in the templates.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// convert shows m in the display currency, or nothing when it is
+		// already in that currency or there is no rate.
+		"convert": func(m money.Money, currency string) string {
+			if currency == "" || currency == m.Currency {
+				return ""
+			}
+
+			converted, ok := currencyRates.Convert(m, currency)
+			if !ok {
+				return ""
+			}
+
+			return "≈ " + converted.String()
+		},
+		// amount shows minor units like the coupon values in the store currency.
+		"amount": func(amount int) string {
+			return money.New(int64(amount), storeCurrency).String()
+		},
+	}
+}
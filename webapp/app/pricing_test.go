@@ -0,0 +1,76 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingDatabaseHandler keeps the last order passed to CreateCheckout.
+type recordingDatabaseHandler struct {
+	database.DevDatabaseHandler
+	order *database.CheckoutRequest
+}
+
+func (dbh recordingDatabaseHandler) CreateCheckout(order database.CheckoutRequest) (string, error) {
+	*dbh.order = order
+	return "dummy-checkout", nil
+}
+
+func TestDisplayCurrency(t *testing.T) {
+	os.Setenv("CURRENCY_RATES", "EUR=0.5")
+	defer os.Unsetenv("CURRENCY_RATES")
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/products?currency=EUR", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "$100.00")
+	assert.Contains(t, w.Body.String(), "≈ €50.00")
+	assert.Contains(t, w.Header().Values("Set-Cookie"), "currency=EUR; Path=/; SameSite=Lax")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/product/1", nil)
+	req.AddCookie(&http.Cookie{Name: displayCurrencyCookieName, Value: "EUR"})
+	router.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), "≈ €50.00")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/products?currency=XYZ", nil)
+	router.ServeHTTP(w, req)
+
+	assert.NotContains(t, w.Body.String(), "≈")
+}
+
+func TestPostCheckoutEndpointTaxRegion(t *testing.T) {
+	os.Setenv("TAX_RATES", "US-CA=7.25,DE=19")
+	os.Setenv("DEFAULT_TAX_REGION", "US-CA")
+	defer os.Unsetenv("TAX_RATES")
+	defer os.Unsetenv("DEFAULT_TAX_REGION")
+
+	var order database.CheckoutRequest
+	router := SetupRouter(recordingDatabaseHandler{order: &order}, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}}, "")
+	assert.Equal(t, 202, w.Code)
+	assert.Equal(t, "US-CA", order.TaxRegion)
+	assert.Equal(t, 7.25, order.TaxRate)
+
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}, "tax_region": {"DE"}}, "")
+	assert.Equal(t, 202, w.Code)
+	assert.Equal(t, "DE", order.TaxRegion)
+	assert.Equal(t, 19.0, order.TaxRate)
+
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}, "tax_region": {"XX"}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `name="tax_region"`)
+	assert.Contains(t, w.Body.String(), "Choose a region from the list.")
+}
@@ -0,0 +1,168 @@
+package app
+
+import (
+	"expvar"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// Counters published on /debug/vars.
+var (
+	rejectedRequests = expvar.NewMap("rejected_requests")
+	inFlightRequests = expvar.NewInt("in_flight_requests")
+)
+
+// idleLimiterTTL is how long the bucket of a client is kept after its last request.
+const idleLimiterTTL = 10 * time.Minute
+
+type rateLimit struct {
+	RPS   float64
+	Burst int
+}
+
+// keyedLimiter holds one token bucket per client.
+type keyedLimiter struct {
+	limit rateLimit
+
+	mu          sync.Mutex
+	buckets     map[string]*bucket
+	lastCleanup time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newKeyedLimiter(limit rateLimit) *keyedLimiter {
+	return &keyedLimiter{limit: limit, buckets: make(map[string]*bucket), lastCleanup: time.Now()}
+}
+
+// allow takes a token from the bucket of the key. When the bucket is empty,
+// it returns how long the client should wait.
+func (l *keyedLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastCleanup) > idleLimiterTTL {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastSeen) > idleLimiterTTL {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(l.limit.RPS), l.limit.Burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return false, time.Second
+	}
+
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+
+	return true, 0
+}
+
+type rateLimitConfig struct {
+	// Routes maps "METHOD /path" to its own limit. Other pages use Default.
+	// A limit with RPS 0 is not applied.
+	Routes  map[string]rateLimit
+	Default rateLimit
+}
+
+// rateLimiter rejects clients which send more requests than their route
+// allows with 429. Clients are told apart by their IP. Static assets and
+// the requests with the admin credentials are not limited.
+func rateLimiter(cfg rateLimitConfig) gin.HandlerFunc {
+	limiters := make(map[string]*keyedLimiter)
+	for route, limit := range cfg.Routes {
+		if limit.RPS > 0 {
+			limiters[route] = newKeyedLimiter(limit)
+		}
+	}
+
+	var defaultLimiter *keyedLimiter
+	if cfg.Default.RPS > 0 {
+		defaultLimiter = newKeyedLimiter(cfg.Default)
+	}
+
+	return func(c *gin.Context) {
+		if strings.HasPrefix(c.FullPath(), "/assets/") || c.FullPath() == "/favicon.ico" || isAdmin(c.Request) {
+			c.Next()
+			return
+		}
+
+		route := c.Request.Method + " " + c.FullPath()
+		limiter, ok := limiters[route]
+		if !ok {
+			limiter = defaultLimiter
+		}
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		if ok, retryAfter := limiter.allow(c.ClientIP(), time.Now()); !ok {
+			rejectedRequests.Add("rate_limit "+route, 1)
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.Error(&httpError{status: http.StatusTooManyRequests, message: "Too many requests. Please slow down and try again."})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// loadShedder answers 503 right away while more than maxInFlight requests
+// are being served, instead of letting them queue up for the database.
+// The routes of exemptPaths are long-lived streams which are not counted.
+func loadShedder(maxInFlight int64, exemptPaths ...string) gin.HandlerFunc {
+	var inFlight int64
+	exempt := make(map[string]bool)
+	for _, path := range exemptPaths {
+		exempt[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if maxInFlight <= 0 || exempt[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		current := atomic.AddInt64(&inFlight, 1)
+		inFlightRequests.Add(1)
+		defer func() {
+			atomic.AddInt64(&inFlight, -1)
+			inFlightRequests.Add(-1)
+		}()
+
+		if current > maxInFlight {
+			rejectedRequests.Add("load_shed", 1)
+			c.Header("Retry-After", "1")
+			c.Error(&httpError{status: http.StatusServiceUnavailable, message: "The shop is busy. Please try again in a moment."})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedLimiter(t *testing.T) {
+	limiter := newKeyedLimiter(rateLimit{RPS: 1, Burst: 2})
+	now := time.Now()
+
+	ok, _ := limiter.allow("10.0.0.1", now)
+	assert.True(t, ok)
+	ok, _ = limiter.allow("10.0.0.1", now)
+	assert.True(t, ok)
+	ok, retryAfter := limiter.allow("10.0.0.1", now)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// Other clients have their own bucket.
+	ok, _ = limiter.allow("10.0.0.2", now)
+	assert.True(t, ok)
+
+	ok, _ = limiter.allow("10.0.0.1", now.Add(time.Second))
+	assert.True(t, ok)
+}
+
+func TestRateLimiterCheckout(t *testing.T) {
+	t.Setenv("RATE_LIMIT_CHECKOUT_RPS", "0.1")
+	t.Setenv("RATE_LIMIT_CHECKOUT_BURST", "1")
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := postCheckoutForm(t, router, "1", "1", "")
+	assert.Equal(t, 202, w.Code)
+
+	w = postCheckoutForm(t, router, "1", "1", "")
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+
+	// Pages without their own limit are not limited by default.
+	for i := 0; i < 3; i++ {
+		w = httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/products", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+	}
+}
+
+func TestRateLimiterDefault(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	// The scoring server sends every request from one address without
+	// credentials, so the limits are disabled unless they are set.
+	for i := 0; i < 50; i++ {
+		w := postCheckoutForm(t, router, "1", "1", "")
+		assert.Equal(t, 202, w.Code)
+	}
+}
+
+func TestRateLimiterClientIP(t *testing.T) {
+	t.Setenv("RATE_LIMIT_CHECKOUT_RPS", "0.1")
+	t.Setenv("RATE_LIMIT_CHECKOUT_BURST", "1")
+	post := func(router http.Handler, remoteAddr, forwardedFor string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/checkout", strings.NewReader("product_id=1&product_quantity=1"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// X-Forwarded-For is ignored without trusted proxies, so a client can't
+	// get a new bucket by sending another one.
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	assert.Equal(t, 202, post(router, "192.0.2.1:1234", "198.51.100.1"))
+	assert.Equal(t, 429, post(router, "192.0.2.1:1234", "198.51.100.2"))
+
+	// Behind a trusted proxy, the clients are told apart by X-Forwarded-For.
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+	router = SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	assert.Equal(t, 202, post(router, "10.0.0.1:1234", "198.51.100.1"))
+	assert.Equal(t, 202, post(router, "10.0.0.1:1234", "198.51.100.2"))
+	assert.Equal(t, 429, post(router, "10.0.0.2:1234", "198.51.100.1"))
+}
+
+func TestRateLimiterAdmin(t *testing.T) {
+	t.Setenv("RATE_LIMIT_CHECKOUT_RPS", "0.1")
+	t.Setenv("RATE_LIMIT_CHECKOUT_BURST", "1")
+	t.Setenv("ADMIN_PASSWORD", testAdminPassword)
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	// The admins are not limited.
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/checkout", strings.NewReader("product_id=1&product_quantity=1"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", testAdminPassword)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 202, w.Code)
+	}
+
+	// Wrong credentials are limited.
+	for _, want := range []int{202, 429} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/checkout", strings.NewReader("product_id=1&product_quantity=1"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", "wrong")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, want, w.Code)
+	}
+}
+
+func TestLoadShedder(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	router := gin.New()
+	router.Use(errorHandler(), loadShedder(1, "/stream"))
+	router.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+	router.GET("/fast", func(c *gin.Context) {
+		c.String(http.StatusOK, "done")
+	})
+	router.GET("/stream", func(c *gin.Context) {
+		c.String(http.StatusOK, "done")
+	})
+
+	slow := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		req, _ := http.NewRequest("GET", "/slow", nil)
+		router.ServeHTTP(slow, req)
+		close(finished)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/fast", nil)
+	req.Header.Set("Accept", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// The exempt streams are served while the others are shed.
+	w = httptest.NewRecorder()
+	streamReq, _ := http.NewRequest("GET", "/stream", nil)
+	router.ServeHTTP(w, streamReq)
+	assert.Equal(t, 200, w.Code)
+
+	close(release)
+	<-finished
+	assert.Equal(t, 200, slow.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+}
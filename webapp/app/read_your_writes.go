@@ -0,0 +1,65 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lastWriteCookieName keeps the time of the last order of the user in
+// milliseconds. The instance which wrote the order reads it back from the
+// primary, but the next request of the user may land on another instance,
+// which reads the orders of the user from the primary as well while the
+// cookie is recent.
+const lastWriteCookieName = "last_write"
+
+var readYourWritesWindow time.Duration
+
+// userWriteRememberer is implemented by the database handlers which read
+// from replicas.
+type userWriteRememberer interface {
+	RememberUserWrite(userID int, at time.Time)
+}
+
+// readYourWrites passes the time of the last write of the cookie to the
+// database handler.
+func readYourWrites() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rememberer, ok := dbHandler.(userWriteRememberer)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		if value, err := c.Cookie(lastWriteCookieName); err == nil {
+			if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+				rememberer.RememberUserWrite(getUserID(), time.UnixMilli(millis))
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// rememberUserWrite sets the cookie after an order of the user is written,
+// or after the user learns that one was written by a worker.
+func rememberUserWrite(c *gin.Context, userID int) {
+	rememberer, ok := dbHandler.(userWriteRememberer)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	rememberer.RememberUserWrite(userID, now)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     lastWriteCookieName,
+		Value:    strconv.FormatInt(now.UnixMilli(), 10),
+		Path:     "/",
+		MaxAge:   int(readYourWritesWindow.Seconds()) + 1,
+		Secure:   isTLS(c.Request),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
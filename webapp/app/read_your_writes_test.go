@@ -0,0 +1,57 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeRememberingDatabaseHandler keeps the writes passed to RememberUserWrite.
+type writeRememberingDatabaseHandler struct {
+	database.DevDatabaseHandler
+	writes map[int]time.Time
+}
+
+func (dbh writeRememberingDatabaseHandler) RememberUserWrite(userID int, at time.Time) {
+	dbh.writes[userID] = at
+}
+
+func TestReadYourWritesCookie(t *testing.T) {
+	dbh := writeRememberingDatabaseHandler{writes: make(map[int]time.Time)}
+	router := SetupRouter(dbh, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/checkouts", nil)
+	router.ServeHTTP(w, req)
+	assert.Empty(t, dbh.writes, "no write without the cookie")
+
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}}, "")
+	assert.Equal(t, 202, w.Code)
+	assert.Contains(t, dbh.writes, getUserID())
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == lastWriteCookieName {
+			cookie = c
+		}
+	}
+	if assert.NotNil(t, cookie) {
+		assert.True(t, cookie.HttpOnly)
+		assert.Equal(t, strconv.FormatInt(dbh.writes[getUserID()].UnixMilli(), 10), cookie.Value)
+	}
+
+	// Another instance gets the write from the cookie.
+	delete(dbh.writes, getUserID())
+	at := time.Now().Add(-time.Second).Truncate(time.Millisecond)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/checkouts", nil)
+	req.AddCookie(&http.Cookie{Name: lastWriteCookieName, Value: strconv.FormatInt(at.UnixMilli(), 10)})
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, at.Equal(dbh.writes[getUserID()]))
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/recommend"
+	"github.com/mittz/role-play-webapp/webapp/utils"
+)
+
+// recommendationsLimit is the number of products shown in a block of
+// recommendations.
+const recommendationsLimit = 4
+
+var recommendations *recommend.Refresher
+
+// recommendationBlock is a titled list of recommended products.
+type recommendationBlock struct {
+	Title    string
+	Products []database.Product
+}
+
+// StartRecommendations computes the recommendations in the background until
+// the app stops. The blocks are empty until the first refresh.
+func StartRecommendations() {
+	go recommendations.Run(context.Background())
+}
+
+func newRecommendations(dbh database.DatabaseHandler) *recommend.Refresher {
+	return recommend.NewRefresher(dbh, recommend.Options{
+		Interval:  utils.GetEnvRecommendationsRefreshInterval(),
+		MinBuyers: utils.GetEnvRecommendationsMinBuyers(),
+	}, slog.Default())
+}
+
+// popularProducts returns the best sellers. Until products are sold they
+// are the best rated products instead.
+func popularProducts(exclude ...int) recommendationBlock {
+	snapshot := recommendations.Snapshot()
+	title := "Best sellers"
+	if len(snapshot.BestSellers(1, exclude...)) == 0 {
+		title = "Top rated"
+	}
+
+	return recommendationBlock{Title: title, Products: snapshot.Popular(recommendationsLimit, exclude...)}
+}
+
+// productRecommendations returns the products bought by the buyers of the
+// product, or the popular products when it isn't bought often enough with
+// others.
+func productRecommendations(productID int) recommendationBlock {
+	products := recommendations.Snapshot().AlsoBought(productID, recommendationsLimit)
+	if len(products) == 0 {
+		return popularProducts(productID)
+	}
+
+	return recommendationBlock{Title: "Customers also bought", Products: products}
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// noPurchasesDatabaseHandler has no paid checkouts yet.
+type noPurchasesDatabaseHandler struct {
+	database.DevDatabaseHandler
+}
+
+func (dbh noPurchasesDatabaseHandler) GetPurchaseStats() ([]database.PurchaseStat, error) {
+	return nil, nil
+}
+
+func TestGetProductEndpointRecommendations(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	// Nothing is shown until the first refresh.
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/product/1", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), `class="recommendations"`)
+
+	assert.Nil(t, recommendations.RunOnce())
+
+	// Both users who bought product1 bought product2 too.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/product/1", nil)
+	router.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "<h5> Customers also bought </h5>")
+	assert.Contains(t, w.Body.String(), `<a href="/product/2" class="recommendation">`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/products", nil)
+	router.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), "<h5> Best sellers </h5>")
+	assert.Contains(t, w.Body.String(), `<a href="/product/1" class="recommendation">`)
+}
+
+func TestGetProductEndpointRecommendationsWithoutPurchases(t *testing.T) {
+	router := SetupRouter(noPurchasesDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+	assert.Nil(t, recommendations.RunOnce())
+
+	// The best rated products are shown instead, without the product itself.
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/product/1", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "<h5> Top rated </h5>")
+	assert.Contains(t, w.Body.String(), `<a href="/product/2" class="recommendation">`)
+	assert.NotContains(t, w.Body.String(), `<a href="/product/1" class="recommendation">`)
+}
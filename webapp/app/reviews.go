@@ -0,0 +1,135 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+)
+
+// productReviewsLimit is the number of the latest reviews shown on the
+// product page.
+const productReviewsLimit = 20
+
+// adminReviewsLimit is the number of the latest reviews shown to admins.
+const adminReviewsLimit = 100
+
+// The orders of the catalog chosen by the sort query.
+const (
+	productSortFeatured = "featured"
+	productSortRating   = "rating"
+)
+
+// reviewActions are the moderation actions of the admin page and the status
+// each of them sets.
+var reviewActions = map[string]string{
+	"flag":    database.ReviewFlagged,
+	"hide":    database.ReviewHidden,
+	"restore": database.ReviewVisible,
+}
+
+// sortProducts orders the catalog in place. The best rated products come
+// first with the rating order and ties go to the product with more reviews.
+// Other orders keep the order of the database.
+func sortProducts(products []database.Product, order string) {
+	if order != productSortRating {
+		return
+	}
+
+	sort.SliceStable(products, func(i, j int) bool {
+		a, b := products[i].Rating, products[j].Rating
+		if a.Average() != b.Average() {
+			return a.Average() > b.Average()
+		}
+		return a.Count > b.Count
+	})
+}
+
+func postProductReviewEndpoint(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("product_id"))
+	if err != nil {
+		c.Error(database.NewValidationError("product_id must be an integer"))
+		return
+	}
+
+	product, err := databaseHandler(c).GetProduct(productID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// The rating is checked with the body by Review.Validate.
+	rating, _ := strconv.Atoi(c.PostForm("rating"))
+	_, err = databaseHandler(c).CreateReview(database.Review{
+		ProductID: productID,
+		User:      database.User{ID: getUserID()},
+		Rating:    rating,
+		Body:      c.PostForm("body"),
+	})
+	if err == nil {
+		c.Redirect(http.StatusSeeOther, fmt.Sprintf("/product/%d#reviews", productID))
+		return
+	}
+
+	var dbErr *database.Error
+	if !errors.As(err, &dbErr) || c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.Error(err)
+		return
+	}
+
+	fields := dbErr.Fields
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, database.ErrConflict):
+		fields = map[string]string{"review": dbErr.Message}
+		status = http.StatusConflict
+	case errors.Is(err, database.ErrValidation) && fields == nil:
+		fields = map[string]string{"review": dbErr.Message}
+	case !errors.Is(err, database.ErrValidation):
+		c.Error(err)
+		return
+	}
+
+	renderProduct(c, status, product, fields)
+}
+
+func getAdminReviewsEndpoint(c *gin.Context) {
+	status := c.Query("status")
+	reviews, err := databaseHandler(c).GetReviews(status, adminReviewsLimit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin_reviews.html", gin.H{
+		"title":     "Reviews",
+		"reviews":   reviews,
+		"status":    status,
+		"csrfToken": csrfToken(c),
+	})
+}
+
+func postAdminReviewStatusEndpoint(c *gin.Context) {
+	status, ok := reviewActions[c.Param("action")]
+	if !ok {
+		c.Error(database.NewNotFoundError("page", c.Request.URL.Path))
+		return
+	}
+
+	if err := databaseHandler(c).UpdateReviewStatus(c.Param("review_id"), status); err != nil {
+		c.Error(err)
+		return
+	}
+
+	// Go back to the list the admin was looking at.
+	location := "/admin/reviews"
+	if filter := c.PostForm("status"); filter != "" {
+		location += "?" + url.Values{"status": {filter}}.Encode()
+	}
+	c.Redirect(http.StatusSeeOther, location)
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// reviewDatabaseHandler has been reviewed by the user for product 2 only.
+type reviewDatabaseHandler struct {
+	database.DevDatabaseHandler
+}
+
+func (dbh reviewDatabaseHandler) CreateReview(review database.Review) (string, error) {
+	if err := review.Validate(); err != nil {
+		return "", err
+	}
+	if review.ProductID == 2 {
+		return "", database.NewConflictError("You have already reviewed this product.")
+	}
+
+	return "review3", nil
+}
+
+func TestSortProducts(t *testing.T) {
+	products := []database.Product{
+		{ID: 1, Rating: database.Rating{}},
+		{ID: 2, Rating: database.Rating{Count: 1, Total: 4}},
+		{ID: 3, Rating: database.Rating{Count: 2, Total: 10}},
+		{ID: 4, Rating: database.Rating{Count: 3, Total: 12}},
+	}
+
+	sortProducts(products, productSortFeatured)
+	assert.Equal(t, 1, products[0].ID)
+
+	sortProducts(products, productSortRating)
+	var ids []int
+	for _, product := range products {
+		ids = append(ids, product.ID)
+	}
+	assert.Equal(t, []int{3, 4, 2, 1}, ids)
+}
+
+func TestGetProductsEndpointSortByRating(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/products?sort=rating", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, "4.0 / 5 (1 reviews)")
+	assert.Contains(t, body, `<option value="rating" selected>`)
+	assert.Less(t, strings.Index(body, "/product/1"), strings.Index(body, "/product/2"))
+}
+
+func TestGetProductEndpointReviews(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/product/1", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "4.0 / 5 (1 reviews)")
+	assert.Contains(t, w.Body.String(), "Keeps good time.")
+	assert.Contains(t, w.Body.String(), `action="/product/1/reviews"`)
+}
+
+func TestPostProductReviewEndpoint(t *testing.T) {
+	router := SetupRouter(reviewDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/product/1/reviews", url.Values{"rating": {"5"}, "body": {"Keeps good time."}}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/product/1#reviews", w.Header().Get("Location"))
+
+	w = postForm(t, router, "/product/1/reviews", url.Values{"rating": {"9"}, "body": {"Too good."}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Rating must be 1 to 5 stars.")
+	assert.Contains(t, w.Body.String(), "Too good.")
+
+	w = postForm(t, router, "/product/2/reviews", url.Values{"rating": {"1"}, "body": {"Once more."}}, "")
+	assert.Equal(t, 409, w.Code)
+	assert.Contains(t, w.Body.String(), "You have already reviewed this product.")
+	assert.Contains(t, w.Body.String(), `<option value="1" selected>`)
+
+	w = postForm(t, router, "/product/1/reviews", url.Values{"rating": {"5"}}, "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"body": "Review must be 1 to 2000 characters."}}}`, w.Body.String())
+}
+
+func TestGetAdminReviewsEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/reviews?status=flagged", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `<td class="review_body">Buy it elsewhere!</td>`)
+	assert.Contains(t, w.Body.String(), `action="/admin/reviews/review2/hide"`)
+	assert.Contains(t, w.Body.String(), `action="/admin/reviews/review2/restore"`)
+	assert.NotContains(t, w.Body.String(), `action="/admin/reviews/review2/flag"`)
+	assert.Contains(t, w.Body.String(), `<option value="flagged" selected>`)
+}
+
+func TestPostAdminReviewStatusEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := postAdminForm(t, router, "/admin/reviews/review2/hide", url.Values{"status": {"flagged"}}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/admin/reviews?status=flagged", w.Header().Get("Location"))
+
+	w = postAdminForm(t, router, "/admin/reviews/review2/delete", url.Values{}, "")
+	assert.Equal(t, 404, w.Code)
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+)
+
+const (
+	// salesReportDefaultDays is the window of the dashboard when none is given.
+	salesReportDefaultDays = 30
+	// salesReportTopLimit is the number of top products and customers shown
+	// on the dashboard. The CSV exports have all of them.
+	salesReportTopLimit = 10
+)
+
+// salesReportPresets are the windows the dashboard links to, in days.
+var salesReportPresets = []int{7, 30, 90, 365}
+
+// salesWindow is the window of a report as shown in the form, from the day
+// From to the day To included.
+type salesWindow struct {
+	From string
+	To   string
+}
+
+// parseSalesWindow reads the window of the report from the from and to days
+// of the query, or from the number of days until today. It returns the first
+// day and the day after the last one.
+func parseSalesWindow(c *gin.Context) (time.Time, time.Time, salesWindow, map[string]string) {
+	fields := make(map[string]string)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	days := salesReportDefaultDays
+	if val := strings.TrimSpace(c.Query("days")); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 1 || n > database.SalesReportMaxDays {
+			fields["days"] = fmt.Sprintf("Days must be a number from 1 to %d.", database.SalesReportMaxDays)
+		} else {
+			days = n
+		}
+	}
+
+	to := today
+	if val := strings.TrimSpace(c.Query("to")); val != "" {
+		day, err := time.Parse(database.SalesDayLayout, val)
+		if err != nil {
+			fields["to"] = "The end must be a date like 2006-01-02."
+		} else {
+			to = day
+		}
+	}
+
+	from := to.AddDate(0, 0, 1-days)
+	if val := strings.TrimSpace(c.Query("from")); val != "" {
+		day, err := time.Parse(database.SalesDayLayout, val)
+		if err != nil {
+			fields["from"] = "The start must be a date like 2006-01-02."
+		} else {
+			from = day
+		}
+	}
+
+	window := salesWindow{From: from.Format(database.SalesDayLayout), To: to.Format(database.SalesDayLayout)}
+	return from, to.AddDate(0, 0, 1), window, fields
+}
+
+// salesReport returns the report of the window of the query. The reports are
+// in the store currency which the orders are charged in.
+func salesReport(c *gin.Context, limit int) (database.SalesReport, salesWindow, error) {
+	from, to, window, fields := parseSalesWindow(c)
+	if len(fields) > 0 {
+		return database.SalesReport{}, window, database.NewFieldValidationError(fields)
+	}
+
+	report, err := databaseHandler(c).GetSalesReport(from, to, storeCurrency, limit)
+	return report, window, err
+}
+
+func getAdminSalesEndpoint(c *gin.Context) {
+	report, window, err := salesReport(c, salesReportTopLimit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin_sales.html", gin.H{
+		"title":   "Sales",
+		"report":  report,
+		"window":  window,
+		"presets": salesReportPresets,
+	})
+}
+
+// salesCSVRows are the rows of the report each CSV export has.
+var salesCSVRows = map[string]func(report database.SalesReport) [][]string{
+	"days": func(report database.SalesReport) [][]string {
+		rows := [][]string{{"day", "orders", "units", "revenue", "currency"}}
+		for _, sales := range report.Days {
+			rows = append(rows, salesCSVRow(sales.SalesTotals, sales.Day.Format(database.SalesDayLayout)))
+		}
+		return rows
+	},
+	"products": func(report database.SalesReport) [][]string {
+		rows := [][]string{{"product_id", "product_name", "orders", "units", "revenue", "currency"}}
+		for _, sales := range report.TopProducts {
+			rows = append(rows, salesCSVRow(sales.SalesTotals, strconv.Itoa(sales.Product.ID), csvText(sales.Product.Name)))
+		}
+		return rows
+	},
+	"customers": func(report database.SalesReport) [][]string {
+		rows := [][]string{{"user_id", "user_name", "orders", "units", "revenue", "currency"}}
+		for _, sales := range report.TopCustomers {
+			rows = append(rows, salesCSVRow(sales.SalesTotals, strconv.Itoa(sales.User.ID), csvText(sales.User.Name)))
+		}
+		return rows
+	},
+}
+
+// salesCSVRow returns the columns followed by the totals. The revenue is in
+// major units like 350.00.
+func salesCSVRow(totals database.SalesTotals, columns ...string) []string {
+	return append(columns, strconv.Itoa(totals.Orders), strconv.Itoa(totals.Units), totals.Revenue.Decimal(), totals.Revenue.Currency)
+}
+
+// getAdminSalesCSVEndpoint exports the rows of a part of the report, by day,
+// by product or by customer, for the same window as the dashboard.
+func getAdminSalesCSVEndpoint(c *gin.Context) {
+	name := c.DefaultQuery("rows", "days")
+	rowsOf, ok := salesCSVRows[name]
+	if !ok {
+		c.Error(database.NewFieldValidationError(map[string]string{"rows": "Rows must be days, products or customers."}))
+		return
+	}
+
+	report, window, err := salesReport(c, 0)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="sales-%s-%s-%s.csv"`, name, window.From, window.To))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	if err := w.WriteAll(rowsOf(report)); err != nil {
+		requestLoggerFrom(c).Error("sales export failed", "error", err.Error())
+	}
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSalesWindow(t *testing.T) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	for _, tt := range []struct {
+		query  string
+		from   time.Time
+		to     time.Time
+		fields []string
+	}{
+		{"", today.AddDate(0, 0, -29), today.AddDate(0, 0, 1), nil},
+		{"days=7", today.AddDate(0, 0, -6), today.AddDate(0, 0, 1), nil},
+		{"from=2026-09-01&to=2026-09-30", time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), nil},
+		{"days=0&from=yesterday&to=2026-13-01", today.AddDate(0, 0, -29), today.AddDate(0, 0, 1), []string{"days", "from", "to"}},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request, _ = http.NewRequest("GET", "/admin/sales?"+tt.query, nil)
+
+		from, to, _, fields := parseSalesWindow(c)
+		assert.Equal(t, tt.from, from, tt.query)
+		assert.Equal(t, tt.to, to, tt.query)
+		assert.Equal(t, len(tt.fields), len(fields), tt.query)
+		for _, field := range tt.fields {
+			assert.Contains(t, fields, field, tt.query)
+		}
+	}
+}
+
+func TestGetAdminSalesEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/sales?from=2026-10-01&to=2026-10-07", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `<p class="card-text sales_revenue"> $500.00 </p>`)
+	assert.Contains(t, w.Body.String(), `<p class="card-text sales_orders"> 3 </p>`)
+	assert.Contains(t, w.Body.String(), `<p class="card-text sales_average_order_value"> $166.67 </p>`)
+	assert.Contains(t, w.Body.String(), `<p class="card-text sales_orders_per_customer"> 1.50 (2 customers) </p>`)
+	assert.Contains(t, w.Body.String(), `<td class="sales_day">2026-10-02</td>`)
+	assert.Contains(t, w.Body.String(), `<td class="product_name"><a href="/product/1">product1</a></td>`)
+	assert.Contains(t, w.Body.String(), `<td class="user_name">scstore</td>`)
+	assert.Contains(t, w.Body.String(), `href="/admin/sales.csv?rows=products&from=2026-10-01&to=2026-10-07"`)
+
+	w = httptest.NewRecorder()
+	req, _ = newAdminRequest("GET", "/admin/sales?from=2026-10-07&to=2026-10-01", nil)
+	req.Header.Set("Accept", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"to": "The end must be after the start."}}}`, w.Body.String())
+}
+
+func TestGetAdminSalesCSVEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/sales.csv?from=2026-10-01&to=2026-10-07", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="sales-days-2026-10-01-2026-10-07.csv"`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "day,orders,units,revenue,currency\n2026-10-01,2,3,300.00,USD\n2026-10-02,1,1,200.00,USD\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	req, _ = newAdminRequest("GET", "/admin/sales.csv?rows=customers&from=2026-10-01&to=2026-10-07", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "user_id,user_name,orders,units,revenue,currency\n2,scstore,2,3,300.00,USD\n1,user00001,1,1,200.00,USD\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	req, _ = newAdminRequest("GET", "/admin/sales.csv?rows=coupons", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestSalesCSVRows(t *testing.T) {
+	report := database.SalesReport{TopProducts: []database.ProductSales{{Product: database.Product{ID: 1, Name: "product1"}}}}
+	assert.Equal(t, [][]string{
+		{"product_id", "product_name", "orders", "units", "revenue", "currency"},
+		{"1", "product1", "0", "0", "0.00", ""},
+	}, salesCSVRows["products"](report))
+
+	// The names aren't run by the spreadsheets.
+	report = database.SalesReport{TopCustomers: []database.CustomerSales{{User: database.User{ID: 2, Name: "=1+1"}}}}
+	assert.Equal(t, []string{"2", "'=1+1", "0", "0", "0.00", ""}, salesCSVRows["customers"](report)[1])
+}
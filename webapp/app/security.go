@@ -0,0 +1,158 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	csrfCookieName      = "csrf_token"
+	csrfHeaderName      = "X-CSRF-Token"
+	csrfFormField       = "csrf_token"
+	contextKeyCSRFToken = "csrfToken"
+)
+
+type securityHeadersConfig struct {
+	ContentSecurityPolicy string
+	FrameOptions          string
+	HSTSMaxAge            int
+}
+
+// securityHeaders sets the headers which keep the pages from being framed or
+// loading scripts from other origins. HSTS is sent only over TLS, including
+// TLS terminated by a load balancer in front of the app.
+func securityHeaders(cfg securityHeadersConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		if cfg.ContentSecurityPolicy != "" {
+			header.Set("Content-Security-Policy", cfg.ContentSecurityPolicy)
+		}
+		if cfg.FrameOptions != "" {
+			header.Set("X-Frame-Options", cfg.FrameOptions)
+		}
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+
+		if isTLS(c.Request) && cfg.HSTSMaxAge > 0 {
+			header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(cfg.HSTSMaxAge)+"; includeSubDomains")
+		}
+
+		c.Next()
+	}
+}
+
+func isTLS(req *http.Request) bool {
+	return req.TLS != nil || strings.EqualFold(req.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// csrfProtection issues a signed token in a cookie per browser session and
+// requires unsafe requests to send the same token back in the csrf_token form
+// field or the X-CSRF-Token header (double submit). The token is available to
+// templates as csrfToken.
+//
+// A cross-site request is always sent by a browser, which adds Origin,
+// Referer, Sec-Fetch-Site or its cookies. Unless strict is set, requests
+// without any of them are let through so that API clients like the scoring
+// server keep working without a token. Requests to exemptPaths are never
+// checked; they have to be authenticated in another way.
+func csrfProtection(secret []byte, strict bool, exemptPaths ...string) gin.HandlerFunc {
+	exempt := make(map[string]bool)
+	for _, path := range exemptPaths {
+		exempt[path] = true
+	}
+
+	return func(c *gin.Context) {
+		cookieToken, err := c.Cookie(csrfCookieName)
+		if err != nil || !validCSRFToken(secret, cookieToken) {
+			cookieToken = ""
+		}
+
+		token := cookieToken
+		if token == "" {
+			token = newCSRFToken(secret)
+			http.SetCookie(c.Writer, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				Path:     "/",
+				Secure:   isTLS(c.Request),
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		c.Set(contextKeyCSRFToken, token)
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		if exempt[c.FullPath()] || (!strict && !isBrowserRequest(c.Request)) {
+			c.Next()
+			return
+		}
+
+		submitted := c.GetHeader(csrfHeaderName)
+		if submitted == "" {
+			submitted = c.PostForm(csrfFormField)
+		}
+
+		if cookieToken == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(cookieToken)) != 1 {
+			c.Error(&httpError{status: http.StatusForbidden, message: "The form has expired. Please go back, reload the page and try again."})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func isBrowserRequest(req *http.Request) bool {
+	for _, header := range []string{"Origin", "Referer", "Sec-Fetch-Site", "Cookie"} {
+		if req.Header.Get(header) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newCSRFToken(secret []byte) string {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(nonce)
+	return encoded + "." + signCSRFNonce(secret, encoded)
+}
+
+// validCSRFToken rejects tokens which were not issued by this app, so that a
+// cookie planted from a sibling domain can't be used.
+func validCSRFToken(secret []byte, token string) bool {
+	nonce, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(signCSRFNonce(secret, nonce)))
+}
+
+func signCSRFNonce(secret []byte, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(nonce))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// csrfToken returns the token to embed in the forms of the page.
+func csrfToken(c *gin.Context) string {
+	return c.GetString(contextKeyCSRFToken)
+}
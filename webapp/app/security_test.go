@@ -0,0 +1,116 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var csrfFieldPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// getCSRFToken opens the product page like a browser and returns the cookie
+// and the token embedded in the checkout form.
+func getCSRFToken(t *testing.T, router http.Handler) (*http.Cookie, string) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/product/1", nil)
+	router.ServeHTTP(w, req)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName {
+		t.Fatalf("csrf cookie is not set: %v", cookies)
+	}
+
+	match := csrfFieldPattern.FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatal("csrf_token is not in the form")
+	}
+
+	return cookies[0], match[1]
+}
+
+func newBrowserCheckoutRequest(cookie *http.Cookie, token string) *http.Request {
+	values := url.Values{}
+	values.Add("product_id", "1")
+	values.Add("product_quantity", "1")
+	if token != "" {
+		values.Add("csrf_token", token)
+	}
+
+	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "http://localhost:8080")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	return req
+}
+
+func TestCSRFFormToken(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	cookie, token := getCSRFToken(t, router)
+	assert.Equal(t, cookie.Value, token)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newBrowserCheckoutRequest(cookie, token))
+	assert.Equal(t, 202, w.Code)
+}
+
+func TestCSRFDoubleSubmitHeader(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	cookie, _ := getCSRFToken(t, router)
+
+	w := httptest.NewRecorder()
+	req := newBrowserCheckoutRequest(cookie, "")
+	req.Header.Set(csrfHeaderName, cookie.Value)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 202, w.Code)
+}
+
+func TestCSRFRejectsCrossSitePost(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	cookie, _ := getCSRFToken(t, router)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newBrowserCheckoutRequest(cookie, ""))
+	assert.Equal(t, 403, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newBrowserCheckoutRequest(nil, "forged"))
+	assert.Equal(t, 403, w.Code)
+
+	// A cookie which was not signed by the app is replaced, not trusted.
+	w = httptest.NewRecorder()
+	forged := &http.Cookie{Name: csrfCookieName, Value: "forged.token"}
+	router.ServeHTTP(w, newBrowserCheckoutRequest(forged, forged.Value))
+	assert.Equal(t, 403, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ := newAdminRequest("POST", "/admin/init", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 403, w.Code)
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/products", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Contains(t, w.Header().Get("Content-Security-Policy"), "frame-ancestors 'none'")
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Empty(t, w.Header().Get("Strict-Transport-Security"))
+
+	w = httptest.NewRecorder()
+	req.Header.Set("X-Forwarded-Proto", "https")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "max-age=31536000; includeSubDomains", w.Header().Get("Strict-Transport-Security"))
+}
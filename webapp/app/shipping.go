@@ -0,0 +1,88 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+// shippingAnyCountry is the key of the rate for the countries without their
+// own rate and for orders without an address.
+const shippingAnyCountry = "*"
+
+var shippingRates map[string]database.ShippingRate
+
+// parseShippingRates reads the rates of SHIPPING_RATES like "flat:5.00:50.00"
+// with the amounts in the currency.
+func parseShippingRates(rules map[string]string, currency string) (map[string]database.ShippingRate, error) {
+	rates := make(map[string]database.ShippingRate)
+	for country, rule := range rules {
+		parts := strings.Split(rule, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("shipping rate of %s should be like flat:5.00 or weight:2.50:50.00, but %s", country, rule)
+		}
+
+		kind := parts[0]
+		if kind != database.ShippingKindFlat && kind != database.ShippingKindWeight {
+			return nil, fmt.Errorf("shipping rate of %s should be flat or weight, but %s", country, kind)
+		}
+
+		amount, err := money.Parse(parts[1], currency)
+		if err != nil {
+			return nil, fmt.Errorf("shipping rate of %s: %w", country, err)
+		}
+
+		rate := database.ShippingRate{Kind: kind, Amount: amount.Amount}
+		if len(parts) == 3 {
+			freeOver, err := money.Parse(parts[2], currency)
+			if err != nil {
+				return nil, fmt.Errorf("shipping rate of %s: %w", country, err)
+			}
+			rate.FreeOver = freeOver.Amount
+		}
+
+		rates[country] = rate
+	}
+
+	return rates, nil
+}
+
+// shippingRateFor returns the rate of the country. It reports false when
+// there are rates but none for the country. Orders without an address have
+// no country and are charged the rate of *, if any.
+func shippingRateFor(country string) (database.ShippingRate, bool) {
+	if rate, ok := shippingRates[country]; ok {
+		return rate, true
+	}
+
+	rate, ok := shippingRates[shippingAnyCountry]
+	return rate, ok || country == "" || len(shippingRates) == 0
+}
+
+// checkoutShipping resolves the address chosen in the checkout form and its
+// rate. An empty id ships without an address. Problems with the choice are
+// added to fields.
+func checkoutShipping(c *gin.Context, userID int, addressID string, fields map[string]string) (database.Address, database.ShippingRate, error) {
+	var address database.Address
+	if addressID != "" {
+		found, ok, err := findAddress(c, userID, addressID)
+		if err != nil {
+			return address, database.ShippingRate{}, err
+		}
+		if !ok {
+			fields["address_id"] = "Choose an address from the list."
+			return address, database.ShippingRate{}, nil
+		}
+		address = found
+	}
+
+	rate, ok := shippingRateFor(address.Country)
+	if !ok {
+		fields["address_id"] = fmt.Sprintf("We don't ship to %s.", address.Country)
+	}
+
+	return address, rate, nil
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseShippingRates(t *testing.T) {
+	rates, err := parseShippingRates(map[string]string{"US": "flat:5.00:50.00", "*": "weight:2.50"}, "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]database.ShippingRate{
+		"US": {Kind: database.ShippingKindFlat, Amount: 500, FreeOver: 5000},
+		"*":  {Kind: database.ShippingKindWeight, Amount: 250},
+	}, rates)
+
+	for _, rule := range []string{"flat", "flat:5.00:50.00:1", "free:5.00", "flat:five", "flat:5.00:fifty"} {
+		_, err := parseShippingRates(map[string]string{"US": rule}, "USD")
+		assert.NotNil(t, err, rule)
+	}
+}
+
+func TestShippingRateFor(t *testing.T) {
+	shippingRates = map[string]database.ShippingRate{}
+	rate, ok := shippingRateFor("US")
+	assert.True(t, ok)
+	assert.Equal(t, database.ShippingRate{}, rate)
+
+	shippingRates = map[string]database.ShippingRate{"US": {Kind: database.ShippingKindFlat, Amount: 500}}
+	_, ok = shippingRateFor("DE")
+	assert.False(t, ok)
+	_, ok = shippingRateFor("")
+	assert.True(t, ok)
+
+	shippingRates["*"] = database.ShippingRate{Kind: database.ShippingKindFlat, Amount: 1500}
+	rate, ok = shippingRateFor("DE")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1500), rate.Amount)
+}
+
+func TestPostCheckoutEndpointShipping(t *testing.T) {
+	os.Setenv("SHIPPING_RATES", "US=flat:5.00")
+	defer os.Unsetenv("SHIPPING_RATES")
+
+	var order database.CheckoutRequest
+	router := SetupRouter(recordingDatabaseHandler{order: &order}, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}, "address_id": {"address1"}}, "")
+	assert.Equal(t, 202, w.Code)
+	assert.Equal(t, "Home", order.ShippingAddress.Name)
+	assert.Equal(t, database.ShippingRate{Kind: database.ShippingKindFlat, Amount: 500}, order.ShippingRate)
+
+	// Orders without an address ship for free without a rate for *.
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}}, "")
+	assert.Equal(t, 202, w.Code)
+	assert.True(t, order.ShippingAddress.IsZero())
+	assert.Equal(t, database.ShippingRate{}, order.ShippingRate)
+
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}, "address_id": {"unknown"}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Choose an address from the list.")
+}
+
+func TestPostCheckoutEndpointShippingUnavailable(t *testing.T) {
+	os.Setenv("SHIPPING_RATES", "DE=weight:2.50")
+	defer os.Unsetenv("SHIPPING_RATES")
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}, "address_id": {"address1"}}, "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "We don't ship to US.")
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+)
+
+var checkoutMaxQuantity int
+
+// validateCheckoutForm parses the checkout form and returns the message per
+// invalid field. Whether the product exists is checked by the caller.
+func validateCheckoutForm(productIDVal string, productQuantityVal string) (int, int, map[string]string) {
+	fields := make(map[string]string)
+
+	productID, err := strconv.Atoi(productIDVal)
+	if err != nil || productID <= 0 {
+		fields["product_id"] = "Product ID must be a positive integer."
+	}
+
+	productQuantity, err := strconv.Atoi(productQuantityVal)
+	switch {
+	case err != nil:
+		fields["product_quantity"] = "Quantity must be a number."
+	case productQuantity <= 0:
+		fields["product_quantity"] = "Quantity must be at least 1."
+	case productQuantity > checkoutMaxQuantity:
+		fields["product_quantity"] = fmt.Sprintf("Quantity must be %d or less.", checkoutMaxQuantity)
+	}
+
+	return productID, productQuantity, fields
+}
+
+// validateTaxRegion returns the tax rate of the region chosen on the checkout
+// form. Orders without a region are not taxed.
+func validateTaxRegion(region string) (float64, string) {
+	if region == "" {
+		return 0, ""
+	}
+
+	rate, ok := taxRates[region]
+	if !ok {
+		return 0, "Choose a region from the list."
+	}
+
+	return rate, ""
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCheckoutForm(t *testing.T) {
+	checkoutMaxQuantity = 100
+
+	productID, productQuantity, fields := validateCheckoutForm("1", "100")
+	assert.Equal(t, 1, productID)
+	assert.Equal(t, 100, productQuantity)
+	assert.Empty(t, fields)
+
+	_, _, fields = validateCheckoutForm("abc", "1")
+	assert.Contains(t, fields, "product_id")
+
+	_, _, fields = validateCheckoutForm("-1", "1")
+	assert.Contains(t, fields, "product_id")
+
+	_, _, fields = validateCheckoutForm("1", "0")
+	assert.Equal(t, "Quantity must be at least 1.", fields["product_quantity"])
+
+	_, _, fields = validateCheckoutForm("1", "-5")
+	assert.Equal(t, "Quantity must be at least 1.", fields["product_quantity"])
+
+	_, _, fields = validateCheckoutForm("1", "101")
+	assert.Equal(t, "Quantity must be 100 or less.", fields["product_quantity"])
+
+	_, _, fields = validateCheckoutForm("1", "")
+	assert.Equal(t, "Quantity must be a number.", fields["product_quantity"])
+}
+
+func postCheckoutForm(t *testing.T, router http.Handler, productID string, productQuantity string, accept string) *httptest.ResponseRecorder {
+	values := url.Values{}
+	values.Add("product_id", productID)
+	values.Add("product_quantity", productQuantity)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(values.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestPostCheckoutEndpointInvalidQuantity(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := postCheckoutForm(t, router, "1", "0", "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `name="product_quantity"`)
+	assert.Contains(t, w.Body.String(), "is-invalid")
+	assert.Contains(t, w.Body.String(), "Quantity must be at least 1.")
+
+	w = postCheckoutForm(t, router, "1", "0", "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"product_quantity": "Quantity must be at least 1."}}}`, w.Body.String())
+}
+
+func TestPostCheckoutEndpointMissingProduct(t *testing.T) {
+	router := SetupRouter(failingDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+
+	w := postCheckoutForm(t, router, "999", "1", "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "The product does not exist.")
+}
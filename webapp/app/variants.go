@@ -0,0 +1,164 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+// selectVariant returns the variant shown on the product page: the one of
+// the sku, else the first in stock, else the first one. ok is false when the
+// product has no variants.
+func selectVariant(variants []database.Variant, sku string) (variant database.Variant, ok bool) {
+	if len(variants) == 0 {
+		return database.Variant{}, false
+	}
+
+	for _, v := range variants {
+		if v.SKU == sku {
+			return v, true
+		}
+	}
+	for _, v := range variants {
+		if v.InStock() {
+			return v, true
+		}
+	}
+
+	return variants[0], true
+}
+
+// parseVariantForm reads the variant form of the admin page. The price is in
+// the currency of the product like 99.00 and an empty one keeps the price of
+// the product, as does an empty image.
+func parseVariantForm(c *gin.Context, product database.Product) (database.Variant, map[string]string) {
+	fields := make(map[string]string)
+	variant := database.Variant{
+		SKU:       c.PostForm("sku"),
+		ProductID: product.ID,
+		Name:      c.PostForm("name"),
+		Image:     c.PostForm("image"),
+	}
+
+	if val := strings.TrimSpace(c.PostForm("price")); val != "" {
+		price, err := money.Parse(val, product.Price.Currency)
+		if err != nil || price.Amount <= 0 {
+			fields["price"] = "Price must be an amount like 99.00."
+		}
+		variant.Price = price
+	}
+
+	stock, err := strconv.Atoi(strings.TrimSpace(c.PostForm("stock")))
+	if err != nil {
+		fields["stock"] = "Stock must be a number."
+	}
+	variant.Stock = stock
+
+	return variant, fields
+}
+
+func adminProduct(c *gin.Context) (database.Product, bool) {
+	productID, err := strconv.Atoi(c.Param("product_id"))
+	if err != nil {
+		c.Error(database.NewValidationError("product_id must be an integer"))
+		return database.Product{}, false
+	}
+
+	product, err := databaseHandler(c).GetProduct(productID)
+	if err != nil {
+		c.Error(err)
+		return database.Product{}, false
+	}
+
+	return product, true
+}
+
+func renderAdminVariants(c *gin.Context, status int, product database.Product, form gin.H, fields map[string]string) {
+	variants, err := databaseHandler(c).GetVariants(product.ID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.HTML(status, "admin_variants.html", gin.H{
+		"title":     "Variants",
+		"product":   product,
+		"variants":  variants,
+		"form":      form,
+		"errors":    fields,
+		"csrfToken": csrfToken(c),
+	})
+}
+
+func getAdminVariantsEndpoint(c *gin.Context) {
+	product, ok := adminProduct(c)
+	if !ok {
+		return
+	}
+
+	renderAdminVariants(c, http.StatusOK, product, gin.H{"stock": "0"}, map[string]string{})
+}
+
+func postAdminVariantsEndpoint(c *gin.Context) {
+	product, ok := adminProduct(c)
+	if !ok {
+		return
+	}
+
+	variant, fields := parseVariantForm(c, product)
+	if len(fields) == 0 {
+		err := databaseHandler(c).CreateVariant(variant)
+		var dbErr *database.Error
+		switch {
+		case err == nil:
+			c.Redirect(http.StatusSeeOther, fmt.Sprintf("/admin/products/%d/variants", product.ID))
+			return
+		case errors.Is(err, database.ErrConflict):
+			fields["sku"] = "The SKU is already in use."
+		case errors.As(err, &dbErr) && dbErr.Fields != nil:
+			fields = dbErr.Fields
+		default:
+			c.Error(err)
+			return
+		}
+	}
+
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.Error(database.NewFieldValidationError(fields))
+		return
+	}
+
+	form := gin.H{}
+	for _, name := range []string{"sku", "name", "price", "stock", "image"} {
+		form[name] = c.PostForm(name)
+	}
+	renderAdminVariants(c, http.StatusBadRequest, product, form, fields)
+}
+
+// postAdminVariantStockEndpoint sets the stock of a variant, like after a
+// delivery or a stock count.
+func postAdminVariantStockEndpoint(c *gin.Context) {
+	product, ok := adminProduct(c)
+	if !ok {
+		return
+	}
+
+	stock, err := strconv.Atoi(strings.TrimSpace(c.PostForm("stock")))
+	if err != nil {
+		c.Error(database.NewFieldValidationError(map[string]string{"stock": "Stock must be a number."}))
+		return
+	}
+
+	if err := databaseHandler(c).UpdateVariantStock(c.Param("sku"), stock); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/admin/products/%d/variants", product.ID))
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/stretchr/testify/assert"
+)
+
+// variantDatabaseHandler serves product2 with the straps of the dev database.
+// Only the black strap is in stock and its SKU is taken.
+type variantDatabaseHandler struct {
+	database.DevDatabaseHandler
+}
+
+func (dbh variantDatabaseHandler) GetProduct(id int) (database.Product, error) {
+	if id != 2 {
+		return dbh.DevDatabaseHandler.GetProduct(id)
+	}
+
+	return database.Product{ID: 2, Name: "product2", Price: money.New(20000, "USD"), Weight: 150, Image: "image/product2.png"}, nil
+}
+
+func (dbh variantDatabaseHandler) CreateCheckout(order database.CheckoutRequest) (string, error) {
+	if order.ProductID == 2 && order.VariantSKU != "P2-BLACK" {
+		return "", database.NewFieldValidationError(map[string]string{"variant_sku": "This variant is out of stock."})
+	}
+
+	return "dummy-checkout", nil
+}
+
+func (dbh variantDatabaseHandler) CreateVariant(variant database.Variant) error {
+	if variant.SKU == "P2-BLACK" {
+		return database.NewConflictError("variant P2-BLACK already exists")
+	}
+
+	return dbh.DevDatabaseHandler.CreateVariant(variant)
+}
+
+func TestSelectVariant(t *testing.T) {
+	variants := []database.Variant{
+		{SKU: "A", Stock: 0},
+		{SKU: "B", Stock: 2},
+		{SKU: "C", Stock: 1},
+	}
+
+	variant, ok := selectVariant(variants, "C")
+	assert.True(t, ok)
+	assert.Equal(t, "C", variant.SKU)
+
+	variant, ok = selectVariant(variants, "")
+	assert.True(t, ok)
+	assert.Equal(t, "B", variant.SKU)
+
+	variant, ok = selectVariant(variants[:1], "Z")
+	assert.True(t, ok)
+	assert.Equal(t, "A", variant.SKU)
+
+	_, ok = selectVariant(nil, "A")
+	assert.False(t, ok)
+}
+
+func TestGetProductEndpointVariants(t *testing.T) {
+	router := SetupRouter(variantDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/product/2", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `<option value="P2-BLACK" selected> Black strap, $200.00 </option>`)
+	assert.Contains(t, w.Body.String(), `<option value="P2-BROWN" > Brown strap, $220.00 (out of stock) </option>`)
+	assert.Contains(t, w.Body.String(), `<input type="hidden" name="variant_sku" value="P2-BLACK">`)
+	assert.Contains(t, w.Body.String(), "Only 3 left in stock.")
+
+	// The page shows the price and the image of the chosen variant.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/product/2?variant=P2-BROWN", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `<img class="product-img" alt="" src="image/product2-brown.png">`)
+	assert.Contains(t, w.Body.String(), " $220.00 ")
+	assert.Contains(t, w.Body.String(), `<input type="hidden" name="variant_sku" value="P2-BROWN">`)
+	assert.Contains(t, w.Body.String(), "This variant is out of stock.")
+	assert.Contains(t, w.Body.String(), `type="submit" disabled> CHECKOUT </button>`)
+
+	// Products without variants have no picker.
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/product/1", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), `name="variant_sku"`)
+}
+
+func TestPostCheckoutEndpointVariant(t *testing.T) {
+	router := SetupRouter(variantDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/checkout", url.Values{"product_id": {"2"}, "product_quantity": {"1"}, "variant_sku": {" P2-BLACK "}}, "")
+	assert.Equal(t, 202, w.Code)
+
+	// The form is shown again with the chosen variant.
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"2"}, "product_quantity": {"1"}, "variant_sku": {"P2-BROWN"}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `<option value="P2-BROWN" selected>`)
+	assert.Contains(t, w.Body.String(), `<div class="invalid-feedback"> This variant is out of stock. </div>`)
+
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"2"}, "product_quantity": {"1"}}, "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"variant_sku": "This variant is out of stock."}}}`, w.Body.String())
+}
+
+func TestGetAdminVariantsEndpoint(t *testing.T) {
+	router := SetupRouter(variantDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/products/2/variants", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `<td class="variant_sku">P2-BLACK</td>`)
+	assert.Contains(t, w.Body.String(), `<td class="variant_price">$220.00</td>`)
+	assert.Contains(t, w.Body.String(), `<td class="variant_stock">0</td>`)
+	assert.Contains(t, w.Body.String(), `action="/admin/products/2/variants/P2-BROWN/stock"`)
+	assert.Contains(t, w.Body.String(), `name="csrf_token"`)
+
+	w = httptest.NewRecorder()
+	req, _ = newAdminRequest("GET", "/admin/products/two/variants", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestPostAdminVariantsEndpoint(t *testing.T) {
+	router := SetupRouter(variantDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+
+	w := postAdminForm(t, router, "/admin/products/2/variants", url.Values{"sku": {"P2-STEEL"}, "name": {"Steel bracelet"}, "price": {"250.00"}, "stock": {"5"}}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/admin/products/2/variants", w.Header().Get("Location"))
+
+	w = postAdminForm(t, router, "/admin/products/2/variants", url.Values{"sku": {"P2-STEEL"}, "name": {"Steel bracelet"}, "price": {"cheap"}, "stock": {"5"}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "Price must be an amount like 99.00.")
+	assert.Contains(t, w.Body.String(), `value="Steel bracelet"`)
+
+	w = postAdminForm(t, router, "/admin/products/2/variants", url.Values{"sku": {"P2-BLACK"}, "name": {"Black strap"}, "stock": {"1"}}, "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"sku": "The SKU is already in use."}}}`, w.Body.String())
+
+	w = postAdminForm(t, router, "/admin/products/2/variants", url.Values{"sku": {"P2 STEEL"}, "name": {"Steel bracelet"}, "stock": {"-1"}}, "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"sku": "SKU must be 1 to 40 letters, digits, - or _.", "stock": "Stock must be 0 or more."}}}`, w.Body.String())
+}
+
+func TestPostAdminVariantStockEndpoint(t *testing.T) {
+	router := SetupRouter(variantDatabaseHandler{}, testAssetsDir, testTemplatesDirMatch)
+
+	w := postAdminForm(t, router, "/admin/products/2/variants/P2-BROWN/stock", url.Values{"stock": {"10"}}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/admin/products/2/variants", w.Header().Get("Location"))
+
+	w = postAdminForm(t, router, "/admin/products/2/variants/P2-BROWN/stock", url.Values{"stock": {"-1"}}, "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"stock": "Stock must be 0 or more."}}}`, w.Body.String())
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/utils"
+	"github.com/mittz/role-play-webapp/webapp/webhook"
+)
+
+// webhookDeliveriesLimit is the number of latest deliveries shown per webhook.
+const webhookDeliveriesLimit = 50
+
+// webhookGuard refuses the webhooks to the private network unless their host
+// is in WEBHOOK_ALLOWED_HOSTS.
+var webhookGuard *webhook.Guard
+
+// StartWebhookDispatcher sends the events of the outbox to the webhooks in
+// the background until the app stops.
+func StartWebhookDispatcher(dbh database.DatabaseHandler) {
+	dispatcher := webhook.NewDispatcher(dbh, webhook.Options{
+		PollInterval: utils.GetEnvWebhookPollInterval(),
+		Timeout:      utils.GetEnvWebhookTimeout(),
+		MaxAttempts:  utils.GetEnvWebhookMaxAttempts(),
+		Backoff:      utils.GetEnvWebhookRetryBackoff(),
+		MaxBackoff:   utils.GetEnvWebhookMaxBackoff(),
+		BatchSize:    utils.GetEnvWebhookBatchSize(),
+		AllowedHosts: utils.GetEnvWebhookAllowedHosts(),
+	}, slog.Default())
+
+	go dispatcher.Run(context.Background())
+}
+
+// newWebhookSecret returns a random secret for the webhooks added without one.
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(secret), nil
+}
+
+func renderAdminWebhooks(c *gin.Context, status int, form gin.H, fields map[string]string) {
+	webhooks, err := databaseHandler(c).GetWebhooks()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.HTML(status, "admin_webhooks.html", gin.H{
+		"title":      "Webhooks",
+		"webhooks":   webhooks,
+		"eventTypes": database.WebhookEventTypes,
+		"form":       form,
+		"errors":     fields,
+		"csrfToken":  csrfToken(c),
+	})
+}
+
+func getAdminWebhooksEndpoint(c *gin.Context) {
+	form := gin.H{"event_types": database.WebhookEventTypes}
+	renderAdminWebhooks(c, http.StatusOK, form, map[string]string{})
+}
+
+func postAdminWebhooksEndpoint(c *gin.Context) {
+	hook := database.Webhook{
+		URL:        strings.TrimSpace(c.PostForm("url")),
+		Secret:     strings.TrimSpace(c.PostForm("secret")),
+		EventTypes: c.PostFormArray("event_types"),
+	}
+	if hook.Secret == "" {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		hook.Secret = secret
+	}
+
+	err := hook.Validate()
+	if err == nil {
+		err = checkWebhookURL(c.Request.Context(), hook.URL)
+	}
+	if err == nil {
+		_, err = databaseHandler(c).CreateWebhook(hook)
+	}
+	var dbErr *database.Error
+	switch {
+	case err == nil:
+		c.Redirect(http.StatusSeeOther, "/admin/webhooks")
+		return
+	case errors.As(err, &dbErr) && dbErr.Fields != nil:
+	default:
+		c.Error(err)
+		return
+	}
+
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		c.Error(err)
+		return
+	}
+
+	form := gin.H{"url": c.PostForm("url"), "secret": c.PostForm("secret"), "event_types": hook.EventTypes}
+	renderAdminWebhooks(c, http.StatusBadRequest, form, dbErr.Fields)
+}
+
+// checkWebhookURL refuses a webhook when it is added already, while the
+// dispatcher checks the address again on every delivery.
+func checkWebhookURL(ctx context.Context, rawURL string) error {
+	err := webhookGuard.CheckURL(ctx, rawURL)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, webhook.ErrForbiddenAddress):
+		return database.NewFieldValidationError(map[string]string{"url": "URL must be a public address. Allow private hosts with WEBHOOK_ALLOWED_HOSTS."})
+	default:
+		return database.NewFieldValidationError(map[string]string{"url": "The host of the URL could not be resolved."})
+	}
+}
+
+func postAdminWebhookDeactivateEndpoint(c *gin.Context) {
+	if err := databaseHandler(c).DeactivateWebhook(c.Param("webhook_id")); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin/webhooks")
+}
+
+// getAdminWebhookDeliveriesEndpoint shows the delivery log of a webhook.
+func getAdminWebhookDeliveriesEndpoint(c *gin.Context) {
+	webhookID := c.Param("webhook_id")
+
+	webhooks, err := databaseHandler(c).GetWebhooks()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var hook *database.Webhook
+	for i := range webhooks {
+		if webhooks[i].ID == webhookID {
+			hook = &webhooks[i]
+		}
+	}
+	if hook == nil {
+		c.Error(database.NewNotFoundError("webhook", webhookID))
+		return
+	}
+
+	deliveries, err := databaseHandler(c).GetWebhookDeliveries(webhookID, webhookDeliveriesLimit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin_webhook_deliveries.html", gin.H{
+		"title":      "Webhook Deliveries",
+		"webhook":    hook,
+		"deliveries": deliveries,
+	})
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// webhookDatabaseHandler keeps the webhook it was asked to create.
+type webhookDatabaseHandler struct {
+	database.DevDatabaseHandler
+	created *database.Webhook
+}
+
+func (dbh webhookDatabaseHandler) CreateWebhook(webhook database.Webhook) (string, error) {
+	*dbh.created = webhook
+	return "webhook2", webhook.Validate()
+}
+
+func TestGetAdminWebhooksEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/webhooks", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `href="/admin/webhooks/webhook1/deliveries"`)
+	assert.Contains(t, w.Body.String(), `<td class="webhook_event_types">checkout.created, checkout.status_changed, product.price_dropped</td>`)
+	assert.Contains(t, w.Body.String(), `action="/admin/webhooks/webhook1/deactivate"`)
+	assert.Contains(t, w.Body.String(), `value="checkout.created" class="form-check-input " checked`)
+	assert.Contains(t, w.Body.String(), `name="csrf_token"`)
+}
+
+func TestPostAdminWebhooksEndpoint(t *testing.T) {
+	var created database.Webhook
+	router := SetupRouter(webhookDatabaseHandler{created: &created}, testAssetsDir, testTemplatesDirMatch)
+
+	w := postAdminForm(t, router, "/admin/webhooks", url.Values{"url": {" https://203.0.113.10/hooks "}, "event_types": {"checkout.created"}}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/admin/webhooks", w.Header().Get("Location"))
+	assert.Equal(t, "https://203.0.113.10/hooks", created.URL)
+	assert.Equal(t, []string{"checkout.created"}, created.EventTypes)
+	// A secret is generated when none is given.
+	assert.Equal(t, 48, len(created.Secret))
+
+	w = postAdminForm(t, router, "/admin/webhooks", url.Values{"url": {"https://203.0.113.10/hooks"}, "secret": {"0123456789abcdef"}, "event_types": {"checkout.created"}}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "0123456789abcdef", created.Secret)
+
+	w = postAdminForm(t, router, "/admin/webhooks", url.Values{"url": {"ftp://example.com"}, "secret": {"short"}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), `value="ftp://example.com"`)
+	assert.Contains(t, w.Body.String(), "Secret must be 16 to 100 characters.")
+	assert.Contains(t, w.Body.String(), "Choose at least one event.")
+
+	w = postAdminForm(t, router, "/admin/webhooks", url.Values{"url": {"ftp://example.com"}, "event_types": {"checkout.created"}}, "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"url": "URL must be an http or https URL of at most 500 characters."}}}`, w.Body.String())
+}
+
+func TestPostAdminWebhooksEndpointPrivateAddress(t *testing.T) {
+	var created database.Webhook
+	router := SetupRouter(webhookDatabaseHandler{created: &created}, testAssetsDir, testTemplatesDirMatch)
+
+	// Only the admin can add webhooks.
+	w := postForm(t, router, "/admin/webhooks", url.Values{"url": {"https://203.0.113.10/hooks"}, "event_types": {"checkout.created"}}, "")
+	assert.Equal(t, 401, w.Code)
+	assert.Empty(t, created.URL)
+
+	for _, target := range []string{"http://127.0.0.1:8080/admin/init", "http://169.254.169.254/computeMetadata/v1/", "http://10.0.0.5/", "http://[::1]/"} {
+		w = postAdminForm(t, router, "/admin/webhooks", url.Values{"url": {target}, "event_types": {"checkout.created"}}, "application/json")
+		assert.Equal(t, 400, w.Code, target)
+		assert.Contains(t, w.Body.String(), "URL must be a public address.", target)
+	}
+	assert.Empty(t, created.URL)
+
+	t.Setenv("WEBHOOK_ALLOWED_HOSTS", "10.0.0.5")
+	router = SetupRouter(webhookDatabaseHandler{created: &created}, testAssetsDir, testTemplatesDirMatch)
+	w = postAdminForm(t, router, "/admin/webhooks", url.Values{"url": {"http://10.0.0.5/"}, "event_types": {"checkout.created"}}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "http://10.0.0.5/", created.URL)
+}
+
+func TestPostAdminWebhookDeactivateEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := postAdminForm(t, router, "/admin/webhooks/webhook1/deactivate", url.Values{}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/admin/webhooks", w.Header().Get("Location"))
+}
+
+func TestGetAdminWebhookDeliveriesEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/webhooks/webhook1/deliveries", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "Deliveries to https://example.com/hooks/scstore")
+
+	w = httptest.NewRecorder()
+	req, _ = newAdminRequest("GET", "/admin/webhooks/unknown/deliveries", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+// wishlistPath is where the wishlist forms go back to unless they were sent
+// from a product page.
+const wishlistPath = "/wishlist"
+
+// onWishlist reports whether the product is on the wishlist of the user.
+func onWishlist(c *gin.Context, userID int, productID int) (bool, error) {
+	items, err := databaseHandler(c).GetWishlist(userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, item := range items {
+		if item.Product.ID == productID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// wishlistReturnPath returns the page the form was sent from. Only the
+// product page can be asked for so that the form can't redirect elsewhere.
+func wishlistReturnPath(c *gin.Context, productID int) string {
+	if c.PostForm("return_to") == "product" {
+		return fmt.Sprintf("/product/%d", productID)
+	}
+
+	return wishlistPath
+}
+
+// removeBoughtFromWishlist moves the product out of the wishlist once it is
+// ordered from the wishlist page. The order is already accepted, so
+// failures are only logged.
+func removeBoughtFromWishlist(c *gin.Context, userID int, productID int) {
+	if c.PostForm("from_wishlist") == "" {
+		return
+	}
+
+	err := databaseHandler(c).RemoveWishlistItem(userID, productID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		requestLoggerFrom(c).Error("wishlist update failed", "product_id", productID, "error", err.Error())
+	}
+}
+
+func getWishlistEndpoint(c *gin.Context) {
+	items, err := databaseHandler(c).GetWishlist(getUserID())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.HTML(http.StatusOK, "wishlist.html", gin.H{
+		"title":           "Wishlist",
+		"items":           items,
+		"maxQuantity":     checkoutMaxQuantity,
+		"displayCurrency": displayCurrency(c),
+		"csrfToken":       csrfToken(c),
+	})
+}
+
+func postWishlistEndpoint(c *gin.Context) {
+	productID, err := strconv.Atoi(c.PostForm("product_id"))
+	if err != nil {
+		c.Error(database.NewFieldValidationError(map[string]string{"product_id": "The product does not exist."}))
+		return
+	}
+
+	if err := databaseHandler(c).AddWishlistItem(getUserID(), productID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, wishlistReturnPath(c, productID))
+}
+
+func postWishlistDeleteEndpoint(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("product_id"))
+	if err != nil {
+		c.Error(database.NewValidationError("product_id must be an integer"))
+		return
+	}
+
+	if err := databaseHandler(c).RemoveWishlistItem(getUserID(), productID); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, wishlistReturnPath(c, productID))
+}
+
+func getAdminProductsEndpoint(c *gin.Context) {
+	products, err := databaseHandler(c).GetProducts()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.HTML(http.StatusOK, "admin_products.html", gin.H{
+		"title":     "Products",
+		"products":  products,
+		"csrfToken": csrfToken(c),
+	})
+}
+
+// postAdminProductPriceEndpoint changes the price of a product. The price
+// is in the currency of the product like 99.00. The users with the product
+// on their wishlists are emailed when it drops.
+func postAdminProductPriceEndpoint(c *gin.Context) {
+	productID, err := strconv.Atoi(c.Param("product_id"))
+	if err != nil {
+		c.Error(database.NewValidationError("product_id must be an integer"))
+		return
+	}
+
+	product, err := databaseHandler(c).GetProduct(productID)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	price, err := money.Parse(c.PostForm("price"), product.Price.Currency)
+	if err != nil {
+		c.Error(database.NewFieldValidationError(map[string]string{"price": "Price must be an amount like 99.00."}))
+		return
+	}
+
+	change, err := databaseHandler(c).UpdateProductPrice(productID, price.Amount)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if change.Dropped() {
+		notifyPriceDrop(requestLoggerFrom(c), change)
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin/products")
+}
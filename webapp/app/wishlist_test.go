@@ -0,0 +1,125 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+// wishlistDatabaseHandler records the products removed from the wishlist.
+type wishlistDatabaseHandler struct {
+	database.DevDatabaseHandler
+	removed *[]int
+}
+
+func (dbh wishlistDatabaseHandler) RemoveWishlistItem(userID int, productID int) error {
+	*dbh.removed = append(*dbh.removed, productID)
+	return nil
+}
+
+func TestGetWishlistEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/wishlist", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `<a href="/product/1">product1</a>`)
+	assert.Contains(t, w.Body.String(), `<input type="hidden" name="from_wishlist" value="true">`)
+	assert.Contains(t, w.Body.String(), `action="/wishlist/1/delete"`)
+}
+
+func TestGetProductEndpointWishlist(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/product/1", nil)
+	router.ServeHTTP(w, req)
+
+	// product1 is on the wishlist of the dev user.
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `action="/wishlist/1/delete"`)
+	assert.Contains(t, w.Body.String(), "REMOVE FROM WISHLIST")
+}
+
+func TestPostWishlistEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/wishlist", url.Values{"product_id": {"2"}, "return_to": {"product"}}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/product/2", w.Header().Get("Location"))
+
+	// Other pages can't be asked for.
+	w = postForm(t, router, "/wishlist", url.Values{"product_id": {"2"}, "return_to": {"https://example.com"}}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/wishlist", w.Header().Get("Location"))
+
+	w = postForm(t, router, "/wishlist", url.Values{"product_id": {"watch"}}, "")
+	assert.Equal(t, 400, w.Code)
+
+	w = postForm(t, router, "/wishlist/2/delete", url.Values{}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/wishlist", w.Header().Get("Location"))
+}
+
+func TestPostCheckoutEndpointFromWishlist(t *testing.T) {
+	var removed []int
+	router := SetupRouter(wishlistDatabaseHandler{removed: &removed}, testAssetsDir, testTemplatesDirMatch)
+
+	w := postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}}, "")
+	assert.Equal(t, 202, w.Code)
+	assert.Empty(t, removed)
+
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"1"}, "from_wishlist": {"true"}}, "")
+	assert.Equal(t, 202, w.Code)
+	assert.Equal(t, []int{1}, removed)
+
+	// An order which is not accepted stays on the wishlist.
+	w = postForm(t, router, "/checkout", url.Values{"product_id": {"1"}, "product_quantity": {"0"}, "from_wishlist": {"true"}}, "")
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, []int{1}, removed)
+}
+
+func TestPostAdminProductPriceEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+	mailer := useRecordingMailer()
+
+	// Only the admin can change the prices, and a refused drop sends no email.
+	w := postForm(t, router, "/admin/products/1/price", url.Values{"price": {"1.00"}}, "")
+	assert.Equal(t, 401, w.Code)
+
+	w = postAdminForm(t, router, "/admin/products/1/price", url.Values{"price": {"120.00"}}, "")
+	assert.Equal(t, 303, w.Code)
+	assert.Equal(t, "/admin/products", w.Header().Get("Location"))
+
+	w = postAdminForm(t, router, "/admin/products/1/price", url.Values{"price": {"89.50"}}, "")
+	assert.Equal(t, 303, w.Code)
+
+	w = postAdminForm(t, router, "/admin/products/1/price", url.Values{"price": {"cheap"}}, "application/json")
+	assert.Equal(t, 400, w.Code)
+	assert.JSONEq(t, `{"error": {"status": 400, "message": "some fields are invalid", "fields": {"price": "Price must be an amount like 99.00."}}}`, w.Body.String())
+
+	// Only the drop is sent to the user with the product on the wishlist.
+	notifier.Close()
+	assert.Equal(t, 1, len(mailer.sent))
+	assert.Equal(t, "scstore@example.com", mailer.sent[0].To)
+	assert.Equal(t, "product1 on your wishlist is now $89.50", mailer.sent[0].Subject)
+	assert.Contains(t, mailer.sent[0].Body, "dropped from $100.00 to $89.50.")
+}
+
+func TestGetAdminProductsEndpoint(t *testing.T) {
+	router := SetupRouter(dbDevHandler, testAssetsDir, testTemplatesDirMatch)
+
+	w := httptest.NewRecorder()
+	req, _ := newAdminRequest("GET", "/admin/products", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `action="/admin/products/2/price"`)
+	assert.Contains(t, w.Body.String(), `value="200.00"`)
+}
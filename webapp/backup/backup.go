@@ -0,0 +1,220 @@
+// Package backup writes the data of a database to an archive and restores it,
+// on the same backend or another one.
+//
+// An archive is made of JSON lines. The first line is the header which names
+// the format and its version, and the last one counts the records, so that a
+// truncated archive is not restored. Every line between them is a record with
+// a single key naming its type:
+//
+//	{"format":"scstore-backup","version":1,"created_at":"2026-10-18T09:00:00Z"}
+//	{"product":{"id":1,"name":"product1","price":10000,"currency":"USD",...}}
+//	{"user":{"id":1,"name":"user00001","email":"user00001@example.com"}}
+//	{"end":{"products":1,"variants":0,"users":1,"coupons":0,"checkouts":0}}
+//
+// The amounts are in minor units of their currency and the times in RFC 3339.
+// The records are in the order they are restored, the records they refer to
+// first: products, variants, users, coupons and checkouts.
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+)
+
+const (
+	// Format names the archives in their header.
+	Format = "scstore-backup"
+	// Version is the version of the archives written. It changes when an
+	// archive can't be read by the previous versions anymore.
+	Version = 1
+)
+
+// Store is the part of the database backed up and restored.
+type Store interface {
+	Backup(fn func(database.BackupRecord) error) error
+	Restore(next func() (database.BackupRecord, error)) error
+}
+
+// Counts are the numbers of records of an archive by type.
+type Counts struct {
+	Products  int `json:"products"`
+	Variants  int `json:"variants"`
+	Users     int `json:"users"`
+	Coupons   int `json:"coupons"`
+	Checkouts int `json:"checkouts"`
+}
+
+func (counts *Counts) add(record line) {
+	switch {
+	case record.Product != nil:
+		counts.Products++
+	case record.Variant != nil:
+		counts.Variants++
+	case record.User != nil:
+		counts.Users++
+	case record.Coupon != nil:
+		counts.Coupons++
+	case record.Checkout != nil:
+		counts.Checkouts++
+	}
+}
+
+// String formats the counts like 10 products, 2 variants, 100 users, 1 coupons and 20 checkouts.
+func (counts Counts) String() string {
+	return fmt.Sprintf("%d products, %d variants, %d users, %d coupons and %d checkouts",
+		counts.Products, counts.Variants, counts.Users, counts.Coupons, counts.Checkouts)
+}
+
+type header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// line is a line after the header. Exactly one of its fields is set.
+type line struct {
+	Product  *product  `json:"product,omitempty"`
+	Variant  *variant  `json:"variant,omitempty"`
+	User     *user     `json:"user,omitempty"`
+	Coupon   *coupon   `json:"coupon,omitempty"`
+	Checkout *checkout `json:"checkout,omitempty"`
+	End      *Counts   `json:"end,omitempty"`
+}
+
+func (l line) fields() int {
+	fields := 0
+	for _, set := range []bool{l.Product != nil, l.Variant != nil, l.User != nil, l.Coupon != nil, l.Checkout != nil, l.End != nil} {
+		if set {
+			fields++
+		}
+	}
+
+	return fields
+}
+
+// Write backs up the store to w and returns the counts of its records.
+func Write(w io.Writer, store Store, now time.Time) (Counts, error) {
+	var counts Counts
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	if err := enc.Encode(header{Format: Format, Version: Version, CreatedAt: now.UTC()}); err != nil {
+		return counts, err
+	}
+
+	if err := store.Backup(func(record database.BackupRecord) error {
+		l, err := newLine(record)
+		if err != nil {
+			return err
+		}
+		counts.add(l)
+
+		return enc.Encode(l)
+	}); err != nil {
+		return counts, err
+	}
+
+	if err := enc.Encode(line{End: &counts}); err != nil {
+		return counts, err
+	}
+
+	return counts, bw.Flush()
+}
+
+// reader reads the lines of an archive one by one.
+type reader struct {
+	dec    *json.Decoder
+	n      int
+	counts Counts
+	done   bool
+}
+
+func newReader(r io.Reader) (*reader, error) {
+	rd := &reader{dec: json.NewDecoder(bufio.NewReader(r)), n: 1}
+
+	var h header
+	if err := rd.dec.Decode(&h); err != nil {
+		return nil, fmt.Errorf("line 1: the header can't be read: %w", err)
+	}
+	if h.Format != Format {
+		return nil, fmt.Errorf("line 1: the format is %q, not %q", h.Format, Format)
+	}
+	if h.Version < 1 || h.Version > Version {
+		return nil, fmt.Errorf("line 1: version %d is not supported, the latest is %d", h.Version, Version)
+	}
+
+	return rd, nil
+}
+
+// next returns the next record, or io.EOF after the end line once its counts
+// are checked.
+func (rd *reader) next() (database.BackupRecord, error) {
+	if rd.done {
+		return database.BackupRecord{}, io.EOF
+	}
+
+	rd.n++
+	var l line
+	err := rd.dec.Decode(&l)
+	if err == io.EOF {
+		return database.BackupRecord{}, errors.New("the archive is truncated, its end is missing")
+	}
+	if err != nil {
+		return database.BackupRecord{}, fmt.Errorf("line %d: %w", rd.n, err)
+	}
+	if l.fields() != 1 {
+		return database.BackupRecord{}, fmt.Errorf("line %d: a line must have exactly one record", rd.n)
+	}
+
+	if l.End != nil {
+		if *l.End != rd.counts {
+			return database.BackupRecord{}, fmt.Errorf("line %d: the archive has %s, but its end counts %s", rd.n, rd.counts, *l.End)
+		}
+		if rd.dec.More() {
+			return database.BackupRecord{}, fmt.Errorf("line %d: there are lines after the end", rd.n+1)
+		}
+
+		rd.done = true
+		return database.BackupRecord{}, io.EOF
+	}
+
+	rd.counts.add(l)
+	return l.record(), nil
+}
+
+// Check reads the whole archive without restoring it, so that an archive
+// which can't be restored is found before the data is replaced.
+func Check(r io.Reader) (Counts, error) {
+	rd, err := newReader(r)
+	if err != nil {
+		return Counts{}, err
+	}
+
+	for {
+		_, err := rd.next()
+		if err == io.EOF {
+			return rd.counts, nil
+		}
+		if err != nil {
+			return rd.counts, err
+		}
+	}
+}
+
+// Restore replaces the data of the store with the archive of r and returns
+// the counts of its records.
+func Restore(r io.Reader, store Store) (Counts, error) {
+	rd, err := newReader(r)
+	if err != nil {
+		return Counts{}, err
+	}
+
+	err = store.Restore(rd.next)
+	return rd.counts, err
+}
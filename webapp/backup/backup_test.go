@@ -0,0 +1,131 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryStore backs up its records and keeps the records restored.
+type memoryStore struct {
+	records []database.BackupRecord
+}
+
+func (store *memoryStore) Backup(fn func(database.BackupRecord) error) error {
+	for _, record := range store.records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (store *memoryStore) Restore(next func() (database.BackupRecord, error)) error {
+	var records []database.BackupRecord
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		records = append(records, record)
+	}
+
+	store.records = records
+	return nil
+}
+
+var testRecords = []database.BackupRecord{
+	{Product: &database.Product{ID: 1, Name: "product1", Price: money.New(35000, "USD"), Weight: 125, Image: "/assets/images/product00001.jpg", Rating: database.Rating{Count: 1, Total: 4}}},
+	{Variant: &database.Variant{SKU: "P1-S", ProductID: 1, Name: "Small", Price: money.Money{}, Stock: 5}},
+	{User: &database.User{ID: 2, Name: "scstore", Email: "scstore@example.com"}},
+	{Coupon: &database.Coupon{Code: "SALE10", Kind: database.CouponKindPercent, Value: 10, ExpiresAt: time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC), Uses: 1, Active: true}},
+	{Coupon: &database.Coupon{Code: "WELCOME", Kind: database.CouponKindFixed, Value: 5000}},
+	{Checkout: &database.Checkout{
+		ID: "checkout1", User: database.User{ID: 2}, Product: database.Product{ID: 1}, Variant: database.Variant{SKU: "P1-S", Name: "Small"},
+		ProductQuantity: 2, CouponCode: "SALE10", TaxRegion: "US-NY",
+		Subtotal: money.New(70000, "USD"), Discount: money.New(7000, "USD"), Tax: money.New(5591, "USD"), Shipping: money.New(0, "USD"), Total: money.New(68591, "USD"),
+		Payment:         database.Payment{Status: database.PaymentPaid, ID: "fake_00001"},
+		ShippingAddress: database.Address{Name: "Jane Doe", Line1: "1 Main St", City: "New York", PostalCode: "10001", Country: "US"},
+		CreatedAt:       time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+	}},
+}
+
+func TestWriteAndRestore(t *testing.T) {
+	var archive bytes.Buffer
+	counts, err := Write(&archive, &memoryStore{records: testRecords}, time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, Counts{Products: 1, Variants: 1, Users: 1, Coupons: 2, Checkouts: 1}, counts)
+
+	lines := strings.Split(strings.TrimSuffix(archive.String(), "\n"), "\n")
+	assert.Equal(t, len(testRecords)+2, len(lines))
+	assert.Equal(t, `{"format":"scstore-backup","version":1,"created_at":"2026-10-18T09:00:00Z"}`, lines[0])
+	assert.Equal(t, `{"user":{"id":2,"name":"scstore","email":"scstore@example.com"}}`, lines[3])
+	assert.Contains(t, lines[5], `"expires_at":null`)
+	assert.Equal(t, `{"end":{"products":1,"variants":1,"users":1,"coupons":2,"checkouts":1}}`, lines[len(lines)-1])
+
+	checked, err := Check(bytes.NewReader(archive.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, counts, checked)
+
+	store := &memoryStore{}
+	restored, err := Restore(&archive, store)
+	assert.Nil(t, err)
+	assert.Equal(t, counts, restored)
+	assert.Equal(t, len(testRecords), len(store.records))
+	assert.Equal(t, *testRecords[0].Product, *store.records[0].Product)
+	assert.Equal(t, *testRecords[2].User, *store.records[2].User)
+	assert.Equal(t, *testRecords[3].Coupon, *store.records[3].Coupon)
+	assert.Equal(t, *testRecords[4].Coupon, *store.records[4].Coupon)
+	assert.Equal(t, *testRecords[5].Checkout, *store.records[5].Checkout)
+	assert.Equal(t, int64(0), store.records[1].Variant.Price.Amount)
+}
+
+func TestCheckRejectsBrokenArchives(t *testing.T) {
+	const header = `{"format":"scstore-backup","version":1,"created_at":"2026-10-18T09:00:00Z"}` + "\n"
+	const user = `{"user":{"id":2,"name":"scstore","email":"scstore@example.com"}}` + "\n"
+
+	for _, tt := range []struct {
+		name    string
+		archive string
+		err     string
+	}{
+		{"empty", "", "line 1: the header can't be read"},
+		{"other format", `{"format":"other","version":1}` + "\n", `the format is "other"`},
+		{"newer version", `{"format":"scstore-backup","version":2}` + "\n", "version 2 is not supported"},
+		{"truncated", header + user, "the archive is truncated"},
+		{"wrong counts", header + user + `{"end":{"users":2}}` + "\n", "the archive has 0 products, 0 variants, 1 users"},
+		{"two records", header + `{"user":{"id":1},"product":{"id":1}}` + "\n", "line 2: a line must have exactly one record"},
+		{"unknown record", header + `{"review":{"id":"review1"}}` + "\n", "line 2: a line must have exactly one record"},
+		{"lines after the end", header + `{"end":{}}` + "\n" + user, "line 3: there are lines after the end"},
+		{"broken line", header + `{"user":` + "\n", "line 2:"},
+	} {
+		_, err := Check(strings.NewReader(tt.archive))
+		if assert.Error(t, err, tt.name) {
+			assert.Contains(t, err.Error(), tt.err, tt.name)
+		}
+	}
+}
+
+func TestRestoreStopsAtTheEndOfATruncatedArchive(t *testing.T) {
+	var archive bytes.Buffer
+	_, err := Write(&archive, &memoryStore{records: testRecords}, time.Now())
+	assert.Nil(t, err)
+
+	truncated := archive.String()
+	truncated = truncated[:strings.LastIndex(strings.TrimSuffix(truncated, "\n"), "\n")+1]
+
+	store := &memoryStore{records: testRecords[:1]}
+	_, err = Restore(strings.NewReader(truncated), store)
+	assert.ErrorContains(t, err, "the archive is truncated")
+	assert.Equal(t, testRecords[:1], store.records)
+}
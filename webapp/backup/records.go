@@ -0,0 +1,162 @@
+package backup
+
+import (
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+// The records of an archive are independent of the types of the database
+// package, so that the archives stay readable when those change.
+
+type product struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Price       int64  `json:"price"`
+	Currency    string `json:"currency"`
+	Weight      int    `json:"weight"`
+	Image       string `json:"image"`
+	ReviewCount int    `json:"review_count"`
+	RatingTotal int    `json:"rating_total"`
+}
+
+// variant has a zero price and an empty image when it has those of its
+// product.
+type variant struct {
+	SKU       string `json:"sku"`
+	ProductID int    `json:"product_id"`
+	Name      string `json:"name"`
+	Price     int64  `json:"price"`
+	Stock     int    `json:"stock"`
+	Image     string `json:"image"`
+}
+
+type user struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type coupon struct {
+	Code           string     `json:"code"`
+	Kind           string     `json:"kind"`
+	Value          int        `json:"value"`
+	MinOrder       int        `json:"min_order"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	MaxUses        int        `json:"max_uses"`
+	MaxUsesPerUser int        `json:"max_uses_per_user"`
+	Uses           int        `json:"uses"`
+	Active         bool       `json:"active"`
+}
+
+type checkout struct {
+	ID                 string    `json:"id"`
+	UserID             int       `json:"user_id"`
+	ProductID          int       `json:"product_id"`
+	VariantSKU         string    `json:"variant_sku"`
+	VariantName        string    `json:"variant_name"`
+	ProductQuantity    int       `json:"product_quantity"`
+	CouponCode         string    `json:"coupon_code"`
+	TaxRegion          string    `json:"tax_region"`
+	Currency           string    `json:"currency"`
+	Subtotal           int64     `json:"subtotal"`
+	Discount           int64     `json:"discount"`
+	Tax                int64     `json:"tax"`
+	Shipping           int64     `json:"shipping"`
+	Total              int64     `json:"total"`
+	PaymentStatus      string    `json:"payment_status"`
+	PaymentID          string    `json:"payment_id"`
+	PaymentError       string    `json:"payment_error"`
+	ShippingName       string    `json:"shipping_name"`
+	ShippingLine1      string    `json:"shipping_line1"`
+	ShippingLine2      string    `json:"shipping_line2"`
+	ShippingCity       string    `json:"shipping_city"`
+	ShippingPostalCode string    `json:"shipping_postal_code"`
+	ShippingCountry    string    `json:"shipping_country"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+func newLine(record database.BackupRecord) (line, error) {
+	switch {
+	case record.Product != nil:
+		p := record.Product
+		return line{Product: &product{
+			ID: p.ID, Name: p.Name, Price: p.Price.Amount, Currency: p.Price.Currency, Weight: p.Weight, Image: p.Image,
+			ReviewCount: p.Rating.Count, RatingTotal: p.Rating.Total,
+		}}, nil
+	case record.Variant != nil:
+		v := record.Variant
+		return line{Variant: &variant{SKU: v.SKU, ProductID: v.ProductID, Name: v.Name, Price: v.Price.Amount, Stock: v.Stock, Image: v.Image}}, nil
+	case record.User != nil:
+		u := record.User
+		return line{User: &user{ID: u.ID, Name: u.Name, Email: u.Email}}, nil
+	case record.Coupon != nil:
+		c := record.Coupon
+		l := line{Coupon: &coupon{
+			Code: c.Code, Kind: c.Kind, Value: c.Value, MinOrder: c.MinOrder, MaxUses: c.MaxUses, MaxUsesPerUser: c.MaxUsesPerUser,
+			Uses: c.Uses, Active: c.Active,
+		}}
+		if !c.ExpiresAt.IsZero() {
+			expiresAt := c.ExpiresAt.UTC()
+			l.Coupon.ExpiresAt = &expiresAt
+		}
+		return l, nil
+	case record.Checkout != nil:
+		c := record.Checkout
+		return line{Checkout: &checkout{
+			ID: c.ID, UserID: c.User.ID, ProductID: c.Product.ID, VariantSKU: c.Variant.SKU, VariantName: c.Variant.Name,
+			ProductQuantity: c.ProductQuantity, CouponCode: c.CouponCode, TaxRegion: c.TaxRegion, Currency: c.Total.Currency,
+			Subtotal: c.Subtotal.Amount, Discount: c.Discount.Amount, Tax: c.Tax.Amount, Shipping: c.Shipping.Amount, Total: c.Total.Amount,
+			PaymentStatus: c.Payment.Status, PaymentID: c.Payment.ID, PaymentError: c.Payment.Error,
+			ShippingName: c.ShippingAddress.Name, ShippingLine1: c.ShippingAddress.Line1, ShippingLine2: c.ShippingAddress.Line2,
+			ShippingCity: c.ShippingAddress.City, ShippingPostalCode: c.ShippingAddress.PostalCode, ShippingCountry: c.ShippingAddress.Country,
+			CreatedAt: c.CreatedAt.UTC(),
+		}}, nil
+	default:
+		return line{}, database.NewValidationError("backup record is empty")
+	}
+}
+
+// record returns the record of a line which is not the end.
+func (l line) record() database.BackupRecord {
+	switch {
+	case l.Product != nil:
+		p := l.Product
+		return database.BackupRecord{Product: &database.Product{
+			ID: p.ID, Name: p.Name, Price: money.New(p.Price, p.Currency), Weight: p.Weight, Image: p.Image,
+			Rating: database.Rating{Count: p.ReviewCount, Total: p.RatingTotal},
+		}}
+	case l.Variant != nil:
+		v := l.Variant
+		return database.BackupRecord{Variant: &database.Variant{SKU: v.SKU, ProductID: v.ProductID, Name: v.Name, Price: money.Money{Amount: v.Price}, Stock: v.Stock, Image: v.Image}}
+	case l.User != nil:
+		u := l.User
+		return database.BackupRecord{User: &database.User{ID: u.ID, Name: u.Name, Email: u.Email}}
+	case l.Coupon != nil:
+		c := l.Coupon
+		record := database.BackupRecord{Coupon: &database.Coupon{
+			Code: c.Code, Kind: c.Kind, Value: c.Value, MinOrder: c.MinOrder, MaxUses: c.MaxUses, MaxUsesPerUser: c.MaxUsesPerUser,
+			Uses: c.Uses, Active: c.Active,
+		}}
+		if c.ExpiresAt != nil {
+			record.Coupon.ExpiresAt = *c.ExpiresAt
+		}
+		return record
+	default:
+		c := l.Checkout
+		return database.BackupRecord{Checkout: &database.Checkout{
+			ID: c.ID, User: database.User{ID: c.UserID}, Product: database.Product{ID: c.ProductID},
+			Variant: database.Variant{SKU: c.VariantSKU, Name: c.VariantName}, ProductQuantity: c.ProductQuantity,
+			CouponCode: c.CouponCode, TaxRegion: c.TaxRegion,
+			Subtotal: money.New(c.Subtotal, c.Currency), Discount: money.New(c.Discount, c.Currency), Tax: money.New(c.Tax, c.Currency),
+			Shipping: money.New(c.Shipping, c.Currency), Total: money.New(c.Total, c.Currency),
+			Payment: database.Payment{Status: c.PaymentStatus, ID: c.PaymentID, Error: c.PaymentError},
+			ShippingAddress: database.Address{
+				Name: c.ShippingName, Line1: c.ShippingLine1, Line2: c.ShippingLine2, City: c.ShippingCity,
+				PostalCode: c.ShippingPostalCode, Country: c.ShippingCountry,
+			},
+			CreatedAt: c.CreatedAt,
+		}}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/app"
+	"github.com/mittz/role-play-webapp/webapp/backup"
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/mittz/role-play-webapp/webapp/utils"
+)
+
+// version is set when the binary is built with
+// -ldflags "-X main.version=1.2.3".
+var version = "dev"
+
+// command is a subcommand of the binary. It parses its own flags from args
+// and writes what it prints to out.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, out io.Writer) error
+}
+
+var commands = []command{
+	{"serve", "migrate the database and serve the app, the default command", serve},
+	{"init", "drop every table and load initdata.json again", initDatabase},
+	{"seed", "insert the products and users of initdata.json which are missing", seedDatabase},
+	{"migrate", "migrate the schema to the version of the app, keeping the data", migrateDatabase},
+	{"backup", "write the data of the database to FILE", backupCommand},
+	{"restore", "replace the data of the database with the archive FILE", restoreCommand},
+	{"check-config", "validate the configuration of the environment", checkConfig},
+	{"version", "print the version of the binary", printVersion},
+}
+
+// runCommand runs the command named by the first argument, or serve when
+// there is no command so that the flags of serve may be given alone.
+func runCommand(args []string, out io.Writer) error {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		printUsage(out)
+		return nil
+	}
+
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+
+		err := c.run(args, out)
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	printUsage(out)
+	return fmt.Errorf("unknown command %q", name)
+}
+
+func printUsage(out io.Writer) {
+	fmt.Fprintf(out, "usage: %s [command] [flags] [arguments]\n\ncommands:\n", program())
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-13s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(out, "\nRun %s COMMAND -h for the flags of a command.\n", program())
+}
+
+func program() string {
+	return filepath.Base(os.Args[0])
+}
+
+// newFlagSet returns the flags of the command, whose usage shows the
+// arguments expected after them.
+func newFlagSet(name string, arguments string, out io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() {
+		fmt.Fprintf(out, "usage: %s %s [flags]%s\n", program(), name, arguments)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// parseFlags parses args and returns the arguments after the flags, which
+// must be n.
+func parseFlags(flags *flag.FlagSet, args []string, n int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() != n {
+		flags.Usage()
+		return nil, fmt.Errorf("%s takes %d arguments, but %d were given", flags.Name(), n, flags.NArg())
+	}
+
+	return flags.Args(), nil
+}
+
+// withDatabase connects to the database of DB_ENVIRONMENT for fn.
+func withDatabase(fn func(dbHandler database.DatabaseHandler) error) error {
+	dbHandler, closeDB, err := openDatabaseHandler(utils.GetEnvDBEnvironment())
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	return fn(dbHandler)
+}
+
+// serve keeps the data of the database. It only migrates the schema unless
+// -init drops every table, and seeds a database without products, which a
+// new one is, so that the shop has products and the app its user.
+func serve(args []string, out io.Writer) error {
+	flags := newFlagSet("serve", "", out)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	migrate := flags.Bool("migrate", true, "create the missing tables and indexes before serving")
+	seed := flags.Bool("seed", false, "insert the missing products and users of initdata.json before serving, which a database without products always gets")
+	reinit := flags.Bool("init", false, "drop every table and load initdata.json before serving")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	return withDatabase(func(dbHandler database.DatabaseHandler) error {
+		if *reinit {
+			if err := dbHandler.InitDatabase(); err != nil {
+				return fmt.Errorf("failed to init database: %w", err)
+			}
+		}
+		if *migrate && !*reinit {
+			if err := dbHandler.MigrateDatabase(); err != nil {
+				return fmt.Errorf("failed to migrate database: %w", err)
+			}
+		}
+		if !*seed && !*reinit {
+			products, err := dbHandler.GetProducts()
+			if err != nil {
+				return fmt.Errorf("failed to read the products: %w", err)
+			}
+			if len(products) == 0 {
+				log.Printf("Seeding the database without products with %s", database.InitDataJSONFileName)
+				*seed = true
+			}
+		}
+		if *seed && !*reinit {
+			if err := dbHandler.SeedDatabase(); err != nil {
+				return fmt.Errorf("failed to seed database: %w", err)
+			}
+		}
+
+		const assetsDir = "./app/assets"
+		const templatesDirMatch = "./app/templates/*"
+
+		router := app.SetupRouter(dbHandler, assetsDir, templatesDirMatch)
+		app.StartWebhookDispatcher(dbHandler)
+		app.StartCheckoutWorkers(dbHandler)
+		app.StartRecommendations()
+		return router.Run(*addr)
+	})
+}
+
+func initDatabase(args []string, out io.Writer) error {
+	flags := newFlagSet("init", "", out)
+	force := flags.Bool("force", false, "confirm that every table is dropped")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if !*force {
+		return errors.New("init drops every table of the database, run it with -force")
+	}
+
+	return withDatabase(func(dbHandler database.DatabaseHandler) error {
+		if err := dbHandler.InitDatabase(); err != nil {
+			return fmt.Errorf("failed to init database: %w", err)
+		}
+
+		log.Printf("Initialized the database with %s", database.InitDataJSONFileName)
+		return nil
+	})
+}
+
+func seedDatabase(args []string, out io.Writer) error {
+	flags := newFlagSet("seed", "", out)
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	return withDatabase(func(dbHandler database.DatabaseHandler) error {
+		if err := dbHandler.SeedDatabase(); err != nil {
+			return fmt.Errorf("failed to seed database: %w", err)
+		}
+
+		log.Printf("Seeded the database with %s", database.InitDataJSONFileName)
+		return nil
+	})
+}
+
+func migrateDatabase(args []string, out io.Writer) error {
+	flags := newFlagSet("migrate", "", out)
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	return withDatabase(func(dbHandler database.DatabaseHandler) error {
+		if err := dbHandler.MigrateDatabase(); err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
+		}
+
+		log.Printf("Migrated the database")
+		return nil
+	})
+}
+
+func backupCommand(args []string, out io.Writer) error {
+	flags := newFlagSet("backup", " FILE", out)
+	args, err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	return withDatabase(func(dbHandler database.DatabaseHandler) error {
+		return backupDatabase(dbHandler, args[0])
+	})
+}
+
+func restoreCommand(args []string, out io.Writer) error {
+	flags := newFlagSet("restore", " FILE", out)
+	args, err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	return withDatabase(func(dbHandler database.DatabaseHandler) error {
+		return restoreDatabase(dbHandler, args[0])
+	})
+}
+
+// checkConfig validates the environment without connecting to the database
+// unless -connect is given, so that it runs before a deployment.
+func checkConfig(args []string, out io.Writer) error {
+	flags := newFlagSet("check-config", "", out)
+	connect := flags.Bool("connect", false, "connect to the database and read the products as well")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	if err := checkDatabaseConfig(utils.GetEnvDBEnvironment()); err != nil {
+		return err
+	}
+	if err := app.CheckConfig(); err != nil {
+		return err
+	}
+
+	if *connect {
+		if err := withDatabase(func(dbHandler database.DatabaseHandler) error {
+			_, err := dbHandler.GetProducts()
+			return err
+		}); err != nil {
+			return fmt.Errorf("failed to read the database: %w", err)
+		}
+	}
+
+	fmt.Fprintln(out, "The configuration is valid")
+	return nil
+}
+
+func printVersion(args []string, out io.Writer) error {
+	flags := newFlagSet("version", "", out)
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%s %s %s", program(), version, runtime.Version())
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				fmt.Fprintf(out, " %s", setting.Value)
+			}
+		}
+	}
+	fmt.Fprintln(out)
+
+	return nil
+}
+
+// backupDatabase writes the archive to a temporary file next to path and
+// renames it once it is complete, so that path is never half written.
+func backupDatabase(dbHandler database.DatabaseHandler, path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	counts, err := backup.Write(f, dbHandler, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to back up the database: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	log.Printf("Backed up %s to %s", counts, path)
+	return nil
+}
+
+// restoreDatabase checks the whole archive first, as the data is replaced.
+// The missing tables are created before the restore so that the database
+// may be a new one.
+func restoreDatabase(dbHandler database.DatabaseHandler, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := backup.Check(f); err != nil {
+		return fmt.Errorf("%s can't be restored: %w", path, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := dbHandler.MigrateDatabase(); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	counts, err := backup.Restore(f, dbHandler)
+	if err != nil {
+		return fmt.Errorf("failed to restore the database: %w", err)
+	}
+
+	log.Printf("Restored %s from %s", counts, path)
+	return nil
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Address is a shipping address saved by a user. Orders keep a copy of the
+// address they were shipped to, so editing or deleting it later doesn't
+// change past orders. Country is an ISO 3166-1 alpha-2 code like US.
+type Address struct {
+	ID         string
+	UserID     int
+	Name       string
+	Line1      string
+	Line2      string
+	City       string
+	PostalCode string
+	Country    string
+}
+
+// Validate checks the fields of an address entered by a user.
+func (address Address) Validate() error {
+	fields := make(map[string]string)
+
+	for _, field := range []struct {
+		name     string
+		value    string
+		required bool
+		max      int
+		message  string
+	}{
+		{"name", address.Name, true, 50, "Name must be 1 to 50 characters."},
+		{"line1", address.Line1, true, 100, "Address must be 1 to 100 characters."},
+		{"line2", address.Line2, false, 100, "Address line 2 must be at most 100 characters."},
+		{"city", address.City, true, 50, "City must be 1 to 50 characters."},
+		{"postal_code", address.PostalCode, true, 20, "Postal code must be 1 to 20 characters."},
+	} {
+		length := utf8.RuneCountInString(strings.TrimSpace(field.value))
+		if (field.required && length == 0) || length > field.max {
+			fields[field.name] = field.message
+		}
+	}
+
+	if !isCountryCode(address.Country) {
+		fields["country"] = "Country must be a two letter code like US."
+	}
+
+	if len(fields) > 0 {
+		return NewFieldValidationError(fields)
+	}
+
+	return nil
+}
+
+// IsZero reports whether the order has no shipping address.
+func (address Address) IsZero() bool {
+	return address.Name == "" && address.Line1 == "" && address.Country == ""
+}
+
+func isCountryCode(code string) bool {
+	if len(code) != 2 {
+		return false
+	}
+
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,21 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressValidate(t *testing.T) {
+	address := Address{Name: "Home", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"}
+	assert.Nil(t, address.Validate())
+
+	err := Address{Name: " ", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "us"}.Validate()
+	assert.ErrorIs(t, err, ErrValidation)
+
+	var dbErr *Error
+	assert.ErrorAs(t, err, &dbErr)
+	assert.Equal(t, 2, len(dbErr.Fields))
+	assert.Contains(t, dbErr.Fields, "name")
+	assert.Contains(t, dbErr.Fields, "country")
+}
@@ -0,0 +1,14 @@
+package database
+
+// BackupRecord is a row of a backup. Exactly one of its fields is set.
+//
+// A Variant has the price and the image of its own row, so a zero price and
+// an empty image keep those of the product like in CreateVariant. A Checkout
+// has the IDs of its user and product, not their other fields.
+type BackupRecord struct {
+	Product  *Product
+	Variant  *Variant
+	User     *User
+	Coupon   *Coupon
+	Checkout *Checkout
+}
@@ -0,0 +1,1101 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/mittz/role-play-webapp/webapp/pubsub"
+	"github.com/stretchr/testify/assert"
+)
+
+// inWebappDir runs fn in the webapp directory where initdata.json is.
+func inWebappDir(t *testing.T, fn func() error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if err := fn(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func initDatabaseForTest(t *testing.T, dbh DatabaseHandler) {
+	inWebappDir(t, dbh.InitDatabase)
+}
+
+// testDatabaseHandlerBehaviour checks the behaviour every backend has to share
+// against a real database.
+func testDatabaseHandlerBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	product, err := dbh.GetProduct(1)
+	assert.Nil(t, err)
+	assert.Equal(t, Product{ID: 1, Name: "Product00001", Price: money.New(35000, "USD"), Weight: 125, Image: "/assets/images/product00001.jpg"}, product)
+
+	_, err = dbh.GetProduct(100000)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	products, err := dbh.GetProducts()
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(products))
+
+	checkoutID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 3, TaxRegion: "US-NY", TaxRate: 8.875})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, checkoutID)
+
+	checkout, err := dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, checkoutID, checkout.ID)
+	assert.Equal(t, User{ID: 2, Name: "scstore", Email: "scstore@example.com"}, checkout.User)
+	assert.Equal(t, product, checkout.Product)
+	assert.Equal(t, 3, checkout.ProductQuantity)
+	assert.Equal(t, "US-NY", checkout.TaxRegion)
+	assert.Equal(t, money.New(105000, "USD"), checkout.Subtotal)
+	assert.Equal(t, money.New(0, "USD"), checkout.Discount)
+	assert.Equal(t, money.New(9319, "USD"), checkout.Tax)
+	assert.Equal(t, money.New(114319, "USD"), checkout.Total)
+	assert.Equal(t, Payment{Status: PaymentPending}, checkout.Payment)
+	assert.False(t, checkout.CreatedAt.IsZero())
+	assert.True(t, checkout.CreatedAt.Before(time.Now().Add(time.Minute)))
+
+	// The schema rejects checkouts of missing products and non positive quantities.
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 100000, ProductQuantity: 1})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 0})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	_, err = dbh.GetCheckout("00000000-0000-0000-0000-000000000000")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// The result of the payment is recorded on the order.
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentFailed, Error: "payment declined: the card was declined"}))
+	checkout, err = dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, Payment{Status: PaymentFailed, Error: "payment declined: the card was declined"}, checkout.Payment)
+
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentPaid, ID: "fake_00001"}))
+	checkout, err = dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, Payment{Status: PaymentPaid, ID: "fake_00001"}, checkout.Payment)
+
+	assert.ErrorIs(t, dbh.UpdateCheckoutPayment("00000000-0000-0000-0000-000000000000", Payment{Status: PaymentPaid}), ErrNotFound)
+	assert.ErrorIs(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: "unknown"}), ErrValidation)
+
+	// A paid order is claimed once for its refund, which only becomes the
+	// result of the refund then.
+	var claimed int32
+	var claims sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		claims.Add(1)
+		go func() {
+			defer claims.Done()
+			if err := dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentRefunding, ID: "fake_00001"}); err == nil {
+				atomic.AddInt32(&claimed, 1)
+			} else {
+				assert.ErrorIs(t, err, ErrConflict)
+			}
+		}()
+	}
+	claims.Wait()
+	assert.Equal(t, int32(1), claimed)
+	assert.ErrorIs(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentCharging}), ErrConflict)
+	assert.ErrorIs(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentPending}), ErrConflict)
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentPaid, ID: "fake_00001"}))
+	assert.ErrorIs(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentCharging}), ErrConflict)
+
+	checkouts, err := dbh.GetCheckouts(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []Checkout{checkout}, checkouts)
+
+	checkouts, err = dbh.GetCheckouts(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(checkouts))
+
+	// InitDatabase drops the existing data.
+	initDatabaseForTest(t, dbh)
+
+	checkouts, err = dbh.GetCheckouts(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(checkouts))
+
+	testCouponBehaviour(t, dbh)
+	testShippingBehaviour(t, dbh)
+	testWebhookBehaviour(t, dbh)
+	testCheckoutQueueBehaviour(t, dbh)
+	testCheckoutUpdateBehaviour(t, dbh)
+	testReviewBehaviour(t, dbh)
+	testWishlistBehaviour(t, dbh)
+	testPurchaseStatsBehaviour(t, dbh)
+	testVariantBehaviour(t, dbh)
+	testSalesReportBehaviour(t, dbh)
+	testExportCheckoutsBehaviour(t, dbh)
+	testBackupBehaviour(t, dbh)
+	testMigrateBehaviour(t, dbh)
+}
+
+// testCouponBehaviour checks that coupons are applied and their usage limits
+// hold under concurrent checkouts. Product 1 costs $350.00.
+func testCouponBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	assert.Nil(t, dbh.CreateCoupon(Coupon{Code: "SALE10", Kind: CouponKindPercent, Value: 10}))
+	assert.Nil(t, dbh.CreateCoupon(Coupon{Code: "BIG", Kind: CouponKindFixed, Value: 10000, MinOrder: 100000}))
+	assert.Nil(t, dbh.CreateCoupon(Coupon{Code: "ONCE", Kind: CouponKindFixed, Value: 50, MaxUsesPerUser: 1}))
+	assert.Nil(t, dbh.CreateCoupon(Coupon{Code: "OLD", Kind: CouponKindFixed, Value: 50, ExpiresAt: time.Now().Add(-time.Hour)}))
+	assert.Nil(t, dbh.CreateCoupon(Coupon{Code: "LIMITED", Kind: CouponKindFixed, Value: 50, MaxUses: 3}))
+
+	assert.ErrorIs(t, dbh.CreateCoupon(Coupon{Code: "SALE10", Kind: CouponKindPercent, Value: 20}), ErrConflict)
+	assert.ErrorIs(t, dbh.CreateCoupon(Coupon{Code: "BAD", Kind: CouponKindPercent, Value: 101}), ErrValidation)
+
+	checkoutID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 2, CouponCode: "SALE10", TaxRegion: "DE", TaxRate: 19})
+	assert.Nil(t, err)
+	checkout, err := dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, "SALE10", checkout.CouponCode)
+	assert.Equal(t, money.New(70000, "USD"), checkout.Subtotal)
+	assert.Equal(t, money.New(7000, "USD"), checkout.Discount)
+	assert.Equal(t, money.New(11970, "USD"), checkout.Tax)
+	assert.Equal(t, money.New(74970, "USD"), checkout.Total)
+
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 2, CouponCode: "BIG"})
+	assert.ErrorIs(t, err, ErrValidation)
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1, CouponCode: "OLD"})
+	assert.ErrorIs(t, err, ErrValidation)
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1, CouponCode: "NOPE"})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	// A failed order gives its use back and doesn't count towards the limit
+	// per user.
+	onceID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1, CouponCode: "ONCE"})
+	assert.Nil(t, err)
+	assert.Nil(t, dbh.UpdateCheckoutPayment(onceID, Payment{Status: PaymentFailed, Error: "payment declined"}))
+	onceID, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1, CouponCode: "ONCE"})
+	assert.Nil(t, err)
+	assert.Nil(t, dbh.UpdateCheckoutPayment(onceID, Payment{Status: PaymentPaid, ID: "fake_00001"}))
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1, CouponCode: "ONCE"})
+	assert.ErrorIs(t, err, ErrValidation)
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 1, ProductID: 1, ProductQuantity: 1, CouponCode: "ONCE"})
+	assert.Nil(t, err)
+
+	// The pending orders count towards the limit per user, so that the
+	// concurrent or queued checkouts of a user can't go over it.
+	assert.Nil(t, dbh.CreateCoupon(Coupon{Code: "TWICE", Kind: CouponKindFixed, Value: 50, MaxUsesPerUser: 2}))
+	twiceID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 1, ProductID: 1, ProductQuantity: 1, CouponCode: "TWICE"})
+	assert.Nil(t, err)
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 1, ProductID: 1, ProductQuantity: 1, CouponCode: "TWICE"})
+	assert.Nil(t, err)
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 1, ProductID: 1, ProductQuantity: 1, CouponCode: "TWICE"})
+	assert.ErrorIs(t, err, ErrValidation)
+	assert.Nil(t, dbh.UpdateCheckoutPayment(twiceID, Payment{Status: PaymentFailed, Error: "payment declined"}))
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 1, ProductID: 1, ProductQuantity: 1, CouponCode: "TWICE"})
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	var succeeded int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1, CouponCode: "TWICE"}); err == nil {
+				atomic.AddInt32(&succeeded, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), succeeded)
+
+	succeeded = 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1, CouponCode: "LIMITED"}); err == nil {
+				atomic.AddInt32(&succeeded, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), succeeded)
+
+	assert.Nil(t, dbh.DeactivateCoupon("SALE10"))
+	assert.ErrorIs(t, dbh.DeactivateCoupon("NOPE"), ErrNotFound)
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1, CouponCode: "SALE10"})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	coupons, err := dbh.GetCoupons()
+	assert.Nil(t, err)
+	uses := make(map[string]int)
+	for _, coupon := range coupons {
+		uses[coupon.Code] = coupon.Uses
+	}
+	assert.Equal(t, map[string]int{"BIG": 0, "LIMITED": 3, "OLD": 0, "ONCE": 2, "SALE10": 1, "TWICE": 4}, uses)
+}
+
+// testShippingBehaviour checks the address book and that orders keep a copy
+// of their address. Product 1 costs $350.00 and weighs 125 g.
+func testShippingBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	home := Address{UserID: 2, Name: "Home", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"}
+	office := Address{UserID: 2, Name: "Office", Line1: "Unter den Linden 1", Line2: "3. OG", City: "Berlin", PostalCode: "10117", Country: "DE"}
+
+	var err error
+	home.ID, err = dbh.CreateAddress(home)
+	assert.Nil(t, err)
+	office.ID, err = dbh.CreateAddress(office)
+	assert.Nil(t, err)
+
+	_, err = dbh.CreateAddress(Address{UserID: 2, Name: "Bad", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "usa"})
+	assert.ErrorIs(t, err, ErrValidation)
+	_, err = dbh.CreateAddress(Address{UserID: 100000, Name: "Nobody", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	addresses, err := dbh.GetAddresses(2)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []Address{home, office}, addresses)
+
+	addresses, err = dbh.GetAddresses(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(addresses))
+
+	// 10 x 125 g are charged as 2 started kilograms.
+	checkoutID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 10, ShippingAddress: office,
+		ShippingRate: ShippingRate{Kind: ShippingKindWeight, Amount: 500}})
+	assert.Nil(t, err)
+	checkout, err := dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, money.New(1000, "USD"), checkout.Shipping)
+	assert.Equal(t, money.New(351000, "USD"), checkout.Total)
+
+	snapshot := office
+	snapshot.ID, snapshot.UserID = "", 0
+	assert.Equal(t, snapshot, checkout.ShippingAddress)
+
+	flat := ShippingRate{Kind: ShippingKindFlat, Amount: 700, FreeOver: 100000}
+	checkoutID, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1, ShippingAddress: home, ShippingRate: flat})
+	assert.Nil(t, err)
+	checkout, err = dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, money.New(700, "USD"), checkout.Shipping)
+	assert.Equal(t, money.New(35700, "USD"), checkout.Total)
+
+	checkoutID, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 3, ShippingAddress: home, ShippingRate: flat})
+	assert.Nil(t, err)
+	checkout, err = dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, money.New(0, "USD"), checkout.Shipping)
+	assert.Equal(t, money.New(105000, "USD"), checkout.Total)
+
+	// Deleting an address keeps the copies on the orders.
+	assert.ErrorIs(t, dbh.DeleteAddress(1, home.ID), ErrNotFound)
+	assert.Nil(t, dbh.DeleteAddress(2, home.ID))
+	assert.ErrorIs(t, dbh.DeleteAddress(2, home.ID), ErrNotFound)
+
+	addresses, err = dbh.GetAddresses(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []Address{office}, addresses)
+
+	checkout, err = dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Home", checkout.ShippingAddress.Name)
+}
+
+// testWebhookBehaviour checks that the changes of the orders reach the
+// webhooks through the outbox, and the retries of their deliveries.
+func testWebhookBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	all := Webhook{URL: "https://example.com/hooks/all", Secret: "0123456789abcdef", EventTypes: WebhookEventTypes}
+	created := Webhook{URL: "https://example.com/hooks/created", Secret: "fedcba9876543210", EventTypes: []string{WebhookEventCheckoutCreated}}
+
+	var err error
+	all.ID, err = dbh.CreateWebhook(all)
+	assert.Nil(t, err)
+	created.ID, err = dbh.CreateWebhook(created)
+	assert.Nil(t, err)
+
+	_, err = dbh.CreateWebhook(Webhook{URL: "ftp://example.com", Secret: "short", EventTypes: []string{"checkout.deleted"}})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	webhooks, err := dbh.GetWebhooks()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(webhooks))
+	for _, webhook := range webhooks {
+		assert.True(t, webhook.Active)
+		assert.False(t, webhook.CreatedAt.IsZero())
+	}
+
+	// Nothing is delivered before the events are dispatched.
+	checkoutID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 3})
+	assert.Nil(t, err)
+	deliveries, err := dbh.ClaimWebhookDeliveries(time.Now(), time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(deliveries))
+
+	dispatched, err := dbh.DispatchWebhookEvents(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, dispatched)
+	dispatched, err = dbh.DispatchWebhookEvents(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, dispatched)
+
+	now := time.Now()
+	deliveries, err = dbh.ClaimWebhookDeliveries(now, time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(deliveries))
+
+	var payload struct {
+		ID   string
+		Type string
+		Data struct {
+			CheckoutID string `json:"checkout_id"`
+			Total      int64
+		}
+	}
+	for _, delivery := range deliveries {
+		assert.Equal(t, DeliveryPending, delivery.Status)
+		assert.Equal(t, WebhookEventCheckoutCreated, delivery.Event.Type)
+		assert.Nil(t, json.Unmarshal(delivery.Event.Payload, &payload))
+		assert.Equal(t, delivery.Event.ID, payload.ID)
+		assert.Equal(t, checkoutID, payload.Data.CheckoutID)
+		assert.Equal(t, int64(105000), payload.Data.Total)
+	}
+
+	// Claimed deliveries are left to their sender until the lease ends.
+	claimed, err := dbh.ClaimWebhookDeliveries(now, time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(claimed))
+	claimed, err = dbh.ClaimWebhookDeliveries(now.Add(2*time.Minute), time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(claimed))
+
+	var retried WebhookDelivery
+	for _, delivery := range deliveries {
+		if delivery.Webhook.ID == all.ID {
+			retried = delivery
+			assert.Equal(t, all.URL, delivery.Webhook.URL)
+			assert.Equal(t, all.Secret, delivery.Webhook.Secret)
+			assert.Nil(t, dbh.RecordWebhookAttempt(delivery.ID, WebhookAttempt{StatusCode: 503, Error: "503 Service Unavailable", NextAttemptAt: now.Add(10 * time.Minute)}))
+		} else {
+			assert.Nil(t, dbh.RecordWebhookAttempt(delivery.ID, WebhookAttempt{Succeeded: true, StatusCode: 204}))
+		}
+	}
+	assert.ErrorIs(t, dbh.RecordWebhookAttempt("00000000-0000-0000-0000-000000000000", WebhookAttempt{Succeeded: true}), ErrNotFound)
+
+	claimed, err = dbh.ClaimWebhookDeliveries(now.Add(5*time.Minute), time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(claimed))
+	claimed, err = dbh.ClaimWebhookDeliveries(now.Add(11*time.Minute), time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(claimed))
+	assert.Equal(t, retried.ID, claimed[0].ID)
+	assert.Equal(t, 1, claimed[0].Attempts)
+	assert.Nil(t, dbh.RecordWebhookAttempt(retried.ID, WebhookAttempt{Error: "connection refused"}))
+
+	deliveries, err = dbh.GetWebhookDeliveries(all.ID, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(deliveries))
+	assert.Equal(t, DeliveryFailed, deliveries[0].Status)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	assert.Equal(t, "connection refused", deliveries[0].LastError)
+
+	deliveries, err = dbh.GetWebhookDeliveries(created.ID, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(deliveries))
+	assert.Equal(t, DeliverySucceeded, deliveries[0].Status)
+	assert.Equal(t, 204, deliveries[0].LastStatusCode)
+
+	// Changes of the payment only go to the webhooks which subscribe to them,
+	// and deactivated webhooks get no new events.
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentPaid, ID: "fake_00001"}))
+	dispatched, err = dbh.DispatchWebhookEvents(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, dispatched)
+	claimed, err = dbh.ClaimWebhookDeliveries(time.Now(), time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(claimed))
+	assert.Equal(t, all.ID, claimed[0].Webhook.ID)
+	assert.Equal(t, WebhookEventCheckoutStatusChanged, claimed[0].Event.Type)
+
+	assert.Nil(t, dbh.DeactivateWebhook(created.ID))
+	assert.ErrorIs(t, dbh.DeactivateWebhook("00000000-0000-0000-0000-000000000000"), ErrNotFound)
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1})
+	assert.Nil(t, err)
+	dispatched, err = dbh.DispatchWebhookEvents(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, dispatched)
+
+	deliveries, err = dbh.GetWebhookDeliveries(created.ID, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(deliveries))
+}
+
+// testCheckoutQueueBehaviour checks the back-pressure of the checkout queue,
+// the leases of its jobs and that a job processed twice creates one order.
+func testCheckoutQueueBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	order := CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 3, TaxRegion: "US-NY", TaxRate: 8.875,
+		ShippingAddress: Address{Name: "Home", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"}}
+
+	firstID, err := dbh.EnqueueCheckout(order, 2)
+	assert.Nil(t, err)
+	secondID, err := dbh.EnqueueCheckout(order, 2)
+	assert.Nil(t, err)
+	_, err = dbh.EnqueueCheckout(order, 2)
+	assert.ErrorIs(t, err, ErrQueueFull)
+	_, err = dbh.EnqueueCheckout(CheckoutRequest{UserID: 100000, ProductID: 1, ProductQuantity: 1}, 0)
+	assert.ErrorIs(t, err, ErrValidation)
+
+	depth, err := dbh.GetCheckoutQueueDepth()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, depth)
+
+	job, err := dbh.GetCheckoutJob(firstID)
+	assert.Nil(t, err)
+	assert.Equal(t, CheckoutJobQueued, job.Status)
+	assert.True(t, job.Pending())
+	expected := order
+	expected.ID = firstID
+	assert.Equal(t, expected, job.Request)
+
+	_, err = dbh.GetCheckoutJob("00000000-0000-0000-0000-000000000000")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Claimed jobs are left to their worker until the lease ends.
+	now := time.Now()
+	jobs, err := dbh.ClaimCheckoutJobs(now, time.Minute, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, firstID, jobs[0].ID)
+	assert.Equal(t, CheckoutJobProcessing, jobs[0].Status)
+	assert.Equal(t, 1, jobs[0].Attempts)
+
+	jobs, err = dbh.ClaimCheckoutJobs(now, time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, secondID, jobs[0].ID)
+	jobs, err = dbh.ClaimCheckoutJobs(now, time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(jobs))
+
+	jobs, err = dbh.ClaimCheckoutJobs(now.Add(2*time.Minute), time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(jobs))
+	assert.Equal(t, 2, jobs[0].Attempts)
+
+	// The job ID is the ID of its checkout.
+	checkoutID, err := dbh.CreateCheckout(jobs[0].Request)
+	assert.Nil(t, err)
+	assert.Equal(t, firstID, checkoutID)
+	_, err = dbh.CreateCheckout(jobs[0].Request)
+	assert.ErrorIs(t, err, ErrConflict)
+	checkouts, err := dbh.GetCheckouts(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(checkouts))
+
+	assert.Nil(t, dbh.FinishCheckoutJob(firstID, CheckoutJobDone, ""))
+	assert.Nil(t, dbh.FinishCheckoutJob(secondID, CheckoutJobFailed, "The coupon code is not valid."))
+	assert.ErrorIs(t, dbh.FinishCheckoutJob(secondID, CheckoutJobQueued, ""), ErrValidation)
+	assert.ErrorIs(t, dbh.FinishCheckoutJob("00000000-0000-0000-0000-000000000000", CheckoutJobDone, ""), ErrNotFound)
+
+	job, err = dbh.GetCheckoutJob(secondID)
+	assert.Nil(t, err)
+	assert.Equal(t, CheckoutJobFailed, job.Status)
+	assert.Equal(t, "The coupon code is not valid.", job.Error)
+	assert.False(t, job.Pending())
+
+	depth, err = dbh.GetCheckoutQueueDepth()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, depth)
+	jobs, err = dbh.ClaimCheckoutJobs(now.Add(time.Hour), time.Minute, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(jobs))
+}
+
+func testCheckoutUpdateBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	userSub := SubscribeUserCheckouts(2)
+	defer userSub.Close()
+
+	checkoutID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, CheckoutUpdate{CheckoutID: checkoutID, UserID: 2, PaymentStatus: PaymentPending}, receiveCheckoutUpdate(t, userSub))
+
+	checkoutSub := SubscribeCheckout(checkoutID)
+	defer checkoutSub.Close()
+
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentFailed, Error: "payment declined"}))
+	update := CheckoutUpdate{CheckoutID: checkoutID, UserID: 2, PaymentStatus: PaymentFailed, PaymentError: "payment declined"}
+	assert.Equal(t, update, receiveCheckoutUpdate(t, checkoutSub))
+	assert.Equal(t, update, receiveCheckoutUpdate(t, userSub))
+
+	// Failed changes are not sent.
+	assert.ErrorIs(t, dbh.UpdateCheckoutPayment("00000000-0000-0000-0000-000000000000", Payment{Status: PaymentPaid}), ErrNotFound)
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentPaid, ID: "fake_00001"}))
+	assert.Equal(t, PaymentPaid, receiveCheckoutUpdate(t, checkoutSub).PaymentStatus)
+}
+
+func receiveCheckoutUpdate(t *testing.T, sub *pubsub.Subscription[CheckoutUpdate]) CheckoutUpdate {
+	t.Helper()
+
+	select {
+	case update := <-sub.C:
+		return update
+	case <-time.After(5 * time.Second):
+		t.Fatal("no checkout update is received")
+		return CheckoutUpdate{}
+	}
+}
+
+func testReviewBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	// Only the users who paid for the product can review it.
+	_, err := dbh.CreateReview(Review{ProductID: 1, User: User{ID: 2}, Rating: 5, Body: "Keeps good time."})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	checkoutID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1})
+	assert.Nil(t, err)
+	_, err = dbh.CreateReview(Review{ProductID: 1, User: User{ID: 2}, Rating: 5, Body: "Keeps good time."})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentPaid, ID: "fake_00001"}))
+	reviewID, err := dbh.CreateReview(Review{ProductID: 1, User: User{ID: 2}, Rating: 5, Body: " Keeps good time. "})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, reviewID)
+
+	_, err = dbh.CreateReview(Review{ProductID: 1, User: User{ID: 2}, Rating: 1, Body: "Changed my mind."})
+	assert.ErrorIs(t, err, ErrConflict)
+	_, err = dbh.CreateReview(Review{ProductID: 1, User: User{ID: 2}, Rating: 0, Body: "No stars."})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	checkoutID, err = dbh.CreateCheckout(CheckoutRequest{UserID: 1, ProductID: 1, ProductQuantity: 1})
+	assert.Nil(t, err)
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentPaid, ID: "fake_00002"}))
+	otherID, err := dbh.CreateReview(Review{ProductID: 1, User: User{ID: 1}, Rating: 2, Body: "The strap broke."})
+	assert.Nil(t, err)
+
+	product, err := dbh.GetProduct(1)
+	assert.Nil(t, err)
+	assert.Equal(t, Rating{Count: 2, Total: 7}, product.Rating)
+
+	reviews, err := dbh.GetProductReviews(1, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(reviews))
+	assert.Equal(t, map[string]bool{reviewID: true, otherID: true}, map[string]bool{reviews[0].ID: true, reviews[1].ID: true})
+	for _, review := range reviews {
+		if review.ID == reviewID {
+			assert.Equal(t, User{ID: 2, Name: "scstore"}, review.User)
+			assert.Equal(t, "Keeps good time.", review.Body)
+			assert.Equal(t, ReviewVisible, review.Status)
+			assert.False(t, review.CreatedAt.IsZero())
+		}
+	}
+
+	// Flagged reviews are still shown, hidden ones are not counted.
+	assert.Nil(t, dbh.UpdateReviewStatus(otherID, ReviewFlagged))
+	flagged, err := dbh.GetReviews(ReviewFlagged, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(flagged))
+	assert.Equal(t, otherID, flagged[0].ID)
+
+	assert.Nil(t, dbh.UpdateReviewStatus(otherID, ReviewHidden))
+	assert.Nil(t, dbh.UpdateReviewStatus(otherID, ReviewHidden))
+	product, err = dbh.GetProduct(1)
+	assert.Nil(t, err)
+	assert.Equal(t, Rating{Count: 1, Total: 5}, product.Rating)
+	reviews, err = dbh.GetProductReviews(1, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(reviews))
+
+	all, err := dbh.GetReviews("", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(all))
+
+	assert.Nil(t, dbh.UpdateReviewStatus(otherID, ReviewVisible))
+	products, err := dbh.GetProducts()
+	assert.Nil(t, err)
+	for _, product := range products {
+		if product.ID == 1 {
+			assert.Equal(t, Rating{Count: 2, Total: 7}, product.Rating)
+		}
+	}
+
+	assert.ErrorIs(t, dbh.UpdateReviewStatus("00000000-0000-0000-0000-000000000000", ReviewHidden), ErrNotFound)
+	assert.ErrorIs(t, dbh.UpdateReviewStatus(otherID, "deleted"), ErrValidation)
+	_, err = dbh.GetReviews("deleted", 10)
+	assert.ErrorIs(t, err, ErrValidation)
+}
+
+func testWishlistBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	assert.Nil(t, dbh.AddWishlistItem(2, 1))
+	assert.Nil(t, dbh.AddWishlistItem(2, 2))
+	// Saving a product again keeps one item.
+	assert.Nil(t, dbh.AddWishlistItem(2, 1))
+	assert.Nil(t, dbh.AddWishlistItem(1, 1))
+	assert.ErrorIs(t, dbh.AddWishlistItem(2, 9999), ErrNotFound)
+
+	items, err := dbh.GetWishlist(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(items))
+	product, err := dbh.GetProduct(2)
+	assert.Nil(t, err)
+	for _, item := range items {
+		assert.False(t, item.AddedAt.IsZero())
+		if item.Product.ID == 2 {
+			assert.Equal(t, product, item.Product)
+		}
+	}
+
+	assert.Nil(t, dbh.RemoveWishlistItem(2, 2))
+	assert.ErrorIs(t, dbh.RemoveWishlistItem(2, 2), ErrNotFound)
+	items, err = dbh.GetWishlist(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, 1, items[0].Product.ID)
+
+	// A drop returns the users to notify and stores the webhook event.
+	webhookID, err := dbh.CreateWebhook(Webhook{URL: "https://example.com/hooks", Secret: "0123456789abcdef", EventTypes: []string{WebhookEventProductPriceDropped}})
+	assert.Nil(t, err)
+
+	product, err = dbh.GetProduct(1)
+	assert.Nil(t, err)
+	change, err := dbh.UpdateProductPrice(1, product.Price.Amount-1000)
+	assert.Nil(t, err)
+	assert.True(t, change.Dropped())
+	assert.Equal(t, product.Price, change.OldPrice)
+	assert.Equal(t, product.Price.Amount-1000, change.Product.Price.Amount)
+	assert.Equal(t, []int{1, 2}, []int{change.Watchers[0].ID, change.Watchers[1].ID})
+	assert.NotEmpty(t, change.Watchers[1].Email)
+
+	product, err = dbh.GetProduct(1)
+	assert.Nil(t, err)
+	assert.Equal(t, change.Product.Price, product.Price)
+
+	dispatched, err := dbh.DispatchWebhookEvents(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, dispatched)
+	deliveries, err := dbh.GetWebhookDeliveries(webhookID, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(deliveries))
+	assert.Equal(t, WebhookEventProductPriceDropped, deliveries[0].Event.Type)
+
+	// A rise notifies nobody.
+	change, err = dbh.UpdateProductPrice(1, product.Price.Amount+5000)
+	assert.Nil(t, err)
+	assert.False(t, change.Dropped())
+	assert.Empty(t, change.Watchers)
+	dispatched, err = dbh.DispatchWebhookEvents(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, dispatched)
+
+	_, err = dbh.UpdateProductPrice(1, 0)
+	assert.ErrorIs(t, err, ErrValidation)
+	_, err = dbh.UpdateProductPrice(9999, 100)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// testPurchaseStatsBehaviour checks that only the paid checkouts are counted
+// and the units of the same product are summed per user.
+func testPurchaseStatsBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	stats, err := dbh.GetPurchaseStats()
+	assert.Nil(t, err)
+	assert.Empty(t, stats)
+
+	orders := []CheckoutRequest{
+		{UserID: 1, ProductID: 1, ProductQuantity: 2},
+		{UserID: 1, ProductID: 1, ProductQuantity: 3},
+		{UserID: 1, ProductID: 2, ProductQuantity: 1},
+		{UserID: 2, ProductID: 2, ProductQuantity: 4},
+	}
+	for i, order := range orders {
+		checkoutID, err := dbh.CreateCheckout(order)
+		assert.Nil(t, err)
+		assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentPaid, ID: fmt.Sprintf("fake_%05d", i)}))
+	}
+
+	// Orders which are not paid are left out.
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1})
+	assert.Nil(t, err)
+
+	stats, err = dbh.GetPurchaseStats()
+	assert.Nil(t, err)
+	assert.Equal(t, []PurchaseStat{
+		{UserID: 1, ProductID: 1, Quantity: 5},
+		{UserID: 1, ProductID: 2, Quantity: 1},
+		{UserID: 2, ProductID: 2, Quantity: 4},
+	}, stats)
+}
+
+// testVariantBehaviour checks that orders of variants are priced and take
+// their stock, and that the stock holds under concurrent checkouts. Product 1
+// costs $350.00.
+func testVariantBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	assert.Nil(t, dbh.CreateVariant(Variant{SKU: "P1-GOLD", ProductID: 1, Name: "Gold case", Price: money.New(50000, "USD"), Stock: 1, Image: "/assets/images/gold.jpg"}))
+	assert.Nil(t, dbh.CreateVariant(Variant{SKU: "P1-BLACK", ProductID: 1, Name: " Black strap ", Stock: 3}))
+
+	assert.ErrorIs(t, dbh.CreateVariant(Variant{SKU: "P1-GOLD", ProductID: 1, Name: "Gold case"}), ErrConflict)
+	assert.ErrorIs(t, dbh.CreateVariant(Variant{SKU: "P9-BLACK", ProductID: 9999, Name: "Black strap"}), ErrNotFound)
+	assert.ErrorIs(t, dbh.CreateVariant(Variant{SKU: "P1 RED", ProductID: 1, Name: "Red strap"}), ErrValidation)
+
+	product, err := dbh.GetProduct(1)
+	assert.Nil(t, err)
+	variants, err := dbh.GetVariants(1)
+	assert.Nil(t, err)
+	assert.Equal(t, []Variant{
+		{SKU: "P1-BLACK", ProductID: 1, Name: "Black strap", Price: product.Price, Stock: 3, Image: product.Image},
+		{SKU: "P1-GOLD", ProductID: 1, Name: "Gold case", Price: money.New(50000, "USD"), Stock: 1, Image: "/assets/images/gold.jpg"},
+	}, variants)
+	variants, err = dbh.GetVariants(2)
+	assert.Nil(t, err)
+	assert.Empty(t, variants)
+
+	// A product with variants is ordered by variant.
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1})
+	assert.ErrorIs(t, err, ErrValidation)
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 2, VariantSKU: "P1-GOLD", ProductQuantity: 1})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	checkoutID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, VariantSKU: "P1-GOLD", ProductQuantity: 1})
+	assert.Nil(t, err)
+	checkout, err := dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, checkout.Product.ID)
+	assert.Equal(t, Variant{SKU: "P1-GOLD", Name: "Gold case"}, checkout.Variant)
+	assert.Equal(t, money.New(50000, "USD"), checkout.Subtotal)
+
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, VariantSKU: "P1-GOLD", ProductQuantity: 1})
+	var dbErr *Error
+	assert.ErrorAs(t, err, &dbErr)
+	assert.Equal(t, map[string]string{"variant_sku": "This variant is out of stock."}, dbErr.Fields)
+
+	// A failed order gives its stock back, and takes it again when it is
+	// paid after all.
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentFailed, Error: "payment declined"}))
+	variants, err = dbh.GetVariants(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, variants[1].Stock)
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentFailed, Error: "payment declined"}))
+	variants, err = dbh.GetVariants(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, variants[1].Stock)
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentPaid, ID: "fake_00001"}))
+	variants, err = dbh.GetVariants(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, variants[1].Stock)
+
+	var wg sync.WaitGroup
+	var succeeded int32
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, VariantSKU: "P1-BLACK", ProductQuantity: 1}); err == nil {
+				atomic.AddInt32(&succeeded, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), succeeded)
+
+	assert.Nil(t, dbh.UpdateVariantStock("P1-GOLD", 5))
+	assert.ErrorIs(t, dbh.UpdateVariantStock("P1-GOLD", -1), ErrValidation)
+	assert.ErrorIs(t, dbh.UpdateVariantStock("NOPE", 1), ErrNotFound)
+
+	variants, err = dbh.GetVariants(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, variants[0].Stock)
+	assert.Equal(t, 5, variants[1].Stock)
+
+	// The orders of the variants are orders of the product.
+	checkouts, err := dbh.GetCheckouts(2)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(checkouts))
+	for _, checkout := range checkouts {
+		assert.Equal(t, 1, checkout.Product.ID)
+	}
+}
+
+// testSalesReportBehaviour checks that the report aggregates the paid
+// checkouts of its window only.
+func testSalesReportBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	from, to := today.AddDate(0, 0, -1), today.AddDate(0, 0, 1)
+
+	report, err := dbh.GetSalesReport(from, to, "USD", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Orders)
+	assert.Equal(t, money.New(0, "USD"), report.Revenue)
+	assert.Empty(t, report.Days)
+	assert.Empty(t, report.TopProducts)
+
+	orders := []CheckoutRequest{
+		{UserID: 1, ProductID: 1, ProductQuantity: 2},
+		{UserID: 1, ProductID: 2, ProductQuantity: 1},
+		{UserID: 2, ProductID: 2, ProductQuantity: 3},
+		// Orders which are not paid or were refunded are left out.
+		{UserID: 2, ProductID: 1, ProductQuantity: 1},
+		{UserID: 2, ProductID: 1, ProductQuantity: 5},
+	}
+	statuses := []string{PaymentPaid, PaymentPaid, PaymentPaid, PaymentFailed, PaymentRefunded}
+	revenue := map[int]int64{}
+	for i, order := range orders {
+		checkoutID, err := dbh.CreateCheckout(order)
+		assert.Nil(t, err)
+		assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: statuses[i], ID: fmt.Sprintf("fake_%05d", i)}))
+
+		if statuses[i] == PaymentPaid {
+			checkout, err := dbh.GetCheckout(checkoutID)
+			assert.Nil(t, err)
+			revenue[order.ProductID] += checkout.Total.Amount
+		}
+	}
+
+	report, err = dbh.GetSalesReport(from, to, "USD", 10)
+	assert.Nil(t, err)
+	total := money.New(revenue[1]+revenue[2], "USD")
+	assert.Equal(t, SalesTotals{Orders: 3, Units: 6, Revenue: total}, report.SalesTotals)
+	assert.Equal(t, 2, report.Customers)
+	assert.Equal(t, 1.5, report.OrdersPerCustomer())
+	assert.Equal(t, []DailySales{{Day: today, SalesTotals: report.SalesTotals}}, report.Days)
+
+	assert.Equal(t, 2, len(report.TopProducts))
+	productSales := map[int]SalesTotals{}
+	for _, sales := range report.TopProducts {
+		productSales[sales.Product.ID] = sales.SalesTotals
+	}
+	assert.Equal(t, SalesTotals{Orders: 1, Units: 2, Revenue: money.New(revenue[1], "USD")}, productSales[1])
+	assert.Equal(t, SalesTotals{Orders: 2, Units: 4, Revenue: money.New(revenue[2], "USD")}, productSales[2])
+	assert.GreaterOrEqual(t, report.TopProducts[0].Revenue.Amount, report.TopProducts[1].Revenue.Amount)
+
+	assert.Equal(t, 2, len(report.TopCustomers))
+	customerOrders := map[int]int{}
+	for _, sales := range report.TopCustomers {
+		customerOrders[sales.User.ID] = sales.Orders
+	}
+	assert.Equal(t, map[int]int{1: 2, 2: 1}, customerOrders)
+
+	report, err = dbh.GetSalesReport(from, to, "USD", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.TopProducts))
+	assert.Equal(t, 1, len(report.TopCustomers))
+	assert.Equal(t, 3, report.Orders)
+
+	// The window ends the day before to.
+	report, err = dbh.GetSalesReport(from, today, "USD", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Orders)
+
+	report, err = dbh.GetSalesReport(from, to, "EUR", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Orders)
+
+	_, err = dbh.GetSalesReport(to, from, "USD", 10)
+	assert.ErrorIs(t, err, ErrValidation)
+}
+
+// testExportCheckoutsBehaviour checks that exports are filtered by user and
+// day, oldest first, and stop at the first error of the callback.
+func testExportCheckoutsBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	var checkoutIDs []string
+	for _, order := range []CheckoutRequest{
+		{UserID: 2, ProductID: 1, ProductQuantity: 1},
+		{UserID: 1, ProductID: 2, ProductQuantity: 2},
+		{UserID: 2, ProductID: 2, ProductQuantity: 3},
+	} {
+		checkoutID, err := dbh.CreateCheckout(order)
+		assert.Nil(t, err)
+		checkoutIDs = append(checkoutIDs, checkoutID)
+	}
+
+	export := func(filter CheckoutFilter) ([]Checkout, error) {
+		var checkouts []Checkout
+		err := dbh.ExportCheckouts(filter, func(checkout Checkout) error {
+			checkouts = append(checkouts, checkout)
+			return nil
+		})
+		return checkouts, err
+	}
+
+	checkouts, err := export(CheckoutFilter{UserID: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(checkouts))
+	for _, checkout := range checkouts {
+		assert.Equal(t, 2, checkout.User.ID)
+	}
+	checkout, err := dbh.GetCheckout(checkoutIDs[0])
+	assert.Nil(t, err)
+	assert.Contains(t, checkouts, checkout)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	checkouts, err = export(CheckoutFilter{From: today, To: today.AddDate(0, 0, 1)})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(checkouts))
+	for i := 1; i < len(checkouts); i++ {
+		assert.False(t, checkouts[i].CreatedAt.Before(checkouts[i-1].CreatedAt))
+	}
+
+	checkouts, err = export(CheckoutFilter{To: today})
+	assert.Nil(t, err)
+	assert.Empty(t, checkouts)
+	checkouts, err = export(CheckoutFilter{From: today.AddDate(0, 0, 1)})
+	assert.Nil(t, err)
+	assert.Empty(t, checkouts)
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = dbh.ExportCheckouts(CheckoutFilter{}, func(checkout Checkout) error {
+		calls++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, calls)
+
+	_, err = export(CheckoutFilter{From: today, To: today})
+	assert.ErrorIs(t, err, ErrValidation)
+}
+
+// testBackupBehaviour checks that a restore brings back the records of the
+// backup and only them.
+func testBackupBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	assert.Nil(t, dbh.CreateCoupon(Coupon{Code: "SALE10", Kind: CouponKindPercent, Value: 10, ExpiresAt: time.Now().Add(time.Hour).Truncate(time.Second)}))
+	assert.Nil(t, dbh.CreateVariant(Variant{SKU: "P1-S", ProductID: 1, Name: "Small", Stock: 5}))
+	assert.Nil(t, dbh.CreateVariant(Variant{SKU: "P1-L", ProductID: 1, Name: "Large", Price: money.New(40000, "USD"), Stock: 5, Image: "/assets/images/large.jpg"}))
+
+	address := Address{Name: "Jane Doe", Line1: "1 Main St", City: "New York", PostalCode: "10001", Country: "US"}
+	checkoutID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, VariantSKU: "P1-L", ProductQuantity: 2, CouponCode: "SALE10", TaxRegion: "US-NY", TaxRate: 8.875, ShippingAddress: address})
+	assert.Nil(t, err)
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentPaid, ID: "fake_00001"}))
+	checkout, err := dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+
+	backup := func() []BackupRecord {
+		var records []BackupRecord
+		assert.Nil(t, dbh.Backup(func(record BackupRecord) error {
+			records = append(records, record)
+			return nil
+		}))
+		return records
+	}
+	records := backup()
+	assert.Equal(t, 100+2+2+1+1, len(records))
+	assert.Equal(t, 1, records[0].Product.ID)
+	assert.Equal(t, Variant{SKU: "P1-L", ProductID: 1, Name: "Large", Price: money.New(40000, "USD"), Stock: 3, Image: "/assets/images/large.jpg"}, *records[100].Variant)
+	assert.Equal(t, int64(0), records[101].Variant.Price.Amount)
+	assert.Equal(t, "", records[101].Variant.Image)
+	assert.Equal(t, 1, records[102].User.ID)
+	assert.Equal(t, 1, records[104].Coupon.Uses)
+	assert.Equal(t, checkoutID, records[105].Checkout.ID)
+
+	// The data added after the backup is dropped by the restore.
+	_, err = dbh.CreateCheckout(CheckoutRequest{UserID: 1, ProductID: 2, ProductQuantity: 1})
+	assert.Nil(t, err)
+	assert.Nil(t, dbh.AddWishlistItem(2, 1))
+
+	i := 0
+	assert.Nil(t, dbh.Restore(func() (BackupRecord, error) {
+		if i == len(records) {
+			return BackupRecord{}, io.EOF
+		}
+		i++
+		return records[i-1], nil
+	}))
+
+	assert.Equal(t, records, backup())
+	restored, err := dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, checkout.Total, restored.Total)
+	assert.Equal(t, checkout.Payment, restored.Payment)
+	assert.Equal(t, checkout.Variant, restored.Variant)
+	assert.Equal(t, "SALE10", restored.CouponCode)
+	checkouts, err := dbh.GetCheckouts(1)
+	assert.Nil(t, err)
+	assert.Empty(t, checkouts)
+	wishlist, err := dbh.GetWishlist(2)
+	assert.Nil(t, err)
+	assert.Empty(t, wishlist)
+	variants, err := dbh.GetVariants(1)
+	assert.Nil(t, err)
+	assert.Equal(t, money.New(35000, "USD"), variants[1].Price)
+
+	// The error of next stops the restore.
+	errStop := errors.New("stop")
+	assert.ErrorIs(t, dbh.Restore(func() (BackupRecord, error) { return BackupRecord{}, errStop }), errStop)
+}
+
+// testMigrateBehaviour checks that migrating and seeding keep the data, and
+// that seeding only inserts the products and users which are missing.
+func testMigrateBehaviour(t *testing.T, dbh DatabaseHandler) {
+	initDatabaseForTest(t, dbh)
+
+	checkoutID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1})
+	assert.Nil(t, err)
+	assert.Nil(t, dbh.MigrateDatabase())
+	_, err = dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+
+	// Restore the data without product 100 and user 1 to seed them again.
+	var records []BackupRecord
+	assert.Nil(t, dbh.Backup(func(record BackupRecord) error {
+		if (record.Product == nil || record.Product.ID != 100) && (record.User == nil || record.User.ID != 1) {
+			records = append(records, record)
+		}
+		return nil
+	}))
+	assert.Nil(t, dbh.Restore(func() (BackupRecord, error) {
+		if len(records) == 0 {
+			return BackupRecord{}, io.EOF
+		}
+		record := records[0]
+		records = records[1:]
+		return record, nil
+	}))
+	_, err = dbh.GetProduct(100)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	inWebappDir(t, dbh.SeedDatabase)
+	inWebappDir(t, dbh.SeedDatabase)
+
+	products, err := dbh.GetProducts()
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(products))
+	var users []int
+	assert.Nil(t, dbh.Backup(func(record BackupRecord) error {
+		if record.User != nil {
+			users = append(users, record.User.ID)
+		}
+		return nil
+	}))
+	assert.Equal(t, []int{1, 2}, users)
+	_, err = dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// The states of a queued checkout. A processing job whose lease ran out is
+// taken over by another worker.
+const (
+	CheckoutJobQueued     = "queued"
+	CheckoutJobProcessing = "processing"
+	CheckoutJobDone       = "done"
+	CheckoutJobFailed     = "failed"
+)
+
+// checkoutJobErrorMaxLength keeps the errors of the jobs in the column.
+const checkoutJobErrorMaxLength = 500
+
+// CheckoutJob is an order accepted into the checkout queue. Its ID is the ID
+// of the checkout it creates, so a job processed twice creates one order.
+// Error is the message shown to the customer when the job failed.
+type CheckoutJob struct {
+	ID        string
+	Request   CheckoutRequest
+	Status    string
+	Attempts  int
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Pending reports whether the job still waits for a worker.
+func (job CheckoutJob) Pending() bool {
+	return job.Status == CheckoutJobQueued || job.Status == CheckoutJobProcessing
+}
+
+// newCheckoutJob returns the job of the order with its request encoded for
+// the request column.
+func newCheckoutJob(order CheckoutRequest, now time.Time) (CheckoutJob, string, error) {
+	uuidObj, err := uuid.NewRandom()
+	if err != nil {
+		return CheckoutJob{}, "", err
+	}
+
+	order.ID = uuidObj.String()
+	request, err := json.Marshal(order)
+	if err != nil {
+		return CheckoutJob{}, "", err
+	}
+
+	now = now.UTC()
+	job := CheckoutJob{ID: order.ID, Request: order, Status: CheckoutJobQueued, CreatedAt: now, UpdatedAt: now}
+
+	return job, string(request), nil
+}
+
+func decodeCheckoutJobRequest(job *CheckoutJob, request string) error {
+	return json.Unmarshal([]byte(request), &job.Request)
+}
+
+// validateCheckoutJobResult checks the arguments of FinishCheckoutJob and
+// cuts the message to the length of the column.
+func validateCheckoutJobResult(status string, message string) (string, error) {
+	if status != CheckoutJobDone && status != CheckoutJobFailed {
+		return "", NewValidationError("checkout job status " + status + " is not a final status")
+	}
+
+	if utf8.RuneCountInString(message) > checkoutJobErrorMaxLength {
+		message = string([]rune(message)[:checkoutJobErrorMaxLength])
+	}
+
+	return message, nil
+}
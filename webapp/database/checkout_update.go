@@ -0,0 +1,109 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/mittz/role-play-webapp/webapp/pubsub"
+)
+
+// checkoutUpdatesChannel is the PostgreSQL channel of the checkout updates.
+const checkoutUpdatesChannel = "checkout_updates"
+
+// checkoutUpdateBuffer is the number of updates a subscriber can fall behind
+// before it is dropped.
+const checkoutUpdateBuffer = 16
+
+// CheckoutUpdate is a change of the state of an order, sent to the pages
+// which show it.
+type CheckoutUpdate struct {
+	CheckoutID    string `json:"checkout_id"`
+	UserID        int    `json:"user_id"`
+	PaymentStatus string `json:"payment_status"`
+	PaymentError  string `json:"payment_error,omitempty"`
+}
+
+func newCheckoutUpdate(checkoutID string, userID int, payment Payment) CheckoutUpdate {
+	return CheckoutUpdate{CheckoutID: checkoutID, UserID: userID, PaymentStatus: payment.Status, PaymentError: payment.errorMessage()}
+}
+
+// checkoutUpdates gets the updates committed by this process and, while
+// ListenCheckoutUpdates runs, by the other instances on PostgreSQL.
+var checkoutUpdates = pubsub.NewBroker[CheckoutUpdate](checkoutUpdateBuffer)
+
+// SubscribeCheckout receives the updates of an order.
+func SubscribeCheckout(checkoutID string) *pubsub.Subscription[CheckoutUpdate] {
+	return checkoutUpdates.Subscribe("checkout/" + checkoutID)
+}
+
+// SubscribeUserCheckouts receives the updates of every order of a user.
+func SubscribeUserCheckouts(userID int) *pubsub.Subscription[CheckoutUpdate] {
+	return checkoutUpdates.Subscribe(fmt.Sprintf("user/%d", userID))
+}
+
+// publishCheckoutUpdate is called after the update is committed.
+func publishCheckoutUpdate(update CheckoutUpdate) {
+	checkoutUpdates.Publish("checkout/"+update.CheckoutID, update)
+	checkoutUpdates.Publish(fmt.Sprintf("user/%d", update.UserID), update)
+}
+
+// notifyCheckoutUpdate sends the committed update to the listeners of every
+// instance.
+func notifyCheckoutUpdate(db *sql.DB, update CheckoutUpdate) error {
+	payload, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("SELECT pg_notify($1, $2)", checkoutUpdatesChannel, string(payload))
+	return err
+}
+
+// ListenCheckoutUpdates publishes the updates notified on PostgreSQL to the
+// subscribers in this process, so that a client gets the updates made by
+// any instance of the app. The listener reconnects by itself. Updates sent
+// while it is disconnected are lost; the pages get the current state again
+// when they reconnect. The returned function stops listening.
+func ListenCheckoutUpdates(connConfig ConnConfig) (func(), error) {
+	dsn, err := connConfig.DSN()
+	if err != nil {
+		return nil, err
+	}
+
+	return listenCheckoutUpdates(dsn)
+}
+
+func listenCheckoutUpdates(dsn string) (func(), error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Checkout update listener: %v", err)
+		}
+	})
+	if err := listener.Listen(checkoutUpdatesChannel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	go func() {
+		for notification := range listener.NotificationChannel() {
+			// nil is sent after the listener reconnected.
+			if notification == nil {
+				continue
+			}
+
+			var update CheckoutUpdate
+			if err := json.Unmarshal([]byte(notification.Extra), &update); err != nil {
+				log.Printf("Checkout update %q is invalid: %v", notification.Extra, err)
+				continue
+			}
+			publishCheckoutUpdate(update)
+		}
+	}()
+
+	return func() { listener.Close() }, nil
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const maxConnectBackoff = 30 * time.Second
+
+type ConnConfig struct {
+	Hostname        string
+	Port            int
+	Username        string
+	Password        string
+	Name            string
+	SSLMode         string
+	SSLRootCert     string
+	SSLCert         string
+	SSLKey          string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnectRetries  int
+	ConnectBackoff  time.Duration
+}
+
+// DSN builds the lib/pq connection string.
+// sslmode=require encrypts the connection and verify-full additionally checks
+// the server certificate against SSLRootCert.
+func (cfg ConnConfig) DSN() (string, error) {
+	sslMode := cfg.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+
+	switch sslMode {
+	case "disable", "require", "verify-ca", "verify-full":
+	default:
+		return "", fmt.Errorf("sslmode: %s is not supported", sslMode)
+	}
+
+	if (sslMode == "verify-ca" || sslMode == "verify-full") && cfg.SSLRootCert == "" {
+		return "", fmt.Errorf("sslmode: %s requires a CA certificate path", sslMode)
+	}
+
+	if (cfg.SSLCert == "") != (cfg.SSLKey == "") {
+		return "", fmt.Errorf("client certificate and key must be set together")
+	}
+
+	params := []string{
+		fmt.Sprintf("host=%s", cfg.Hostname),
+		fmt.Sprintf("port=%d", cfg.Port),
+		fmt.Sprintf("user=%s", cfg.Username),
+		fmt.Sprintf("dbname=%s", cfg.Name),
+		fmt.Sprintf("password=%s", cfg.Password),
+		fmt.Sprintf("sslmode=%s", sslMode),
+	}
+
+	if cfg.SSLRootCert != "" {
+		params = append(params, fmt.Sprintf("sslrootcert=%s", cfg.SSLRootCert))
+	}
+
+	if cfg.SSLCert != "" {
+		params = append(params, fmt.Sprintf("sslcert=%s", cfg.SSLCert), fmt.Sprintf("sslkey=%s", cfg.SSLKey))
+	}
+
+	return strings.Join(params, " "), nil
+}
+
+// OpenDBConn opens a connection pool, applies the pool limits and waits until
+// the database answers a ping.
+func OpenDBConn(driverName string, cfg ConnConfig) (*sql.DB, error) {
+	dsn, err := cfg.DSN()
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	if err := pingWithRetry(db, cfg.ConnectRetries, cfg.ConnectBackoff); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func pingWithRetry(db *sql.DB, retries int, backoff time.Duration) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if err = db.Ping(); err == nil {
+			return nil
+		}
+
+		if attempt == retries {
+			break
+		}
+
+		log.Printf("Database is not reachable yet (attempt %d/%d), retrying in %s: %v", attempt+1, retries+1, backoff, err)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+
+	return fmt.Errorf("database is not reachable after %d attempts: %w", retries+1, err)
+}
+
+// MonitorDBStats logs the pool statistics every interval while requests had
+// to wait for a free connection.
+func MonitorDBStats(db *sql.DB, interval time.Duration) {
+	go func() {
+		var last sql.DBStats
+		for range time.Tick(interval) {
+			stats := db.Stats()
+			if isPoolSaturated(last, stats) {
+				log.Printf("Database connection pool is saturated: open=%d in_use=%d idle=%d max_open=%d wait_count=%d wait_duration=%s",
+					stats.OpenConnections, stats.InUse, stats.Idle, stats.MaxOpenConnections,
+					stats.WaitCount-last.WaitCount, stats.WaitDuration-last.WaitDuration)
+			}
+			last = stats
+		}
+	}()
+}
+
+func isPoolSaturated(last, current sql.DBStats) bool {
+	return current.WaitCount > last.WaitCount ||
+		(current.MaxOpenConnections > 0 && current.InUse >= current.MaxOpenConnections)
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDSN(t *testing.T) {
+	cfg := ConnConfig{Hostname: "localhost", Port: 5432, Username: "scstore", Password: "scstore", Name: "scstore"}
+
+	dsn, err := cfg.DSN()
+	assert.Nil(t, err)
+	assert.Equal(t, "host=localhost port=5432 user=scstore dbname=scstore password=scstore sslmode=disable", dsn)
+
+	cfg.SSLMode = "verify-full"
+	_, err = cfg.DSN()
+	assert.NotNil(t, err)
+
+	cfg.SSLRootCert = "/certs/ca.pem"
+	cfg.SSLCert = "/certs/client.pem"
+	cfg.SSLKey = "/certs/client.key"
+	dsn, err = cfg.DSN()
+	assert.Nil(t, err)
+	assert.Contains(t, dsn, "sslmode=verify-full sslrootcert=/certs/ca.pem sslcert=/certs/client.pem sslkey=/certs/client.key")
+
+	cfg.SSLMode = "allow"
+	_, err = cfg.DSN()
+	assert.NotNil(t, err)
+}
+
+func TestPingWithRetry(t *testing.T) {
+	mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectPing()
+
+	assert.Nil(t, pingWithRetry(mockDB, 2, 0))
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+	assert.NotNil(t, pingWithRetry(mockDB, 1, 0))
+}
+
+func TestIsPoolSaturated(t *testing.T) {
+	assert.False(t, isPoolSaturated(sql.DBStats{}, sql.DBStats{MaxOpenConnections: 10, InUse: 5}))
+	assert.True(t, isPoolSaturated(sql.DBStats{}, sql.DBStats{MaxOpenConnections: 10, InUse: 10}))
+	assert.True(t, isPoolSaturated(sql.DBStats{WaitCount: 1}, sql.DBStats{WaitCount: 3}))
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+const (
+	CouponKindPercent = "percent"
+	CouponKindFixed   = "fixed"
+)
+
+// Coupon is a discount code entered on the checkout form.
+// Value is a percentage for percent coupons and an amount in the minor units
+// of the order currency for fixed coupons, like MinOrder.
+// A zero ExpiresAt, MaxUses or MaxUsesPerUser means no limit.
+type Coupon struct {
+	Code           string
+	Kind           string
+	Value          int
+	MinOrder       int
+	ExpiresAt      time.Time
+	MaxUses        int
+	MaxUsesPerUser int
+	Uses           int
+	Active         bool
+}
+
+// Validate checks the fields of a coupon created by an admin.
+func (coupon Coupon) Validate() error {
+	fields := make(map[string]string)
+
+	if coupon.Code == "" || len(coupon.Code) > 40 {
+		fields["code"] = "Code must be 1 to 40 characters."
+	}
+
+	switch coupon.Kind {
+	case CouponKindPercent:
+		if coupon.Value < 1 || coupon.Value > 100 {
+			fields["value"] = "Percentage must be between 1 and 100."
+		}
+	case CouponKindFixed:
+		if coupon.Value < 1 {
+			fields["value"] = "Amount must be at least 1."
+		}
+	default:
+		fields["kind"] = "Kind must be percent or fixed."
+	}
+
+	if coupon.MinOrder < 0 {
+		fields["min_order"] = "Minimum order must not be negative."
+	}
+	if coupon.MaxUses < 0 {
+		fields["max_uses"] = "Usage limit must not be negative."
+	}
+	if coupon.MaxUsesPerUser < 0 {
+		fields["max_uses_per_user"] = "Usage limit per user must not be negative."
+	}
+
+	if len(fields) > 0 {
+		return NewFieldValidationError(fields)
+	}
+
+	return nil
+}
+
+// check returns why the coupon can't be applied to an order of subtotal at now.
+// The usage limits are checked by the backends while the coupon row is locked.
+func (coupon Coupon) check(subtotal money.Money, now time.Time) error {
+	if !coupon.Active || (!coupon.ExpiresAt.IsZero() && !now.Before(coupon.ExpiresAt)) {
+		return newCouponError("The coupon code has expired.")
+	}
+
+	if subtotal.Amount < int64(coupon.MinOrder) {
+		return newCouponError(fmt.Sprintf("The coupon code requires an order of %s or more.", money.New(int64(coupon.MinOrder), subtotal.Currency)))
+	}
+
+	return nil
+}
+
+// Discount returns the amount taken off an order of subtotal. It never
+// exceeds the subtotal.
+func (coupon Coupon) Discount(subtotal money.Money) money.Money {
+	discount := money.New(int64(coupon.Value), subtotal.Currency)
+	if coupon.Kind == CouponKindPercent {
+		discount = subtotal.Percent(float64(coupon.Value))
+	}
+
+	if discount.Amount > subtotal.Amount {
+		return subtotal
+	}
+
+	return discount
+}
+
+func newCouponError(message string) error {
+	return NewFieldValidationError(map[string]string{"coupon_code": message})
+}
+
+// redeemCoupon is shared by the SQL backends. It returns the discount of the
+// order and takes one use of the coupon within tx.
+//
+// The guarded UPDATE locks the coupon row until tx ends, so concurrent
+// checkouts with the same code are serialized and the global and per-user
+// usage limits can't be exceeded. The orders which hold their reservation,
+// pending ones included, count towards the limit per user, so that
+// concurrent or queued checkouts of a user can't go over it.
+func redeemCoupon(tx *sql.Tx, order CheckoutRequest, subtotal money.Money, now time.Time) (money.Money, error) {
+	result, err := tx.Exec("UPDATE coupons SET uses = uses + 1 WHERE code = $1 AND (max_uses = 0 OR uses < max_uses)", order.CouponCode)
+	if err != nil {
+		return money.Money{}, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	coupon, err := scanCoupon(tx.QueryRow(queryGetCoupon, order.CouponCode))
+	if errors.Is(err, sql.ErrNoRows) {
+		return money.Money{}, newCouponError("The coupon code is not valid.")
+	}
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	if err := coupon.check(subtotal, now); err != nil {
+		return money.Money{}, err
+	}
+	if updated == 0 {
+		return money.Money{}, newCouponError("The coupon code has been used up.")
+	}
+
+	if coupon.MaxUsesPerUser > 0 {
+		var used int
+		query := "SELECT COUNT(*) FROM checkouts WHERE user_id = $1 AND coupon_code = $2 AND payment_status <> $3"
+		if err := tx.QueryRow(query, order.UserID, order.CouponCode, PaymentFailed).Scan(&used); err != nil {
+			return money.Money{}, err
+		}
+		if used >= coupon.MaxUsesPerUser {
+			return money.Money{}, newCouponError("You have already used this coupon code.")
+		}
+	}
+
+	return coupon.Discount(subtotal), nil
+}
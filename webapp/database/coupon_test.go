@@ -0,0 +1,45 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCouponDiscount(t *testing.T) {
+	subtotal := money.New(35000, "USD")
+
+	assert.Equal(t, money.New(3500, "USD"), Coupon{Kind: CouponKindPercent, Value: 10}.Discount(subtotal))
+	assert.Equal(t, money.New(35000, "USD"), Coupon{Kind: CouponKindPercent, Value: 100}.Discount(subtotal))
+	assert.Equal(t, money.New(5000, "USD"), Coupon{Kind: CouponKindFixed, Value: 5000}.Discount(subtotal))
+	assert.Equal(t, money.New(35000, "USD"), Coupon{Kind: CouponKindFixed, Value: 50000}.Discount(subtotal))
+}
+
+func TestCouponValidate(t *testing.T) {
+	assert.Nil(t, Coupon{Code: "SALE10", Kind: CouponKindPercent, Value: 10}.Validate())
+
+	err := Coupon{Code: "", Kind: "gift", Value: 10, MaxUses: -1}.Validate()
+	assert.ErrorIs(t, err, ErrValidation)
+
+	var dbErr *Error
+	assert.ErrorAs(t, err, &dbErr)
+	assert.Contains(t, dbErr.Fields, "code")
+	assert.Contains(t, dbErr.Fields, "kind")
+	assert.Contains(t, dbErr.Fields, "max_uses")
+
+	assert.ErrorIs(t, Coupon{Code: "SALE", Kind: CouponKindPercent, Value: 150}.Validate(), ErrValidation)
+	assert.ErrorIs(t, Coupon{Code: "SALE", Kind: CouponKindFixed, Value: 0}.Validate(), ErrValidation)
+}
+
+func TestCouponCheck(t *testing.T) {
+	now := time.Now()
+	subtotal := money.New(10000, "USD")
+
+	assert.Nil(t, Coupon{Active: true}.check(subtotal, now))
+	assert.Nil(t, Coupon{Active: true, MinOrder: 10000, ExpiresAt: now.Add(time.Hour)}.check(subtotal, now))
+	assert.ErrorIs(t, Coupon{Active: false}.check(subtotal, now), ErrValidation)
+	assert.ErrorIs(t, Coupon{Active: true, ExpiresAt: now}.check(subtotal, now), ErrValidation)
+	assert.ErrorIs(t, Coupon{Active: true, MinOrder: 10001}.check(subtotal, now), ErrValidation)
+}
@@ -2,58 +2,219 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"time"
 
+	"cloud.google.com/go/spanner"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mittz/role-play-webapp/webapp/money"
 )
 
 type DatabaseHandler interface {
+	// InitDatabase drops every table and recreates them with the data of
+	// initdata.json.
 	InitDatabase() error
+	// MigrateDatabase migrates the schema of the database to schemaVersion
+	// and creates the missing tables and indexes. It fails before changing
+	// the database when the schema is newer than the app or lacks columns
+	// which no migration adds. It keeps the data, so it is run before
+	// serving.
+	MigrateDatabase() error
+	// SeedDatabase inserts the products and users of initdata.json which
+	// the database doesn't have yet.
+	SeedDatabase() error
 	GetProduct(id int) (Product, error)
 	GetProducts() ([]Product, error)
 	GetCheckouts(userID int) ([]Checkout, error)
-	CreateCheckout(userID int, productID int, productQuantity int) (string, error)
+	CreateCheckout(order CheckoutRequest) (string, error)
 	GetCheckout(checkoutID string) (Checkout, error)
+	CreateCoupon(coupon Coupon) error
+	GetCoupons() ([]Coupon, error)
+	DeactivateCoupon(code string) error
+	UpdateCheckoutPayment(checkoutID string, payment Payment) error
+	CreateAddress(address Address) (string, error)
+	GetAddresses(userID int) ([]Address, error)
+	DeleteAddress(userID int, addressID string) error
+	CreateWebhook(webhook Webhook) (string, error)
+	GetWebhooks() ([]Webhook, error)
+	DeactivateWebhook(webhookID string) error
+	GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error)
+	// DispatchWebhookEvents creates the deliveries of up to limit events of
+	// the outbox to the webhooks which subscribe to them.
+	DispatchWebhookEvents(limit int) (int, error)
+	// ClaimWebhookDeliveries returns up to limit pending deliveries which
+	// are due at now, and postpones them by lease so that no other instance
+	// sends them at the same time.
+	ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error)
+	RecordWebhookAttempt(deliveryID string, attempt WebhookAttempt) error
+	// EnqueueCheckout accepts the order into the checkout queue and returns
+	// the ID of its job, which is the ID of the checkout it creates. It fails
+	// with ErrQueueFull when maxDepth jobs are waiting, or never when
+	// maxDepth is 0.
+	EnqueueCheckout(order CheckoutRequest, maxDepth int) (string, error)
+	GetCheckoutJob(jobID string) (CheckoutJob, error)
+	// ClaimCheckoutJobs returns up to limit waiting jobs and locks them for
+	// lease so that no other worker processes them at the same time.
+	ClaimCheckoutJobs(now time.Time, lease time.Duration, limit int) ([]CheckoutJob, error)
+	FinishCheckoutJob(jobID string, status string, message string) error
+	// GetCheckoutQueueDepth returns the number of queued and processing jobs.
+	GetCheckoutQueueDepth() (int, error)
+	// CreateReview stores the review of a user who has a paid checkout of the
+	// product and adds it to the rating of the product. It fails with
+	// ErrConflict when the user has already reviewed the product.
+	CreateReview(review Review) (string, error)
+	// GetProductReviews returns up to limit shown reviews of a product, newest first.
+	GetProductReviews(productID int, limit int) ([]Review, error)
+	// GetReviews returns up to limit reviews in the status for the admins,
+	// newest first. An empty status returns the reviews in every status.
+	GetReviews(status string, limit int) ([]Review, error)
+	// UpdateReviewStatus moderates a review and updates the rating of the
+	// product when the review is hidden or shown again.
+	UpdateReviewStatus(reviewID string, status string) error
+	// AddWishlistItem saves the product on the wishlist of the user. It does
+	// nothing when the product is already on it.
+	AddWishlistItem(userID int, productID int) error
+	// GetWishlist returns the wishlist of the user, newest first.
+	GetWishlist(userID int) ([]WishlistItem, error)
+	RemoveWishlistItem(userID int, productID int) error
+	// UpdateProductPrice sets the price of the product in minor units of its
+	// currency. When the price drops, the users with the product on their
+	// wishlists are returned and the product.price_dropped webhook event is
+	// stored in the same transaction.
+	UpdateProductPrice(productID int, price int64) (PriceChange, error)
+	// GetPurchaseStats returns the units of every product bought by every
+	// user in paid checkouts.
+	GetPurchaseStats() ([]PurchaseStat, error)
+	// CreateVariant adds a variant to its product. It fails with ErrConflict
+	// when the SKU is already taken.
+	CreateVariant(variant Variant) error
+	// GetVariants returns the variants of the product by name.
+	GetVariants(productID int) ([]Variant, error)
+	// UpdateVariantStock sets the units of the variant left in stock.
+	UpdateVariantStock(sku string, stock int) error
+	// GetSalesReport aggregates the paid checkouts in the currency from the
+	// day of from until the day before to. The top products and customers
+	// are limited to limit, or not limited when it is 0.
+	GetSalesReport(from, to time.Time, currency string, limit int) (SalesReport, error)
+	// ExportCheckouts calls fn with the checkouts of the filter, oldest
+	// first, as they are read so that they are not all held in memory. It
+	// stops at the first error of fn and returns it.
+	ExportCheckouts(filter CheckoutFilter, fn func(Checkout) error) error
+	// Backup calls fn with every product, variant, user, coupon and checkout
+	// of a snapshot of the database, in the order Restore inserts them.
+	Backup(fn func(BackupRecord) error) error
+	// Restore empties the tables created by InitDatabase and inserts the
+	// records next returns until it returns io.EOF. It stops at the first
+	// error of next and returns it.
+	Restore(next func() (BackupRecord, error)) error
 }
 
 const InitDataJSONFileName = "initdata.json"
 
+// schemaTables are the tables InitDatabase creates, the tables referencing the
+// others first.
+var schemaTables = []string{"product_variants", "wishlist_items", "reviews", "checkout_jobs", "webhook_deliveries", "webhook_events", "webhooks", "checkouts", "coupons", "addresses", "products", "users"}
+
 type Database struct {
 	DB *sql.DB
 }
 
+// Product is an item of the catalog. Weight is in grams.
 type Product struct {
-	ID    int
-	Name  string
-	Price int
-	Image string
+	ID     int
+	Name   string
+	Price  money.Money
+	Weight int
+	Image  string
+	Rating Rating
 }
 
 type User struct {
-	ID   int
-	Name string
+	ID    int
+	Name  string
+	Email string
 }
 
 type Checkout struct {
-	ID              string
-	User            User
-	Product         Product
+	ID      string
+	User    User
+	Product Product
+	// Variant has the SKU and the name of the variant ordered, which are
+	// copied to the order. It is zero for a product without variants.
+	Variant         Variant
 	ProductQuantity int
+	CouponCode      string
+	TaxRegion       string
+	// The amounts are in the currency the order was charged in.
+	Subtotal money.Money
+	Discount money.Money
+	Tax      money.Money
+	Shipping money.Money
+	Total    money.Money
+	Payment  Payment
+	// ShippingAddress is a copy of the address the order was shipped to.
+	ShippingAddress Address
 	CreatedAt       time.Time
 }
 
+// readInitData reads initdata.json from the working directory.
+func readInitData() (Blob, error) {
+	var data Blob
+
+	jsonFromFile, err := ioutil.ReadFile(InitDataJSONFileName)
+	if err != nil {
+		return data, err
+	}
+
+	err = json.Unmarshal(jsonFromFile, &data)
+	return data, err
+}
+
+// Blob is the data in initdata.json. Prices are in the minor units of Currency.
 type Blob struct {
-	Products []Product `json:"products"`
-	Users    []User    `json:"users"`
+	Currency string        `json:"currency"`
+	Products []BlobProduct `json:"products"`
+	Users    []User        `json:"users"`
+}
+
+type BlobProduct struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Price  int64  `json:"price"`
+	Weight int    `json:"weight"`
+	Image  string `json:"image"`
 }
 
-func NewDatabaseHandler(environment string, db *sql.DB) (DatabaseHandler, error) {
+// NewDatabaseHandler returns the handler for the environment.
+// conn is a *sql.DB for production, development and sqlite, and a *spanner.Client for spanner.
+func NewDatabaseHandler(environment string, conn interface{}) (DatabaseHandler, error) {
 	switch environment {
 	case "production":
+		db, ok := conn.(*sql.DB)
+		if !ok {
+			return nil, fmt.Errorf("environment: %s requires *sql.DB, but %T", environment, conn)
+		}
 		return NewProdDatabaseHandler(db), nil
 	case "development":
+		db, ok := conn.(*sql.DB)
+		if !ok {
+			return nil, fmt.Errorf("environment: %s requires *sql.DB, but %T", environment, conn)
+		}
 		return NewDevDatabaseHandler(db), nil
+	case "sqlite":
+		db, ok := conn.(*sql.DB)
+		if !ok {
+			return nil, fmt.Errorf("environment: %s requires *sql.DB, but %T", environment, conn)
+		}
+		return NewSQLiteDatabaseHandler(db), nil
+	case "spanner":
+		client, ok := conn.(*spanner.Client)
+		if !ok {
+			return nil, fmt.Errorf("environment: %s requires *spanner.Client, but %T", environment, conn)
+		}
+		return NewSpannerDatabaseHandler(client), nil
 	default:
 		return nil, fmt.Errorf("environment: %s is not supported", environment)
 	}
@@ -1 +1,23 @@
 package database
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDatabaseHandler(t *testing.T) {
+	mockDB, _, err := sqlmock.New()
+	assert.Nil(t, err)
+
+	dbh, err := NewDatabaseHandler("production", mockDB)
+	assert.Nil(t, err)
+	assert.IsType(t, ProdDatabaseHandler{}, dbh)
+
+	_, err = NewDatabaseHandler("spanner", mockDB)
+	assert.NotNil(t, err)
+
+	_, err = NewDatabaseHandler("staging", mockDB)
+	assert.NotNil(t, err)
+}
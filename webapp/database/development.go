@@ -2,6 +2,10 @@ package database
 
 import (
 	"database/sql"
+	"io"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
 )
 
 type DevDatabaseHandler struct {
@@ -16,9 +20,17 @@ func (dbh DevDatabaseHandler) InitDatabase() error {
 	return nil
 }
 
+func (dbh DevDatabaseHandler) MigrateDatabase() error {
+	return nil
+}
+
+func (dbh DevDatabaseHandler) SeedDatabase() error {
+	return nil
+}
+
 func (dbh DevDatabaseHandler) GetProduct(id int) (Product, error) {
 	product := Product{
-		ID: 1, Name: "product1", Price: 100, Image: "image/product1.png",
+		ID: 1, Name: "product1", Price: money.New(10000, "USD"), Weight: 125, Image: "image/product1.png", Rating: Rating{Count: 1, Total: 4},
 	}
 
 	return product, nil
@@ -26,8 +38,8 @@ func (dbh DevDatabaseHandler) GetProduct(id int) (Product, error) {
 
 func (dbh DevDatabaseHandler) GetProducts() ([]Product, error) {
 	products := []Product{
-		{ID: 1, Name: "product1", Price: 100, Image: "image/product1.png"},
-		{ID: 2, Name: "product2", Price: 200, Image: "image/product2.png"},
+		{ID: 1, Name: "product1", Price: money.New(10000, "USD"), Weight: 125, Image: "image/product1.png", Rating: Rating{Count: 1, Total: 4}},
+		{ID: 2, Name: "product2", Price: money.New(20000, "USD"), Weight: 150, Image: "image/product2.png", Rating: Rating{Count: 1, Total: 1}},
 	}
 
 	return products, nil
@@ -37,12 +49,12 @@ func (dbh DevDatabaseHandler) GetCheckouts(userID int) ([]Checkout, error) {
 	checkouts := []Checkout{
 		{
 			User:            User{ID: userID},
-			Product:         Product{Price: 100, Image: "image/product1.png"},
+			Product:         Product{Price: money.New(10000, "USD"), Image: "image/product1.png"},
 			ProductQuantity: 111,
 		},
 		{
 			User:            User{ID: userID},
-			Product:         Product{Price: 200, Image: "image/product2.png"},
+			Product:         Product{Price: money.New(20000, "USD"), Image: "image/product2.png"},
 			ProductQuantity: 222,
 		},
 	}
@@ -50,14 +62,316 @@ func (dbh DevDatabaseHandler) GetCheckouts(userID int) ([]Checkout, error) {
 	return checkouts, nil
 }
 
-func (dbh DevDatabaseHandler) CreateCheckout(userID int, productID int, productQuantity int) (string, error) {
+func (dbh DevDatabaseHandler) CreateCheckout(order CheckoutRequest) (string, error) {
 	return "", nil
 }
 
 func (dbh DevDatabaseHandler) GetCheckout(checkoutID string) (Checkout, error) {
 	checkout := Checkout{
-		ID: checkoutID, Product: Product{Name: "product1", Image: "image/product1.png"}, ProductQuantity: 111,
+		ID: checkoutID, User: User{ID: 2, Name: "scstore", Email: "scstore@example.com"},
+		Product: Product{Name: "product1", Image: "image/product1.png"}, ProductQuantity: 111,
+		Payment: Payment{Status: PaymentPaid},
 	}
 
 	return checkout, nil
 }
+
+func (dbh DevDatabaseHandler) CreateCoupon(coupon Coupon) error {
+	return coupon.Validate()
+}
+
+func (dbh DevDatabaseHandler) GetCoupons() ([]Coupon, error) {
+	coupons := []Coupon{
+		{Code: "SALE10", Kind: CouponKindPercent, Value: 10, Active: true},
+		{Code: "WELCOME", Kind: CouponKindFixed, Value: 5000, MinOrder: 20000, MaxUsesPerUser: 1, Active: true},
+	}
+
+	return coupons, nil
+}
+
+func (dbh DevDatabaseHandler) DeactivateCoupon(code string) error {
+	return nil
+}
+
+func (dbh DevDatabaseHandler) UpdateCheckoutPayment(checkoutID string, payment Payment) error {
+	if err := payment.validate(); err != nil {
+		return err
+	}
+	publishCheckoutUpdate(newCheckoutUpdate(checkoutID, 2, payment))
+
+	return nil
+}
+
+func (dbh DevDatabaseHandler) CreateAddress(address Address) (string, error) {
+	return "", address.Validate()
+}
+
+func (dbh DevDatabaseHandler) GetAddresses(userID int) ([]Address, error) {
+	addresses := []Address{
+		{ID: "address1", UserID: userID, Name: "Home", Line1: "1 Main St", City: "Springfield", PostalCode: "12345", Country: "US"},
+	}
+
+	return addresses, nil
+}
+
+func (dbh DevDatabaseHandler) DeleteAddress(userID int, addressID string) error {
+	return nil
+}
+
+func (dbh DevDatabaseHandler) CreateWebhook(webhook Webhook) (string, error) {
+	return "", webhook.Validate()
+}
+
+func (dbh DevDatabaseHandler) GetWebhooks() ([]Webhook, error) {
+	webhooks := []Webhook{
+		{ID: "webhook1", URL: "https://example.com/hooks/scstore", Secret: "0123456789abcdef", EventTypes: WebhookEventTypes, Active: true},
+	}
+
+	return webhooks, nil
+}
+
+func (dbh DevDatabaseHandler) DeactivateWebhook(webhookID string) error {
+	return nil
+}
+
+func (dbh DevDatabaseHandler) GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (dbh DevDatabaseHandler) DispatchWebhookEvents(limit int) (int, error) {
+	return 0, nil
+}
+
+func (dbh DevDatabaseHandler) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	return nil, nil
+}
+
+func (dbh DevDatabaseHandler) RecordWebhookAttempt(deliveryID string, attempt WebhookAttempt) error {
+	return nil
+}
+
+func (dbh DevDatabaseHandler) EnqueueCheckout(order CheckoutRequest, maxDepth int) (string, error) {
+	return "dummy-checkout-job", nil
+}
+
+func (dbh DevDatabaseHandler) GetCheckoutJob(jobID string) (CheckoutJob, error) {
+	job := CheckoutJob{
+		ID: jobID, Request: CheckoutRequest{ID: jobID, UserID: 2, ProductID: 1, ProductQuantity: 1},
+		Status: CheckoutJobQueued,
+	}
+
+	return job, nil
+}
+
+func (dbh DevDatabaseHandler) ClaimCheckoutJobs(now time.Time, lease time.Duration, limit int) ([]CheckoutJob, error) {
+	return nil, nil
+}
+
+func (dbh DevDatabaseHandler) FinishCheckoutJob(jobID string, status string, message string) error {
+	_, err := validateCheckoutJobResult(status, message)
+	return err
+}
+
+func (dbh DevDatabaseHandler) GetCheckoutQueueDepth() (int, error) {
+	return 0, nil
+}
+
+func (dbh DevDatabaseHandler) CreateReview(review Review) (string, error) {
+	return "", review.Validate()
+}
+
+func (dbh DevDatabaseHandler) GetProductReviews(productID int, limit int) ([]Review, error) {
+	reviews := []Review{
+		{ID: "review1", ProductID: productID, User: User{ID: 2, Name: "scstore"}, Rating: 4, Body: "Keeps good time.", Status: ReviewVisible},
+	}
+
+	return reviews, nil
+}
+
+func (dbh DevDatabaseHandler) GetReviews(status string, limit int) ([]Review, error) {
+	reviews := []Review{
+		{ID: "review1", ProductID: 1, User: User{ID: 2, Name: "scstore"}, Rating: 4, Body: "Keeps good time.", Status: ReviewVisible},
+		{ID: "review2", ProductID: 2, User: User{ID: 1, Name: "user00001"}, Rating: 1, Body: "Buy it elsewhere!", Status: ReviewFlagged},
+	}
+
+	return reviews, nil
+}
+
+func (dbh DevDatabaseHandler) UpdateReviewStatus(reviewID string, status string) error {
+	return validateReviewStatus(status)
+}
+
+func (dbh DevDatabaseHandler) AddWishlistItem(userID int, productID int) error {
+	return nil
+}
+
+func (dbh DevDatabaseHandler) GetWishlist(userID int) ([]WishlistItem, error) {
+	items := []WishlistItem{
+		{Product: Product{ID: 1, Name: "product1", Price: money.New(10000, "USD"), Weight: 125, Image: "image/product1.png", Rating: Rating{Count: 1, Total: 4}}},
+	}
+
+	return items, nil
+}
+
+func (dbh DevDatabaseHandler) RemoveWishlistItem(userID int, productID int) error {
+	return nil
+}
+
+// UpdateProductPrice changes the price of product1, which is on the
+// wishlist of scstore above.
+func (dbh DevDatabaseHandler) UpdateProductPrice(productID int, price int64) (PriceChange, error) {
+	if err := validateProductPrice(price); err != nil {
+		return PriceChange{}, err
+	}
+
+	product, _ := dbh.GetProduct(productID)
+	change := PriceChange{Product: product, OldPrice: product.Price}
+	change.Product.Price.Amount = price
+	if change.Dropped() {
+		change.Watchers = []User{{ID: 2, Name: "scstore", Email: "scstore@example.com"}}
+	}
+
+	return change, nil
+}
+
+// GetPurchaseStats returns two users who bought both products and one who
+// bought product1 only.
+func (dbh DevDatabaseHandler) GetPurchaseStats() ([]PurchaseStat, error) {
+	stats := []PurchaseStat{
+		{UserID: 1, ProductID: 1, Quantity: 2},
+		{UserID: 1, ProductID: 2, Quantity: 1},
+		{UserID: 2, ProductID: 1, Quantity: 111},
+		{UserID: 2, ProductID: 2, Quantity: 1},
+		{UserID: 3, ProductID: 1, Quantity: 1},
+	}
+
+	return stats, nil
+}
+
+func (dbh DevDatabaseHandler) CreateVariant(variant Variant) error {
+	return variant.Validate()
+}
+
+// GetVariants returns two straps of product2, the brown one costing more and
+// sold out.
+func (dbh DevDatabaseHandler) GetVariants(productID int) ([]Variant, error) {
+	if productID != 2 {
+		return nil, nil
+	}
+
+	variants := []Variant{
+		{SKU: "P2-BLACK", ProductID: 2, Name: "Black strap", Price: money.New(20000, "USD"), Stock: 3, Image: "image/product2.png"},
+		{SKU: "P2-BROWN", ProductID: 2, Name: "Brown strap", Price: money.New(22000, "USD"), Stock: 0, Image: "image/product2-brown.png"},
+	}
+
+	return variants, nil
+}
+
+func (dbh DevDatabaseHandler) UpdateVariantStock(sku string, stock int) error {
+	return validateVariantStock(stock)
+}
+
+// GetSalesReport returns the sales of the first two days of the window, where
+// user2 bought product1 twice and user1 bought product2 once, whatever the
+// length of the window.
+func (dbh DevDatabaseHandler) GetSalesReport(from, to time.Time, currency string, limit int) (SalesReport, error) {
+	report, err := newSalesReport(from, to, currency)
+	if err != nil {
+		return report, err
+	}
+
+	if err := report.addDay(report.From.Format(SalesDayLayout), SalesTotals{Orders: 2, Units: 3, Revenue: money.New(30000, currency)}); err != nil {
+		return report, err
+	}
+	if err := report.addDay(report.From.AddDate(0, 0, 1).Format(SalesDayLayout), SalesTotals{Orders: 1, Units: 1, Revenue: money.New(20000, currency)}); err != nil {
+		return report, err
+	}
+	report.Customers = 2
+	report.TopProducts = []ProductSales{
+		{Product: Product{ID: 1, Name: "product1"}, SalesTotals: SalesTotals{Orders: 2, Units: 3, Revenue: money.New(30000, currency)}},
+		{Product: Product{ID: 2, Name: "product2"}, SalesTotals: SalesTotals{Orders: 1, Units: 1, Revenue: money.New(20000, currency)}},
+	}
+	report.TopCustomers = []CustomerSales{
+		{User: User{ID: 2, Name: "scstore"}, SalesTotals: SalesTotals{Orders: 2, Units: 3, Revenue: money.New(30000, currency)}},
+		{User: User{ID: 1, Name: "user00001"}, SalesTotals: SalesTotals{Orders: 1, Units: 1, Revenue: money.New(20000, currency)}},
+	}
+	if limit > 0 && limit < len(report.TopProducts) {
+		report.TopProducts = report.TopProducts[:limit]
+		report.TopCustomers = report.TopCustomers[:limit]
+	}
+
+	return report, nil
+}
+
+// ExportCheckouts exports the checkouts of GetCheckouts, of user2 when no
+// user is given, whatever the window.
+func (dbh DevDatabaseHandler) ExportCheckouts(filter CheckoutFilter, fn func(Checkout) error) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	userID := filter.UserID
+	if userID == 0 {
+		userID = 2
+	}
+	checkouts, err := dbh.GetCheckouts(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, checkout := range checkouts {
+		if err := fn(checkout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Backup backs up the products and coupons above, scstore and the checkout of
+// GetCheckout.
+func (dbh DevDatabaseHandler) Backup(fn func(BackupRecord) error) error {
+	products, err := dbh.GetProducts()
+	if err != nil {
+		return err
+	}
+	coupons, err := dbh.GetCoupons()
+	if err != nil {
+		return err
+	}
+	checkout, err := dbh.GetCheckout("checkout1")
+	if err != nil {
+		return err
+	}
+
+	var records []BackupRecord
+	for i := range products {
+		records = append(records, BackupRecord{Product: &products[i]})
+	}
+	records = append(records, BackupRecord{User: &User{ID: 2, Name: "scstore", Email: "scstore@example.com"}})
+	for i := range coupons {
+		records = append(records, BackupRecord{Coupon: &coupons[i]})
+	}
+	records = append(records, BackupRecord{Checkout: &checkout})
+
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Restore reads the records and drops them.
+func (dbh DevDatabaseHandler) Restore(next func() (BackupRecord, error)) error {
+	for {
+		_, err := next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// The kinds of errors returned by every DatabaseHandler. Check them with errors.Is.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrValidation = errors.New("validation failed")
+	ErrConflict   = errors.New("conflict")
+	ErrQueueFull  = errors.New("queue is full")
+)
+
+// Error is an error with a message which is safe to show to clients.
+// Fields holds the message per input field of a validation error.
+// The underlying driver error is kept in Err for logging.
+type Error struct {
+	Kind    error
+	Message string
+	Fields  map[string]string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NewNotFoundError(resource string, id interface{}) error {
+	return &Error{Kind: ErrNotFound, Message: fmt.Sprintf("%s %v is not found", resource, id)}
+}
+
+func NewValidationError(message string) error {
+	return &Error{Kind: ErrValidation, Message: message}
+}
+
+// NewFieldValidationError returns a validation error with the message per input field.
+func NewFieldValidationError(fields map[string]string) error {
+	return &Error{Kind: ErrValidation, Message: "some fields are invalid", Fields: fields}
+}
+
+func NewConflictError(message string) error {
+	return &Error{Kind: ErrConflict, Message: message}
+}
+
+func NewQueueFullError(message string) error {
+	return &Error{Kind: ErrQueueFull, Message: message}
+}
+
+// SQLite extended result codes for constraint violations.
+const (
+	sqliteConstraintCheck      = 275
+	sqliteConstraintForeignKey = 787
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+// translateError converts the driver errors into the typed errors above.
+// Other errors are returned as they are.
+func translateError(err error, resource string, id interface{}) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Error{Kind: ErrNotFound, Message: fmt.Sprintf("%s %v is not found", resource, id), Err: err}
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Name() {
+		case "unique_violation":
+			return &Error{Kind: ErrConflict, Message: fmt.Sprintf("%s %v already exists", resource, id), Err: err}
+		case "foreign_key_violation", "check_violation", "not_null_violation":
+			return &Error{Kind: ErrValidation, Message: fmt.Sprintf("%s %v is invalid", resource, id), Err: err}
+		}
+	}
+
+	var codeErr interface{ Code() int }
+	if errors.As(err, &codeErr) {
+		switch codeErr.Code() {
+		case sqliteConstraintPrimaryKey, sqliteConstraintUnique:
+			return &Error{Kind: ErrConflict, Message: fmt.Sprintf("%s %v already exists", resource, id), Err: err}
+		case sqliteConstraintForeignKey, sqliteConstraintCheck:
+			return &Error{Kind: ErrValidation, Message: fmt.Sprintf("%s %v is invalid", resource, id), Err: err}
+		}
+	}
+
+	return err
+}
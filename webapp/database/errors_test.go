@@ -0,0 +1,34 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+type codeError struct {
+	code int
+}
+
+func (e codeError) Error() string { return "constraint failed" }
+func (e codeError) Code() int     { return e.code }
+
+func TestTranslateError(t *testing.T) {
+	assert.Nil(t, translateError(nil, "product", 1))
+
+	err := translateError(sql.ErrNoRows, "product", 1)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.Equal(t, "product 1 is not found", err.(*Error).Message)
+
+	assert.ErrorIs(t, translateError(&pq.Error{Code: "23505"}, "checkout", "id"), ErrConflict)
+	assert.ErrorIs(t, translateError(&pq.Error{Code: "23503"}, "checkout", "id"), ErrValidation)
+	assert.ErrorIs(t, translateError(codeError{code: sqliteConstraintPrimaryKey}, "checkout", "id"), ErrConflict)
+	assert.ErrorIs(t, translateError(codeError{code: sqliteConstraintCheck}, "checkout", "id"), ErrValidation)
+
+	other := errors.New("connection refused")
+	assert.Equal(t, other, translateError(other, "product", 1))
+}
@@ -0,0 +1,32 @@
+package database
+
+import (
+	"time"
+)
+
+// CheckoutFilter selects the checkouts of an export. A zero UserID selects
+// the checkouts of every user. The checkouts are those from the day From
+// until the day before To, in UTC, and a zero From or To leaves that end of
+// the window open.
+type CheckoutFilter struct {
+	UserID int
+	From   time.Time
+	To     time.Time
+}
+
+// Validate checks the window of the filter and truncates its ends to their
+// day in UTC.
+func (filter *CheckoutFilter) Validate() error {
+	if !filter.From.IsZero() {
+		filter.From = filter.From.UTC().Truncate(24 * time.Hour)
+	}
+	if !filter.To.IsZero() {
+		filter.To = filter.To.UTC().Truncate(24 * time.Hour)
+	}
+
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.To.After(filter.From) {
+		return NewFieldValidationError(map[string]string{"to": "The end must be after the start."})
+	}
+
+	return nil
+}
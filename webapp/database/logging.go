@@ -0,0 +1,258 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// LoggingDatabaseHandler logs the duration of every call to the wrapped
+// handler. Create one per request with a logger carrying the request ID so
+// that slow queries can be matched with the request.
+type LoggingDatabaseHandler struct {
+	dbh           DatabaseHandler
+	logger        *slog.Logger
+	slowThreshold time.Duration
+}
+
+func NewLoggingDatabaseHandler(dbh DatabaseHandler, logger *slog.Logger, slowThreshold time.Duration) LoggingDatabaseHandler {
+	return LoggingDatabaseHandler{dbh: dbh, logger: logger, slowThreshold: slowThreshold}
+}
+
+func (dbh LoggingDatabaseHandler) log(method string, start time.Time, err error) {
+	duration := time.Since(start)
+
+	// Typed errors like a missing product are answered to the client and
+	// not worth a warning.
+	var dbErr *Error
+	level := slog.LevelDebug
+	if (err != nil && !errors.As(err, &dbErr)) || duration >= dbh.slowThreshold {
+		level = slog.LevelWarn
+	}
+
+	attrs := []slog.Attr{
+		slog.String("db_method", method),
+		slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	dbh.logger.LogAttrs(context.Background(), level, "database call", attrs...)
+}
+
+func (dbh LoggingDatabaseHandler) InitDatabase() (err error) {
+	defer func(start time.Time) { dbh.log("InitDatabase", start, err) }(time.Now())
+	return dbh.dbh.InitDatabase()
+}
+
+func (dbh LoggingDatabaseHandler) MigrateDatabase() (err error) {
+	defer func(start time.Time) { dbh.log("MigrateDatabase", start, err) }(time.Now())
+	return dbh.dbh.MigrateDatabase()
+}
+
+func (dbh LoggingDatabaseHandler) SeedDatabase() (err error) {
+	defer func(start time.Time) { dbh.log("SeedDatabase", start, err) }(time.Now())
+	return dbh.dbh.SeedDatabase()
+}
+
+func (dbh LoggingDatabaseHandler) GetProduct(id int) (product Product, err error) {
+	defer func(start time.Time) { dbh.log("GetProduct", start, err) }(time.Now())
+	return dbh.dbh.GetProduct(id)
+}
+
+func (dbh LoggingDatabaseHandler) GetProducts() (products []Product, err error) {
+	defer func(start time.Time) { dbh.log("GetProducts", start, err) }(time.Now())
+	return dbh.dbh.GetProducts()
+}
+
+func (dbh LoggingDatabaseHandler) GetCheckouts(userID int) (checkouts []Checkout, err error) {
+	defer func(start time.Time) { dbh.log("GetCheckouts", start, err) }(time.Now())
+	return dbh.dbh.GetCheckouts(userID)
+}
+
+func (dbh LoggingDatabaseHandler) CreateCheckout(order CheckoutRequest) (checkoutID string, err error) {
+	defer func(start time.Time) { dbh.log("CreateCheckout", start, err) }(time.Now())
+	return dbh.dbh.CreateCheckout(order)
+}
+
+func (dbh LoggingDatabaseHandler) GetCheckout(checkoutID string) (checkout Checkout, err error) {
+	defer func(start time.Time) { dbh.log("GetCheckout", start, err) }(time.Now())
+	return dbh.dbh.GetCheckout(checkoutID)
+}
+
+func (dbh LoggingDatabaseHandler) CreateCoupon(coupon Coupon) (err error) {
+	defer func(start time.Time) { dbh.log("CreateCoupon", start, err) }(time.Now())
+	return dbh.dbh.CreateCoupon(coupon)
+}
+
+func (dbh LoggingDatabaseHandler) GetCoupons() (coupons []Coupon, err error) {
+	defer func(start time.Time) { dbh.log("GetCoupons", start, err) }(time.Now())
+	return dbh.dbh.GetCoupons()
+}
+
+func (dbh LoggingDatabaseHandler) DeactivateCoupon(code string) (err error) {
+	defer func(start time.Time) { dbh.log("DeactivateCoupon", start, err) }(time.Now())
+	return dbh.dbh.DeactivateCoupon(code)
+}
+
+func (dbh LoggingDatabaseHandler) UpdateCheckoutPayment(checkoutID string, payment Payment) (err error) {
+	defer func(start time.Time) { dbh.log("UpdateCheckoutPayment", start, err) }(time.Now())
+	return dbh.dbh.UpdateCheckoutPayment(checkoutID, payment)
+}
+
+func (dbh LoggingDatabaseHandler) CreateAddress(address Address) (addressID string, err error) {
+	defer func(start time.Time) { dbh.log("CreateAddress", start, err) }(time.Now())
+	return dbh.dbh.CreateAddress(address)
+}
+
+func (dbh LoggingDatabaseHandler) GetAddresses(userID int) (addresses []Address, err error) {
+	defer func(start time.Time) { dbh.log("GetAddresses", start, err) }(time.Now())
+	return dbh.dbh.GetAddresses(userID)
+}
+
+func (dbh LoggingDatabaseHandler) DeleteAddress(userID int, addressID string) (err error) {
+	defer func(start time.Time) { dbh.log("DeleteAddress", start, err) }(time.Now())
+	return dbh.dbh.DeleteAddress(userID, addressID)
+}
+
+func (dbh LoggingDatabaseHandler) CreateWebhook(webhook Webhook) (webhookID string, err error) {
+	defer func(start time.Time) { dbh.log("CreateWebhook", start, err) }(time.Now())
+	return dbh.dbh.CreateWebhook(webhook)
+}
+
+func (dbh LoggingDatabaseHandler) GetWebhooks() (webhooks []Webhook, err error) {
+	defer func(start time.Time) { dbh.log("GetWebhooks", start, err) }(time.Now())
+	return dbh.dbh.GetWebhooks()
+}
+
+func (dbh LoggingDatabaseHandler) DeactivateWebhook(webhookID string) (err error) {
+	defer func(start time.Time) { dbh.log("DeactivateWebhook", start, err) }(time.Now())
+	return dbh.dbh.DeactivateWebhook(webhookID)
+}
+
+func (dbh LoggingDatabaseHandler) GetWebhookDeliveries(webhookID string, limit int) (deliveries []WebhookDelivery, err error) {
+	defer func(start time.Time) { dbh.log("GetWebhookDeliveries", start, err) }(time.Now())
+	return dbh.dbh.GetWebhookDeliveries(webhookID, limit)
+}
+
+func (dbh LoggingDatabaseHandler) DispatchWebhookEvents(limit int) (dispatched int, err error) {
+	defer func(start time.Time) { dbh.log("DispatchWebhookEvents", start, err) }(time.Now())
+	return dbh.dbh.DispatchWebhookEvents(limit)
+}
+
+func (dbh LoggingDatabaseHandler) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) (deliveries []WebhookDelivery, err error) {
+	defer func(start time.Time) { dbh.log("ClaimWebhookDeliveries", start, err) }(time.Now())
+	return dbh.dbh.ClaimWebhookDeliveries(now, lease, limit)
+}
+
+func (dbh LoggingDatabaseHandler) RecordWebhookAttempt(deliveryID string, attempt WebhookAttempt) (err error) {
+	defer func(start time.Time) { dbh.log("RecordWebhookAttempt", start, err) }(time.Now())
+	return dbh.dbh.RecordWebhookAttempt(deliveryID, attempt)
+}
+
+func (dbh LoggingDatabaseHandler) EnqueueCheckout(order CheckoutRequest, maxDepth int) (jobID string, err error) {
+	defer func(start time.Time) { dbh.log("EnqueueCheckout", start, err) }(time.Now())
+	return dbh.dbh.EnqueueCheckout(order, maxDepth)
+}
+
+func (dbh LoggingDatabaseHandler) GetCheckoutJob(jobID string) (job CheckoutJob, err error) {
+	defer func(start time.Time) { dbh.log("GetCheckoutJob", start, err) }(time.Now())
+	return dbh.dbh.GetCheckoutJob(jobID)
+}
+
+func (dbh LoggingDatabaseHandler) ClaimCheckoutJobs(now time.Time, lease time.Duration, limit int) (jobs []CheckoutJob, err error) {
+	defer func(start time.Time) { dbh.log("ClaimCheckoutJobs", start, err) }(time.Now())
+	return dbh.dbh.ClaimCheckoutJobs(now, lease, limit)
+}
+
+func (dbh LoggingDatabaseHandler) FinishCheckoutJob(jobID string, status string, message string) (err error) {
+	defer func(start time.Time) { dbh.log("FinishCheckoutJob", start, err) }(time.Now())
+	return dbh.dbh.FinishCheckoutJob(jobID, status, message)
+}
+
+func (dbh LoggingDatabaseHandler) GetCheckoutQueueDepth() (depth int, err error) {
+	defer func(start time.Time) { dbh.log("GetCheckoutQueueDepth", start, err) }(time.Now())
+	return dbh.dbh.GetCheckoutQueueDepth()
+}
+
+func (dbh LoggingDatabaseHandler) CreateReview(review Review) (reviewID string, err error) {
+	defer func(start time.Time) { dbh.log("CreateReview", start, err) }(time.Now())
+	return dbh.dbh.CreateReview(review)
+}
+
+func (dbh LoggingDatabaseHandler) GetProductReviews(productID int, limit int) (reviews []Review, err error) {
+	defer func(start time.Time) { dbh.log("GetProductReviews", start, err) }(time.Now())
+	return dbh.dbh.GetProductReviews(productID, limit)
+}
+
+func (dbh LoggingDatabaseHandler) GetReviews(status string, limit int) (reviews []Review, err error) {
+	defer func(start time.Time) { dbh.log("GetReviews", start, err) }(time.Now())
+	return dbh.dbh.GetReviews(status, limit)
+}
+
+func (dbh LoggingDatabaseHandler) UpdateReviewStatus(reviewID string, status string) (err error) {
+	defer func(start time.Time) { dbh.log("UpdateReviewStatus", start, err) }(time.Now())
+	return dbh.dbh.UpdateReviewStatus(reviewID, status)
+}
+
+func (dbh LoggingDatabaseHandler) AddWishlistItem(userID int, productID int) (err error) {
+	defer func(start time.Time) { dbh.log("AddWishlistItem", start, err) }(time.Now())
+	return dbh.dbh.AddWishlistItem(userID, productID)
+}
+
+func (dbh LoggingDatabaseHandler) GetWishlist(userID int) (items []WishlistItem, err error) {
+	defer func(start time.Time) { dbh.log("GetWishlist", start, err) }(time.Now())
+	return dbh.dbh.GetWishlist(userID)
+}
+
+func (dbh LoggingDatabaseHandler) RemoveWishlistItem(userID int, productID int) (err error) {
+	defer func(start time.Time) { dbh.log("RemoveWishlistItem", start, err) }(time.Now())
+	return dbh.dbh.RemoveWishlistItem(userID, productID)
+}
+
+func (dbh LoggingDatabaseHandler) UpdateProductPrice(productID int, price int64) (change PriceChange, err error) {
+	defer func(start time.Time) { dbh.log("UpdateProductPrice", start, err) }(time.Now())
+	return dbh.dbh.UpdateProductPrice(productID, price)
+}
+
+func (dbh LoggingDatabaseHandler) GetPurchaseStats() (stats []PurchaseStat, err error) {
+	defer func(start time.Time) { dbh.log("GetPurchaseStats", start, err) }(time.Now())
+	return dbh.dbh.GetPurchaseStats()
+}
+
+func (dbh LoggingDatabaseHandler) CreateVariant(variant Variant) (err error) {
+	defer func(start time.Time) { dbh.log("CreateVariant", start, err) }(time.Now())
+	return dbh.dbh.CreateVariant(variant)
+}
+
+func (dbh LoggingDatabaseHandler) GetVariants(productID int) (variants []Variant, err error) {
+	defer func(start time.Time) { dbh.log("GetVariants", start, err) }(time.Now())
+	return dbh.dbh.GetVariants(productID)
+}
+
+func (dbh LoggingDatabaseHandler) UpdateVariantStock(sku string, stock int) (err error) {
+	defer func(start time.Time) { dbh.log("UpdateVariantStock", start, err) }(time.Now())
+	return dbh.dbh.UpdateVariantStock(sku, stock)
+}
+
+func (dbh LoggingDatabaseHandler) GetSalesReport(from, to time.Time, currency string, limit int) (report SalesReport, err error) {
+	defer func(start time.Time) { dbh.log("GetSalesReport", start, err) }(time.Now())
+	return dbh.dbh.GetSalesReport(from, to, currency, limit)
+}
+
+func (dbh LoggingDatabaseHandler) ExportCheckouts(filter CheckoutFilter, fn func(Checkout) error) (err error) {
+	defer func(start time.Time) { dbh.log("ExportCheckouts", start, err) }(time.Now())
+	return dbh.dbh.ExportCheckouts(filter, fn)
+}
+
+func (dbh LoggingDatabaseHandler) Backup(fn func(BackupRecord) error) (err error) {
+	defer func(start time.Time) { dbh.log("Backup", start, err) }(time.Now())
+	return dbh.dbh.Backup(fn)
+}
+
+func (dbh LoggingDatabaseHandler) Restore(next func() (BackupRecord, error)) (err error) {
+	defer func(start time.Time) { dbh.log("Restore", start, err) }(time.Now())
+	return dbh.dbh.Restore(next)
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggingDatabaseHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})).With("request_id", "req-1")
+
+	dbh := NewLoggingDatabaseHandler(NewDevDatabaseHandler(nil), logger, time.Second)
+
+	product, err := dbh.GetProduct(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, product.ID)
+
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "DEBUG", line["level"])
+	assert.Equal(t, "GetProduct", line["db_method"])
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Contains(t, line, "duration_ms")
+
+	buf.Reset()
+	dbh = NewLoggingDatabaseHandler(NewDevDatabaseHandler(nil), logger, 0)
+	_, err = dbh.GetProducts()
+	assert.Nil(t, err)
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "WARN", line["level"])
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// schemaVersion is the version of the schema of the app. The databases keep
+// the version of their schema in the schema_version table, which
+// MigrateDatabase brings up to schemaVersion with the migrations of the
+// backend. The databases created before the versions were at version 1.
+//
+//	1: the schema of the tables of schemaTables
+//	2: the columns added since the first release, and checkouts.created_at
+//	   is a timestamp with an index
+//	3: checkouts.payment_status can be charging and refunding
+const schemaVersion = 3
+
+// schemaMigration changes a table of a database at the previous version to
+// the version. The tables which don't exist yet are created at
+// schemaVersion instead. When column is set, the statements add it and are
+// only applied to the tables without it, as the tables of version 1 were
+// created by any of the releases before the versions.
+type schemaMigration struct {
+	version    int
+	table      string
+	column     string
+	statements []string
+}
+
+// schemaColumns are the columns of the tables which the app reads and
+// writes. MigrateDatabase checks that the database has them all or that a
+// migration adds them before changing it, so that a database whose schema
+// was changed outside of the app fails before serving instead of on the
+// first query.
+var schemaColumns = map[string][]string{
+	"products":           {"id", "name", "price", "currency", "weight", "image", "review_count", "rating_total"},
+	"users":              {"id", "name", "email"},
+	"addresses":          {"id", "user_id", "name", "line1", "line2", "city", "postal_code", "country", "created_at"},
+	"coupons":            {"code", "kind", "value", "min_order", "expires_at", "max_uses", "max_uses_per_user", "uses", "active"},
+	"checkouts":          {"id", "user_id", "product_id", "variant_sku", "variant_name", "product_quantity", "coupon_code", "tax_region", "currency", "subtotal", "discount", "tax", "shipping", "total", "payment_status", "payment_id", "payment_error", "shipping_name", "shipping_line1", "shipping_line2", "shipping_city", "shipping_postal_code", "shipping_country", "created_at"},
+	"webhooks":           {"id", "url", "secret", "event_types", "active", "created_at"},
+	"webhook_events":     {"id", "type", "payload", "created_at", "dispatched_at"},
+	"webhook_deliveries": {"id", "webhook_id", "event_id", "status", "attempts", "next_attempt_at", "last_status_code", "last_error", "updated_at"},
+	"checkout_jobs":      {"id", "user_id", "request", "status", "attempts", "locked_until", "error", "created_at", "updated_at"},
+	"reviews":            {"id", "product_id", "user_id", "rating", "body", "status", "created_at"},
+	"wishlist_items":     {"user_id", "product_id", "created_at"},
+	"product_variants":   {"sku", "product_id", "name", "price", "stock", "image"},
+}
+
+// checkSchemaVersion fails for the databases migrated by a newer app, whose
+// schema this app may not know how to use.
+func checkSchemaVersion(version int) error {
+	if version > schemaVersion {
+		return fmt.Errorf("the schema of the database is at version %d, but this app only knows up to version %d: deploy a newer app", version, schemaVersion)
+	}
+
+	return nil
+}
+
+// checkSchemaColumns fails when an existing table lacks a column of
+// schemaColumns which no migration newer than version adds. It runs before
+// the migrations, so that such a database is left as it is. columns returns
+// the columns of a table, or nil when the table doesn't exist yet.
+func checkSchemaColumns(version int, migrations []schemaMigration, columns func(table string) (map[string]bool, error)) error {
+	added := make(map[string]bool)
+	for _, migration := range migrations {
+		if migration.version > version && migration.column != "" {
+			added[migration.table+"."+migration.column] = true
+		}
+	}
+
+	tables := make([]string, 0, len(schemaColumns))
+	for table := range schemaColumns {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		existing, err := columns(table)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			continue
+		}
+		for _, column := range schemaColumns[table] {
+			if !existing[column] && !added[table+"."+column] {
+				return fmt.Errorf("the table %s has no column %s and no migration adds it, so its schema was changed outside of the app: add the column and migrate again", table, column)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sqlQuerier is implemented by *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// querySchemaColumns returns the columns of the table from a query which
+// reads no row.
+func querySchemaColumns(q sqlQuerier, table string) (map[string]bool, error) {
+	rows, err := q.Query("SELECT * FROM " + table + " LIMIT 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]bool)
+	for _, name := range names {
+		columns[name] = true
+	}
+
+	return columns, nil
+}
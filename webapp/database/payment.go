@@ -0,0 +1,76 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The payment states of an order. An order is created pending and becomes
+// paid only once the payment provider captured its total. It is charging or
+// refunding while the provider is called, so that only one caller charges
+// or refunds it.
+const (
+	PaymentPending   = "pending"
+	PaymentCharging  = "charging"
+	PaymentPaid      = "paid"
+	PaymentFailed    = "failed"
+	PaymentRefunding = "refunding"
+	PaymentRefunded  = "refunded"
+)
+
+// paymentErrorMaxLength keeps the messages of the providers in the column.
+const paymentErrorMaxLength = 200
+
+// Payment is the state of the payment of an order. ID is the authorization
+// at the payment provider and Error is why the payment failed.
+type Payment struct {
+	Status string
+	ID     string
+	Error  string
+}
+
+func (payment Payment) validate() error {
+	switch payment.Status {
+	case PaymentPending, PaymentCharging, PaymentPaid, PaymentFailed, PaymentRefunding, PaymentRefunded:
+	default:
+		return NewValidationError("payment status must be pending, charging, paid, failed, refunding or refunded")
+	}
+
+	if len(payment.ID) > 100 {
+		return NewValidationError("payment ID must be at most 100 characters")
+	}
+
+	return nil
+}
+
+// checkTransition fails with ErrConflict when an order whose payment is
+// previous can't become the payment. An order is claimed only once: it
+// becomes charging only when pending and refunding only when paid. A
+// claimed order only becomes the result of the call to the provider.
+func (payment Payment) checkTransition(checkoutID string, previous string) error {
+	allowed := true
+	switch {
+	case previous == PaymentCharging:
+		allowed = payment.Status == PaymentPaid || payment.Status == PaymentFailed
+	case previous == PaymentRefunding:
+		allowed = payment.Status == PaymentRefunded || payment.Status == PaymentPaid
+	case payment.Status == PaymentCharging:
+		allowed = previous == PaymentPending
+	case payment.Status == PaymentRefunding:
+		allowed = previous == PaymentPaid
+	}
+	if !allowed {
+		return NewConflictError(fmt.Sprintf("the payment of checkout %s is %s and can't become %s", checkoutID, previous, payment.Status))
+	}
+
+	return nil
+}
+
+// errorMessage returns the failure reason cut to the length of the column.
+func (payment Payment) errorMessage() string {
+	if len(payment.Error) > paymentErrorMaxLength {
+		return strings.ToValidUTF8(payment.Error[:paymentErrorMaxLength], "")
+	}
+
+	return payment.Error
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+// CheckoutRequest is an order to be stored by CreateCheckout.
+// TaxRate is the rate in percent of TaxRegion and ShippingRate is the rate of
+// the country of ShippingAddress, which the app looked up. ShippingAddress is
+// copied to the order and may be zero.
+type CheckoutRequest struct {
+	// ID is the ID of the new checkout. A random one is used when it is
+	// empty. Creating a checkout with an existing ID fails with ErrConflict.
+	ID        string
+	UserID    int
+	ProductID int
+	// VariantSKU is the variant ordered. It is required for the products
+	// with variants, and the quantity ordered is taken from its stock.
+	VariantSKU      string
+	ProductQuantity int
+	CouponCode      string
+	TaxRegion       string
+	TaxRate         float64
+	ShippingAddress Address
+	ShippingRate    ShippingRate
+}
+
+// checkoutID returns the ID of the checkout of the order.
+func (order CheckoutRequest) checkoutID() (string, error) {
+	if order.ID != "" {
+		return order.ID, nil
+	}
+
+	uuidObj, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	return uuidObj.String(), nil
+}
+
+// checkoutAmounts are the amounts of an order in the currency of its product.
+// Tax is charged on the subtotal after the discount, and shipping is added
+// untaxed.
+type checkoutAmounts struct {
+	Subtotal money.Money
+	Discount money.Money
+	Tax      money.Money
+	Shipping money.Money
+	Total    money.Money
+}
+
+func newCheckoutAmounts(price money.Money, weight int, order CheckoutRequest, discount money.Money) checkoutAmounts {
+	subtotal := price.Mul(order.ProductQuantity)
+	taxable := subtotal.Sub(discount)
+	tax := taxable.Percent(order.TaxRate)
+	shipping := order.ShippingRate.Fee(taxable, weight*order.ProductQuantity)
+
+	return checkoutAmounts{
+		Subtotal: subtotal,
+		Discount: discount,
+		Tax:      tax,
+		Shipping: shipping,
+		Total:    taxable.Add(tax).Add(shipping),
+	}
+}
+
+func errProductNotExist() error {
+	return NewFieldValidationError(map[string]string{"product_id": "The product does not exist."})
+}
+
+// priceCheckout is shared by the SQL backends. It reads the current price of
+// the product or its variant, takes the units of the variant from its stock
+// and redeems the coupon of the order within tx. It returns the variant
+// ordered, which is zero for a product without variants.
+func priceCheckout(tx *sql.Tx, order CheckoutRequest, now time.Time) (checkoutAmounts, Variant, error) {
+	var price money.Money
+	var weight int
+	var hasVariants bool
+	query := "SELECT price, currency, weight, EXISTS (SELECT 1 FROM product_variants WHERE product_id = products.id) FROM products WHERE id = $1"
+	if err := tx.QueryRow(query, order.ProductID).Scan(&price.Amount, &price.Currency, &weight, &hasVariants); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return checkoutAmounts{}, Variant{}, errProductNotExist()
+		}
+		return checkoutAmounts{}, Variant{}, err
+	}
+
+	var variant Variant
+	switch {
+	case order.VariantSKU != "":
+		var err error
+		if variant, err = reserveVariant(tx, order); err != nil {
+			return checkoutAmounts{}, Variant{}, err
+		}
+		price.Amount = variant.Price.Amount
+	case hasVariants:
+		return checkoutAmounts{}, Variant{}, errVariantRequired()
+	}
+
+	discount := money.New(0, price.Currency)
+	if order.CouponCode != "" {
+		var err error
+		if discount, err = redeemCoupon(tx, order, price.Mul(order.ProductQuantity), now); err != nil {
+			return checkoutAmounts{}, Variant{}, err
+		}
+	}
+
+	return newCheckoutAmounts(price, weight, order, discount), variant, nil
+}
+
+// reserveVariant takes the units of the order from the stock of its variant
+// with a guarded UPDATE, so that concurrent checkouts can't sell more than
+// the stock. It returns the variant with its price.
+func reserveVariant(tx *sql.Tx, order CheckoutRequest) (Variant, error) {
+	variant := Variant{SKU: order.VariantSKU, ProductID: order.ProductID}
+	query := `
+	SELECT product_variants.name, COALESCE(product_variants.price, products.price), products.currency, product_variants.stock
+	FROM product_variants
+	JOIN products ON product_variants.product_id = products.id
+	WHERE product_variants.sku = $1 AND product_variants.product_id = $2
+	`
+	if err := tx.QueryRow(query, order.VariantSKU, order.ProductID).Scan(&variant.Name, &variant.Price.Amount, &variant.Price.Currency, &variant.Stock); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Variant{}, errVariantNotExist()
+		}
+		return Variant{}, err
+	}
+
+	result, err := tx.Exec("UPDATE product_variants SET stock = stock - $1 WHERE sku = $2 AND stock >= $1", order.ProductQuantity, order.VariantSKU)
+	if err != nil {
+		return Variant{}, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return Variant{}, err
+	}
+	if updated == 0 {
+		return Variant{}, errOutOfStock(variant.Stock)
+	}
+	variant.Stock -= order.ProductQuantity
+
+	return variant, nil
+}
+
+// checkoutReservation is what an order took when it was created: the units
+// of its variant and a use of its coupon. VariantSKU and CouponCode are
+// empty when the order has none.
+type checkoutReservation struct {
+	ProductID  int
+	VariantSKU string
+	Quantity   int
+	CouponCode string
+}
+
+// holdsReservation reports whether an order with the payment status keeps
+// its reservation. A failed order gives it back, so that a declined card
+// doesn't keep the stock or the use of the coupon.
+func holdsReservation(status string) bool {
+	return status != PaymentFailed
+}
+
+// releaseReservation gives the reservation back within tx.
+func releaseReservation(tx *sql.Tx, reservation checkoutReservation) error {
+	if reservation.VariantSKU != "" {
+		if _, err := tx.Exec("UPDATE product_variants SET stock = stock + $1 WHERE sku = $2", reservation.Quantity, reservation.VariantSKU); err != nil {
+			return err
+		}
+	}
+	if reservation.CouponCode != "" {
+		if _, err := tx.Exec("UPDATE coupons SET uses = uses - 1 WHERE code = $1 AND uses > 0", reservation.CouponCode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retakeReservation takes the reservation again within tx when a failed
+// order is paid after all, like with a capture confirmed late. The customer
+// has paid, so the stock left is taken even when it isn't enough, and the
+// usage limits of the coupon are not checked.
+func retakeReservation(tx *sql.Tx, reservation checkoutReservation) error {
+	if reservation.VariantSKU != "" {
+		query := "UPDATE product_variants SET stock = CASE WHEN stock >= $1 THEN stock - $1 ELSE 0 END WHERE sku = $2"
+		if _, err := tx.Exec(query, reservation.Quantity, reservation.VariantSKU); err != nil {
+			return err
+		}
+	}
+	if reservation.CouponCode != "" {
+		if _, err := tx.Exec("UPDATE coupons SET uses = uses + 1 WHERE code = $1", reservation.CouponCode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
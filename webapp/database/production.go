@@ -1,118 +1,488 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/mittz/role-play-webapp/webapp/money"
 )
 
 type ProdDatabaseHandler struct {
 	DB *sql.DB
+	// notify sends the checkout updates with pg_notify so that every instance
+	// gets them from ListenCheckoutUpdates. Without it they are published in
+	// this process only.
+	notify bool
+	// checkoutDay is the SQL expression of the day of a checkout in UTC as
+	// YYYY-MM-DD. The PostgreSQL one is used when it is empty.
+	checkoutDay string
 }
 
 func NewProdDatabaseHandler(db *sql.DB) ProdDatabaseHandler {
-	return ProdDatabaseHandler{DB: db}
+	return ProdDatabaseHandler{DB: db, notify: true}
 }
 
+// prodSchema creates the tables with their indexes, the tables referenced by
+// the others first.
+var prodSchema = []struct {
+	table      string
+	statements []string
+}{
+	{"products", []string{
+		`
+		CREATE TABLE products (
+			id bigint NOT NULL,
+			name character varying(20) NOT NULL,
+			price bigint NOT NULL,
+			currency character varying(3) NOT NULL,
+			weight bigint NOT NULL,
+			image character varying(100) NOT NULL,
+			review_count bigint NOT NULL,
+			rating_total bigint NOT NULL,
+			PRIMARY KEY(id)
+		)
+		`,
+	}},
+	{"users", []string{
+		`
+		CREATE TABLE users (
+			id bigint NOT NULL,
+			name character varying(20) NOT NULL,
+			email character varying(254) NOT NULL,
+			PRIMARY KEY(id)
+		)
+		`,
+	}},
+	{"addresses", []string{
+		`
+		CREATE TABLE addresses (
+			id character varying(40) NOT NULL,
+			user_id bigint NOT NULL,
+			name character varying(50) NOT NULL,
+			line1 character varying(100) NOT NULL,
+			line2 character varying(100) NOT NULL,
+			city character varying(50) NOT NULL,
+			postal_code character varying(20) NOT NULL,
+			country character varying(2) NOT NULL,
+			created_at timestamp with time zone NOT NULL,
+			PRIMARY KEY(id),
+			CONSTRAINT fk_addresses_users FOREIGN KEY (user_id) REFERENCES users (id)
+		)
+		`,
+	}},
+	{"coupons", []string{
+		`
+		CREATE TABLE coupons (
+			code character varying(40) NOT NULL,
+			kind character varying(10) NOT NULL,
+			value bigint NOT NULL,
+			min_order bigint NOT NULL,
+			expires_at timestamp with time zone,
+			max_uses bigint NOT NULL,
+			max_uses_per_user bigint NOT NULL,
+			uses bigint NOT NULL,
+			active boolean NOT NULL,
+			PRIMARY KEY(code),
+			CONSTRAINT chk_coupons_kind CHECK (kind IN ('percent', 'fixed')),
+			CONSTRAINT chk_coupons_value CHECK (value > 0)
+		)
+		`,
+	}},
+	{"checkouts", []string{
+		`
+		CREATE TABLE checkouts (
+			id character varying(40) NOT NULL,
+			user_id bigint NOT NULL,
+			product_id bigint NOT NULL,
+			variant_sku character varying(40) NOT NULL,
+			variant_name character varying(50) NOT NULL,
+			product_quantity bigint NOT NULL,
+			coupon_code character varying(40),
+			tax_region character varying(20) NOT NULL,
+			currency character varying(3) NOT NULL,
+			subtotal bigint NOT NULL,
+			discount bigint NOT NULL,
+			tax bigint NOT NULL,
+			shipping bigint NOT NULL,
+			total bigint NOT NULL,
+			payment_status character varying(10) NOT NULL,
+			payment_id character varying(100) NOT NULL,
+			payment_error character varying(200) NOT NULL,
+			shipping_name character varying(50) NOT NULL,
+			shipping_line1 character varying(100) NOT NULL,
+			shipping_line2 character varying(100) NOT NULL,
+			shipping_city character varying(50) NOT NULL,
+			shipping_postal_code character varying(20) NOT NULL,
+			shipping_country character varying(2) NOT NULL,
+			created_at timestamp with time zone NOT NULL,
+			PRIMARY KEY(id),
+			CONSTRAINT fk_checkouts_users FOREIGN KEY (user_id) REFERENCES users (id),
+			CONSTRAINT fk_checkouts_products FOREIGN KEY (product_id) REFERENCES products (id),
+			CONSTRAINT fk_checkouts_coupons FOREIGN KEY (coupon_code) REFERENCES coupons (code),
+			CONSTRAINT chk_checkouts_product_quantity CHECK (product_quantity > 0),
+			CONSTRAINT chk_checkouts_amounts CHECK (discount >= 0 AND tax >= 0 AND shipping >= 0 AND total >= 0),
+			CONSTRAINT chk_checkouts_payment_status CHECK (payment_status IN ('pending', 'charging', 'paid', 'failed', 'refunding', 'refunded'))
+		)
+		`,
+		"CREATE INDEX checkouts_created_at ON checkouts (created_at)",
+	}},
+	{"webhooks", []string{
+		`
+		CREATE TABLE webhooks (
+			id character varying(40) NOT NULL,
+			url character varying(500) NOT NULL,
+			secret character varying(100) NOT NULL,
+			event_types character varying(200) NOT NULL,
+			active boolean NOT NULL,
+			created_at timestamp with time zone NOT NULL,
+			PRIMARY KEY(id)
+		)
+		`,
+	}},
+	// webhook_events is the outbox. The events not dispatched yet have no
+	// dispatched_at.
+	{"webhook_events", []string{
+		`
+		CREATE TABLE webhook_events (
+			id character varying(40) NOT NULL,
+			type character varying(50) NOT NULL,
+			payload text NOT NULL,
+			created_at timestamp with time zone NOT NULL,
+			dispatched_at timestamp with time zone,
+			PRIMARY KEY(id)
+		)
+		`,
+		"CREATE INDEX webhook_events_dispatched_at ON webhook_events (dispatched_at, created_at)",
+	}},
+	{"webhook_deliveries", []string{
+		`
+		CREATE TABLE webhook_deliveries (
+			id character varying(40) NOT NULL,
+			webhook_id character varying(40) NOT NULL,
+			event_id character varying(40) NOT NULL,
+			status character varying(10) NOT NULL,
+			attempts bigint NOT NULL,
+			next_attempt_at timestamp with time zone NOT NULL,
+			last_status_code bigint NOT NULL,
+			last_error character varying(200) NOT NULL,
+			updated_at timestamp with time zone NOT NULL,
+			PRIMARY KEY(id),
+			CONSTRAINT fk_webhook_deliveries_webhooks FOREIGN KEY (webhook_id) REFERENCES webhooks (id),
+			CONSTRAINT fk_webhook_deliveries_events FOREIGN KEY (event_id) REFERENCES webhook_events (id),
+			CONSTRAINT chk_webhook_deliveries_status CHECK (status IN ('pending', 'succeeded', 'failed'))
+		)
+		`,
+		"CREATE INDEX webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at)",
+	}},
+	// checkout_jobs is the queue of the asynchronous checkouts. Queued jobs
+	// are locked until they are accepted, so that the due jobs are those
+	// locked until now in both states.
+	{"checkout_jobs", []string{
+		`
+		CREATE TABLE checkout_jobs (
+			id character varying(40) NOT NULL,
+			user_id bigint NOT NULL,
+			request text NOT NULL,
+			status character varying(10) NOT NULL,
+			attempts bigint NOT NULL,
+			locked_until timestamp with time zone NOT NULL,
+			error character varying(500) NOT NULL,
+			created_at timestamp with time zone NOT NULL,
+			updated_at timestamp with time zone NOT NULL,
+			PRIMARY KEY(id),
+			CONSTRAINT fk_checkout_jobs_users FOREIGN KEY (user_id) REFERENCES users (id),
+			CONSTRAINT chk_checkout_jobs_status CHECK (status IN ('queued', 'processing', 'done', 'failed'))
+		)
+		`,
+		"CREATE INDEX checkout_jobs_due ON checkout_jobs (status, locked_until)",
+	}},
+	// review_count and rating_total of the product sum up its shown reviews.
+	{"reviews", []string{
+		`
+		CREATE TABLE reviews (
+			id character varying(40) NOT NULL,
+			product_id bigint NOT NULL,
+			user_id bigint NOT NULL,
+			rating bigint NOT NULL,
+			body character varying(2000) NOT NULL,
+			status character varying(10) NOT NULL,
+			created_at timestamp with time zone NOT NULL,
+			PRIMARY KEY(id),
+			CONSTRAINT fk_reviews_products FOREIGN KEY (product_id) REFERENCES products (id),
+			CONSTRAINT fk_reviews_users FOREIGN KEY (user_id) REFERENCES users (id),
+			CONSTRAINT uq_reviews_product_user UNIQUE (product_id, user_id),
+			CONSTRAINT chk_reviews_rating CHECK (rating BETWEEN 1 AND 5),
+			CONSTRAINT chk_reviews_status CHECK (status IN ('visible', 'flagged', 'hidden'))
+		)
+		`,
+		"CREATE INDEX reviews_product_created_at ON reviews (product_id, created_at)",
+		"CREATE INDEX reviews_status_created_at ON reviews (status, created_at)",
+	}},
+	{"wishlist_items", []string{
+		`
+		CREATE TABLE wishlist_items (
+			user_id bigint NOT NULL,
+			product_id bigint NOT NULL,
+			created_at timestamp with time zone NOT NULL,
+			PRIMARY KEY(user_id, product_id),
+			CONSTRAINT fk_wishlist_items_users FOREIGN KEY (user_id) REFERENCES users (id),
+			CONSTRAINT fk_wishlist_items_products FOREIGN KEY (product_id) REFERENCES products (id)
+		)
+		`,
+		"CREATE INDEX wishlist_items_product ON wishlist_items (product_id)",
+	}},
+	// price and image are those of the product when they are NULL and empty.
+	{"product_variants", []string{
+		`
+		CREATE TABLE product_variants (
+			sku character varying(40) NOT NULL,
+			product_id bigint NOT NULL,
+			name character varying(50) NOT NULL,
+			price bigint,
+			stock bigint NOT NULL,
+			image character varying(100) NOT NULL,
+			PRIMARY KEY(sku),
+			CONSTRAINT fk_product_variants_products FOREIGN KEY (product_id) REFERENCES products (id),
+			CONSTRAINT chk_product_variants_price CHECK (price > 0),
+			CONSTRAINT chk_product_variants_stock CHECK (stock >= 0)
+		)
+		`,
+		"CREATE INDEX product_variants_product ON product_variants (product_id, name)",
+	}},
+}
+
+// prodMigrations change the tables of the older versions of the schema, in
+// the order of their versions.
+var prodMigrations = []schemaMigration{
+	// The rows of the older releases get the defaults of the new columns. Their
+	// orders are paid, as they were taken before the payments.
+	{2, "products", "currency", []string{"ALTER TABLE products ADD COLUMN currency character varying(3) NOT NULL DEFAULT 'USD'"}},
+	{2, "products", "weight", []string{"ALTER TABLE products ADD COLUMN weight bigint NOT NULL DEFAULT 0"}},
+	{2, "products", "review_count", []string{"ALTER TABLE products ADD COLUMN review_count bigint NOT NULL DEFAULT 0"}},
+	{2, "products", "rating_total", []string{"ALTER TABLE products ADD COLUMN rating_total bigint NOT NULL DEFAULT 0"}},
+	{2, "users", "email", []string{"ALTER TABLE users ADD COLUMN email character varying(254) NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "variant_sku", []string{"ALTER TABLE checkouts ADD COLUMN variant_sku character varying(40) NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "variant_name", []string{"ALTER TABLE checkouts ADD COLUMN variant_name character varying(50) NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "coupon_code", []string{"ALTER TABLE checkouts ADD COLUMN coupon_code character varying(40)"}},
+	{2, "checkouts", "tax_region", []string{"ALTER TABLE checkouts ADD COLUMN tax_region character varying(20) NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "currency", []string{"ALTER TABLE checkouts ADD COLUMN currency character varying(3) NOT NULL DEFAULT 'USD'"}},
+	{2, "checkouts", "subtotal", []string{"ALTER TABLE checkouts ADD COLUMN subtotal bigint NOT NULL DEFAULT 0"}},
+	{2, "checkouts", "discount", []string{"ALTER TABLE checkouts ADD COLUMN discount bigint NOT NULL DEFAULT 0"}},
+	{2, "checkouts", "tax", []string{"ALTER TABLE checkouts ADD COLUMN tax bigint NOT NULL DEFAULT 0"}},
+	{2, "checkouts", "shipping", []string{"ALTER TABLE checkouts ADD COLUMN shipping bigint NOT NULL DEFAULT 0"}},
+	{2, "checkouts", "total", []string{"ALTER TABLE checkouts ADD COLUMN total bigint NOT NULL DEFAULT 0"}},
+	{2, "checkouts", "payment_status", []string{"ALTER TABLE checkouts ADD COLUMN payment_status character varying(10) NOT NULL DEFAULT 'paid' CONSTRAINT chk_checkouts_payment_status CHECK (payment_status IN ('pending', 'paid', 'failed', 'refunded'))"}},
+	{2, "checkouts", "payment_id", []string{"ALTER TABLE checkouts ADD COLUMN payment_id character varying(100) NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "payment_error", []string{"ALTER TABLE checkouts ADD COLUMN payment_error character varying(200) NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_name", []string{"ALTER TABLE checkouts ADD COLUMN shipping_name character varying(50) NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_line1", []string{"ALTER TABLE checkouts ADD COLUMN shipping_line1 character varying(100) NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_line2", []string{"ALTER TABLE checkouts ADD COLUMN shipping_line2 character varying(100) NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_city", []string{"ALTER TABLE checkouts ADD COLUMN shipping_city character varying(50) NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_postal_code", []string{"ALTER TABLE checkouts ADD COLUMN shipping_postal_code character varying(20) NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_country", []string{"ALTER TABLE checkouts ADD COLUMN shipping_country character varying(2) NOT NULL DEFAULT ''"}},
+	// The days of the older checkouts become their midnight in the time zone
+	// of the database.
+	{2, "checkouts", "", []string{
+		"ALTER TABLE checkouts ALTER COLUMN created_at TYPE timestamp with time zone USING created_at::timestamp with time zone",
+		"ALTER TABLE checkouts ALTER COLUMN created_at SET NOT NULL",
+		"CREATE INDEX checkouts_created_at ON checkouts (created_at)",
+	}},
+	{3, "checkouts", "", []string{
+		"ALTER TABLE checkouts DROP CONSTRAINT chk_checkouts_payment_status",
+		"ALTER TABLE checkouts ADD CONSTRAINT chk_checkouts_payment_status CHECK (payment_status IN ('pending', 'charging', 'paid', 'failed', 'refunding', 'refunded'))",
+	}},
+}
+
+// InitDatabase drops the tables, creates them again and loads initdata.json.
 func (dbh ProdDatabaseHandler) InitDatabase() error {
-	jsonFromFile, err := ioutil.ReadFile(InitDataJSONFileName)
+	db := dbh.DB
+
+	// Don't use "IF EXISTS" as it is not supported by Spanner PGAdapter.
+	// Drop the tables referencing the others first.
+	for _, table := range schemaTables {
+		if checkTableExists(db, table) {
+			if _, err := db.Exec("DROP TABLE " + table); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The tables are created at schemaVersion again.
+	if checkTableExists(db, "schema_version") {
+		if _, err := db.Exec("DROP TABLE schema_version"); err != nil {
+			return err
+		}
+	}
+
+	if err := dbh.MigrateDatabase(); err != nil {
+		return err
+	}
+
+	return dbh.SeedDatabase()
+}
+
+// MigrateDatabase checks that the existing tables have every column or a
+// migration adding it, applies the migrations newer than the version of the
+// schema to them and creates the tables which don't exist yet with their
+// indexes.
+func (dbh ProdDatabaseHandler) MigrateDatabase() error {
+	db := dbh.DB
+
+	version, err := dbh.readSchemaVersion()
 	if err != nil {
 		return err
 	}
+	if err := checkSchemaVersion(version); err != nil {
+		return err
+	}
 
-	var jsonData Blob
-	if err := json.Unmarshal(jsonFromFile, &jsonData); err != nil {
+	if err := checkSchemaColumns(version, prodMigrations, dbh.readColumns); err != nil {
 		return err
 	}
 
-	db := dbh.DB
+	// The version is recorded after each one, so that a failed migration is
+	// applied again by the next run.
+	for version < schemaVersion {
+		version++
+		for _, migration := range prodMigrations {
+			if migration.version != version {
+				continue
+			}
+			columns, err := dbh.readColumns(migration.table)
+			if err != nil {
+				return err
+			}
+			if columns == nil || (migration.column != "" && columns[migration.column]) {
+				continue
+			}
 
-	// Don't use "IF EXISTS" as it is not supported by Spanner PGAdapter.
-	queryCheckProductsTable := "SELECT * FROM products"
-	queryDropProductsTables := "DROP TABLE products"
-	_, err = db.Query(queryCheckProductsTable)
-	tableExists := (err == nil)
-	if tableExists {
-		if _, err := db.Exec(queryDropProductsTables); err != nil {
+			for _, statement := range migration.statements {
+				if _, err := db.Exec(statement); err != nil {
+					return fmt.Errorf("failed to migrate the schema to version %d: %w", version, err)
+				}
+			}
+		}
+
+		if _, err := db.Exec("UPDATE schema_version SET version = $1 WHERE id = 1", version); err != nil {
 			return err
 		}
 	}
 
-	queryCheckUsersTable := "SELECT * FROM users"
-	queryDropUsersTables := "DROP TABLE users"
-	_, err = db.Query(queryCheckUsersTable)
-	tableExists = (err == nil)
-	if tableExists {
-		if _, err := db.Exec(queryDropUsersTables); err != nil {
-			return err
+	// Don't use "IF NOT EXISTS" as it is not supported by Spanner PGAdapter.
+	for _, table := range prodSchema {
+		if checkTableExists(db, table.table) {
+			continue
+		}
+
+		for _, statement := range table.statements {
+			if _, err := db.Exec(statement); err != nil {
+				return err
+			}
 		}
 	}
 
-	queryCheckCheckoutsTable := "SELECT * FROM checkouts"
-	queryDropCheckoutsTables := "DROP TABLE checkouts"
-	_, err = db.Query(queryCheckCheckoutsTable)
-	tableExists = (err == nil)
-	if tableExists {
-		if _, err := db.Exec(queryDropCheckoutsTables); err != nil {
-			return err
+	return nil
+}
+
+// readColumns returns the columns of the table, or nil when it doesn't
+// exist.
+func (dbh ProdDatabaseHandler) readColumns(table string) (map[string]bool, error) {
+	if !checkTableExists(dbh.DB, table) {
+		return nil, nil
+	}
+
+	return querySchemaColumns(dbh.DB, table)
+}
+
+// readSchemaVersion returns the version of the schema, creating
+// schema_version when it doesn't exist. The database is at version 1 then
+// if it has tables, and at schemaVersion if it is new.
+func (dbh ProdDatabaseHandler) readSchemaVersion() (int, error) {
+	db := dbh.DB
+
+	var version int
+	if checkTableExists(db, "schema_version") {
+		err := db.QueryRow("SELECT version FROM schema_version WHERE id = 1").Scan(&version)
+		return version, err
+	}
+
+	version = schemaVersion
+	for _, table := range schemaTables {
+		if checkTableExists(db, table) {
+			version = 1
+			break
 		}
 	}
 
-	// Don't use "IF EXISTS" as it is not supported by Spanner PGAdapter.
-	queryCreateProductsTable := `
-	CREATE TABLE products (
-		id bigint NOT NULL,
-		name character varying(20) NOT NULL,
-		price bigint NOT NULL,
-		image character varying(100) NOT NULL,
-		PRIMARY KEY(id)
-	)
-	`
+	if _, err := db.Exec("CREATE TABLE schema_version (id bigint NOT NULL, version bigint NOT NULL, PRIMARY KEY(id))"); err != nil {
+		return version, err
+	}
+	if _, err := db.Exec("INSERT INTO schema_version VALUES (1, $1)", version); err != nil {
+		return version, err
+	}
 
-	queryCreateUsersTable := `
-	CREATE TABLE users (
-		id bigint NOT NULL,
-		name character varying(20) NOT NULL,
-		PRIMARY KEY(id)
-	)
-	`
+	return version, nil
+}
 
-	queryCreateCheckoutsTable := `
-	CREATE TABLE checkouts (
-		id character varying(40) NOT NULL,
-		user_id bigint,
-		product_id bigint,
-		product_quantity bigint,
-		created_at date,
-		PRIMARY KEY(id)
-	)
-	`
+// SeedDatabase inserts the products and users of initdata.json which are not
+// in the database yet in a transaction.
+func (dbh ProdDatabaseHandler) SeedDatabase() error {
+	data, err := readInitData()
+	if err != nil {
+		return err
+	}
 
-	if _, err := db.Exec(queryCreateProductsTable); err != nil {
+	tx, err := dbh.DB.Begin()
+	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	if _, err := db.Exec(queryCreateUsersTable); err != nil {
+	if err := seedInitData(tx, data); err != nil {
 		return err
 	}
 
-	if _, err := db.Exec(queryCreateCheckoutsTable); err != nil {
+	return tx.Commit()
+}
+
+// seedInitData inserts the products and users of data whose IDs are not
+// taken yet.
+func seedInitData(tx *sql.Tx, data Blob) error {
+	productIDs, err := queryIDs(tx, "SELECT id FROM products")
+	if err != nil {
 		return err
 	}
 
-	queryInsertProduct := "INSERT INTO products VALUES($1, $2, $3, $4)"
-	for _, product := range jsonData.Products {
-		if _, err := db.Exec(queryInsertProduct, product.ID, product.Name, product.Price, product.Image); err != nil {
+	queryInsertProduct := "INSERT INTO products VALUES($1, $2, $3, $4, $5, $6, 0, 0)"
+	for _, product := range data.Products {
+		if productIDs[product.ID] {
+			continue
+		}
+
+		if _, err := tx.Exec(queryInsertProduct, product.ID, product.Name, product.Price, data.Currency, product.Weight, product.Image); err != nil {
 			return err
 		}
 	}
 
-	queryInsertUser := "INSERT INTO users VALUES($1, $2)"
-	for _, user := range jsonData.Users {
-		if _, err := db.Exec(queryInsertUser, user.ID, user.Name); err != nil {
+	userIDs, err := queryIDs(tx, "SELECT id FROM users")
+	if err != nil {
+		return err
+	}
+
+	queryInsertUser := "INSERT INTO users VALUES($1, $2, $3)"
+	for _, user := range data.Users {
+		if userIDs[user.ID] {
+			continue
+		}
+
+		if _, err := tx.Exec(queryInsertUser, user.ID, user.Name, user.Email); err != nil {
 			return err
 		}
 	}
@@ -120,13 +490,45 @@ func (dbh ProdDatabaseHandler) InitDatabase() error {
 	return nil
 }
 
+func queryIDs(tx *sql.Tx, query string) (map[int]bool, error) {
+	ids := make(map[int]bool)
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids[id] = true
+	}
+
+	return ids, rows.Err()
+}
+
+// checkTableExists selects a row of the table and closes the rows so that
+// the connection goes back to the pool.
+func checkTableExists(db *sql.DB, table string) bool {
+	rows, err := db.Query("SELECT * FROM " + table + " LIMIT 1")
+	if err != nil {
+		return false
+	}
+	rows.Close()
+
+	return true
+}
+
 func (dbh ProdDatabaseHandler) GetProduct(id int) (Product, error) {
 	var product Product
 
 	db := dbh.DB
-	query := "SELECT id, name, price, image FROM products WHERE id = $1"
-	if err := db.QueryRow(query, id).Scan(&product.ID, &product.Name, &product.Price, &product.Image); err != nil {
-		return product, err
+	query := "SELECT id, name, price, currency, weight, image, review_count, rating_total FROM products WHERE id = $1"
+	if err := scanProduct(db.QueryRow(query, id), &product); err != nil {
+		return product, translateError(err, "product", id)
 	}
 
 	return product, nil
@@ -136,22 +538,23 @@ func (dbh ProdDatabaseHandler) GetProducts() ([]Product, error) {
 	var products []Product
 
 	db := dbh.DB
-	query := "SELECT id, name, price, image FROM products"
+	query := "SELECT id, name, price, currency, weight, image, review_count, rating_total FROM products"
 	rows, err := db.Query(query)
 	if err != nil {
 		return products, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var product Product
-		if err := rows.Scan(&product.ID, &product.Name, &product.Price, &product.Image); err != nil {
+		if err := scanProduct(rows, &product); err != nil {
 			return products, err
 		}
 
 		products = append(products, product)
 	}
 
-	return products, nil
+	return products, rows.Err()
 }
 
 func (dbh ProdDatabaseHandler) GetCheckouts(userID int) ([]Checkout, error) {
@@ -163,11 +566,33 @@ func (dbh ProdDatabaseHandler) GetCheckouts(userID int) ([]Checkout, error) {
 	  checkouts.id               AS checkout_id,
 	  users.id                   AS user_id,
 	  users.name                 AS user_name,
+	  users.email                AS user_email,
 	  products.id                AS product_id,
 	  products.name              AS product_name,
 	  products.price             AS product_price,
+	  products.currency          AS product_currency,
+	  products.weight            AS product_weight,
 	  products.image             AS product_image,
+	  checkouts.variant_sku      AS checkout_variant_sku,
+	  checkouts.variant_name     AS checkout_variant_name,
 	  checkouts.product_quantity AS checkout_product_quantity,
+	  COALESCE(checkouts.coupon_code, '') AS checkout_coupon_code,
+	  checkouts.tax_region       AS checkout_tax_region,
+	  checkouts.currency         AS checkout_currency,
+	  checkouts.subtotal         AS checkout_subtotal,
+	  checkouts.discount         AS checkout_discount,
+	  checkouts.tax              AS checkout_tax,
+	  checkouts.shipping         AS checkout_shipping,
+	  checkouts.total            AS checkout_total,
+	  checkouts.payment_status   AS checkout_payment_status,
+	  checkouts.payment_id       AS checkout_payment_id,
+	  checkouts.payment_error    AS checkout_payment_error,
+	  checkouts.shipping_name    AS checkout_shipping_name,
+	  checkouts.shipping_line1   AS checkout_shipping_line1,
+	  checkouts.shipping_line2   AS checkout_shipping_line2,
+	  checkouts.shipping_city    AS checkout_shipping_city,
+	  checkouts.shipping_postal_code AS checkout_shipping_postal_code,
+	  checkouts.shipping_country AS checkout_shipping_country,
 	  checkouts.created_at       AS checkout_created_at
 	FROM checkouts
 	LEFT JOIN users ON checkouts.user_id = users.id
@@ -179,32 +604,65 @@ func (dbh ProdDatabaseHandler) GetCheckouts(userID int) ([]Checkout, error) {
 	if err != nil {
 		return checkouts, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var checkout Checkout
-		if err := rows.Scan(&checkout.ID, &checkout.User.ID, &checkout.User.Name, &checkout.Product.ID, &checkout.Product.Name, &checkout.Product.Price, &checkout.Product.Image, &checkout.ProductQuantity, &checkout.CreatedAt); err != nil {
+		if err := scanCheckout(rows, &checkout); err != nil {
 			return checkouts, err
 		}
 
 		checkouts = append(checkouts, checkout)
 	}
 
-	return checkouts, nil
+	return checkouts, rows.Err()
 }
 
-func (dbh ProdDatabaseHandler) CreateCheckout(userID int, productID int, productQuantity int) (string, error) {
-	uuidObj, err := uuid.NewRandom()
-	checkoutID := uuidObj.String()
+func (dbh ProdDatabaseHandler) CreateCheckout(order CheckoutRequest) (string, error) {
+	checkoutID, err := order.checkoutID()
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	db := dbh.DB
-	query := "INSERT INTO checkouts (id, user_id, product_id, product_quantity, created_at) VALUES ($1, $2, $3, $4, $5)"
-	if _, err := db.Exec(query, checkoutID, userID, productID, productQuantity, time.Now()); err != nil {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	amounts, variant, err := priceCheckout(tx, order, now)
+	if err != nil {
+		return "", translateError(err, "checkout", checkoutID)
+	}
+
+	couponCode := sql.NullString{String: order.CouponCode, Valid: order.CouponCode != ""}
+	address := order.ShippingAddress
+	query := `
+	INSERT INTO checkouts (id, user_id, product_id, variant_sku, variant_name, product_quantity, coupon_code, tax_region, currency, subtotal, discount, tax, shipping, total, payment_status, payment_id, payment_error,
+	  shipping_name, shipping_line1, shipping_line2, shipping_city, shipping_postal_code, shipping_country, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, '', '', $16, $17, $18, $19, $20, $21, $22)
+	`
+	if _, err := tx.Exec(query, checkoutID, order.UserID, order.ProductID, variant.SKU, variant.Name, order.ProductQuantity, couponCode, order.TaxRegion,
+		amounts.Subtotal.Currency, amounts.Subtotal.Amount, amounts.Discount.Amount, amounts.Tax.Amount, amounts.Shipping.Amount, amounts.Total.Amount, PaymentPending,
+		address.Name, address.Line1, address.Line2, address.City, address.PostalCode, address.Country, now); err != nil {
+		return "", translateError(err, "checkout", checkoutID)
+	}
+
+	event, err := newCheckoutCreatedEvent(checkoutID, order, amounts, now)
+	if err != nil {
+		return "", err
+	}
+	if err := insertWebhookEvent(tx, event); err != nil {
 		return "", err
 	}
 
+	update := newCheckoutUpdate(checkoutID, order.UserID, Payment{Status: PaymentPending})
+	if err := dbh.commitCheckoutUpdate(tx, update); err != nil {
+		return "", translateError(err, "checkout", checkoutID)
+	}
+
 	return checkoutID, nil
 }
 
@@ -221,30 +679,1626 @@ func (dbh ProdDatabaseHandler) GetCheckout(checkoutID string) (Checkout, error)
 	  checkouts.id,
 	  users.id,
 	  users.name,
+	  users.email,
 	  products.id,
 	  products.name,
 	  products.price,
+	  products.currency,
+	  products.weight,
 	  products.image,
+	  checkouts.variant_sku,
+	  checkouts.variant_name,
 	  checkouts.product_quantity,
+	  COALESCE(checkouts.coupon_code, ''),
+	  checkouts.tax_region,
+	  checkouts.currency,
+	  checkouts.subtotal,
+	  checkouts.discount,
+	  checkouts.tax,
+	  checkouts.shipping,
+	  checkouts.total,
+	  checkouts.payment_status,
+	  checkouts.payment_id,
+	  checkouts.payment_error,
+	  checkouts.shipping_name,
+	  checkouts.shipping_line1,
+	  checkouts.shipping_line2,
+	  checkouts.shipping_city,
+	  checkouts.shipping_postal_code,
+	  checkouts.shipping_country,
 	  checkouts.created_at
 	FROM checkouts
 	LEFT JOIN users ON checkouts.user_id = users.id
 	LEFT JOIN products ON checkouts.product_id = products.id
 	WHERE checkouts.id = $1
 	`
-	if err := db.QueryRow(query, checkoutID).Scan(
+	if err := scanCheckout(db.QueryRow(query, checkoutID), &checkout); err != nil {
+		return checkout, translateError(err, "checkout", checkoutID)
+	}
+
+	return checkout, nil
+}
+
+// UpdateCheckoutPayment records the result of charging the order. A failed
+// order gives back the stock of its variant and the use of its coupon in the
+// same transaction.
+//
+// The UPDATE is guarded by the status read before, so that two concurrent
+// results of the same order can't both give the reservation back or both
+// claim it. The later one fails with ErrConflict, like a transition which
+// checkTransition doesn't allow.
+func (dbh ProdDatabaseHandler) UpdateCheckoutPayment(checkoutID string, payment Payment) error {
+	if err := payment.validate(); err != nil {
+		return err
+	}
+
+	db := dbh.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	var userID int
+	var reservation checkoutReservation
+	query := "SELECT payment_status, user_id, product_id, variant_sku, product_quantity, COALESCE(coupon_code, '') FROM checkouts WHERE id = $1"
+	if err := tx.QueryRow(query, checkoutID).Scan(&previous, &userID, &reservation.ProductID, &reservation.VariantSKU, &reservation.Quantity, &reservation.CouponCode); err != nil {
+		return translateError(err, "checkout", checkoutID)
+	}
+	if err := payment.checkTransition(checkoutID, previous); err != nil {
+		return err
+	}
+
+	query = "UPDATE checkouts SET payment_status = $1, payment_id = $2, payment_error = $3 WHERE id = $4 AND payment_status = $5"
+	result, err := tx.Exec(query, payment.Status, payment.ID, payment.errorMessage(), checkoutID, previous)
+	if err != nil {
+		return translateError(err, "checkout", checkoutID)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return NewConflictError(fmt.Sprintf("the payment of checkout %s has changed meanwhile", checkoutID))
+	}
+
+	switch {
+	case holdsReservation(previous) && !holdsReservation(payment.Status):
+		err = releaseReservation(tx, reservation)
+	case !holdsReservation(previous) && holdsReservation(payment.Status):
+		err = retakeReservation(tx, reservation)
+	}
+	if err != nil {
+		return err
+	}
+
+	event, err := newCheckoutStatusChangedEvent(checkoutID, payment, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := insertWebhookEvent(tx, event); err != nil {
+		return err
+	}
+
+	return dbh.commitCheckoutUpdate(tx, newCheckoutUpdate(checkoutID, userID, payment))
+}
+
+// commitCheckoutUpdate commits tx with the update of the order and sends it
+// to the subscribers. The notification is sent after the commit, as a
+// pg_notify in the transaction would make the commits of every order wait
+// for each other on the lock of the notification queue. The update is
+// committed then, so a failed notification is only published to the
+// subscribers of this process.
+func (dbh ProdDatabaseHandler) commitCheckoutUpdate(tx *sql.Tx, update CheckoutUpdate) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if !dbh.notify {
+		publishCheckoutUpdate(update)
+		return nil
+	}
+	if err := notifyCheckoutUpdate(dbh.DB, update); err != nil {
+		log.Printf("Failed to notify the update of checkout %s: %v", update.CheckoutID, err)
+		publishCheckoutUpdate(update)
+	}
+
+	return nil
+}
+
+// scanProduct reads a row of the product queries above.
+func scanProduct(row rowScanner, product *Product) error {
+	return row.Scan(&product.ID, &product.Name, &product.Price.Amount, &product.Price.Currency, &product.Weight, &product.Image, &product.Rating.Count, &product.Rating.Total)
+}
+
+// scanCheckout reads a row of the checkout queries above.
+func scanCheckout(row rowScanner, checkout *Checkout) error {
+	var currency string
+	if err := row.Scan(
 		&checkout.ID,
 		&checkout.User.ID,
 		&checkout.User.Name,
+		&checkout.User.Email,
 		&checkout.Product.ID,
 		&checkout.Product.Name,
-		&checkout.Product.Price,
+		&checkout.Product.Price.Amount,
+		&checkout.Product.Price.Currency,
+		&checkout.Product.Weight,
 		&checkout.Product.Image,
+		&checkout.Variant.SKU,
+		&checkout.Variant.Name,
 		&checkout.ProductQuantity,
+		&checkout.CouponCode,
+		&checkout.TaxRegion,
+		&currency,
+		&checkout.Subtotal.Amount,
+		&checkout.Discount.Amount,
+		&checkout.Tax.Amount,
+		&checkout.Shipping.Amount,
+		&checkout.Total.Amount,
+		&checkout.Payment.Status,
+		&checkout.Payment.ID,
+		&checkout.Payment.Error,
+		&checkout.ShippingAddress.Name,
+		&checkout.ShippingAddress.Line1,
+		&checkout.ShippingAddress.Line2,
+		&checkout.ShippingAddress.City,
+		&checkout.ShippingAddress.PostalCode,
+		&checkout.ShippingAddress.Country,
 		&checkout.CreatedAt,
 	); err != nil {
-		return checkout, err
+		return err
 	}
 
-	return checkout, nil
+	checkout.Subtotal.Currency = currency
+	checkout.Discount.Currency = currency
+	checkout.Tax.Currency = currency
+	checkout.Shipping.Currency = currency
+	checkout.Total.Currency = currency
+
+	return nil
+}
+
+const queryGetCoupon = `
+	SELECT code, kind, value, min_order, expires_at, max_uses, max_uses_per_user, uses, active
+	FROM coupons
+	WHERE code = $1
+	`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCoupon(row rowScanner) (Coupon, error) {
+	var coupon Coupon
+	var expiresAt sql.NullTime
+	if err := row.Scan(&coupon.Code, &coupon.Kind, &coupon.Value, &coupon.MinOrder, &expiresAt, &coupon.MaxUses, &coupon.MaxUsesPerUser, &coupon.Uses, &coupon.Active); err != nil {
+		return coupon, err
+	}
+	coupon.ExpiresAt = expiresAt.Time
+
+	return coupon, nil
+}
+
+func (dbh ProdDatabaseHandler) CreateCoupon(coupon Coupon) error {
+	if err := coupon.Validate(); err != nil {
+		return err
+	}
+
+	expiresAt := sql.NullTime{Time: coupon.ExpiresAt, Valid: !coupon.ExpiresAt.IsZero()}
+
+	db := dbh.DB
+	query := "INSERT INTO coupons (code, kind, value, min_order, expires_at, max_uses, max_uses_per_user, uses, active) VALUES ($1, $2, $3, $4, $5, $6, $7, 0, $8)"
+	if _, err := db.Exec(query, coupon.Code, coupon.Kind, coupon.Value, coupon.MinOrder, expiresAt, coupon.MaxUses, coupon.MaxUsesPerUser, true); err != nil {
+		return translateError(err, "coupon", coupon.Code)
+	}
+
+	return nil
+}
+
+func (dbh ProdDatabaseHandler) GetCoupons() ([]Coupon, error) {
+	var coupons []Coupon
+
+	db := dbh.DB
+	query := "SELECT code, kind, value, min_order, expires_at, max_uses, max_uses_per_user, uses, active FROM coupons ORDER BY code"
+	rows, err := db.Query(query)
+	if err != nil {
+		return coupons, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		coupon, err := scanCoupon(rows)
+		if err != nil {
+			return coupons, err
+		}
+
+		coupons = append(coupons, coupon)
+	}
+
+	return coupons, rows.Err()
+}
+
+// DeactivateCoupon stops the coupon from being used. It is kept so that the
+// orders which used it still refer to it.
+func (dbh ProdDatabaseHandler) DeactivateCoupon(code string) error {
+	db := dbh.DB
+	result, err := db.Exec("UPDATE coupons SET active = $1 WHERE code = $2", false, code)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return NewNotFoundError("coupon", code)
+	}
+
+	return nil
+}
+
+func (dbh ProdDatabaseHandler) CreateAddress(address Address) (string, error) {
+	if err := address.Validate(); err != nil {
+		return "", err
+	}
+
+	uuidObj, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	addressID := uuidObj.String()
+
+	db := dbh.DB
+	query := "INSERT INTO addresses (id, user_id, name, line1, line2, city, postal_code, country, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+	if _, err := db.Exec(query, addressID, address.UserID, address.Name, address.Line1, address.Line2, address.City, address.PostalCode, address.Country, time.Now()); err != nil {
+		return "", translateError(err, "address", addressID)
+	}
+
+	return addressID, nil
+}
+
+func (dbh ProdDatabaseHandler) GetAddresses(userID int) ([]Address, error) {
+	var addresses []Address
+
+	db := dbh.DB
+	query := "SELECT id, user_id, name, line1, line2, city, postal_code, country FROM addresses WHERE user_id = $1 ORDER BY created_at, id"
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return addresses, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var address Address
+		if err := rows.Scan(&address.ID, &address.UserID, &address.Name, &address.Line1, &address.Line2, &address.City, &address.PostalCode, &address.Country); err != nil {
+			return addresses, err
+		}
+
+		addresses = append(addresses, address)
+	}
+
+	return addresses, rows.Err()
+}
+
+// DeleteAddress deletes an address of the user. The orders shipped to it
+// keep their copy.
+func (dbh ProdDatabaseHandler) DeleteAddress(userID int, addressID string) error {
+	db := dbh.DB
+	result, err := db.Exec("DELETE FROM addresses WHERE user_id = $1 AND id = $2", userID, addressID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return NewNotFoundError("address", addressID)
+	}
+
+	return nil
+}
+
+func (dbh ProdDatabaseHandler) CreateWebhook(webhook Webhook) (string, error) {
+	if err := webhook.Validate(); err != nil {
+		return "", err
+	}
+
+	uuidObj, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	webhookID := uuidObj.String()
+
+	db := dbh.DB
+	query := "INSERT INTO webhooks (id, url, secret, event_types, active, created_at) VALUES ($1, $2, $3, $4, $5, $6)"
+	if _, err := db.Exec(query, webhookID, webhook.URL, webhook.Secret, joinEventTypes(webhook.EventTypes), true, time.Now().UTC()); err != nil {
+		return "", translateError(err, "webhook", webhookID)
+	}
+
+	return webhookID, nil
+}
+
+func (dbh ProdDatabaseHandler) GetWebhooks() ([]Webhook, error) {
+	var webhooks []Webhook
+
+	db := dbh.DB
+	rows, err := db.Query("SELECT id, url, secret, event_types, active, created_at FROM webhooks ORDER BY created_at, id")
+	if err != nil {
+		return webhooks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var webhook Webhook
+		var eventTypes string
+		if err := rows.Scan(&webhook.ID, &webhook.URL, &webhook.Secret, &eventTypes, &webhook.Active, &webhook.CreatedAt); err != nil {
+			return webhooks, err
+		}
+		webhook.EventTypes = splitEventTypes(eventTypes)
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// DeactivateWebhook stops new events from being delivered to the webhook.
+// It is kept for its delivery log.
+func (dbh ProdDatabaseHandler) DeactivateWebhook(webhookID string) error {
+	db := dbh.DB
+	result, err := db.Exec("UPDATE webhooks SET active = $1 WHERE id = $2", false, webhookID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return NewNotFoundError("webhook", webhookID)
+	}
+
+	return nil
+}
+
+const queryGetWebhookDeliveries = `
+	SELECT
+	  webhook_deliveries.id,
+	  webhook_deliveries.status,
+	  webhook_deliveries.attempts,
+	  webhook_deliveries.next_attempt_at,
+	  webhook_deliveries.last_status_code,
+	  webhook_deliveries.last_error,
+	  webhook_deliveries.updated_at,
+	  webhooks.id,
+	  webhooks.url,
+	  webhooks.secret,
+	  webhook_events.id,
+	  webhook_events.type,
+	  webhook_events.payload,
+	  webhook_events.created_at
+	FROM webhook_deliveries
+	JOIN webhooks ON webhook_deliveries.webhook_id = webhooks.id
+	JOIN webhook_events ON webhook_deliveries.event_id = webhook_events.id
+	`
+
+// scanWebhookDelivery reads a row of queryGetWebhookDeliveries.
+func scanWebhookDelivery(row rowScanner, delivery *WebhookDelivery) error {
+	var payload string
+	if err := row.Scan(&delivery.ID, &delivery.Status, &delivery.Attempts, &delivery.NextAttemptAt, &delivery.LastStatusCode, &delivery.LastError, &delivery.UpdatedAt,
+		&delivery.Webhook.ID, &delivery.Webhook.URL, &delivery.Webhook.Secret,
+		&delivery.Event.ID, &delivery.Event.Type, &payload, &delivery.Event.CreatedAt); err != nil {
+		return err
+	}
+	delivery.Event.Payload = []byte(payload)
+
+	return nil
+}
+
+// GetWebhookDeliveries returns the latest deliveries to the webhook first.
+func (dbh ProdDatabaseHandler) GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+
+	db := dbh.DB
+	query := queryGetWebhookDeliveries + "WHERE webhook_deliveries.webhook_id = $1 ORDER BY webhook_deliveries.updated_at DESC, webhook_deliveries.id LIMIT $2"
+	rows, err := db.Query(query, webhookID, limit)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+		if err := scanWebhookDelivery(rows, &delivery); err != nil {
+			return deliveries, err
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// DispatchWebhookEvents marks the events dispatched in the transaction which
+// creates their deliveries. The guarded UPDATE makes concurrent dispatchers
+// skip the events another one has taken.
+func (dbh ProdDatabaseHandler) DispatchWebhookEvents(limit int) (int, error) {
+	db := dbh.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	webhooks, err := queryActiveWebhooks(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	var events []WebhookEvent
+	rows, err := tx.Query("SELECT id, type FROM webhook_events WHERE dispatched_at IS NULL ORDER BY created_at, id LIMIT $1", limit)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var event WebhookEvent
+		if err := rows.Scan(&event.ID, &event.Type); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	dispatched := 0
+	for _, event := range events {
+		result, err := tx.Exec("UPDATE webhook_events SET dispatched_at = $1 WHERE id = $2 AND dispatched_at IS NULL", now, event.ID)
+		if err != nil {
+			return 0, err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if updated == 0 {
+			continue
+		}
+
+		for _, webhook := range webhooks {
+			if !webhook.Subscribes(event.Type) {
+				continue
+			}
+
+			uuidObj, err := uuid.NewRandom()
+			if err != nil {
+				return 0, err
+			}
+			query := `
+			INSERT INTO webhook_deliveries (id, webhook_id, event_id, status, attempts, next_attempt_at, last_status_code, last_error, updated_at)
+			VALUES ($1, $2, $3, $4, 0, $5, 0, '', $5)
+			`
+			if _, err := tx.Exec(query, uuidObj.String(), webhook.ID, event.ID, DeliveryPending, now); err != nil {
+				return 0, err
+			}
+		}
+		dispatched++
+	}
+
+	return dispatched, tx.Commit()
+}
+
+// queryActiveWebhooks returns the IDs and the event types of the webhooks
+// which get new events.
+func queryActiveWebhooks(tx *sql.Tx) ([]Webhook, error) {
+	var webhooks []Webhook
+
+	rows, err := tx.Query("SELECT id, event_types FROM webhooks WHERE active = $1", true)
+	if err != nil {
+		return webhooks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var webhook Webhook
+		var eventTypes string
+		if err := rows.Scan(&webhook.ID, &eventTypes); err != nil {
+			return webhooks, err
+		}
+		webhook.EventTypes = splitEventTypes(eventTypes)
+
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// ClaimWebhookDeliveries postpones each due delivery with a guarded UPDATE,
+// so a delivery claimed by another instance in the meantime is skipped.
+// The deliveries of deactivated webhooks are kept pending but not sent.
+func (dbh ProdDatabaseHandler) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	db := dbh.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now = now.UTC()
+	var due []WebhookDelivery
+	query := queryGetWebhookDeliveries + `
+	WHERE webhook_deliveries.status = $1 AND webhook_deliveries.next_attempt_at <= $2 AND webhooks.active = $3
+	ORDER BY webhook_deliveries.next_attempt_at, webhook_deliveries.id
+	LIMIT $4`
+	rows, err := tx.Query(query, DeliveryPending, now, true, limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var delivery WebhookDelivery
+		if err := scanWebhookDelivery(rows, &delivery); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, delivery)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []WebhookDelivery
+	until := now.Add(lease)
+	for _, delivery := range due {
+		query := "UPDATE webhook_deliveries SET next_attempt_at = $1 WHERE id = $2 AND status = $3 AND next_attempt_at <= $4"
+		result, err := tx.Exec(query, until, delivery.ID, DeliveryPending, now)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if updated == 1 {
+			delivery.NextAttemptAt = until
+			claimed = append(claimed, delivery)
+		}
+	}
+
+	return claimed, tx.Commit()
+}
+
+func (dbh ProdDatabaseHandler) RecordWebhookAttempt(deliveryID string, attempt WebhookAttempt) error {
+	now := time.Now().UTC()
+	nextAttemptAt := attempt.NextAttemptAt.UTC()
+	if attempt.NextAttemptAt.IsZero() {
+		nextAttemptAt = now
+	}
+
+	db := dbh.DB
+	query := `
+	UPDATE webhook_deliveries
+	SET status = $1, attempts = attempts + 1, next_attempt_at = $2, last_status_code = $3, last_error = $4, updated_at = $5
+	WHERE id = $6
+	`
+	result, err := db.Exec(query, attempt.status(), nextAttemptAt, attempt.StatusCode, attempt.errorMessage(), now, deliveryID)
+	if err != nil {
+		return translateError(err, "webhook delivery", deliveryID)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return NewNotFoundError("webhook delivery", deliveryID)
+	}
+
+	return nil
+}
+
+// EnqueueCheckout counts the waiting jobs in the transaction of the insert.
+// Concurrent requests may still push the queue slightly over maxDepth.
+func (dbh ProdDatabaseHandler) EnqueueCheckout(order CheckoutRequest, maxDepth int) (string, error) {
+	job, request, err := newCheckoutJob(order, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	db := dbh.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if maxDepth > 0 {
+		var depth int
+		query := "SELECT COUNT(*) FROM checkout_jobs WHERE status IN ($1, $2)"
+		if err := tx.QueryRow(query, CheckoutJobQueued, CheckoutJobProcessing).Scan(&depth); err != nil {
+			return "", err
+		}
+		if depth >= maxDepth {
+			return "", NewQueueFullError(fmt.Sprintf("checkout queue has %d jobs waiting", depth))
+		}
+	}
+
+	query := `
+	INSERT INTO checkout_jobs (id, user_id, request, status, attempts, locked_until, error, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, 0, $5, '', $6, $7)
+	`
+	if _, err := tx.Exec(query, job.ID, order.UserID, request, job.Status, job.CreatedAt, job.CreatedAt, job.UpdatedAt); err != nil {
+		return "", translateError(err, "checkout job", job.ID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return job.ID, nil
+}
+
+const queryGetCheckoutJobs = "SELECT id, request, status, attempts, error, created_at, updated_at FROM checkout_jobs "
+
+func (dbh ProdDatabaseHandler) GetCheckoutJob(jobID string) (CheckoutJob, error) {
+	var job CheckoutJob
+
+	db := dbh.DB
+	if err := scanCheckoutJob(db.QueryRow(queryGetCheckoutJobs+"WHERE id = $1", jobID), &job); err != nil {
+		return job, translateError(err, "checkout job", jobID)
+	}
+
+	return job, nil
+}
+
+// ClaimCheckoutJobs locks each due job with a guarded UPDATE, so a job
+// claimed by another worker in the meantime is skipped.
+func (dbh ProdDatabaseHandler) ClaimCheckoutJobs(now time.Time, lease time.Duration, limit int) ([]CheckoutJob, error) {
+	db := dbh.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now = now.UTC()
+	var due []CheckoutJob
+	query := queryGetCheckoutJobs + "WHERE status IN ($1, $2) AND locked_until <= $3 ORDER BY created_at, id LIMIT $4"
+	rows, err := tx.Query(query, CheckoutJobQueued, CheckoutJobProcessing, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var job CheckoutJob
+		if err := scanCheckoutJob(rows, &job); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []CheckoutJob
+	for _, job := range due {
+		query := `
+		UPDATE checkout_jobs SET status = $1, attempts = attempts + 1, locked_until = $2, updated_at = $3
+		WHERE id = $4 AND status IN ($5, $6) AND locked_until <= $7
+		`
+		result, err := tx.Exec(query, CheckoutJobProcessing, now.Add(lease), now, job.ID, CheckoutJobQueued, CheckoutJobProcessing, now)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if updated == 1 {
+			job.Status, job.Attempts, job.UpdatedAt = CheckoutJobProcessing, job.Attempts+1, now
+			claimed = append(claimed, job)
+		}
+	}
+
+	return claimed, tx.Commit()
+}
+
+func (dbh ProdDatabaseHandler) FinishCheckoutJob(jobID string, status string, message string) error {
+	message, err := validateCheckoutJobResult(status, message)
+	if err != nil {
+		return err
+	}
+
+	db := dbh.DB
+	query := "UPDATE checkout_jobs SET status = $1, error = $2, updated_at = $3 WHERE id = $4"
+	result, err := db.Exec(query, status, message, time.Now().UTC(), jobID)
+	if err != nil {
+		return translateError(err, "checkout job", jobID)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return NewNotFoundError("checkout job", jobID)
+	}
+
+	return nil
+}
+
+func (dbh ProdDatabaseHandler) GetCheckoutQueueDepth() (int, error) {
+	var depth int
+
+	db := dbh.DB
+	query := "SELECT COUNT(*) FROM checkout_jobs WHERE status IN ($1, $2)"
+	if err := db.QueryRow(query, CheckoutJobQueued, CheckoutJobProcessing).Scan(&depth); err != nil {
+		return 0, err
+	}
+
+	return depth, nil
+}
+
+// scanCheckoutJob reads a row of queryGetCheckoutJobs.
+func scanCheckoutJob(row rowScanner, job *CheckoutJob) error {
+	var request string
+	if err := row.Scan(&job.ID, &request, &job.Status, &job.Attempts, &job.Error, &job.CreatedAt, &job.UpdatedAt); err != nil {
+		return err
+	}
+
+	return decodeCheckoutJobRequest(job, request)
+}
+
+// CreateReview checks the purchase and adds the rating to the product in the
+// transaction of the insert.
+func (dbh ProdDatabaseHandler) CreateReview(review Review) (string, error) {
+	if err := review.Validate(); err != nil {
+		return "", err
+	}
+
+	uuidObj, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	reviewID := uuidObj.String()
+
+	db := dbh.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var bought int
+	query := "SELECT COUNT(*) FROM checkouts WHERE user_id = $1 AND product_id = $2 AND payment_status = $3"
+	if err := tx.QueryRow(query, review.User.ID, review.ProductID, PaymentPaid).Scan(&bought); err != nil {
+		return "", err
+	}
+	if bought == 0 {
+		return "", errProductNotBought()
+	}
+
+	var reviewed int
+	query = "SELECT COUNT(*) FROM reviews WHERE product_id = $1 AND user_id = $2"
+	if err := tx.QueryRow(query, review.ProductID, review.User.ID).Scan(&reviewed); err != nil {
+		return "", err
+	}
+	if reviewed > 0 {
+		return "", errAlreadyReviewed()
+	}
+
+	query = "INSERT INTO reviews (id, product_id, user_id, rating, body, status, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	if _, err := tx.Exec(query, reviewID, review.ProductID, review.User.ID, review.Rating, strings.TrimSpace(review.Body), ReviewVisible, time.Now().UTC()); err != nil {
+		return "", translateError(err, "review", reviewID)
+	}
+
+	if err := updateProductRating(tx, review.ProductID, 1, review.Rating); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return reviewID, nil
+}
+
+const queryGetReviews = `
+SELECT reviews.id, reviews.product_id, users.id, users.name, reviews.rating, reviews.body, reviews.status, reviews.created_at
+FROM reviews
+JOIN users ON reviews.user_id = users.id
+`
+
+func (dbh ProdDatabaseHandler) GetProductReviews(productID int, limit int) ([]Review, error) {
+	query := queryGetReviews + "WHERE reviews.product_id = $1 AND reviews.status <> $2 ORDER BY reviews.created_at DESC, reviews.id LIMIT $3"
+	return dbh.queryReviews(query, productID, ReviewHidden, limit)
+}
+
+func (dbh ProdDatabaseHandler) GetReviews(status string, limit int) ([]Review, error) {
+	if status == "" {
+		return dbh.queryReviews(queryGetReviews+"ORDER BY reviews.created_at DESC, reviews.id LIMIT $1", limit)
+	}
+	if err := validateReviewStatus(status); err != nil {
+		return nil, err
+	}
+
+	return dbh.queryReviews(queryGetReviews+"WHERE reviews.status = $1 ORDER BY reviews.created_at DESC, reviews.id LIMIT $2", status, limit)
+}
+
+func (dbh ProdDatabaseHandler) queryReviews(query string, args ...interface{}) ([]Review, error) {
+	var reviews []Review
+
+	db := dbh.DB
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return reviews, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var review Review
+		if err := rows.Scan(&review.ID, &review.ProductID, &review.User.ID, &review.User.Name, &review.Rating, &review.Body, &review.Status, &review.CreatedAt); err != nil {
+			return reviews, err
+		}
+
+		reviews = append(reviews, review)
+	}
+
+	return reviews, rows.Err()
+}
+
+// UpdateReviewStatus changes the status with a guarded UPDATE, so that the
+// rating of the product is changed once when two admins moderate the review
+// at the same time.
+func (dbh ProdDatabaseHandler) UpdateReviewStatus(reviewID string, status string) error {
+	if err := validateReviewStatus(status); err != nil {
+		return err
+	}
+
+	db := dbh.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var productID, rating int
+	var current string
+	query := "SELECT product_id, rating, status FROM reviews WHERE id = $1"
+	if err := tx.QueryRow(query, reviewID).Scan(&productID, &rating, &current); err != nil {
+		return translateError(err, "review", reviewID)
+	}
+	if current == status {
+		return nil
+	}
+
+	result, err := tx.Exec("UPDATE reviews SET status = $1 WHERE id = $2 AND status = $3", status, reviewID, current)
+	if err != nil {
+		return translateError(err, "review", reviewID)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return NewConflictError(fmt.Sprintf("review %s was changed at the same time", reviewID))
+	}
+
+	if count, total := ratingChange(rating, current, status); count != 0 {
+		if err := updateProductRating(tx, productID, count, total); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// updateProductRating adds count reviews with total stars to the rating of
+// the product within tx.
+func updateProductRating(tx *sql.Tx, productID int, count int, total int) error {
+	query := "UPDATE products SET review_count = review_count + $1, rating_total = rating_total + $2 WHERE id = $3"
+	result, err := tx.Exec(query, count, total, productID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return NewNotFoundError("product", productID)
+	}
+
+	return nil
+}
+
+// AddWishlistItem checks the product first so that a missing product is
+// ErrNotFound rather than a foreign key violation.
+func (dbh ProdDatabaseHandler) AddWishlistItem(userID int, productID int) error {
+	db := dbh.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var products, saved int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM products WHERE id = $1", productID).Scan(&products); err != nil {
+		return err
+	}
+	if products == 0 {
+		return NewNotFoundError("product", productID)
+	}
+
+	query := "SELECT COUNT(*) FROM wishlist_items WHERE user_id = $1 AND product_id = $2"
+	if err := tx.QueryRow(query, userID, productID).Scan(&saved); err != nil {
+		return err
+	}
+	if saved > 0 {
+		return nil
+	}
+
+	query = "INSERT INTO wishlist_items (user_id, product_id, created_at) VALUES ($1, $2, $3)"
+	if _, err := tx.Exec(query, userID, productID, time.Now().UTC()); err != nil {
+		err = translateError(err, "wishlist item", productID)
+		// The product was saved at the same time by another request.
+		if errors.Is(err, ErrConflict) {
+			return nil
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (dbh ProdDatabaseHandler) GetWishlist(userID int) ([]WishlistItem, error) {
+	var items []WishlistItem
+
+	db := dbh.DB
+	query := `
+	SELECT products.id, products.name, products.price, products.currency, products.weight, products.image, products.review_count, products.rating_total, wishlist_items.created_at
+	FROM wishlist_items
+	JOIN products ON wishlist_items.product_id = products.id
+	WHERE wishlist_items.user_id = $1
+	ORDER BY wishlist_items.created_at DESC, products.id
+	`
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item WishlistItem
+		product := &item.Product
+		if err := rows.Scan(&product.ID, &product.Name, &product.Price.Amount, &product.Price.Currency, &product.Weight, &product.Image,
+			&product.Rating.Count, &product.Rating.Total, &item.AddedAt); err != nil {
+			return items, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+func (dbh ProdDatabaseHandler) RemoveWishlistItem(userID int, productID int) error {
+	db := dbh.DB
+	result, err := db.Exec("DELETE FROM wishlist_items WHERE user_id = $1 AND product_id = $2", userID, productID)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return NewNotFoundError("wishlist item", productID)
+	}
+
+	return nil
+}
+
+// UpdateProductPrice changes the price with a guarded UPDATE, so that two
+// admins changing the price at the same time don't both report a drop.
+func (dbh ProdDatabaseHandler) UpdateProductPrice(productID int, price int64) (PriceChange, error) {
+	var change PriceChange
+	if err := validateProductPrice(price); err != nil {
+		return change, err
+	}
+
+	db := dbh.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return change, err
+	}
+	defer tx.Rollback()
+
+	query := "SELECT id, name, price, currency, weight, image, review_count, rating_total FROM products WHERE id = $1"
+	if err := scanProduct(tx.QueryRow(query, productID), &change.Product); err != nil {
+		return change, translateError(err, "product", productID)
+	}
+	change.OldPrice = change.Product.Price
+	change.Product.Price.Amount = price
+
+	result, err := tx.Exec("UPDATE products SET price = $1 WHERE id = $2 AND price = $3", price, productID, change.OldPrice.Amount)
+	if err != nil {
+		return change, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return change, err
+	}
+	if updated == 0 {
+		return change, NewConflictError(fmt.Sprintf("product %d was changed at the same time", productID))
+	}
+
+	if change.Dropped() {
+		if change.Watchers, err = queryWishlistWatchers(tx, productID); err != nil {
+			return change, err
+		}
+
+		event, err := newProductPriceDroppedEvent(change, time.Now())
+		if err != nil {
+			return change, err
+		}
+		if err := insertWebhookEvent(tx, event); err != nil {
+			return change, err
+		}
+	}
+
+	return change, tx.Commit()
+}
+
+// queryWishlistWatchers returns the users with the product on their
+// wishlists. It is shared by the SQL backends.
+func queryWishlistWatchers(tx *sql.Tx, productID int) ([]User, error) {
+	var users []User
+
+	query := `
+	SELECT users.id, users.name, users.email
+	FROM wishlist_items
+	JOIN users ON wishlist_items.user_id = users.id
+	WHERE wishlist_items.product_id = $1
+	ORDER BY users.id
+	`
+	rows, err := tx.Query(query, productID)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name, &user.Email); err != nil {
+			return users, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (dbh ProdDatabaseHandler) GetPurchaseStats() ([]PurchaseStat, error) {
+	var stats []PurchaseStat
+
+	query := `
+	SELECT user_id, product_id, SUM(product_quantity)
+	FROM checkouts
+	WHERE payment_status = $1
+	GROUP BY user_id, product_id
+	ORDER BY user_id, product_id
+	`
+	db := dbh.DB
+	rows, err := db.Query(query, PaymentPaid)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stat PurchaseStat
+		if err := rows.Scan(&stat.UserID, &stat.ProductID, &stat.Quantity); err != nil {
+			return stats, err
+		}
+
+		stats = append(stats, stat)
+	}
+
+	return stats, rows.Err()
+}
+
+// CreateVariant checks that the product exists so that a missing product is
+// reported as such rather than as a broken foreign key.
+func (dbh ProdDatabaseHandler) CreateVariant(variant Variant) error {
+	if err := variant.Validate(); err != nil {
+		return err
+	}
+
+	db := dbh.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var products int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM products WHERE id = $1", variant.ProductID).Scan(&products); err != nil {
+		return err
+	}
+	if products == 0 {
+		return NewNotFoundError("product", variant.ProductID)
+	}
+
+	price := sql.NullInt64{Int64: variant.Price.Amount, Valid: variant.Price.Amount > 0}
+	query := "INSERT INTO product_variants (sku, product_id, name, price, stock, image) VALUES ($1, $2, $3, $4, $5, $6)"
+	if _, err := tx.Exec(query, variant.SKU, variant.ProductID, variant.Name, price, variant.Stock, variant.Image); err != nil {
+		return translateError(err, "variant", variant.SKU)
+	}
+
+	return tx.Commit()
+}
+
+func (dbh ProdDatabaseHandler) GetVariants(productID int) ([]Variant, error) {
+	var variants []Variant
+
+	query := `
+	SELECT product_variants.sku, product_variants.product_id, product_variants.name, COALESCE(product_variants.price, products.price), products.currency,
+	  product_variants.stock, CASE WHEN product_variants.image = '' THEN products.image ELSE product_variants.image END
+	FROM product_variants
+	JOIN products ON product_variants.product_id = products.id
+	WHERE product_variants.product_id = $1
+	ORDER BY product_variants.name, product_variants.sku
+	`
+	db := dbh.DB
+	rows, err := db.Query(query, productID)
+	if err != nil {
+		return variants, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var variant Variant
+		if err := rows.Scan(&variant.SKU, &variant.ProductID, &variant.Name, &variant.Price.Amount, &variant.Price.Currency, &variant.Stock, &variant.Image); err != nil {
+			return variants, err
+		}
+
+		variants = append(variants, variant)
+	}
+
+	return variants, rows.Err()
+}
+
+func (dbh ProdDatabaseHandler) UpdateVariantStock(sku string, stock int) error {
+	if err := validateVariantStock(stock); err != nil {
+		return err
+	}
+
+	db := dbh.DB
+	result, err := db.Exec("UPDATE product_variants SET stock = $1 WHERE sku = $2", stock, sku)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return NewNotFoundError("variant", sku)
+	}
+
+	return nil
+}
+
+// checkoutDayColumn returns the SQL expression of the day of a checkout in
+// UTC as YYYY-MM-DD. It is only used to group the checkouts, which are
+// selected by created_at so that its index is used.
+func (dbh ProdDatabaseHandler) checkoutDayColumn() string {
+	if dbh.checkoutDay == "" {
+		return "to_char(checkouts.created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD')"
+	}
+
+	return dbh.checkoutDay
+}
+
+// GetSalesReport runs an aggregate query per part of the report. The totals
+// are those of the days so that they always add up.
+func (dbh ProdDatabaseHandler) GetSalesReport(from, to time.Time, currency string, limit int) (SalesReport, error) {
+	report, err := newSalesReport(from, to, currency)
+	if err != nil {
+		return report, err
+	}
+
+	day := dbh.checkoutDayColumn()
+	where := "WHERE checkouts.created_at >= $1 AND checkouts.created_at < $2 AND checkouts.payment_status = $3 AND checkouts.currency = $4"
+	args := []interface{}{report.From, report.To, PaymentPaid, currency}
+	totals := "COUNT(*), SUM(checkouts.product_quantity), SUM(checkouts.total)"
+	limitClause := ""
+	if limit > 0 {
+		limitClause = fmt.Sprintf("LIMIT %d", limit)
+	}
+
+	var date string
+	query := fmt.Sprintf("SELECT %s, %s FROM checkouts %s GROUP BY %s ORDER BY %s", day, totals, where, day, day)
+	if err := dbh.querySales(query, args, currency, func(totals SalesTotals) error {
+		return report.addDay(date, totals)
+	}, &date); err != nil {
+		return report, err
+	}
+
+	query = "SELECT COUNT(DISTINCT checkouts.user_id) FROM checkouts " + where
+	if err := dbh.DB.QueryRow(query, args...).Scan(&report.Customers); err != nil {
+		return report, err
+	}
+
+	var product Product
+	query = fmt.Sprintf(`
+	SELECT products.id, products.name, %s
+	FROM checkouts INNER JOIN products ON products.id = checkouts.product_id
+	%s
+	GROUP BY products.id, products.name
+	ORDER BY SUM(checkouts.total) DESC, products.id
+	%s`, totals, where, limitClause)
+	if err := dbh.querySales(query, args, currency, func(totals SalesTotals) error {
+		report.TopProducts = append(report.TopProducts, ProductSales{Product: product, SalesTotals: totals})
+		return nil
+	}, &product.ID, &product.Name); err != nil {
+		return report, err
+	}
+
+	var user User
+	query = fmt.Sprintf(`
+	SELECT users.id, users.name, %s
+	FROM checkouts INNER JOIN users ON users.id = checkouts.user_id
+	%s
+	GROUP BY users.id, users.name
+	ORDER BY SUM(checkouts.total) DESC, users.id
+	%s`, totals, where, limitClause)
+	if err := dbh.querySales(query, args, currency, func(totals SalesTotals) error {
+		report.TopCustomers = append(report.TopCustomers, CustomerSales{User: user, SalesTotals: totals})
+		return nil
+	}, &user.ID, &user.Name); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// querySales runs a query whose rows end with the orders, the units and the
+// revenue of a group. The other columns are scanned into dest before add is
+// called with the totals of the row.
+func (dbh ProdDatabaseHandler) querySales(query string, args []interface{}, currency string, add func(SalesTotals) error, dest ...interface{}) error {
+	rows, err := dbh.DB.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var totals SalesTotals
+		var revenue int64
+		if err := rows.Scan(append(dest, &totals.Orders, &totals.Units, &revenue)...); err != nil {
+			return err
+		}
+		totals.Revenue = money.New(revenue, currency)
+
+		if err := add(totals); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (dbh ProdDatabaseHandler) ExportCheckouts(filter CheckoutFilter, fn func(Checkout) error) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	var conditions []string
+	var args []interface{}
+	if filter.UserID != 0 {
+		args = append(args, filter.UserID)
+		conditions = append(conditions, fmt.Sprintf("checkouts.user_id = $%d", len(args)))
+	}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("checkouts.created_at >= $%d", len(args)))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("checkouts.created_at < $%d", len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	db := dbh.DB
+	query := fmt.Sprintf(`
+	SELECT
+	  checkouts.id,
+	  users.id,
+	  users.name,
+	  users.email,
+	  products.id,
+	  products.name,
+	  products.price,
+	  products.currency,
+	  products.weight,
+	  products.image,
+	  checkouts.variant_sku,
+	  checkouts.variant_name,
+	  checkouts.product_quantity,
+	  COALESCE(checkouts.coupon_code, ''),
+	  checkouts.tax_region,
+	  checkouts.currency,
+	  checkouts.subtotal,
+	  checkouts.discount,
+	  checkouts.tax,
+	  checkouts.shipping,
+	  checkouts.total,
+	  checkouts.payment_status,
+	  checkouts.payment_id,
+	  checkouts.payment_error,
+	  checkouts.shipping_name,
+	  checkouts.shipping_line1,
+	  checkouts.shipping_line2,
+	  checkouts.shipping_city,
+	  checkouts.shipping_postal_code,
+	  checkouts.shipping_country,
+	  checkouts.created_at
+	FROM checkouts
+	LEFT JOIN users ON checkouts.user_id = users.id
+	LEFT JOIN products ON checkouts.product_id = products.id
+	%s
+	ORDER BY checkouts.created_at, checkouts.id
+	`, where)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var checkout Checkout
+		if err := scanCheckout(rows, &checkout); err != nil {
+			return err
+		}
+
+		if err := fn(checkout); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// prodBackupQueries read the rows of a backup in the order Restore inserts
+// them, so that the rows they reference come first.
+var prodBackupQueries = []struct {
+	query string
+	scan  func(row rowScanner) (BackupRecord, error)
+}{
+	{
+		"SELECT id, name, price, currency, weight, image, review_count, rating_total FROM products ORDER BY id",
+		func(row rowScanner) (BackupRecord, error) {
+			var product Product
+			err := scanProduct(row, &product)
+			return BackupRecord{Product: &product}, err
+		},
+	},
+	{
+		`SELECT product_variants.sku, product_variants.product_id, product_variants.name, COALESCE(product_variants.price, 0), products.currency,
+		  product_variants.stock, product_variants.image
+		FROM product_variants
+		JOIN products ON product_variants.product_id = products.id
+		ORDER BY product_variants.product_id, product_variants.sku`,
+		func(row rowScanner) (BackupRecord, error) {
+			var variant Variant
+			err := row.Scan(&variant.SKU, &variant.ProductID, &variant.Name, &variant.Price.Amount, &variant.Price.Currency, &variant.Stock, &variant.Image)
+			return BackupRecord{Variant: &variant}, err
+		},
+	},
+	{
+		"SELECT id, name, email FROM users ORDER BY id",
+		func(row rowScanner) (BackupRecord, error) {
+			var user User
+			err := row.Scan(&user.ID, &user.Name, &user.Email)
+			return BackupRecord{User: &user}, err
+		},
+	},
+	{
+		"SELECT code, kind, value, min_order, expires_at, max_uses, max_uses_per_user, uses, active FROM coupons ORDER BY code",
+		func(row rowScanner) (BackupRecord, error) {
+			coupon, err := scanCoupon(row)
+			return BackupRecord{Coupon: &coupon}, err
+		},
+	},
+	{
+		`SELECT id, user_id, product_id, variant_sku, variant_name, product_quantity, COALESCE(coupon_code, ''), tax_region, currency,
+		  subtotal, discount, tax, shipping, total, payment_status, payment_id, payment_error,
+		  shipping_name, shipping_line1, shipping_line2, shipping_city, shipping_postal_code, shipping_country, created_at
+		FROM checkouts
+		ORDER BY created_at, id`,
+		func(row rowScanner) (BackupRecord, error) {
+			var checkout Checkout
+			err := scanBackupCheckout(row, &checkout)
+			return BackupRecord{Checkout: &checkout}, err
+		},
+	},
+}
+
+func scanBackupCheckout(row rowScanner, checkout *Checkout) error {
+	var currency string
+	if err := row.Scan(
+		&checkout.ID,
+		&checkout.User.ID,
+		&checkout.Product.ID,
+		&checkout.Variant.SKU,
+		&checkout.Variant.Name,
+		&checkout.ProductQuantity,
+		&checkout.CouponCode,
+		&checkout.TaxRegion,
+		&currency,
+		&checkout.Subtotal.Amount,
+		&checkout.Discount.Amount,
+		&checkout.Tax.Amount,
+		&checkout.Shipping.Amount,
+		&checkout.Total.Amount,
+		&checkout.Payment.Status,
+		&checkout.Payment.ID,
+		&checkout.Payment.Error,
+		&checkout.ShippingAddress.Name,
+		&checkout.ShippingAddress.Line1,
+		&checkout.ShippingAddress.Line2,
+		&checkout.ShippingAddress.City,
+		&checkout.ShippingAddress.PostalCode,
+		&checkout.ShippingAddress.Country,
+		&checkout.CreatedAt,
+	); err != nil {
+		return err
+	}
+
+	checkout.Subtotal.Currency = currency
+	checkout.Discount.Currency = currency
+	checkout.Tax.Currency = currency
+	checkout.Shipping.Currency = currency
+	checkout.Total.Currency = currency
+
+	return nil
+}
+
+// Backup reads the tables in a read-only repeatable read transaction so that
+// the records are a snapshot even while the app is writing.
+func (dbh ProdDatabaseHandler) Backup(fn func(BackupRecord) error) error {
+	db := dbh.DB
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range prodBackupQueries {
+		if err := backupRows(tx, table.query, table.scan, fn); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func backupRows(tx *sql.Tx, query string, scan func(row rowScanner) (BackupRecord, error), fn func(BackupRecord) error) error {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scan(rows)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Restore empties every table and inserts the records in one transaction, so
+// that the data is left as it was when a record fails.
+func (dbh ProdDatabaseHandler) Restore(next func() (BackupRecord, error)) error {
+	db := dbh.DB
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range schemaTables {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+	}
+
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := restoreRecord(tx, record); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func restoreRecord(tx *sql.Tx, record BackupRecord) error {
+	switch {
+	case record.Product != nil:
+		product := record.Product
+		query := "INSERT INTO products (id, name, price, currency, weight, image, review_count, rating_total) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)"
+		_, err := tx.Exec(query, product.ID, product.Name, product.Price.Amount, product.Price.Currency, product.Weight, product.Image, product.Rating.Count, product.Rating.Total)
+		return translateError(err, "product", product.ID)
+	case record.Variant != nil:
+		variant := record.Variant
+		price := sql.NullInt64{Int64: variant.Price.Amount, Valid: variant.Price.Amount > 0}
+		query := "INSERT INTO product_variants (sku, product_id, name, price, stock, image) VALUES ($1, $2, $3, $4, $5, $6)"
+		_, err := tx.Exec(query, variant.SKU, variant.ProductID, variant.Name, price, variant.Stock, variant.Image)
+		return translateError(err, "variant", variant.SKU)
+	case record.User != nil:
+		user := record.User
+		_, err := tx.Exec("INSERT INTO users (id, name, email) VALUES ($1, $2, $3)", user.ID, user.Name, user.Email)
+		return translateError(err, "user", user.ID)
+	case record.Coupon != nil:
+		coupon := record.Coupon
+		expiresAt := sql.NullTime{Time: coupon.ExpiresAt, Valid: !coupon.ExpiresAt.IsZero()}
+		query := "INSERT INTO coupons (code, kind, value, min_order, expires_at, max_uses, max_uses_per_user, uses, active) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
+		_, err := tx.Exec(query, coupon.Code, coupon.Kind, coupon.Value, coupon.MinOrder, expiresAt, coupon.MaxUses, coupon.MaxUsesPerUser, coupon.Uses, coupon.Active)
+		return translateError(err, "coupon", coupon.Code)
+	case record.Checkout != nil:
+		checkout := record.Checkout
+		couponCode := sql.NullString{String: checkout.CouponCode, Valid: checkout.CouponCode != ""}
+		query := `
+		INSERT INTO checkouts (
+		  id, user_id, product_id, variant_sku, variant_name, product_quantity, coupon_code, tax_region, currency,
+		  subtotal, discount, tax, shipping, total, payment_status, payment_id, payment_error,
+		  shipping_name, shipping_line1, shipping_line2, shipping_city, shipping_postal_code, shipping_country, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24)
+		`
+		_, err := tx.Exec(query,
+			checkout.ID, checkout.User.ID, checkout.Product.ID, checkout.Variant.SKU, checkout.Variant.Name, checkout.ProductQuantity,
+			couponCode, checkout.TaxRegion, checkout.Total.Currency,
+			checkout.Subtotal.Amount, checkout.Discount.Amount, checkout.Tax.Amount, checkout.Shipping.Amount, checkout.Total.Amount,
+			checkout.Payment.Status, checkout.Payment.ID, checkout.Payment.Error,
+			checkout.ShippingAddress.Name, checkout.ShippingAddress.Line1, checkout.ShippingAddress.Line2,
+			checkout.ShippingAddress.City, checkout.ShippingAddress.PostalCode, checkout.ShippingAddress.Country,
+			checkout.CreatedAt.UTC())
+		return translateError(err, "checkout", checkout.ID)
+	default:
+		return NewValidationError("backup record is empty")
+	}
 }
@@ -1,11 +1,15 @@
 package database
 
 import (
+	"database/sql"
+	"os"
 	"regexp"
 	"testing"
 	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	_ "github.com/lib/pq"
+	"github.com/mittz/role-play-webapp/webapp/money"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,6 +35,29 @@ func TestNewProdDatabaseHandler(t *testing.T) {
 func TestOpenDatabase(t *testing.T) {
 }
 
+// TestProdDatabaseHandlerBehaviour runs against a real PostgreSQL given by
+// SCSTORE_TEST_POSTGRES_DSN like "host=localhost user=scstore password=scstore dbname=scstore sslmode=disable".
+func TestProdDatabaseHandlerBehaviour(t *testing.T) {
+	dsn := os.Getenv("SCSTORE_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("SCSTORE_TEST_POSTGRES_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	stopListening, err := listenCheckoutUpdates(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stopListening()
+
+	testDatabaseHandlerBehaviour(t, NewProdDatabaseHandler(db))
+}
+
 func TestInitDatabase(t *testing.T) {
 
 }
@@ -41,13 +68,13 @@ func TestGetProduct(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	p := Product{ID: 1, Name: "", Price: 150, Image: "/assets/hunters-race-Vk3QiwyrAUA-unsplash.jpg"}
+	p := Product{ID: 1, Name: "", Price: money.New(15000, "USD"), Image: "/assets/hunters-race-Vk3QiwyrAUA-unsplash.jpg"}
 
 	mock.ExpectQuery(regexp.QuoteMeta(
-		`SELECT id, name, price, image FROM products WHERE id = $1`)).
+		`SELECT id, name, price, currency, weight, image, review_count, rating_total FROM products WHERE id = $1`)).
 		WithArgs(p.ID).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price", "image"}).
-			AddRow(p.ID, p.Name, p.Price, p.Image))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price", "currency", "weight", "image", "review_count", "rating_total"}).
+			AddRow(p.ID, p.Name, p.Price.Amount, p.Price.Currency, p.Weight, p.Image, p.Rating.Count, p.Rating.Total))
 
 	product, err := mdb.GetProduct(p.ID)
 
@@ -61,14 +88,14 @@ func TestGetProducts(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	p1 := Product{ID: 1, Name: "Product00001", Price: 150, Image: "product00001.jpg"}
-	p2 := Product{ID: 2, Name: "Product00002", Price: 200, Image: "product00002.jpg"}
+	p1 := Product{ID: 1, Name: "Product00001", Price: money.New(15000, "USD"), Image: "product00001.jpg", Rating: Rating{Count: 2, Total: 9}}
+	p2 := Product{ID: 2, Name: "Product00002", Price: money.New(20000, "USD"), Image: "product00002.jpg"}
 
 	mock.ExpectQuery(regexp.QuoteMeta(
-		`SELECT id, name, price, image FROM products`)).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price", "image"}).
-			AddRow(p1.ID, p1.Name, p1.Price, p1.Image).
-			AddRow(p2.ID, p2.Name, p2.Price, p2.Image))
+		`SELECT id, name, price, currency, weight, image, review_count, rating_total FROM products`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price", "currency", "weight", "image", "review_count", "rating_total"}).
+			AddRow(p1.ID, p1.Name, p1.Price.Amount, p1.Price.Currency, p1.Weight, p1.Image, p1.Rating.Count, p1.Rating.Total).
+			AddRow(p2.ID, p2.Name, p2.Price.Amount, p2.Price.Currency, p2.Weight, p2.Image, p2.Rating.Count, p2.Rating.Total))
 
 	products, err := mdb.GetProducts()
 	assert.Nil(t, err)
@@ -87,41 +114,48 @@ func TestGetCheckouts(t *testing.T) {
 	checkout1 := Checkout{
 		ID: "dummy-checkout-00001",
 		User: User{
-			ID:   userID,
-			Name: userName,
+			ID:    userID,
+			Name:  userName,
+			Email: "user00001@example.com",
 		},
 		Product: Product{
 			ID:    1,
 			Name:  "product00001",
-			Price: 100,
+			Price: money.New(10000, "USD"),
 			Image: "product00001.png",
 		},
 		ProductQuantity: 1,
+		Payment:         Payment{Status: PaymentPaid, ID: "fake_00001"},
 		CreatedAt:       time.Now(),
 	}
 
 	checkout2 := Checkout{
 		ID: "dummy-checkout-00002",
 		User: User{
-			ID:   userID,
-			Name: userName,
+			ID:    userID,
+			Name:  userName,
+			Email: "user00001@example.com",
 		},
 		Product: Product{
 			ID:    1,
 			Name:  "product00002",
-			Price: 200,
+			Price: money.New(20000, "USD"),
 			Image: "product00002.png",
 		},
+		Variant:         Variant{SKU: "P2-BLACK", Name: "Black strap"},
 		ProductQuantity: 2,
+		Payment:         Payment{Status: PaymentFailed, Error: "payment declined"},
 		CreatedAt:       time.Now(),
 	}
 
 	mock.ExpectQuery(regexp.QuoteMeta(
-		`SELECT checkouts.id AS checkout_id, users.id AS user_id, users.name AS user_name, products.id AS product_id, products.name AS product_name, products.price AS product_price, products.image AS product_image, checkouts.product_quantity AS checkout_product_quantity, checkouts.created_at AS checkout_created_at FROM checkouts LEFT JOIN users ON checkouts.user_id = users.id LEFT JOIN products ON checkouts.product_id = products.id WHERE users.id = $1`)).
+		`SELECT checkouts.id AS checkout_id, users.id AS user_id, users.name AS user_name, users.email AS user_email, products.id AS product_id, products.name AS product_name, products.price AS product_price, products.currency AS product_currency, products.weight AS product_weight, products.image AS product_image, checkouts.variant_sku AS checkout_variant_sku, checkouts.variant_name AS checkout_variant_name, checkouts.product_quantity AS checkout_product_quantity, COALESCE(checkouts.coupon_code, '') AS checkout_coupon_code, checkouts.tax_region AS checkout_tax_region, checkouts.currency AS checkout_currency, checkouts.subtotal AS checkout_subtotal, checkouts.discount AS checkout_discount, checkouts.tax AS checkout_tax, checkouts.shipping AS checkout_shipping, checkouts.total AS checkout_total, checkouts.payment_status AS checkout_payment_status, checkouts.payment_id AS checkout_payment_id, checkouts.payment_error AS checkout_payment_error, checkouts.shipping_name AS checkout_shipping_name, checkouts.shipping_line1 AS checkout_shipping_line1, checkouts.shipping_line2 AS checkout_shipping_line2, checkouts.shipping_city AS checkout_shipping_city, checkouts.shipping_postal_code AS checkout_shipping_postal_code, checkouts.shipping_country AS checkout_shipping_country, checkouts.created_at AS checkout_created_at FROM checkouts LEFT JOIN users ON checkouts.user_id = users.id LEFT JOIN products ON checkouts.product_id = products.id WHERE users.id = $1`)).
 		WithArgs(userID).
-		WillReturnRows(sqlmock.NewRows([]string{"checkout_id", "user_id", "user_name", "product_id", "product_name", "product_price", "product_image", "checkout_product_quantity", "checkout_created_at"}).
-			AddRow(checkout1.ID, checkout1.User.ID, checkout1.User.Name, checkout1.Product.ID, checkout1.Product.Name, checkout1.Product.Price, checkout1.Product.Image, checkout1.ProductQuantity, checkout1.CreatedAt).
-			AddRow(checkout2.ID, checkout2.User.ID, checkout2.User.Name, checkout2.Product.ID, checkout2.Product.Name, checkout2.Product.Price, checkout2.Product.Image, checkout2.ProductQuantity, checkout2.CreatedAt))
+		WillReturnRows(sqlmock.NewRows([]string{"checkout_id", "user_id", "user_name", "user_email", "product_id", "product_name", "product_price", "product_currency", "product_weight", "product_image", "checkout_variant_sku", "checkout_variant_name", "checkout_product_quantity", "checkout_coupon_code", "checkout_tax_region", "checkout_currency", "checkout_subtotal", "checkout_discount", "checkout_tax", "checkout_shipping", "checkout_total", "checkout_payment_status", "checkout_payment_id", "checkout_payment_error", "checkout_shipping_name", "checkout_shipping_line1", "checkout_shipping_line2", "checkout_shipping_city", "checkout_shipping_postal_code", "checkout_shipping_country", "checkout_created_at"}).
+			AddRow(checkout1.ID, checkout1.User.ID, checkout1.User.Name, checkout1.User.Email, checkout1.Product.ID, checkout1.Product.Name, checkout1.Product.Price.Amount, checkout1.Product.Price.Currency, checkout1.Product.Weight, checkout1.Product.Image, checkout1.Variant.SKU, checkout1.Variant.Name, checkout1.ProductQuantity, checkout1.CouponCode, checkout1.TaxRegion, checkout1.Total.Currency, checkout1.Subtotal.Amount, checkout1.Discount.Amount, checkout1.Tax.Amount, checkout1.Shipping.Amount, checkout1.Total.Amount, checkout1.Payment.Status, checkout1.Payment.ID, checkout1.Payment.Error,
+				checkout1.ShippingAddress.Name, checkout1.ShippingAddress.Line1, checkout1.ShippingAddress.Line2, checkout1.ShippingAddress.City, checkout1.ShippingAddress.PostalCode, checkout1.ShippingAddress.Country, checkout1.CreatedAt).
+			AddRow(checkout2.ID, checkout2.User.ID, checkout2.User.Name, checkout2.User.Email, checkout2.Product.ID, checkout2.Product.Name, checkout2.Product.Price.Amount, checkout2.Product.Price.Currency, checkout2.Product.Weight, checkout2.Product.Image, checkout2.Variant.SKU, checkout2.Variant.Name, checkout2.ProductQuantity, checkout2.CouponCode, checkout2.TaxRegion, checkout2.Total.Currency, checkout2.Subtotal.Amount, checkout2.Discount.Amount, checkout2.Tax.Amount, checkout2.Shipping.Amount, checkout2.Total.Amount, checkout2.Payment.Status, checkout2.Payment.ID, checkout2.Payment.Error,
+				checkout2.ShippingAddress.Name, checkout2.ShippingAddress.Line1, checkout2.ShippingAddress.Line2, checkout2.ShippingAddress.City, checkout2.ShippingAddress.PostalCode, checkout2.ShippingAddress.Country, checkout2.CreatedAt))
 
 	checkouts, err := mdb.GetCheckouts(userID)
 	assert.Nil(t, err)
@@ -143,13 +177,14 @@ func TestGetCheckout(t *testing.T) {
 	checkout := Checkout{
 		ID: "dummy-checkout-00001",
 		User: User{
-			ID:   1,
-			Name: "user00001",
+			ID:    1,
+			Name:  "user00001",
+			Email: "user00001@example.com",
 		},
 		Product: Product{
 			ID:    1,
 			Name:  "Product00001",
-			Price: 100,
+			Price: money.New(10000, "USD"),
 			Image: "product00001.png",
 		},
 		ProductQuantity: 1,
@@ -157,12 +192,123 @@ func TestGetCheckout(t *testing.T) {
 	}
 
 	mock.ExpectQuery(regexp.QuoteMeta(
-		`SELECT checkouts.id, users.id, users.name, products.id, products.name, products.price, products.image, checkouts.product_quantity, checkouts.created_at FROM checkouts LEFT JOIN users ON checkouts.user_id = users.id LEFT JOIN products ON checkouts.product_id = products.id WHERE checkouts.id = $1`)).
+		`SELECT checkouts.id, users.id, users.name, users.email, products.id, products.name, products.price, products.currency, products.weight, products.image, checkouts.variant_sku, checkouts.variant_name, checkouts.product_quantity, COALESCE(checkouts.coupon_code, ''), checkouts.tax_region, checkouts.currency, checkouts.subtotal, checkouts.discount, checkouts.tax, checkouts.shipping, checkouts.total, checkouts.payment_status, checkouts.payment_id, checkouts.payment_error, checkouts.shipping_name, checkouts.shipping_line1, checkouts.shipping_line2, checkouts.shipping_city, checkouts.shipping_postal_code, checkouts.shipping_country, checkouts.created_at FROM checkouts LEFT JOIN users ON checkouts.user_id = users.id LEFT JOIN products ON checkouts.product_id = products.id WHERE checkouts.id = $1`)).
 		WithArgs(checkout.ID).
-		WillReturnRows(sqlmock.NewRows([]string{"checkout_id", "user_id", "user_name", "product_id", "product_name", "product_price", "product_image", "product_quantity", "created_at"}).
-			AddRow(checkout.ID, checkout.User.ID, checkout.User.Name, checkout.Product.ID, checkout.Product.Name, checkout.Product.Price, checkout.Product.Image, checkout.ProductQuantity, checkout.CreatedAt))
+		WillReturnRows(sqlmock.NewRows([]string{"checkout_id", "user_id", "user_name", "user_email", "product_id", "product_name", "product_price", "product_currency", "product_weight", "product_image", "variant_sku", "variant_name", "product_quantity", "coupon_code", "tax_region", "currency", "subtotal", "discount", "tax", "shipping", "total", "payment_status", "payment_id", "payment_error", "shipping_name", "shipping_line1", "shipping_line2", "shipping_city", "shipping_postal_code", "shipping_country", "created_at"}).
+			AddRow(checkout.ID, checkout.User.ID, checkout.User.Name, checkout.User.Email, checkout.Product.ID, checkout.Product.Name, checkout.Product.Price.Amount, checkout.Product.Price.Currency, checkout.Product.Weight, checkout.Product.Image, checkout.Variant.SKU, checkout.Variant.Name, checkout.ProductQuantity, checkout.CouponCode, checkout.TaxRegion, checkout.Total.Currency, checkout.Subtotal.Amount, checkout.Discount.Amount, checkout.Tax.Amount, checkout.Shipping.Amount, checkout.Total.Amount, checkout.Payment.Status, checkout.Payment.ID, checkout.Payment.Error,
+				checkout.ShippingAddress.Name, checkout.ShippingAddress.Line1, checkout.ShippingAddress.Line2, checkout.ShippingAddress.City, checkout.ShippingAddress.PostalCode, checkout.ShippingAddress.Country, checkout.CreatedAt))
 
 	c, err := mdb.GetCheckout(checkout.ID)
 	assert.Nil(t, err)
 	assert.Equal(t, checkout, c)
 }
+
+func TestUpdateCheckoutPayment(t *testing.T) {
+	mdb, mock, err := NewMockDatabaseHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selectQuery := regexp.QuoteMeta(`SELECT payment_status, user_id, product_id, variant_sku, product_quantity, COALESCE(coupon_code, '') FROM checkouts WHERE id = $1`)
+	updateQuery := regexp.QuoteMeta(`UPDATE checkouts SET payment_status = $1, payment_id = $2, payment_error = $3 WHERE id = $4 AND payment_status = $5`)
+	reservationColumns := []string{"payment_status", "user_id", "product_id", "variant_sku", "product_quantity", "coupon_code"}
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs("dummy-checkout-00001").
+		WillReturnRows(sqlmock.NewRows(reservationColumns).AddRow(PaymentPending, 1, 1, "", 1, ""))
+	mock.ExpectExec(updateQuery).
+		WithArgs(PaymentPaid, "fake_00001", "", "dummy-checkout-00001", PaymentPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO webhook_events (id, type, payload, created_at) VALUES ($1, $2, $3, $4)`)).
+		WithArgs(sqlmock.AnyArg(), WebhookEventCheckoutStatusChanged, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(checkoutUpdatesChannel, `{"checkout_id":"dummy-checkout-00001","user_id":1,"payment_status":"paid"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// A declined order gives back its stock and its use of the coupon.
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs("dummy-checkout-00003").
+		WillReturnRows(sqlmock.NewRows(reservationColumns).AddRow(PaymentPending, 1, 1, "P1-GOLD", 2, "SALE10"))
+	mock.ExpectExec(updateQuery).
+		WithArgs(PaymentFailed, "", "payment declined", "dummy-checkout-00003", PaymentPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE product_variants SET stock = stock + $1 WHERE sku = $2`)).
+		WithArgs(2, "P1-GOLD").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE coupons SET uses = uses - 1 WHERE code = $1 AND uses > 0`)).
+		WithArgs("SALE10").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO webhook_events (id, type, payload, created_at) VALUES ($1, $2, $3, $4)`)).
+		WithArgs(sqlmock.AnyArg(), WebhookEventCheckoutStatusChanged, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(checkoutUpdatesChannel, `{"checkout_id":"dummy-checkout-00003","user_id":1,"payment_status":"failed","payment_error":"payment declined"}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// The status changed since it was read.
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs("dummy-checkout-00004").
+		WillReturnRows(sqlmock.NewRows(reservationColumns).AddRow(PaymentPending, 1, 1, "", 1, ""))
+	mock.ExpectExec(updateQuery).
+		WithArgs(PaymentFailed, "", "payment declined", "dummy-checkout-00004", PaymentPending).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs("dummy-checkout-00002").
+		WillReturnRows(sqlmock.NewRows(reservationColumns))
+	mock.ExpectRollback()
+
+	// The committed update is published in this process when pg_notify
+	// fails.
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectQuery).
+		WithArgs("dummy-checkout-00005").
+		WillReturnRows(sqlmock.NewRows(reservationColumns).AddRow(PaymentPending, 1, 1, "", 1, ""))
+	mock.ExpectExec(updateQuery).
+		WithArgs(PaymentPaid, "fake_00005", "", "dummy-checkout-00005", PaymentPending).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO webhook_events (id, type, payload, created_at) VALUES ($1, $2, $3, $4)`)).
+		WithArgs(sqlmock.AnyArg(), WebhookEventCheckoutStatusChanged, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(checkoutUpdatesChannel, sqlmock.AnyArg()).
+		WillReturnError(sql.ErrConnDone)
+
+	assert.Nil(t, mdb.UpdateCheckoutPayment("dummy-checkout-00001", Payment{Status: PaymentPaid, ID: "fake_00001"}))
+	assert.Nil(t, mdb.UpdateCheckoutPayment("dummy-checkout-00003", Payment{Status: PaymentFailed, Error: "payment declined"}))
+	assert.ErrorIs(t, mdb.UpdateCheckoutPayment("dummy-checkout-00004", Payment{Status: PaymentFailed, Error: "payment declined"}), ErrConflict)
+	assert.ErrorIs(t, mdb.UpdateCheckoutPayment("dummy-checkout-00002", Payment{Status: PaymentFailed, Error: "payment declined"}), ErrNotFound)
+	sub := SubscribeCheckout("dummy-checkout-00005")
+	defer sub.Close()
+	assert.Nil(t, mdb.UpdateCheckoutPayment("dummy-checkout-00005", Payment{Status: PaymentPaid, ID: "fake_00005"}))
+	assert.Equal(t, CheckoutUpdate{CheckoutID: "dummy-checkout-00005", UserID: 1, PaymentStatus: PaymentPaid}, receiveCheckoutUpdate(t, sub))
+	assert.ErrorIs(t, mdb.UpdateCheckoutPayment("dummy-checkout-00001", Payment{Status: "unknown"}), ErrValidation)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestGetSalesReport(t *testing.T) {
+	mdb, mock, err := NewMockDatabaseHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The checkouts are selected by a range of created_at so that its index
+	// is used, and grouped by day after.
+	from := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(regexp.QuoteMeta(
+		`SELECT to_char(checkouts.created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD'), COUNT(*), SUM(checkouts.product_quantity), SUM(checkouts.total) FROM checkouts WHERE checkouts.created_at >= $1 AND checkouts.created_at < $2 AND`)).
+		WithArgs(from, from.AddDate(0, 0, 7), PaymentPaid, "USD").
+		WillReturnError(sql.ErrConnDone)
+
+	_, err = mdb.GetSalesReport(from, from.AddDate(0, 0, 7), "USD", 10)
+	assert.ErrorIs(t, err, sql.ErrConnDone)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,9 @@
+package database
+
+// PurchaseStat is the number of units of a product a user has bought in
+// paid checkouts. The recommendations are computed from these.
+type PurchaseStat struct {
+	UserID    int
+	ProductID int
+	Quantity  int
+}
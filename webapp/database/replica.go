@@ -0,0 +1,494 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReplicaDatabaseHandler sends writes to the primary and spreads reads over
+// the healthy replicas. Reads which must see a write done a moment ago
+// (read-your-writes) are sent to the primary. The writes of this process are
+// remembered by the handler, and those of the other instances are passed in
+// with RememberUserWrite.
+type ReplicaDatabaseHandler struct {
+	primary          ProdDatabaseHandler
+	replicas         []*replica
+	next             uint32
+	readYourWrites   time.Duration
+	mu               sync.Mutex
+	recentCheckouts  map[string]time.Time
+	recentUserWrites map[int]time.Time
+}
+
+type replica struct {
+	db      *sql.DB
+	healthy int32
+}
+
+func (r *replica) isHealthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+func (r *replica) setHealthy(healthy bool) {
+	var val int32
+	if healthy {
+		val = 1
+	}
+
+	if atomic.SwapInt32(&r.healthy, val) != val {
+		if healthy {
+			log.Printf("Database replica is healthy again")
+		} else {
+			log.Printf("Database replica is unhealthy, reads fail over to the other replicas or the primary")
+		}
+	}
+}
+
+func NewReplicaDatabaseHandler(primary *sql.DB, replicas []*sql.DB, readYourWrites time.Duration) *ReplicaDatabaseHandler {
+	dbh := &ReplicaDatabaseHandler{
+		primary:          NewProdDatabaseHandler(primary),
+		readYourWrites:   readYourWrites,
+		recentCheckouts:  make(map[string]time.Time),
+		recentUserWrites: make(map[int]time.Time),
+	}
+
+	for _, db := range replicas {
+		dbh.replicas = append(dbh.replicas, &replica{db: db, healthy: 1})
+	}
+
+	return dbh
+}
+
+// OpenReplicaDatabaseHandler opens every replica from its connection config.
+// Replicas which are not reachable at startup are marked unhealthy instead of
+// failing the startup.
+func OpenReplicaDatabaseHandler(driverName string, primary *sql.DB, replicaConfigs []ConnConfig, readYourWrites time.Duration) (*ReplicaDatabaseHandler, error) {
+	var replicas []*sql.DB
+	for _, cfg := range replicaConfigs {
+		dsn, err := cfg.DSN()
+		if err != nil {
+			return nil, err
+		}
+
+		db, err := sql.Open(driverName, dsn)
+		if err != nil {
+			return nil, err
+		}
+
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+		replicas = append(replicas, db)
+	}
+
+	dbh := NewReplicaDatabaseHandler(primary, replicas, readYourWrites)
+	dbh.checkHealth()
+
+	return dbh, nil
+}
+
+// StartHealthCheck pings every replica each interval in the background.
+func (dbh *ReplicaDatabaseHandler) StartHealthCheck(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			dbh.checkHealth()
+		}
+	}()
+}
+
+func (dbh *ReplicaDatabaseHandler) checkHealth() {
+	for _, r := range dbh.replicas {
+		r.setHealthy(r.db.Ping() == nil)
+	}
+}
+
+// reader returns a handler for a healthy replica chosen round robin, or the
+// primary when every replica is down.
+func (dbh *ReplicaDatabaseHandler) reader() (ProdDatabaseHandler, *replica) {
+	n := len(dbh.replicas)
+	for i := 0; i < n; i++ {
+		r := dbh.replicas[int(atomic.AddUint32(&dbh.next, 1))%n]
+		if r.isHealthy() {
+			return NewProdDatabaseHandler(r.db), r
+		}
+	}
+
+	return dbh.primary, nil
+}
+
+// read runs fn on a replica and retries it on the primary when the replica
+// fails for another reason than a typed error like a missing row.
+func (dbh *ReplicaDatabaseHandler) read(fn func(ProdDatabaseHandler) error) error {
+	reader, r := dbh.reader()
+	err := fn(reader)
+	var dbErr *Error
+	if err == nil || r == nil || errors.As(err, &dbErr) {
+		return err
+	}
+
+	r.setHealthy(false)
+
+	return fn(dbh.primary)
+}
+
+func (dbh *ReplicaDatabaseHandler) rememberCheckout(userID int, checkoutID string) {
+	dbh.mu.Lock()
+	defer dbh.mu.Unlock()
+
+	now := time.Now()
+	dbh.forgetOldWrites(now)
+
+	dbh.recentCheckouts[checkoutID] = now
+	dbh.recentUserWrites[userID] = now
+}
+
+// RememberUserWrite sends the reads of the checkouts of the user to the
+// primary until readYourWrites after at, like after a write of this process.
+// It is given the writes of the user which another instance made.
+func (dbh *ReplicaDatabaseHandler) RememberUserWrite(userID int, at time.Time) {
+	dbh.mu.Lock()
+	defer dbh.mu.Unlock()
+
+	now := time.Now()
+	dbh.forgetOldWrites(now)
+
+	if at.After(now) {
+		at = now
+	}
+	if last, ok := dbh.recentUserWrites[userID]; !ok || at.After(last) {
+		dbh.recentUserWrites[userID] = at
+	}
+}
+
+// rememberCheckoutUpdate is rememberCheckout for a write to an existing
+// checkout whose user is not known.
+func (dbh *ReplicaDatabaseHandler) rememberCheckoutUpdate(checkoutID string) {
+	dbh.mu.Lock()
+	defer dbh.mu.Unlock()
+
+	now := time.Now()
+	dbh.forgetOldWrites(now)
+
+	dbh.recentCheckouts[checkoutID] = now
+}
+
+// forgetOldWrites must be called with mu held.
+func (dbh *ReplicaDatabaseHandler) forgetOldWrites(now time.Time) {
+	for id, at := range dbh.recentCheckouts {
+		if now.Sub(at) > dbh.readYourWrites {
+			delete(dbh.recentCheckouts, id)
+		}
+	}
+	for id, at := range dbh.recentUserWrites {
+		if now.Sub(at) > dbh.readYourWrites {
+			delete(dbh.recentUserWrites, id)
+		}
+	}
+}
+
+func (dbh *ReplicaDatabaseHandler) isRecentCheckout(checkoutID string) bool {
+	dbh.mu.Lock()
+	defer dbh.mu.Unlock()
+
+	at, ok := dbh.recentCheckouts[checkoutID]
+	return ok && time.Since(at) <= dbh.readYourWrites
+}
+
+func (dbh *ReplicaDatabaseHandler) isRecentUserWrite(userID int) bool {
+	dbh.mu.Lock()
+	defer dbh.mu.Unlock()
+
+	at, ok := dbh.recentUserWrites[userID]
+	return ok && time.Since(at) <= dbh.readYourWrites
+}
+
+func (dbh *ReplicaDatabaseHandler) InitDatabase() error {
+	return dbh.primary.InitDatabase()
+}
+
+func (dbh *ReplicaDatabaseHandler) MigrateDatabase() error {
+	return dbh.primary.MigrateDatabase()
+}
+
+func (dbh *ReplicaDatabaseHandler) SeedDatabase() error {
+	return dbh.primary.SeedDatabase()
+}
+
+func (dbh *ReplicaDatabaseHandler) GetProduct(id int) (Product, error) {
+	var product Product
+	err := dbh.read(func(reader ProdDatabaseHandler) (err error) {
+		product, err = reader.GetProduct(id)
+		return err
+	})
+
+	return product, err
+}
+
+func (dbh *ReplicaDatabaseHandler) GetProducts() ([]Product, error) {
+	var products []Product
+	err := dbh.read(func(reader ProdDatabaseHandler) (err error) {
+		products, err = reader.GetProducts()
+		return err
+	})
+
+	return products, err
+}
+
+func (dbh *ReplicaDatabaseHandler) GetCheckouts(userID int) ([]Checkout, error) {
+	if dbh.isRecentUserWrite(userID) {
+		return dbh.primary.GetCheckouts(userID)
+	}
+
+	var checkouts []Checkout
+	err := dbh.read(func(reader ProdDatabaseHandler) (err error) {
+		checkouts, err = reader.GetCheckouts(userID)
+		return err
+	})
+
+	return checkouts, err
+}
+
+func (dbh *ReplicaDatabaseHandler) CreateCheckout(order CheckoutRequest) (string, error) {
+	checkoutID, err := dbh.primary.CreateCheckout(order)
+	if err != nil {
+		return "", err
+	}
+
+	dbh.rememberCheckout(order.UserID, checkoutID)
+
+	return checkoutID, nil
+}
+
+// GetCheckout reads the checkout from the primary as well when the replica
+// doesn't have it yet, as it may have been created by another instance a
+// moment ago, or when its user wrote recently.
+func (dbh *ReplicaDatabaseHandler) GetCheckout(checkoutID string) (Checkout, error) {
+	if dbh.isRecentCheckout(checkoutID) {
+		return dbh.primary.GetCheckout(checkoutID)
+	}
+
+	var checkout Checkout
+	err := dbh.read(func(reader ProdDatabaseHandler) (err error) {
+		checkout, err = reader.GetCheckout(checkoutID)
+		return err
+	})
+	if errors.Is(err, ErrNotFound) || (err == nil && dbh.isRecentUserWrite(checkout.User.ID)) {
+		return dbh.primary.GetCheckout(checkoutID)
+	}
+
+	return checkout, err
+}
+
+func (dbh *ReplicaDatabaseHandler) CreateCoupon(coupon Coupon) error {
+	return dbh.primary.CreateCoupon(coupon)
+}
+
+// GetCoupons reads from the primary so that the admin sees the current usage.
+func (dbh *ReplicaDatabaseHandler) GetCoupons() ([]Coupon, error) {
+	return dbh.primary.GetCoupons()
+}
+
+func (dbh *ReplicaDatabaseHandler) DeactivateCoupon(code string) error {
+	return dbh.primary.DeactivateCoupon(code)
+}
+
+func (dbh *ReplicaDatabaseHandler) UpdateCheckoutPayment(checkoutID string, payment Payment) error {
+	if err := dbh.primary.UpdateCheckoutPayment(checkoutID, payment); err != nil {
+		return err
+	}
+
+	dbh.rememberCheckoutUpdate(checkoutID)
+
+	return nil
+}
+
+func (dbh *ReplicaDatabaseHandler) CreateAddress(address Address) (string, error) {
+	return dbh.primary.CreateAddress(address)
+}
+
+// GetAddresses reads from the primary so that a user sees an address right
+// after saving it.
+func (dbh *ReplicaDatabaseHandler) GetAddresses(userID int) ([]Address, error) {
+	return dbh.primary.GetAddresses(userID)
+}
+
+func (dbh *ReplicaDatabaseHandler) DeleteAddress(userID int, addressID string) error {
+	return dbh.primary.DeleteAddress(userID, addressID)
+}
+
+func (dbh *ReplicaDatabaseHandler) CreateWebhook(webhook Webhook) (string, error) {
+	return dbh.primary.CreateWebhook(webhook)
+}
+
+// GetWebhooks reads from the primary so that the admin sees a webhook right
+// after adding it.
+func (dbh *ReplicaDatabaseHandler) GetWebhooks() ([]Webhook, error) {
+	return dbh.primary.GetWebhooks()
+}
+
+func (dbh *ReplicaDatabaseHandler) DeactivateWebhook(webhookID string) error {
+	return dbh.primary.DeactivateWebhook(webhookID)
+}
+
+// GetWebhookDeliveries reads from the primary so that the admin sees the
+// latest attempts.
+func (dbh *ReplicaDatabaseHandler) GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	return dbh.primary.GetWebhookDeliveries(webhookID, limit)
+}
+
+func (dbh *ReplicaDatabaseHandler) DispatchWebhookEvents(limit int) (int, error) {
+	return dbh.primary.DispatchWebhookEvents(limit)
+}
+
+func (dbh *ReplicaDatabaseHandler) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	return dbh.primary.ClaimWebhookDeliveries(now, lease, limit)
+}
+
+func (dbh *ReplicaDatabaseHandler) RecordWebhookAttempt(deliveryID string, attempt WebhookAttempt) error {
+	return dbh.primary.RecordWebhookAttempt(deliveryID, attempt)
+}
+
+func (dbh *ReplicaDatabaseHandler) EnqueueCheckout(order CheckoutRequest, maxDepth int) (string, error) {
+	return dbh.primary.EnqueueCheckout(order, maxDepth)
+}
+
+// GetCheckoutJob reads from the primary so that the customer sees the job
+// right after placing the order.
+func (dbh *ReplicaDatabaseHandler) GetCheckoutJob(jobID string) (CheckoutJob, error) {
+	return dbh.primary.GetCheckoutJob(jobID)
+}
+
+func (dbh *ReplicaDatabaseHandler) ClaimCheckoutJobs(now time.Time, lease time.Duration, limit int) ([]CheckoutJob, error) {
+	return dbh.primary.ClaimCheckoutJobs(now, lease, limit)
+}
+
+func (dbh *ReplicaDatabaseHandler) FinishCheckoutJob(jobID string, status string, message string) error {
+	return dbh.primary.FinishCheckoutJob(jobID, status, message)
+}
+
+func (dbh *ReplicaDatabaseHandler) GetCheckoutQueueDepth() (int, error) {
+	return dbh.primary.GetCheckoutQueueDepth()
+}
+
+func (dbh *ReplicaDatabaseHandler) CreateReview(review Review) (string, error) {
+	return dbh.primary.CreateReview(review)
+}
+
+// GetProductReviews reads from the replicas like the product it is shown with.
+func (dbh *ReplicaDatabaseHandler) GetProductReviews(productID int, limit int) ([]Review, error) {
+	var reviews []Review
+	err := dbh.read(func(reader ProdDatabaseHandler) (err error) {
+		reviews, err = reader.GetProductReviews(productID, limit)
+		return err
+	})
+
+	return reviews, err
+}
+
+// GetReviews reads from the primary so that the admin sees the review right
+// after moderating it.
+func (dbh *ReplicaDatabaseHandler) GetReviews(status string, limit int) ([]Review, error) {
+	return dbh.primary.GetReviews(status, limit)
+}
+
+func (dbh *ReplicaDatabaseHandler) UpdateReviewStatus(reviewID string, status string) error {
+	return dbh.primary.UpdateReviewStatus(reviewID, status)
+}
+
+func (dbh *ReplicaDatabaseHandler) AddWishlistItem(userID int, productID int) error {
+	return dbh.primary.AddWishlistItem(userID, productID)
+}
+
+// GetWishlist reads from the primary so that a user sees a product right
+// after saving it.
+func (dbh *ReplicaDatabaseHandler) GetWishlist(userID int) ([]WishlistItem, error) {
+	return dbh.primary.GetWishlist(userID)
+}
+
+func (dbh *ReplicaDatabaseHandler) RemoveWishlistItem(userID int, productID int) error {
+	return dbh.primary.RemoveWishlistItem(userID, productID)
+}
+
+func (dbh *ReplicaDatabaseHandler) UpdateProductPrice(productID int, price int64) (PriceChange, error) {
+	return dbh.primary.UpdateProductPrice(productID, price)
+}
+
+// GetPurchaseStats reads from the replicas. The recommendations are
+// refreshed periodically, so a lag of the replicas doesn't matter.
+func (dbh *ReplicaDatabaseHandler) GetPurchaseStats() ([]PurchaseStat, error) {
+	var stats []PurchaseStat
+	err := dbh.read(func(reader ProdDatabaseHandler) (err error) {
+		stats, err = reader.GetPurchaseStats()
+		return err
+	})
+
+	return stats, err
+}
+
+func (dbh *ReplicaDatabaseHandler) CreateVariant(variant Variant) error {
+	return dbh.primary.CreateVariant(variant)
+}
+
+// GetVariants reads from the replicas like the product they are shown with.
+// The stock may lag behind, but the checkout takes it on the primary.
+func (dbh *ReplicaDatabaseHandler) GetVariants(productID int) ([]Variant, error) {
+	var variants []Variant
+	err := dbh.read(func(reader ProdDatabaseHandler) (err error) {
+		variants, err = reader.GetVariants(productID)
+		return err
+	})
+
+	return variants, err
+}
+
+func (dbh *ReplicaDatabaseHandler) UpdateVariantStock(sku string, stock int) error {
+	return dbh.primary.UpdateVariantStock(sku, stock)
+}
+
+func (dbh *ReplicaDatabaseHandler) GetSalesReport(from, to time.Time, currency string, limit int) (SalesReport, error) {
+	var report SalesReport
+	err := dbh.read(func(reader ProdDatabaseHandler) (err error) {
+		report, err = reader.GetSalesReport(from, to, currency, limit)
+		return err
+	})
+
+	return report, err
+}
+
+// ExportCheckouts streams the checkouts from a replica. The checkouts already
+// passed to fn can't be taken back, so the export is retried on the primary
+// only when the replica fails before the first one, and never for the errors
+// of fn.
+func (dbh *ReplicaDatabaseHandler) ExportCheckouts(filter CheckoutFilter, fn func(Checkout) error) error {
+	if filter.UserID != 0 && dbh.isRecentUserWrite(filter.UserID) {
+		return dbh.primary.ExportCheckouts(filter, fn)
+	}
+
+	reader, r := dbh.reader()
+	exported := false
+	err := reader.ExportCheckouts(filter, func(checkout Checkout) error {
+		exported = true
+		return fn(checkout)
+	})
+	var dbErr *Error
+	if err == nil || r == nil || exported || errors.As(err, &dbErr) {
+		return err
+	}
+
+	r.setHealthy(false)
+
+	return dbh.primary.ExportCheckouts(filter, fn)
+}
+
+// Backup reads the primary so that the backup has the latest writes.
+func (dbh *ReplicaDatabaseHandler) Backup(fn func(BackupRecord) error) error {
+	return dbh.primary.Backup(fn)
+}
+
+func (dbh *ReplicaDatabaseHandler) Restore(next func() (BackupRecord, error)) error {
+	return dbh.primary.Restore(next)
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockReplicaDatabaseHandler(t *testing.T) (*ReplicaDatabaseHandler, sqlmock.Sqlmock, sqlmock.Sqlmock) {
+	primaryDB, primaryMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replicaDB, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewReplicaDatabaseHandler(primaryDB, []*sql.DB{replicaDB}, time.Minute), primaryMock, replicaMock
+}
+
+func TestReplicaGetProductReadsFromReplica(t *testing.T) {
+	dbh, primaryMock, replicaMock := newMockReplicaDatabaseHandler(t)
+
+	p := Product{ID: 1, Name: "Product00001", Price: money.New(15000, "USD"), Image: "product00001.jpg"}
+	replicaMock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, price, currency, weight, image, review_count, rating_total FROM products WHERE id = $1`)).
+		WithArgs(p.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price", "currency", "weight", "image", "review_count", "rating_total"}).AddRow(p.ID, p.Name, p.Price.Amount, p.Price.Currency, p.Weight, p.Image, p.Rating.Count, p.Rating.Total))
+
+	product, err := dbh.GetProduct(p.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, p, product)
+	assert.Nil(t, replicaMock.ExpectationsWereMet())
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+}
+
+func TestReplicaFailsOverToPrimary(t *testing.T) {
+	dbh, primaryMock, replicaMock := newMockReplicaDatabaseHandler(t)
+
+	p := Product{ID: 1, Name: "Product00001", Price: money.New(15000, "USD"), Image: "product00001.jpg"}
+	replicaMock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, price, currency, weight, image, review_count, rating_total FROM products WHERE id = $1`)).
+		WillReturnError(errors.New("connection refused"))
+	primaryMock.ExpectQuery(regexp.QuoteMeta(`SELECT id, name, price, currency, weight, image, review_count, rating_total FROM products WHERE id = $1`)).
+		WithArgs(p.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "price", "currency", "weight", "image", "review_count", "rating_total"}).AddRow(p.ID, p.Name, p.Price.Amount, p.Price.Currency, p.Weight, p.Image, p.Rating.Count, p.Rating.Total))
+
+	product, err := dbh.GetProduct(p.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, p, product)
+	assert.False(t, dbh.replicas[0].isHealthy())
+	assert.Nil(t, replicaMock.ExpectationsWereMet())
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+}
+
+func TestReplicaReadYourWrites(t *testing.T) {
+	dbh, primaryMock, replicaMock := newMockReplicaDatabaseHandler(t)
+
+	primaryMock.ExpectBegin()
+	primaryMock.ExpectQuery(regexp.QuoteMeta(`SELECT price, currency, weight, EXISTS (SELECT 1 FROM product_variants WHERE product_id = products.id) FROM products WHERE id = $1`)).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"price", "currency", "weight", "has_variants"}).AddRow(10000, "USD", 125, false))
+	primaryMock.ExpectExec(regexp.QuoteMeta(`INSERT INTO checkouts`)).
+		WithArgs(sqlmock.AnyArg(), 1, 2, "", "", 3, nil, "", "USD", 30000, 0, 0, 0, 30000, PaymentPending, "", "", "", "", "", "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	primaryMock.ExpectExec(regexp.QuoteMeta(`INSERT INTO webhook_events`)).
+		WithArgs(sqlmock.AnyArg(), WebhookEventCheckoutCreated, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	primaryMock.ExpectCommit()
+	primaryMock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($1, $2)`)).
+		WithArgs(checkoutUpdatesChannel, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	checkoutID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 1, ProductID: 2, ProductQuantity: 3})
+	assert.Nil(t, err)
+
+	primaryMock.ExpectQuery(regexp.QuoteMeta(`WHERE checkouts.id = $1`)).
+		WithArgs(checkoutID).
+		WillReturnRows(sqlmock.NewRows([]string{"checkout_id", "user_id", "user_name", "user_email", "product_id", "product_name", "product_price", "product_currency", "product_weight", "product_image", "variant_sku", "variant_name", "product_quantity", "coupon_code", "tax_region", "currency", "subtotal", "discount", "tax", "shipping", "total", "payment_status", "payment_id", "payment_error", "shipping_name", "shipping_line1", "shipping_line2", "shipping_city", "shipping_postal_code", "shipping_country", "created_at"}).
+			AddRow(checkoutID, 1, "user00001", "user00001@example.com", 2, "Product00002", 10000, "USD", 125, "product00002.jpg", "", "", 3, "", "", "USD", 30000, 0, 0, 0, 30000, PaymentPending, "", "", "", "", "", "", "", "", time.Now()))
+
+	checkout, err := dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, checkoutID, checkout.ID)
+	assert.Nil(t, replicaMock.ExpectationsWereMet())
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+}
+
+func TestReplicaExportCheckoutsFailsOverBeforeTheFirstCheckout(t *testing.T) {
+	columns := make([]string, 31)
+	for i := range columns {
+		columns[i] = fmt.Sprintf("column%d", i)
+	}
+	row := func(checkoutID string) []driver.Value {
+		return []driver.Value{
+			checkoutID, 2, "scstore", "scstore@example.com", 1, "Product00001", 15000, "USD", 125, "product00001.jpg",
+			"", "", 1, "", "", "USD", 15000, 0, 0, 0, 15000, PaymentPaid, "fake_00001", "",
+			"", "", "", "", "", "", time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	query := regexp.QuoteMeta("ORDER BY checkouts.created_at, checkouts.id")
+
+	// The primary takes over when the replica fails before the first checkout.
+	dbh, primaryMock, replicaMock := newMockReplicaDatabaseHandler(t)
+	replicaMock.ExpectQuery(query).WillReturnError(errors.New("connection refused"))
+	primaryMock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(columns).AddRow(row("checkout1")...))
+
+	var checkoutIDs []string
+	err := dbh.ExportCheckouts(CheckoutFilter{}, func(checkout Checkout) error {
+		checkoutIDs = append(checkoutIDs, checkout.ID)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"checkout1"}, checkoutIDs)
+	assert.Nil(t, replicaMock.ExpectationsWereMet())
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+
+	// It doesn't once checkouts were exported, which would export them twice.
+	dbh, primaryMock, replicaMock = newMockReplicaDatabaseHandler(t)
+	replicaMock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(columns).
+		AddRow(row("checkout1")...).
+		AddRow(row("checkout2")...).
+		RowError(1, errors.New("connection reset")))
+
+	checkoutIDs = nil
+	err = dbh.ExportCheckouts(CheckoutFilter{}, func(checkout Checkout) error {
+		checkoutIDs = append(checkoutIDs, checkout.ID)
+		return nil
+	})
+	assert.EqualError(t, err, "connection reset")
+	assert.Equal(t, []string{"checkout1"}, checkoutIDs)
+	assert.Nil(t, replicaMock.ExpectationsWereMet())
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+}
+
+func TestReplicaRememberUserWrite(t *testing.T) {
+	columns := []string{"checkout_id", "user_id", "user_name", "user_email", "product_id", "product_name", "product_price", "product_currency", "product_weight", "product_image", "variant_sku", "variant_name", "product_quantity", "coupon_code", "tax_region", "currency", "subtotal", "discount", "tax", "shipping", "total", "payment_status", "payment_id", "payment_error", "shipping_name", "shipping_line1", "shipping_line2", "shipping_city", "shipping_postal_code", "shipping_country", "created_at"}
+	row := func(status string) []driver.Value {
+		return []driver.Value{"checkout1", 2, "scstore", "scstore@example.com", 1, "Product00001", 15000, "USD", 125, "product00001.jpg",
+			"", "", 1, "", "", "USD", 15000, 0, 0, 0, 15000, status, "", "", "", "", "", "", "", "", time.Now()}
+	}
+	query := regexp.QuoteMeta(`WHERE checkouts.id = $1`)
+
+	// A checkout which is not on the replica yet is read from the primary.
+	dbh, primaryMock, replicaMock := newMockReplicaDatabaseHandler(t)
+	replicaMock.ExpectQuery(query).WithArgs("checkout1").WillReturnRows(sqlmock.NewRows(columns))
+	primaryMock.ExpectQuery(query).WithArgs("checkout1").WillReturnRows(sqlmock.NewRows(columns).AddRow(row(PaymentPending)...))
+
+	checkout, err := dbh.GetCheckout("checkout1")
+	assert.Nil(t, err)
+	assert.Equal(t, "checkout1", checkout.ID)
+	assert.Nil(t, replicaMock.ExpectationsWereMet())
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+
+	// After a write of the user made by another instance, its checkouts are
+	// read from the primary.
+	dbh.RememberUserWrite(2, time.Now().Add(-time.Second))
+	replicaMock.ExpectQuery(query).WithArgs("checkout1").WillReturnRows(sqlmock.NewRows(columns).AddRow(row(PaymentPending)...))
+	primaryMock.ExpectQuery(query).WithArgs("checkout1").WillReturnRows(sqlmock.NewRows(columns).AddRow(row(PaymentPaid)...))
+
+	checkout, err = dbh.GetCheckout("checkout1")
+	assert.Nil(t, err)
+	assert.Equal(t, PaymentPaid, checkout.Payment.Status)
+	assert.True(t, dbh.isRecentUserWrite(2))
+
+	// A write older than the window is forgotten.
+	dbh.RememberUserWrite(3, time.Now().Add(-2*time.Minute))
+	assert.False(t, dbh.isRecentUserWrite(3))
+	assert.Nil(t, replicaMock.ExpectationsWereMet())
+	assert.Nil(t, primaryMock.ExpectationsWereMet())
+}
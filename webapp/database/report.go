@@ -0,0 +1,118 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+// SalesDayLayout is the format of the days of the sales reports.
+const SalesDayLayout = "2006-01-02"
+
+// SalesReportMaxDays is the longest window of a sales report.
+const SalesReportMaxDays = 366
+
+// SalesTotals are the orders, the units and the revenue of a group of paid
+// checkouts. The revenue is what the customers were charged, with the taxes
+// and the shipping fees.
+type SalesTotals struct {
+	Orders  int
+	Units   int
+	Revenue money.Money
+}
+
+// AverageOrderValue returns the revenue per order rounded to the minor unit.
+func (totals SalesTotals) AverageOrderValue() money.Money {
+	if totals.Orders == 0 {
+		return money.New(0, totals.Revenue.Currency)
+	}
+
+	orders := int64(totals.Orders)
+	return money.New((totals.Revenue.Amount+orders/2)/orders, totals.Revenue.Currency)
+}
+
+// DailySales are the sales of one day in UTC.
+type DailySales struct {
+	Day time.Time
+	SalesTotals
+}
+
+// ProductSales are the sales of a product, with its variants.
+type ProductSales struct {
+	Product Product
+	SalesTotals
+}
+
+// CustomerSales are the orders of a user.
+type CustomerSales struct {
+	User User
+	SalesTotals
+}
+
+// SalesReport is the sales of the paid checkouts in one currency from the
+// day From until the day before To. Days without sales are left out of Days.
+// The top products and customers have the most revenue first.
+type SalesReport struct {
+	From     time.Time
+	To       time.Time
+	Currency string
+	SalesTotals
+	// Customers is the number of users who ordered.
+	Customers    int
+	Days         []DailySales
+	TopProducts  []ProductSales
+	TopCustomers []CustomerSales
+}
+
+// OrdersPerCustomer returns the average number of orders of the users who
+// ordered.
+func (report SalesReport) OrdersPerCustomer() float64 {
+	if report.Customers == 0 {
+		return 0
+	}
+
+	return float64(report.Orders) / float64(report.Customers)
+}
+
+// newSalesReport checks the window of a report. from and to are truncated to
+// their day in UTC.
+func newSalesReport(from, to time.Time, currency string) (SalesReport, error) {
+	report := SalesReport{
+		From:        from.UTC().Truncate(24 * time.Hour),
+		To:          to.UTC().Truncate(24 * time.Hour),
+		Currency:    currency,
+		SalesTotals: SalesTotals{Revenue: money.New(0, currency)},
+	}
+
+	fields := map[string]string{}
+	if !report.To.After(report.From) {
+		fields["to"] = "The end must be after the start."
+	} else if report.To.Sub(report.From) > SalesReportMaxDays*24*time.Hour {
+		fields["to"] = fmt.Sprintf("A report covers at most %d days.", SalesReportMaxDays)
+	}
+	if !money.IsSupported(currency) {
+		fields["currency"] = "The currency is not supported."
+	}
+	if len(fields) > 0 {
+		return report, NewFieldValidationError(fields)
+	}
+
+	return report, nil
+}
+
+// addDay adds the sales of a day read as YYYY-MM-DD to the report and its
+// totals.
+func (report *SalesReport) addDay(day string, totals SalesTotals) error {
+	date, err := time.Parse(SalesDayLayout, day)
+	if err != nil {
+		return err
+	}
+
+	report.Days = append(report.Days, DailySales{Day: date, SalesTotals: totals})
+	report.Orders += totals.Orders
+	report.Units += totals.Units
+	report.Revenue = report.Revenue.Add(totals.Revenue)
+
+	return nil
+}
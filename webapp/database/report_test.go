@@ -0,0 +1,44 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSalesTotalsAverageOrderValue(t *testing.T) {
+	assert.Equal(t, money.New(3333, "USD"), SalesTotals{Orders: 3, Revenue: money.New(10000, "USD")}.AverageOrderValue())
+	assert.Equal(t, money.New(6667, "USD"), SalesTotals{Orders: 3, Revenue: money.New(20000, "USD")}.AverageOrderValue())
+	assert.Equal(t, money.New(0, "JPY"), SalesTotals{Revenue: money.New(0, "JPY")}.AverageOrderValue())
+}
+
+func TestNewSalesReport(t *testing.T) {
+	from := time.Date(2026, 10, 1, 15, 4, 5, 0, time.UTC)
+	report, err := newSalesReport(from, from.AddDate(0, 0, 7), "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), report.From)
+	assert.Equal(t, time.Date(2026, 10, 8, 0, 0, 0, 0, time.UTC), report.To)
+	assert.Equal(t, money.New(0, "USD"), report.Revenue)
+
+	assert.Nil(t, report.addDay("2026-10-02", SalesTotals{Orders: 2, Units: 3, Revenue: money.New(500, "USD")}))
+	assert.Nil(t, report.addDay("2026-10-04", SalesTotals{Orders: 1, Units: 1, Revenue: money.New(100, "USD")}))
+	assert.Equal(t, SalesTotals{Orders: 3, Units: 4, Revenue: money.New(600, "USD")}, report.SalesTotals)
+	assert.Equal(t, time.Date(2026, 10, 4, 0, 0, 0, 0, time.UTC), report.Days[1].Day)
+
+	for _, window := range []struct {
+		to       time.Time
+		currency string
+		field    string
+	}{
+		{from, "USD", "to"},
+		{from.AddDate(0, 0, SalesReportMaxDays+1), "USD", "to"},
+		{from.AddDate(0, 0, 1), "XXX", "currency"},
+	} {
+		_, err := newSalesReport(from, window.to, window.currency)
+		var dbErr *Error
+		assert.ErrorAs(t, err, &dbErr)
+		assert.Contains(t, dbErr.Fields, window.field)
+	}
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The states of a review. Flagged reviews are still shown but wait for an
+// admin to look at them. Hidden reviews are neither shown nor counted in the
+// rating of the product.
+const (
+	ReviewVisible = "visible"
+	ReviewFlagged = "flagged"
+	ReviewHidden  = "hidden"
+)
+
+// reviewBodyMaxLength is the length of the text of a review in characters.
+const reviewBodyMaxLength = 2000
+
+// Review is the rating of a product from 1 to 5 stars with a text by a user
+// who has bought it. A user reviews a product once.
+type Review struct {
+	ID        string
+	ProductID int
+	User      User
+	Rating    int
+	Body      string
+	Status    string
+	CreatedAt time.Time
+}
+
+// Validate checks the fields of a review entered by a user.
+func (review Review) Validate() error {
+	fields := make(map[string]string)
+
+	if review.Rating < 1 || review.Rating > 5 {
+		fields["rating"] = "Rating must be 1 to 5 stars."
+	}
+
+	if length := utf8.RuneCountInString(strings.TrimSpace(review.Body)); length == 0 || length > reviewBodyMaxLength {
+		fields["body"] = fmt.Sprintf("Review must be 1 to %d characters.", reviewBodyMaxLength)
+	}
+
+	if len(fields) > 0 {
+		return NewFieldValidationError(fields)
+	}
+
+	return nil
+}
+
+// Shown reports whether the review is shown and counted in the rating.
+func (review Review) Shown() bool {
+	return review.Status != ReviewHidden
+}
+
+func validateReviewStatus(status string) error {
+	switch status {
+	case ReviewVisible, ReviewFlagged, ReviewHidden:
+		return nil
+	default:
+		return NewValidationError("review status must be visible, flagged or hidden")
+	}
+}
+
+// Rating sums up the shown reviews of a product. It is kept on the product
+// so that the catalog is read without the reviews.
+type Rating struct {
+	Count int
+	Total int
+}
+
+// Average returns the mean number of stars, or 0 without reviews.
+func (rating Rating) Average() float64 {
+	if rating.Count == 0 {
+		return 0
+	}
+
+	return float64(rating.Total) / float64(rating.Count)
+}
+
+// String formats the average with one decimal like 4.5.
+func (rating Rating) String() string {
+	return fmt.Sprintf("%.1f", rating.Average())
+}
+
+// ratingChange returns how the rating of the product changes when the review
+// goes from the status from to the status to.
+func ratingChange(rating int, from string, to string) (count int, total int) {
+	wasShown, isShown := from != ReviewHidden, to != ReviewHidden
+	switch {
+	case wasShown && !isShown:
+		return -1, -rating
+	case !wasShown && isShown:
+		return 1, rating
+	default:
+		return 0, 0
+	}
+}
+
+func errProductNotBought() error {
+	return NewValidationError("You can review only the products you have bought.")
+}
+
+func errAlreadyReviewed() error {
+	return NewConflictError("You have already reviewed this product.")
+}
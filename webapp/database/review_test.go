@@ -0,0 +1,48 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReviewValidate(t *testing.T) {
+	assert.Nil(t, Review{Rating: 5, Body: "Keeps good time."}.Validate())
+
+	err := Review{Rating: 6, Body: " "}.Validate()
+	assert.ErrorIs(t, err, ErrValidation)
+
+	var dbErr *Error
+	assert.ErrorAs(t, err, &dbErr)
+	assert.Equal(t, 2, len(dbErr.Fields))
+	assert.Contains(t, dbErr.Fields, "rating")
+	assert.Contains(t, dbErr.Fields, "body")
+
+	assert.ErrorIs(t, Review{Rating: 0, Body: "Too early."}.Validate(), ErrValidation)
+	assert.ErrorIs(t, Review{Rating: 3, Body: strings.Repeat("a", reviewBodyMaxLength+1)}.Validate(), ErrValidation)
+}
+
+func TestRating(t *testing.T) {
+	assert.Equal(t, 0.0, Rating{}.Average())
+	assert.Equal(t, 4.5, Rating{Count: 2, Total: 9}.Average())
+	assert.Equal(t, "4.3", Rating{Count: 3, Total: 13}.String())
+}
+
+func TestRatingChange(t *testing.T) {
+	for _, tt := range []struct {
+		from, to     string
+		count, total int
+	}{
+		{ReviewVisible, ReviewFlagged, 0, 0},
+		{ReviewFlagged, ReviewVisible, 0, 0},
+		{ReviewVisible, ReviewHidden, -1, -4},
+		{ReviewFlagged, ReviewHidden, -1, -4},
+		{ReviewHidden, ReviewVisible, 1, 4},
+		{ReviewHidden, ReviewHidden, 0, 0},
+	} {
+		count, total := ratingChange(4, tt.from, tt.to)
+		assert.Equal(t, tt.count, count, tt.from+" to "+tt.to)
+		assert.Equal(t, tt.total, total, tt.from+" to "+tt.to)
+	}
+}
@@ -0,0 +1,36 @@
+package database
+
+import "github.com/mittz/role-play-webapp/webapp/money"
+
+const (
+	ShippingKindFlat   = "flat"
+	ShippingKindWeight = "weight"
+)
+
+// ShippingRate is how the shipping fee of an order is computed. Amount is in
+// the minor units of the order currency: the fee of the order for flat rates
+// and the fee per started kilogram for weight rates. Orders whose subtotal
+// after the discount reaches FreeOver ship for free; 0 means never.
+// The zero value ships every order for free.
+type ShippingRate struct {
+	Kind     string
+	Amount   int64
+	FreeOver int64
+}
+
+// Fee returns the shipping fee of an order of grams which costs subtotal.
+func (rate ShippingRate) Fee(subtotal money.Money, grams int) money.Money {
+	fee := money.New(0, subtotal.Currency)
+	if rate.FreeOver > 0 && subtotal.Amount >= rate.FreeOver {
+		return fee
+	}
+
+	switch rate.Kind {
+	case ShippingKindFlat:
+		fee.Amount = rate.Amount
+	case ShippingKindWeight:
+		fee.Amount = rate.Amount * int64((grams+999)/1000)
+	}
+
+	return fee
+}
@@ -0,0 +1,19 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShippingRateFee(t *testing.T) {
+	subtotal := money.New(35000, "USD")
+
+	assert.Equal(t, money.New(0, "USD"), ShippingRate{}.Fee(subtotal, 125))
+	assert.Equal(t, money.New(700, "USD"), ShippingRate{Kind: ShippingKindFlat, Amount: 700}.Fee(subtotal, 125))
+	assert.Equal(t, money.New(500, "USD"), ShippingRate{Kind: ShippingKindWeight, Amount: 500}.Fee(subtotal, 1000))
+	assert.Equal(t, money.New(1000, "USD"), ShippingRate{Kind: ShippingKindWeight, Amount: 500}.Fee(subtotal, 1001))
+	assert.Equal(t, money.New(0, "USD"), ShippingRate{Kind: ShippingKindFlat, Amount: 700, FreeOver: 35000}.Fee(subtotal, 125))
+	assert.Equal(t, money.New(700, "USD"), ShippingRate{Kind: ShippingKindFlat, Amount: 700, FreeOver: 35001}.Fee(subtotal, 125))
+}
@@ -0,0 +1,2490 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	dbadmin "cloud.google.com/go/spanner/admin/database/apiv1"
+	"github.com/google/uuid"
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"google.golang.org/api/iterator"
+	databasepb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
+	"google.golang.org/grpc/codes"
+)
+
+// DefaultSpannerStaleness is how old the catalog may be when products are read.
+// Stale reads are served by the nearest replica without waiting for the leader.
+const DefaultSpannerStaleness = 15 * time.Second
+
+// spannerMutationBatchSize keeps each commit well below the mutation limit.
+const spannerMutationBatchSize = 1000
+
+type SpannerDatabaseHandler struct {
+	Client    *spanner.Client
+	Staleness time.Duration
+
+	// initializedAt holds the UnixNano of the last InitDatabase, or of the
+	// last MigrateDatabase which created tables, so that the catalog is read
+	// strongly until the stale reads can see the new data.
+	initializedAt *int64
+}
+
+func NewSpannerDatabaseHandler(client *spanner.Client) SpannerDatabaseHandler {
+	return SpannerDatabaseHandler{Client: client, Staleness: DefaultSpannerStaleness, initializedAt: new(int64)}
+}
+
+// spannerSchema creates the tables with their indexes, the parent tables and
+// the tables referenced by the others first.
+var spannerSchema = []struct {
+	table      string
+	statements []string
+}{
+	{"products", []string{
+		`CREATE TABLE products (
+			id INT64 NOT NULL,
+			name STRING(20) NOT NULL,
+			price INT64 NOT NULL,
+			currency STRING(3) NOT NULL,
+			weight INT64 NOT NULL,
+			image STRING(100) NOT NULL,
+			review_count INT64 NOT NULL,
+			rating_total INT64 NOT NULL,
+		) PRIMARY KEY (id)`,
+	}},
+	{"users", []string{
+		`CREATE TABLE users (
+			id INT64 NOT NULL,
+			name STRING(20) NOT NULL,
+			email STRING(254) NOT NULL,
+		) PRIMARY KEY (id)`,
+	}},
+	{"addresses", []string{
+		`CREATE TABLE addresses (
+			user_id INT64 NOT NULL,
+			id STRING(40) NOT NULL,
+			name STRING(50) NOT NULL,
+			line1 STRING(100) NOT NULL,
+			line2 STRING(100) NOT NULL,
+			city STRING(50) NOT NULL,
+			postal_code STRING(20) NOT NULL,
+			country STRING(2) NOT NULL,
+			created_at TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+		) PRIMARY KEY (user_id, id),
+		INTERLEAVE IN PARENT users ON DELETE CASCADE`,
+	}},
+	{"coupons", []string{
+		`CREATE TABLE coupons (
+			code STRING(40) NOT NULL,
+			kind STRING(10) NOT NULL,
+			value INT64 NOT NULL,
+			min_order INT64 NOT NULL,
+			expires_at TIMESTAMP,
+			max_uses INT64 NOT NULL,
+			max_uses_per_user INT64 NOT NULL,
+			uses INT64 NOT NULL,
+			active BOOL NOT NULL,
+			CONSTRAINT chk_coupons_kind CHECK (kind IN ('percent', 'fixed')),
+			CONSTRAINT chk_coupons_value CHECK (value > 0),
+		) PRIMARY KEY (code)`,
+	}},
+	{"checkouts", []string{
+		`CREATE TABLE checkouts (
+			user_id INT64 NOT NULL,
+			id STRING(40) NOT NULL,
+			product_id INT64 NOT NULL,
+			variant_sku STRING(40) NOT NULL,
+			variant_name STRING(50) NOT NULL,
+			product_quantity INT64 NOT NULL,
+			coupon_code STRING(40),
+			tax_region STRING(20) NOT NULL,
+			currency STRING(3) NOT NULL,
+			subtotal INT64 NOT NULL,
+			discount INT64 NOT NULL,
+			tax INT64 NOT NULL,
+			shipping INT64 NOT NULL,
+			total INT64 NOT NULL,
+			payment_status STRING(10) NOT NULL,
+			payment_id STRING(100) NOT NULL,
+			payment_error STRING(200) NOT NULL,
+			shipping_name STRING(50) NOT NULL,
+			shipping_line1 STRING(100) NOT NULL,
+			shipping_line2 STRING(100) NOT NULL,
+			shipping_city STRING(50) NOT NULL,
+			shipping_postal_code STRING(20) NOT NULL,
+			shipping_country STRING(2) NOT NULL,
+			created_at TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+			CONSTRAINT fk_checkouts_products FOREIGN KEY (product_id) REFERENCES products (id),
+			CONSTRAINT fk_checkouts_coupons FOREIGN KEY (coupon_code) REFERENCES coupons (code),
+			CONSTRAINT chk_checkouts_product_quantity CHECK (product_quantity > 0),
+			CONSTRAINT chk_checkouts_amounts CHECK (discount >= 0 AND tax >= 0 AND shipping >= 0 AND total >= 0),
+			CONSTRAINT chk_checkouts_payment_status CHECK (payment_status IN ('pending', 'charging', 'paid', 'failed', 'refunding', 'refunded')),
+		) PRIMARY KEY (user_id, id),
+		INTERLEAVE IN PARENT users ON DELETE CASCADE`,
+		"CREATE UNIQUE INDEX checkouts_by_id ON checkouts(id)",
+		"CREATE INDEX checkouts_by_created_at ON checkouts(created_at)",
+	}},
+	{"webhooks", []string{
+		`CREATE TABLE webhooks (
+			id STRING(40) NOT NULL,
+			url STRING(500) NOT NULL,
+			secret STRING(100) NOT NULL,
+			event_types STRING(200) NOT NULL,
+			active BOOL NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+		) PRIMARY KEY (id)`,
+	}},
+	{"webhook_events", []string{
+		`CREATE TABLE webhook_events (
+			id STRING(40) NOT NULL,
+			type STRING(50) NOT NULL,
+			payload STRING(MAX) NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			dispatched_at TIMESTAMP,
+		) PRIMARY KEY (id)`,
+		"CREATE INDEX webhook_events_by_dispatched_at ON webhook_events(dispatched_at, created_at)",
+	}},
+	{"webhook_deliveries", []string{
+		`CREATE TABLE webhook_deliveries (
+			id STRING(40) NOT NULL,
+			webhook_id STRING(40) NOT NULL,
+			event_id STRING(40) NOT NULL,
+			status STRING(10) NOT NULL,
+			attempts INT64 NOT NULL,
+			next_attempt_at TIMESTAMP NOT NULL,
+			last_status_code INT64 NOT NULL,
+			last_error STRING(200) NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			CONSTRAINT fk_webhook_deliveries_webhooks FOREIGN KEY (webhook_id) REFERENCES webhooks (id),
+			CONSTRAINT fk_webhook_deliveries_events FOREIGN KEY (event_id) REFERENCES webhook_events (id),
+			CONSTRAINT chk_webhook_deliveries_status CHECK (status IN ('pending', 'succeeded', 'failed')),
+		) PRIMARY KEY (id)`,
+		"CREATE INDEX webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at)",
+	}},
+	{"checkout_jobs", []string{
+		`CREATE TABLE checkout_jobs (
+			id STRING(40) NOT NULL,
+			user_id INT64 NOT NULL,
+			request STRING(MAX) NOT NULL,
+			status STRING(10) NOT NULL,
+			attempts INT64 NOT NULL,
+			locked_until TIMESTAMP NOT NULL,
+			error STRING(500) NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			CONSTRAINT fk_checkout_jobs_users FOREIGN KEY (user_id) REFERENCES users (id),
+			CONSTRAINT chk_checkout_jobs_status CHECK (status IN ('queued', 'processing', 'done', 'failed')),
+		) PRIMARY KEY (id)`,
+		"CREATE INDEX checkout_jobs_due ON checkout_jobs(status, locked_until)",
+	}},
+	{"reviews", []string{
+		`CREATE TABLE reviews (
+			id STRING(40) NOT NULL,
+			product_id INT64 NOT NULL,
+			user_id INT64 NOT NULL,
+			rating INT64 NOT NULL,
+			body STRING(2000) NOT NULL,
+			status STRING(10) NOT NULL,
+			created_at TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+			CONSTRAINT fk_reviews_products FOREIGN KEY (product_id) REFERENCES products (id),
+			CONSTRAINT fk_reviews_users FOREIGN KEY (user_id) REFERENCES users (id),
+			CONSTRAINT chk_reviews_rating CHECK (rating BETWEEN 1 AND 5),
+			CONSTRAINT chk_reviews_status CHECK (status IN ('visible', 'flagged', 'hidden')),
+		) PRIMARY KEY (id)`,
+		"CREATE UNIQUE INDEX reviews_by_product_user ON reviews(product_id, user_id)",
+		"CREATE INDEX reviews_by_product_created_at ON reviews(product_id, created_at DESC)",
+		"CREATE INDEX reviews_by_status ON reviews(status, created_at DESC)",
+	}},
+	{"wishlist_items", []string{
+		`CREATE TABLE wishlist_items (
+			user_id INT64 NOT NULL,
+			product_id INT64 NOT NULL,
+			created_at TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+			CONSTRAINT fk_wishlist_items_products FOREIGN KEY (product_id) REFERENCES products (id),
+		) PRIMARY KEY (user_id, product_id),
+		INTERLEAVE IN PARENT users ON DELETE CASCADE`,
+		"CREATE INDEX wishlist_items_by_product ON wishlist_items(product_id)",
+	}},
+	{"product_variants", []string{
+		`CREATE TABLE product_variants (
+			product_id INT64 NOT NULL,
+			sku STRING(40) NOT NULL,
+			name STRING(50) NOT NULL,
+			price INT64,
+			stock INT64 NOT NULL,
+			image STRING(100) NOT NULL,
+			CONSTRAINT chk_product_variants_price CHECK (price > 0),
+			CONSTRAINT chk_product_variants_stock CHECK (stock >= 0),
+		) PRIMARY KEY (product_id, sku),
+		INTERLEAVE IN PARENT products ON DELETE CASCADE`,
+		"CREATE UNIQUE INDEX product_variants_by_sku ON product_variants(sku)",
+	}},
+}
+
+// spannerMigrations change the tables of the older versions of the schema,
+// in the order of their versions.
+var spannerMigrations = []schemaMigration{
+	{2, "products", "currency", []string{"ALTER TABLE products ADD COLUMN currency STRING(3) NOT NULL DEFAULT ('USD')"}},
+	{2, "products", "weight", []string{"ALTER TABLE products ADD COLUMN weight INT64 NOT NULL DEFAULT (0)"}},
+	{2, "products", "review_count", []string{"ALTER TABLE products ADD COLUMN review_count INT64 NOT NULL DEFAULT (0)"}},
+	{2, "products", "rating_total", []string{"ALTER TABLE products ADD COLUMN rating_total INT64 NOT NULL DEFAULT (0)"}},
+	{2, "users", "email", []string{"ALTER TABLE users ADD COLUMN email STRING(254) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "variant_sku", []string{"ALTER TABLE checkouts ADD COLUMN variant_sku STRING(40) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "variant_name", []string{"ALTER TABLE checkouts ADD COLUMN variant_name STRING(50) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "coupon_code", []string{"ALTER TABLE checkouts ADD COLUMN coupon_code STRING(40)"}},
+	{2, "checkouts", "tax_region", []string{"ALTER TABLE checkouts ADD COLUMN tax_region STRING(20) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "currency", []string{"ALTER TABLE checkouts ADD COLUMN currency STRING(3) NOT NULL DEFAULT ('USD')"}},
+	{2, "checkouts", "subtotal", []string{"ALTER TABLE checkouts ADD COLUMN subtotal INT64 NOT NULL DEFAULT (0)"}},
+	{2, "checkouts", "discount", []string{"ALTER TABLE checkouts ADD COLUMN discount INT64 NOT NULL DEFAULT (0)"}},
+	{2, "checkouts", "tax", []string{"ALTER TABLE checkouts ADD COLUMN tax INT64 NOT NULL DEFAULT (0)"}},
+	{2, "checkouts", "shipping", []string{"ALTER TABLE checkouts ADD COLUMN shipping INT64 NOT NULL DEFAULT (0)"}},
+	{2, "checkouts", "total", []string{"ALTER TABLE checkouts ADD COLUMN total INT64 NOT NULL DEFAULT (0)"}},
+	{2, "checkouts", "payment_status", []string{
+		"ALTER TABLE checkouts ADD COLUMN payment_status STRING(10) NOT NULL DEFAULT ('paid')",
+		"ALTER TABLE checkouts ADD CONSTRAINT chk_checkouts_payment_status CHECK (payment_status IN ('pending', 'paid', 'failed', 'refunded'))",
+	}},
+	{2, "checkouts", "payment_id", []string{"ALTER TABLE checkouts ADD COLUMN payment_id STRING(100) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "payment_error", []string{"ALTER TABLE checkouts ADD COLUMN payment_error STRING(200) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "shipping_name", []string{"ALTER TABLE checkouts ADD COLUMN shipping_name STRING(50) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "shipping_line1", []string{"ALTER TABLE checkouts ADD COLUMN shipping_line1 STRING(100) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "shipping_line2", []string{"ALTER TABLE checkouts ADD COLUMN shipping_line2 STRING(100) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "shipping_city", []string{"ALTER TABLE checkouts ADD COLUMN shipping_city STRING(50) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "shipping_postal_code", []string{"ALTER TABLE checkouts ADD COLUMN shipping_postal_code STRING(20) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "shipping_country", []string{"ALTER TABLE checkouts ADD COLUMN shipping_country STRING(2) NOT NULL DEFAULT ('')"}},
+	{2, "checkouts", "", []string{"CREATE INDEX checkouts_by_created_at ON checkouts(created_at)"}},
+	{3, "checkouts", "", []string{
+		"ALTER TABLE checkouts DROP CONSTRAINT chk_checkouts_payment_status",
+		"ALTER TABLE checkouts ADD CONSTRAINT chk_checkouts_payment_status CHECK (payment_status IN ('pending', 'charging', 'paid', 'failed', 'refunding', 'refunded'))",
+	}},
+}
+
+const spannerSchemaVersionTable = `CREATE TABLE schema_version (
+	id INT64 NOT NULL,
+	version INT64 NOT NULL,
+) PRIMARY KEY (id)`
+
+func (dbh SpannerDatabaseHandler) InitDatabase() error {
+	ctx := context.Background()
+
+	existingTables, err := dbh.existingTables(ctx)
+	if err != nil {
+		return err
+	}
+	existingIndexes, err := dbh.existingIndexes(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Interleaved checkouts and their index have to be dropped before users.
+	var statements []string
+	if existingTables["product_variants"] {
+		statements = append(statements, "DROP INDEX product_variants_by_sku", "DROP TABLE product_variants")
+	}
+	if existingTables["wishlist_items"] {
+		statements = append(statements, "DROP INDEX wishlist_items_by_product", "DROP TABLE wishlist_items")
+	}
+	if existingTables["reviews"] {
+		statements = append(statements, "DROP INDEX reviews_by_product_user", "DROP INDEX reviews_by_product_created_at", "DROP INDEX reviews_by_status", "DROP TABLE reviews")
+	}
+	if existingTables["checkout_jobs"] {
+		statements = append(statements, "DROP INDEX checkout_jobs_due", "DROP TABLE checkout_jobs")
+	}
+	if existingTables["webhook_deliveries"] {
+		statements = append(statements, "DROP INDEX webhook_deliveries_due", "DROP TABLE webhook_deliveries")
+	}
+	if existingTables["webhook_events"] {
+		statements = append(statements, "DROP INDEX webhook_events_by_dispatched_at", "DROP TABLE webhook_events")
+	}
+	if existingTables["webhooks"] {
+		statements = append(statements, "DROP TABLE webhooks")
+	}
+	if existingTables["checkouts"] {
+		// The databases at version 1 don't have the index of created_at.
+		if existingIndexes["checkouts_by_created_at"] {
+			statements = append(statements, "DROP INDEX checkouts_by_created_at")
+		}
+		statements = append(statements, "DROP INDEX checkouts_by_id", "DROP TABLE checkouts")
+	}
+	if existingTables["coupons"] {
+		statements = append(statements, "DROP TABLE coupons")
+	}
+	if existingTables["addresses"] {
+		statements = append(statements, "DROP TABLE addresses")
+	}
+	if existingTables["users"] {
+		statements = append(statements, "DROP TABLE users")
+	}
+	if existingTables["products"] {
+		statements = append(statements, "DROP TABLE products")
+	}
+
+	for _, table := range spannerSchema {
+		statements = append(statements, table.statements...)
+	}
+	if !existingTables["schema_version"] {
+		statements = append(statements, spannerSchemaVersionTable)
+	}
+
+	if err := dbh.updateDDL(ctx, statements); err != nil {
+		return err
+	}
+	if err := dbh.writeSchemaVersion(ctx); err != nil {
+		return err
+	}
+
+	return dbh.SeedDatabase()
+}
+
+// MigrateDatabase checks that the existing tables have every column or a
+// migration adding it, then applies the migrations newer than the version of
+// the schema to them and creates the tables which don't exist yet with their
+// indexes in one batch of DDL statements. The version is recorded once the
+// batch is done, so a failed batch is applied again by the next run.
+func (dbh SpannerDatabaseHandler) MigrateDatabase() error {
+	ctx := context.Background()
+
+	existingTables, err := dbh.existingTables(ctx)
+	if err != nil {
+		return err
+	}
+	version, err := dbh.readSchemaVersion(ctx, existingTables)
+	if err != nil {
+		return err
+	}
+	if err := checkSchemaVersion(version); err != nil {
+		return err
+	}
+	existingColumns, err := dbh.existingColumns(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkSchemaColumns(version, spannerMigrations, func(table string) (map[string]bool, error) {
+		return existingColumns[table], nil
+	}); err != nil {
+		return err
+	}
+
+	var statements []string
+	for _, migration := range spannerMigrations {
+		if migration.version <= version || !existingTables[migration.table] {
+			continue
+		}
+		if migration.column == "" || !existingColumns[migration.table][migration.column] {
+			statements = append(statements, migration.statements...)
+		}
+	}
+	for _, table := range spannerSchema {
+		if !existingTables[table.table] {
+			statements = append(statements, table.statements...)
+		}
+	}
+	if !existingTables["schema_version"] {
+		statements = append(statements, spannerSchemaVersionTable)
+	}
+
+	if len(statements) > 0 {
+		if err := dbh.updateDDL(ctx, statements); err != nil {
+			return err
+		}
+		// The stale reads can't see the new tables yet.
+		atomic.StoreInt64(dbh.initializedAt, time.Now().UnixNano())
+	}
+	if version < schemaVersion || !existingTables["schema_version"] {
+		return dbh.writeSchemaVersion(ctx)
+	}
+
+	return nil
+}
+
+// readSchemaVersion returns the version in schema_version. Without it, the
+// database is at version 1 if it has tables, and at schemaVersion if it is
+// new.
+func (dbh SpannerDatabaseHandler) readSchemaVersion(ctx context.Context, existingTables map[string]bool) (int, error) {
+	if existingTables["schema_version"] {
+		row, err := dbh.Client.Single().ReadRow(ctx, "schema_version", spanner.Key{1}, []string{"version"})
+		if err == nil {
+			var version int64
+			err := row.Columns(&version)
+			return int(version), err
+		}
+		if spanner.ErrCode(err) != codes.NotFound {
+			return 0, err
+		}
+	}
+
+	for _, table := range schemaTables {
+		if existingTables[table] {
+			return 1, nil
+		}
+	}
+
+	return schemaVersion, nil
+}
+
+func (dbh SpannerDatabaseHandler) writeSchemaVersion(ctx context.Context) error {
+	_, err := dbh.Client.Apply(ctx, []*spanner.Mutation{
+		spanner.InsertOrUpdate("schema_version", []string{"id", "version"}, []interface{}{1, schemaVersion}),
+	})
+	return err
+}
+
+// existingColumns reads the columns of every table at once.
+func (dbh SpannerDatabaseHandler) existingColumns(ctx context.Context) (map[string]map[string]bool, error) {
+	columns := make(map[string]map[string]bool)
+
+	stmt := spanner.Statement{SQL: "SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = ''"}
+	err := dbh.Client.Single().Query(ctx, stmt).Do(func(row *spanner.Row) error {
+		var table, column string
+		if err := row.Columns(&table, &column); err != nil {
+			return err
+		}
+		if columns[table] == nil {
+			columns[table] = make(map[string]bool)
+		}
+		columns[table][column] = true
+		return nil
+	})
+
+	return columns, err
+}
+
+// SeedDatabase inserts the products and users of initdata.json which are not
+// in the database yet, spannerMutationBatchSize mutations per commit.
+func (dbh SpannerDatabaseHandler) SeedDatabase() error {
+	data, err := readInitData()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	txn := dbh.Client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	productIDs, err := readSpannerIDs(ctx, txn, "products")
+	if err != nil {
+		return err
+	}
+	userIDs, err := readSpannerIDs(ctx, txn, "users")
+	if err != nil {
+		return err
+	}
+
+	var mutations []*spanner.Mutation
+	for _, product := range data.Products {
+		if productIDs[product.ID] {
+			continue
+		}
+
+		mutations = append(mutations, spanner.Insert("products",
+			[]string{"id", "name", "price", "currency", "weight", "image", "review_count", "rating_total"},
+			[]interface{}{product.ID, product.Name, product.Price, data.Currency, product.Weight, product.Image, 0, 0}))
+	}
+
+	for _, user := range data.Users {
+		if userIDs[user.ID] {
+			continue
+		}
+
+		mutations = append(mutations, spanner.Insert("users",
+			[]string{"id", "name", "email"},
+			[]interface{}{user.ID, user.Name, user.Email}))
+	}
+
+	for start := 0; start < len(mutations); start += spannerMutationBatchSize {
+		end := start + spannerMutationBatchSize
+		if end > len(mutations) {
+			end = len(mutations)
+		}
+
+		if _, err := dbh.Client.Apply(ctx, mutations[start:end]); err != nil {
+			return err
+		}
+	}
+
+	atomic.StoreInt64(dbh.initializedAt, time.Now().UnixNano())
+
+	return nil
+}
+
+// readSpannerIDs returns the IDs of the rows of the table.
+func readSpannerIDs(ctx context.Context, txn *spanner.ReadOnlyTransaction, table string) (map[int]bool, error) {
+	ids := make(map[int]bool)
+	err := txn.Read(ctx, table, spanner.AllKeys(), []string{"id"}).Do(func(row *spanner.Row) error {
+		var id int64
+		if err := row.Columns(&id); err != nil {
+			return err
+		}
+		ids[int(id)] = true
+		return nil
+	})
+
+	return ids, err
+}
+
+func (dbh SpannerDatabaseHandler) existingTables(ctx context.Context) (map[string]bool, error) {
+	return dbh.queryNames(ctx, "SELECT table_name FROM information_schema.tables WHERE table_schema = ''")
+}
+
+func (dbh SpannerDatabaseHandler) existingIndexes(ctx context.Context) (map[string]bool, error) {
+	return dbh.queryNames(ctx, "SELECT index_name FROM information_schema.indexes WHERE table_schema = '' AND index_type = 'INDEX'")
+}
+
+// queryNames returns the names of the first column of the query.
+func (dbh SpannerDatabaseHandler) queryNames(ctx context.Context, query string) (map[string]bool, error) {
+	names := make(map[string]bool)
+
+	iter := dbh.Client.Single().Query(ctx, spanner.Statement{SQL: query})
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var name string
+		if err := row.Columns(&name); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+}
+
+func (dbh SpannerDatabaseHandler) updateDDL(ctx context.Context, statements []string) error {
+	adminClient, err := dbadmin.NewDatabaseAdminClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer adminClient.Close()
+
+	op, err := adminClient.UpdateDatabaseDdl(ctx, &databasepb.UpdateDatabaseDdlRequest{
+		Database:   dbh.Client.DatabaseName(),
+		Statements: statements,
+	})
+	if err != nil {
+		return err
+	}
+
+	return op.Wait(ctx)
+}
+
+func (dbh SpannerDatabaseHandler) catalogReader() *spanner.ReadOnlyTransaction {
+	if time.Since(time.Unix(0, atomic.LoadInt64(dbh.initializedAt))) < dbh.Staleness {
+		return dbh.Client.Single()
+	}
+
+	return dbh.Client.Single().WithTimestampBound(spanner.MaxStaleness(dbh.Staleness))
+}
+
+var spannerProductColumns = []string{"id", "name", "price", "currency", "weight", "image", "review_count", "rating_total"}
+
+func (dbh SpannerDatabaseHandler) GetProduct(id int) (Product, error) {
+	var product Product
+
+	row, err := dbh.catalogReader().ReadRow(context.Background(), "products", spanner.Key{id}, spannerProductColumns)
+	if err != nil {
+		return product, translateSpannerError(err, "product", id)
+	}
+
+	if err := scanSpannerProduct(row, &product); err != nil {
+		return product, err
+	}
+
+	return product, nil
+}
+
+func (dbh SpannerDatabaseHandler) GetProducts() ([]Product, error) {
+	var products []Product
+
+	iter := dbh.catalogReader().Query(context.Background(), spanner.Statement{SQL: "SELECT id, name, price, currency, weight, image, review_count, rating_total FROM products ORDER BY id"})
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return products, nil
+		}
+		if err != nil {
+			return products, err
+		}
+
+		var product Product
+		if err := scanSpannerProduct(row, &product); err != nil {
+			return products, err
+		}
+
+		products = append(products, product)
+	}
+}
+
+func (dbh SpannerDatabaseHandler) GetCheckouts(userID int) ([]Checkout, error) {
+	var checkouts []Checkout
+
+	stmt := spanner.Statement{
+		SQL: `
+		SELECT
+		  checkouts.id,
+		  users.id,
+		  users.name,
+		  users.email,
+		  products.id,
+		  products.name,
+		  products.price,
+		  products.currency,
+		  products.weight,
+		  products.image,
+		  checkouts.variant_sku,
+		  checkouts.variant_name,
+		  checkouts.product_quantity,
+		  IFNULL(checkouts.coupon_code, ''),
+		  checkouts.tax_region,
+		  checkouts.currency,
+		  checkouts.subtotal,
+		  checkouts.discount,
+		  checkouts.tax,
+		  checkouts.shipping,
+		  checkouts.total,
+		  checkouts.payment_status,
+		  checkouts.payment_id,
+		  checkouts.payment_error,
+		  checkouts.shipping_name,
+		  checkouts.shipping_line1,
+		  checkouts.shipping_line2,
+		  checkouts.shipping_city,
+		  checkouts.shipping_postal_code,
+		  checkouts.shipping_country,
+		  checkouts.created_at
+		FROM checkouts
+		JOIN users ON checkouts.user_id = users.id
+		LEFT JOIN products ON checkouts.product_id = products.id
+		WHERE checkouts.user_id = @user_id
+		ORDER BY checkouts.created_at
+		`,
+		Params: map[string]interface{}{"user_id": int64(userID)},
+	}
+
+	iter := dbh.Client.Single().Query(context.Background(), stmt)
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return checkouts, nil
+		}
+		if err != nil {
+			return checkouts, err
+		}
+
+		var checkout Checkout
+		if err := scanSpannerCheckout(row, &checkout); err != nil {
+			return checkouts, err
+		}
+
+		checkouts = append(checkouts, checkout)
+	}
+}
+
+func (dbh SpannerDatabaseHandler) CreateCheckout(order CheckoutRequest) (string, error) {
+	checkoutID, err := order.checkoutID()
+	if err != nil {
+		return "", err
+	}
+
+	// The read-write transaction locks the coupon row which is read, so
+	// concurrent checkouts can't exceed its usage limits.
+	_, err = dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		amounts, variant, err := dbh.priceCheckout(ctx, txn, order, time.Now())
+		if err != nil {
+			return err
+		}
+
+		event, err := newCheckoutCreatedEvent(checkoutID, order, amounts, time.Now())
+		if err != nil {
+			return err
+		}
+
+		couponCode := spanner.NullString{StringVal: order.CouponCode, Valid: order.CouponCode != ""}
+		address := order.ShippingAddress
+		return txn.BufferWrite([]*spanner.Mutation{spannerWebhookEventMutation(event), spanner.Insert("checkouts",
+			[]string{"user_id", "id", "product_id", "variant_sku", "variant_name", "product_quantity", "coupon_code", "tax_region", "currency", "subtotal", "discount", "tax", "shipping", "total",
+				"payment_status", "payment_id", "payment_error",
+				"shipping_name", "shipping_line1", "shipping_line2", "shipping_city", "shipping_postal_code", "shipping_country", "created_at"},
+			[]interface{}{order.UserID, checkoutID, order.ProductID, variant.SKU, variant.Name, order.ProductQuantity, couponCode, order.TaxRegion,
+				amounts.Subtotal.Currency, amounts.Subtotal.Amount, amounts.Discount.Amount, amounts.Tax.Amount, amounts.Shipping.Amount, amounts.Total.Amount,
+				PaymentPending, "", "",
+				address.Name, address.Line1, address.Line2, address.City, address.PostalCode, address.Country, spanner.CommitTimestamp})})
+	})
+	if err != nil {
+		return "", translateSpannerError(err, "checkout", checkoutID)
+	}
+	publishCheckoutUpdate(newCheckoutUpdate(checkoutID, order.UserID, Payment{Status: PaymentPending}))
+
+	return checkoutID, nil
+}
+
+func (dbh SpannerDatabaseHandler) priceCheckout(ctx context.Context, txn *spanner.ReadWriteTransaction, order CheckoutRequest, now time.Time) (checkoutAmounts, Variant, error) {
+	row, err := txn.ReadRow(ctx, "products", spanner.Key{order.ProductID}, []string{"price", "currency", "weight"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return checkoutAmounts{}, Variant{}, errProductNotExist()
+	}
+	if err != nil {
+		return checkoutAmounts{}, Variant{}, err
+	}
+
+	var price money.Money
+	var weight int64
+	if err := row.Columns(&price.Amount, &price.Currency, &weight); err != nil {
+		return checkoutAmounts{}, Variant{}, err
+	}
+
+	var variant Variant
+	if order.VariantSKU != "" {
+		if variant, err = dbh.reserveVariant(ctx, txn, order, price); err != nil {
+			return checkoutAmounts{}, Variant{}, err
+		}
+		price = variant.Price
+	} else if err := dbh.checkNoVariants(ctx, txn, order.ProductID); err != nil {
+		return checkoutAmounts{}, Variant{}, err
+	}
+
+	discount := money.New(0, price.Currency)
+	if order.CouponCode != "" {
+		if discount, err = dbh.redeemCoupon(ctx, txn, order, price.Mul(order.ProductQuantity), now); err != nil {
+			return checkoutAmounts{}, Variant{}, err
+		}
+	}
+
+	return newCheckoutAmounts(price, int(weight), order, discount), variant, nil
+}
+
+// reserveVariant takes the units of the order from the stock of its variant.
+// The read-write transaction locks the variant, so concurrent checkouts can't
+// sell more than the stock. price is the price of the product.
+func (dbh SpannerDatabaseHandler) reserveVariant(ctx context.Context, txn *spanner.ReadWriteTransaction, order CheckoutRequest, price money.Money) (Variant, error) {
+	row, err := txn.ReadRow(ctx, "product_variants", spanner.Key{order.ProductID, order.VariantSKU}, []string{"name", "price", "stock"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return Variant{}, errVariantNotExist()
+	}
+	if err != nil {
+		return Variant{}, err
+	}
+
+	variant := Variant{SKU: order.VariantSKU, ProductID: order.ProductID, Price: price}
+	var override spanner.NullInt64
+	var stock int64
+	if err := row.Columns(&variant.Name, &override, &stock); err != nil {
+		return Variant{}, err
+	}
+	if override.Valid {
+		variant.Price.Amount = override.Int64
+	}
+	if int(stock) < order.ProductQuantity {
+		return Variant{}, errOutOfStock(int(stock))
+	}
+	variant.Stock = int(stock) - order.ProductQuantity
+
+	mutation := spanner.Update("product_variants", []string{"product_id", "sku", "stock"}, []interface{}{order.ProductID, order.VariantSKU, variant.Stock})
+	if err := txn.BufferWrite([]*spanner.Mutation{mutation}); err != nil {
+		return Variant{}, err
+	}
+
+	return variant, nil
+}
+
+// checkNoVariants fails when the product has variants, since one of them has
+// to be ordered.
+func (dbh SpannerDatabaseHandler) checkNoVariants(ctx context.Context, txn *spanner.ReadWriteTransaction, productID int) error {
+	iter := txn.Read(ctx, "product_variants", spanner.Key{productID}.AsPrefix(), []string{"sku"})
+	defer iter.Stop()
+
+	_, err := iter.Next()
+	if err == iterator.Done {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return errVariantRequired()
+}
+
+func (dbh SpannerDatabaseHandler) redeemCoupon(ctx context.Context, txn *spanner.ReadWriteTransaction, order CheckoutRequest, subtotal money.Money, now time.Time) (money.Money, error) {
+	row, err := txn.ReadRow(ctx, "coupons", spanner.Key{order.CouponCode}, spannerCouponColumns)
+	if spanner.ErrCode(err) == codes.NotFound {
+		return money.Money{}, newCouponError("The coupon code is not valid.")
+	}
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	var coupon Coupon
+	if err := scanSpannerCoupon(row, &coupon); err != nil {
+		return money.Money{}, err
+	}
+
+	if err := coupon.check(subtotal, now); err != nil {
+		return money.Money{}, err
+	}
+	if coupon.MaxUses > 0 && coupon.Uses >= coupon.MaxUses {
+		return money.Money{}, newCouponError("The coupon code has been used up.")
+	}
+
+	if coupon.MaxUsesPerUser > 0 {
+		stmt := spanner.Statement{
+			SQL:    "SELECT COUNT(*) FROM checkouts WHERE user_id = @user_id AND coupon_code = @coupon_code AND payment_status != @failed",
+			Params: map[string]interface{}{"user_id": int64(order.UserID), "coupon_code": order.CouponCode, "failed": PaymentFailed},
+		}
+		iter := txn.Query(ctx, stmt)
+		defer iter.Stop()
+
+		row, err := iter.Next()
+		if err != nil {
+			return money.Money{}, err
+		}
+
+		var used int64
+		if err := row.Columns(&used); err != nil {
+			return money.Money{}, err
+		}
+		if int(used) >= coupon.MaxUsesPerUser {
+			return money.Money{}, newCouponError("You have already used this coupon code.")
+		}
+	}
+
+	mutation := spanner.Update("coupons", []string{"code", "uses"}, []interface{}{coupon.Code, coupon.Uses + 1})
+	if err := txn.BufferWrite([]*spanner.Mutation{mutation}); err != nil {
+		return money.Money{}, err
+	}
+
+	return coupon.Discount(subtotal), nil
+}
+
+func (dbh SpannerDatabaseHandler) GetCheckout(checkoutID string) (Checkout, error) {
+	checkout := Checkout{
+		Product: Product{},
+		User:    User{},
+	}
+
+	stmt := spanner.Statement{
+		SQL: `
+		SELECT
+		  checkouts.id,
+		  users.id,
+		  users.name,
+		  users.email,
+		  products.id,
+		  products.name,
+		  products.price,
+		  products.currency,
+		  products.weight,
+		  products.image,
+		  checkouts.variant_sku,
+		  checkouts.variant_name,
+		  checkouts.product_quantity,
+		  IFNULL(checkouts.coupon_code, ''),
+		  checkouts.tax_region,
+		  checkouts.currency,
+		  checkouts.subtotal,
+		  checkouts.discount,
+		  checkouts.tax,
+		  checkouts.shipping,
+		  checkouts.total,
+		  checkouts.payment_status,
+		  checkouts.payment_id,
+		  checkouts.payment_error,
+		  checkouts.shipping_name,
+		  checkouts.shipping_line1,
+		  checkouts.shipping_line2,
+		  checkouts.shipping_city,
+		  checkouts.shipping_postal_code,
+		  checkouts.shipping_country,
+		  checkouts.created_at
+		FROM checkouts@{FORCE_INDEX=checkouts_by_id}
+		JOIN users ON checkouts.user_id = users.id
+		LEFT JOIN products ON checkouts.product_id = products.id
+		WHERE checkouts.id = @checkout_id
+		`,
+		Params: map[string]interface{}{"checkout_id": checkoutID},
+	}
+
+	iter := dbh.Client.Single().Query(context.Background(), stmt)
+	defer iter.Stop()
+
+	row, err := iter.Next()
+	if err == iterator.Done {
+		return checkout, NewNotFoundError("checkout", checkoutID)
+	}
+	if err != nil {
+		return checkout, err
+	}
+
+	if err := scanSpannerCheckout(row, &checkout); err != nil {
+		return checkout, err
+	}
+
+	return checkout, nil
+}
+
+// UpdateCheckoutPayment updates the order by its ID with DML because the
+// primary key of checkouts also has the user ID. A failed order gives back
+// the stock of its variant and the use of its coupon in the same read-write
+// transaction, which locks the order once it is read, so that only one of
+// two concurrent claims of the order passes checkTransition.
+func (dbh SpannerDatabaseHandler) UpdateCheckoutPayment(checkoutID string, payment Payment) error {
+	if err := payment.validate(); err != nil {
+		return err
+	}
+
+	var found bool
+	var userID int64
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		iter := txn.Query(ctx, spanner.Statement{
+			SQL:    "SELECT user_id, payment_status, product_id, variant_sku, product_quantity, coupon_code FROM checkouts WHERE id = @checkout_id",
+			Params: map[string]interface{}{"checkout_id": checkoutID},
+		})
+		defer iter.Stop()
+
+		row, err := iter.Next()
+		if err == iterator.Done {
+			found = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+
+		var previous, variantSKU string
+		var productID, quantity int64
+		var couponCode spanner.NullString
+		if err := row.Columns(&userID, &previous, &productID, &variantSKU, &quantity, &couponCode); err != nil {
+			return err
+		}
+		reservation := checkoutReservation{ProductID: int(productID), VariantSKU: variantSKU, Quantity: int(quantity), CouponCode: couponCode.StringVal}
+		if err := payment.checkTransition(checkoutID, previous); err != nil {
+			return err
+		}
+
+		if _, err := txn.Update(ctx, spanner.Statement{
+			SQL: "UPDATE checkouts SET payment_status = @status, payment_id = @payment_id, payment_error = @error WHERE id = @checkout_id",
+			Params: map[string]interface{}{
+				"status":      payment.Status,
+				"payment_id":  payment.ID,
+				"error":       payment.errorMessage(),
+				"checkout_id": checkoutID,
+			},
+		}); err != nil {
+			return err
+		}
+
+		if holdsReservation(previous) != holdsReservation(payment.Status) {
+			if err := dbh.adjustReservation(ctx, txn, reservation, holdsReservation(payment.Status)); err != nil {
+				return err
+			}
+		}
+
+		event, err := newCheckoutStatusChangedEvent(checkoutID, payment, time.Now())
+		if err != nil {
+			return err
+		}
+		return txn.BufferWrite([]*spanner.Mutation{spannerWebhookEventMutation(event)})
+	})
+	if err != nil {
+		return translateSpannerError(err, "checkout", checkoutID)
+	}
+	if !found {
+		return NewNotFoundError("checkout", checkoutID)
+	}
+	publishCheckoutUpdate(newCheckoutUpdate(checkoutID, int(userID), payment))
+
+	return nil
+}
+
+// adjustReservation takes the reservation of an order again when take is
+// true, like the SQL retakeReservation, or gives it back otherwise.
+func (dbh SpannerDatabaseHandler) adjustReservation(ctx context.Context, txn *spanner.ReadWriteTransaction, reservation checkoutReservation, take bool) error {
+	var mutations []*spanner.Mutation
+
+	if reservation.VariantSKU != "" {
+		row, err := txn.ReadRow(ctx, "product_variants", spanner.Key{reservation.ProductID, reservation.VariantSKU}, []string{"stock"})
+		if err != nil && spanner.ErrCode(err) != codes.NotFound {
+			return err
+		}
+		if err == nil {
+			var stock int64
+			if err := row.Columns(&stock); err != nil {
+				return err
+			}
+			if take {
+				stock = max(stock-int64(reservation.Quantity), 0)
+			} else {
+				stock += int64(reservation.Quantity)
+			}
+			mutations = append(mutations, spanner.Update("product_variants", []string{"product_id", "sku", "stock"}, []interface{}{reservation.ProductID, reservation.VariantSKU, stock}))
+		}
+	}
+
+	if reservation.CouponCode != "" {
+		row, err := txn.ReadRow(ctx, "coupons", spanner.Key{reservation.CouponCode}, []string{"uses"})
+		if err != nil {
+			return err
+		}
+		var uses int64
+		if err := row.Columns(&uses); err != nil {
+			return err
+		}
+		if take {
+			uses++
+		} else {
+			uses = max(uses-1, 0)
+		}
+		mutations = append(mutations, spanner.Update("coupons", []string{"code", "uses"}, []interface{}{reservation.CouponCode, uses}))
+	}
+
+	return txn.BufferWrite(mutations)
+}
+
+var spannerCouponColumns = []string{"code", "kind", "value", "min_order", "expires_at", "max_uses", "max_uses_per_user", "uses", "active"}
+
+func (dbh SpannerDatabaseHandler) CreateCoupon(coupon Coupon) error {
+	if err := coupon.Validate(); err != nil {
+		return err
+	}
+
+	expiresAt := spanner.NullTime{Time: coupon.ExpiresAt, Valid: !coupon.ExpiresAt.IsZero()}
+	mutation := spanner.Insert("coupons", spannerCouponColumns,
+		[]interface{}{coupon.Code, coupon.Kind, coupon.Value, coupon.MinOrder, expiresAt, coupon.MaxUses, coupon.MaxUsesPerUser, 0, true})
+	if _, err := dbh.Client.Apply(context.Background(), []*spanner.Mutation{mutation}); err != nil {
+		return translateSpannerError(err, "coupon", coupon.Code)
+	}
+
+	return nil
+}
+
+func (dbh SpannerDatabaseHandler) GetCoupons() ([]Coupon, error) {
+	var coupons []Coupon
+
+	stmt := spanner.Statement{SQL: "SELECT code, kind, value, min_order, expires_at, max_uses, max_uses_per_user, uses, active FROM coupons ORDER BY code"}
+	iter := dbh.Client.Single().Query(context.Background(), stmt)
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return coupons, nil
+		}
+		if err != nil {
+			return coupons, err
+		}
+
+		var coupon Coupon
+		if err := scanSpannerCoupon(row, &coupon); err != nil {
+			return coupons, err
+		}
+
+		coupons = append(coupons, coupon)
+	}
+}
+
+func (dbh SpannerDatabaseHandler) DeactivateCoupon(code string) error {
+	mutation := spanner.Update("coupons", []string{"code", "active"}, []interface{}{code, false})
+	if _, err := dbh.Client.Apply(context.Background(), []*spanner.Mutation{mutation}); err != nil {
+		return translateSpannerError(err, "coupon", code)
+	}
+
+	return nil
+}
+
+func (dbh SpannerDatabaseHandler) CreateAddress(address Address) (string, error) {
+	if err := address.Validate(); err != nil {
+		return "", err
+	}
+
+	uuidObj, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	addressID := uuidObj.String()
+
+	mutation := spanner.Insert("addresses",
+		[]string{"user_id", "id", "name", "line1", "line2", "city", "postal_code", "country", "created_at"},
+		[]interface{}{address.UserID, addressID, address.Name, address.Line1, address.Line2, address.City, address.PostalCode, address.Country, spanner.CommitTimestamp})
+	if _, err := dbh.Client.Apply(context.Background(), []*spanner.Mutation{mutation}); err != nil {
+		return "", translateSpannerError(err, "address", addressID)
+	}
+
+	return addressID, nil
+}
+
+func (dbh SpannerDatabaseHandler) GetAddresses(userID int) ([]Address, error) {
+	var addresses []Address
+
+	stmt := spanner.Statement{
+		SQL:    "SELECT id, user_id, name, line1, line2, city, postal_code, country FROM addresses WHERE user_id = @user_id ORDER BY created_at, id",
+		Params: map[string]interface{}{"user_id": int64(userID)},
+	}
+	iter := dbh.Client.Single().Query(context.Background(), stmt)
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return addresses, nil
+		}
+		if err != nil {
+			return addresses, err
+		}
+
+		var address Address
+		var addressUserID int64
+		if err := row.Columns(&address.ID, &addressUserID, &address.Name, &address.Line1, &address.Line2, &address.City, &address.PostalCode, &address.Country); err != nil {
+			return addresses, err
+		}
+		address.UserID = int(addressUserID)
+
+		addresses = append(addresses, address)
+	}
+}
+
+func (dbh SpannerDatabaseHandler) DeleteAddress(userID int, addressID string) error {
+	stmt := spanner.Statement{
+		SQL:    "DELETE FROM addresses WHERE user_id = @user_id AND id = @address_id",
+		Params: map[string]interface{}{"user_id": int64(userID), "address_id": addressID},
+	}
+
+	var deleted int64
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) (err error) {
+		deleted, err = txn.Update(ctx, stmt)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return NewNotFoundError("address", addressID)
+	}
+
+	return nil
+}
+
+func (dbh SpannerDatabaseHandler) CreateWebhook(webhook Webhook) (string, error) {
+	if err := webhook.Validate(); err != nil {
+		return "", err
+	}
+
+	uuidObj, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	webhookID := uuidObj.String()
+
+	mutation := spanner.Insert("webhooks",
+		[]string{"id", "url", "secret", "event_types", "active", "created_at"},
+		[]interface{}{webhookID, webhook.URL, webhook.Secret, joinEventTypes(webhook.EventTypes), true, time.Now().UTC()})
+	if _, err := dbh.Client.Apply(context.Background(), []*spanner.Mutation{mutation}); err != nil {
+		return "", translateSpannerError(err, "webhook", webhookID)
+	}
+
+	return webhookID, nil
+}
+
+func (dbh SpannerDatabaseHandler) GetWebhooks() ([]Webhook, error) {
+	var webhooks []Webhook
+
+	stmt := spanner.Statement{SQL: "SELECT id, url, secret, event_types, active, created_at FROM webhooks ORDER BY created_at, id"}
+	iter := dbh.Client.Single().Query(context.Background(), stmt)
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return webhooks, nil
+		}
+		if err != nil {
+			return webhooks, err
+		}
+
+		var webhook Webhook
+		var eventTypes string
+		if err := row.Columns(&webhook.ID, &webhook.URL, &webhook.Secret, &eventTypes, &webhook.Active, &webhook.CreatedAt); err != nil {
+			return webhooks, err
+		}
+		webhook.EventTypes = splitEventTypes(eventTypes)
+
+		webhooks = append(webhooks, webhook)
+	}
+}
+
+func (dbh SpannerDatabaseHandler) DeactivateWebhook(webhookID string) error {
+	mutation := spanner.Update("webhooks", []string{"id", "active"}, []interface{}{webhookID, false})
+	if _, err := dbh.Client.Apply(context.Background(), []*spanner.Mutation{mutation}); err != nil {
+		return translateSpannerError(err, "webhook", webhookID)
+	}
+
+	return nil
+}
+
+func (dbh SpannerDatabaseHandler) GetWebhookDeliveries(webhookID string, limit int) ([]WebhookDelivery, error) {
+	stmt := spanner.Statement{
+		SQL:    queryGetWebhookDeliveries + "WHERE webhook_deliveries.webhook_id = @webhook_id ORDER BY webhook_deliveries.updated_at DESC, webhook_deliveries.id LIMIT @limit",
+		Params: map[string]interface{}{"webhook_id": webhookID, "limit": int64(limit)},
+	}
+	iter := dbh.Client.Single().Query(context.Background(), stmt)
+
+	return scanSpannerWebhookDeliveries(iter)
+}
+
+// DispatchWebhookEvents reads the events and creates their deliveries in a
+// read-write transaction, so concurrent dispatchers can't take the same
+// events.
+func (dbh SpannerDatabaseHandler) DispatchWebhookEvents(limit int) (int, error) {
+	var dispatched int
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		webhooks, err := queryActiveSpannerWebhooks(ctx, txn)
+		if err != nil {
+			return err
+		}
+
+		stmt := spanner.Statement{
+			SQL:    "SELECT id, type FROM webhook_events WHERE dispatched_at IS NULL ORDER BY created_at, id LIMIT @limit",
+			Params: map[string]interface{}{"limit": int64(limit)},
+		}
+		iter := txn.Query(ctx, stmt)
+		defer iter.Stop()
+
+		now := time.Now().UTC()
+		var mutations []*spanner.Mutation
+		dispatched = 0
+		for {
+			row, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			var event WebhookEvent
+			if err := row.Columns(&event.ID, &event.Type); err != nil {
+				return err
+			}
+
+			mutations = append(mutations, spanner.Update("webhook_events", []string{"id", "dispatched_at"}, []interface{}{event.ID, now}))
+			for _, webhook := range webhooks {
+				if !webhook.Subscribes(event.Type) {
+					continue
+				}
+
+				uuidObj, err := uuid.NewRandom()
+				if err != nil {
+					return err
+				}
+				mutations = append(mutations, spanner.Insert("webhook_deliveries",
+					[]string{"id", "webhook_id", "event_id", "status", "attempts", "next_attempt_at", "last_status_code", "last_error", "updated_at"},
+					[]interface{}{uuidObj.String(), webhook.ID, event.ID, DeliveryPending, 0, now, 0, "", now}))
+			}
+			dispatched++
+		}
+
+		return txn.BufferWrite(mutations)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return dispatched, nil
+}
+
+func queryActiveSpannerWebhooks(ctx context.Context, txn *spanner.ReadWriteTransaction) ([]Webhook, error) {
+	var webhooks []Webhook
+
+	iter := txn.Query(ctx, spanner.Statement{SQL: "SELECT id, event_types FROM webhooks WHERE active"})
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return webhooks, nil
+		}
+		if err != nil {
+			return webhooks, err
+		}
+
+		var webhook Webhook
+		var eventTypes string
+		if err := row.Columns(&webhook.ID, &eventTypes); err != nil {
+			return webhooks, err
+		}
+		webhook.EventTypes = splitEventTypes(eventTypes)
+
+		webhooks = append(webhooks, webhook)
+	}
+}
+
+// ClaimWebhookDeliveries reads the due deliveries and postpones them in a
+// read-write transaction, so concurrent instances can't claim the same
+// deliveries. The deliveries of deactivated webhooks are kept pending but
+// not sent.
+func (dbh SpannerDatabaseHandler) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]WebhookDelivery, error) {
+	now = now.UTC()
+	until := now.Add(lease)
+
+	var claimed []WebhookDelivery
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		stmt := spanner.Statement{
+			SQL: queryGetWebhookDeliveries + `
+			WHERE webhook_deliveries.status = @status AND webhook_deliveries.next_attempt_at <= @now AND webhooks.active
+			ORDER BY webhook_deliveries.next_attempt_at, webhook_deliveries.id
+			LIMIT @limit`,
+			Params: map[string]interface{}{"status": DeliveryPending, "now": now, "limit": int64(limit)},
+		}
+		deliveries, err := scanSpannerWebhookDeliveries(txn.Query(ctx, stmt))
+		if err != nil {
+			return err
+		}
+
+		claimed = nil
+		var mutations []*spanner.Mutation
+		for _, delivery := range deliveries {
+			mutations = append(mutations, spanner.Update("webhook_deliveries", []string{"id", "next_attempt_at"}, []interface{}{delivery.ID, until}))
+			delivery.NextAttemptAt = until
+			claimed = append(claimed, delivery)
+		}
+
+		return txn.BufferWrite(mutations)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+func (dbh SpannerDatabaseHandler) RecordWebhookAttempt(deliveryID string, attempt WebhookAttempt) error {
+	now := time.Now().UTC()
+	nextAttemptAt := attempt.NextAttemptAt.UTC()
+	if attempt.NextAttemptAt.IsZero() {
+		nextAttemptAt = now
+	}
+
+	stmt := spanner.Statement{
+		SQL: `
+		UPDATE webhook_deliveries
+		SET status = @status, attempts = attempts + 1, next_attempt_at = @next_attempt_at, last_status_code = @status_code, last_error = @error, updated_at = @now
+		WHERE id = @delivery_id`,
+		Params: map[string]interface{}{
+			"status":          attempt.status(),
+			"next_attempt_at": nextAttemptAt,
+			"status_code":     int64(attempt.StatusCode),
+			"error":           attempt.errorMessage(),
+			"now":             now,
+			"delivery_id":     deliveryID,
+		},
+	}
+
+	var updated int64
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) (err error) {
+		updated, err = txn.Update(ctx, stmt)
+		return err
+	})
+	if err != nil {
+		return translateSpannerError(err, "webhook delivery", deliveryID)
+	}
+	if updated == 0 {
+		return NewNotFoundError("webhook delivery", deliveryID)
+	}
+
+	return nil
+}
+
+func spannerWebhookEventMutation(event WebhookEvent) *spanner.Mutation {
+	return spanner.Insert("webhook_events",
+		[]string{"id", "type", "payload", "created_at"},
+		[]interface{}{event.ID, event.Type, string(event.Payload), event.CreatedAt})
+}
+
+// scanSpannerWebhookDeliveries reads the rows of queryGetWebhookDeliveries.
+func scanSpannerWebhookDeliveries(iter *spanner.RowIterator) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return deliveries, nil
+		}
+		if err != nil {
+			return deliveries, err
+		}
+
+		var delivery WebhookDelivery
+		var attempts, statusCode int64
+		var payload string
+		if err := row.Columns(&delivery.ID, &delivery.Status, &attempts, &delivery.NextAttemptAt, &statusCode, &delivery.LastError, &delivery.UpdatedAt,
+			&delivery.Webhook.ID, &delivery.Webhook.URL, &delivery.Webhook.Secret,
+			&delivery.Event.ID, &delivery.Event.Type, &payload, &delivery.Event.CreatedAt); err != nil {
+			return deliveries, err
+		}
+		delivery.Attempts = int(attempts)
+		delivery.LastStatusCode = int(statusCode)
+		delivery.Event.Payload = []byte(payload)
+
+		deliveries = append(deliveries, delivery)
+	}
+}
+
+// EnqueueCheckout counts the waiting jobs in the read-write transaction of
+// the insert, so concurrent requests can't push the queue over maxDepth.
+func (dbh SpannerDatabaseHandler) EnqueueCheckout(order CheckoutRequest, maxDepth int) (string, error) {
+	job, request, err := newCheckoutJob(order, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	_, err = dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		if maxDepth > 0 {
+			depth, err := querySpannerCheckoutQueueDepth(ctx, txn)
+			if err != nil {
+				return err
+			}
+			if depth >= maxDepth {
+				return NewQueueFullError(fmt.Sprintf("checkout queue has %d jobs waiting", depth))
+			}
+		}
+
+		return txn.BufferWrite([]*spanner.Mutation{spanner.Insert("checkout_jobs",
+			[]string{"id", "user_id", "request", "status", "attempts", "locked_until", "error", "created_at", "updated_at"},
+			[]interface{}{job.ID, order.UserID, request, job.Status, 0, job.CreatedAt, "", job.CreatedAt, job.UpdatedAt})})
+	})
+	if err != nil {
+		return "", translateSpannerError(err, "checkout job", job.ID)
+	}
+
+	return job.ID, nil
+}
+
+const spannerQueryGetCheckoutJobs = "SELECT id, request, status, attempts, error, created_at, updated_at FROM checkout_jobs "
+
+func (dbh SpannerDatabaseHandler) GetCheckoutJob(jobID string) (CheckoutJob, error) {
+	stmt := spanner.Statement{
+		SQL:    spannerQueryGetCheckoutJobs + "WHERE id = @id",
+		Params: map[string]interface{}{"id": jobID},
+	}
+	jobs, err := scanSpannerCheckoutJobs(dbh.Client.Single().Query(context.Background(), stmt))
+	if err != nil {
+		return CheckoutJob{}, err
+	}
+	if len(jobs) == 0 {
+		return CheckoutJob{}, NewNotFoundError("checkout job", jobID)
+	}
+
+	return jobs[0], nil
+}
+
+// ClaimCheckoutJobs reads the due jobs and locks them in a read-write
+// transaction, so concurrent workers can't claim the same jobs.
+func (dbh SpannerDatabaseHandler) ClaimCheckoutJobs(now time.Time, lease time.Duration, limit int) ([]CheckoutJob, error) {
+	now = now.UTC()
+
+	var claimed []CheckoutJob
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		stmt := spanner.Statement{
+			SQL:    spannerQueryGetCheckoutJobs + "WHERE status IN (@queued, @processing) AND locked_until <= @now ORDER BY created_at, id LIMIT @limit",
+			Params: map[string]interface{}{"queued": CheckoutJobQueued, "processing": CheckoutJobProcessing, "now": now, "limit": int64(limit)},
+		}
+		jobs, err := scanSpannerCheckoutJobs(txn.Query(ctx, stmt))
+		if err != nil {
+			return err
+		}
+
+		claimed = nil
+		var mutations []*spanner.Mutation
+		for _, job := range jobs {
+			job.Status, job.Attempts, job.UpdatedAt = CheckoutJobProcessing, job.Attempts+1, now
+			mutations = append(mutations, spanner.Update("checkout_jobs",
+				[]string{"id", "status", "attempts", "locked_until", "updated_at"},
+				[]interface{}{job.ID, job.Status, job.Attempts, now.Add(lease), now}))
+			claimed = append(claimed, job)
+		}
+
+		return txn.BufferWrite(mutations)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return claimed, nil
+}
+
+func (dbh SpannerDatabaseHandler) FinishCheckoutJob(jobID string, status string, message string) error {
+	message, err := validateCheckoutJobResult(status, message)
+	if err != nil {
+		return err
+	}
+
+	mutation := spanner.Update("checkout_jobs", []string{"id", "status", "error", "updated_at"}, []interface{}{jobID, status, message, time.Now().UTC()})
+	if _, err := dbh.Client.Apply(context.Background(), []*spanner.Mutation{mutation}); err != nil {
+		return translateSpannerError(err, "checkout job", jobID)
+	}
+
+	return nil
+}
+
+func (dbh SpannerDatabaseHandler) GetCheckoutQueueDepth() (int, error) {
+	txn := dbh.Client.Single()
+	defer txn.Close()
+
+	return querySpannerCheckoutQueueDepth(context.Background(), txn)
+}
+
+// spannerQuerier is implemented by the read-only and read-write transactions.
+type spannerQuerier interface {
+	Query(ctx context.Context, statement spanner.Statement) *spanner.RowIterator
+}
+
+func querySpannerCheckoutQueueDepth(ctx context.Context, txn spannerQuerier) (int, error) {
+	stmt := spanner.Statement{
+		SQL:    "SELECT COUNT(*) FROM checkout_jobs WHERE status IN (@queued, @processing)",
+		Params: map[string]interface{}{"queued": CheckoutJobQueued, "processing": CheckoutJobProcessing},
+	}
+	iter := txn.Query(ctx, stmt)
+	defer iter.Stop()
+
+	row, err := iter.Next()
+	if err != nil {
+		return 0, err
+	}
+
+	var depth int64
+	if err := row.Columns(&depth); err != nil {
+		return 0, err
+	}
+
+	return int(depth), nil
+}
+
+// scanSpannerCheckoutJobs reads the rows of spannerQueryGetCheckoutJobs.
+func scanSpannerCheckoutJobs(iter *spanner.RowIterator) ([]CheckoutJob, error) {
+	var jobs []CheckoutJob
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return jobs, nil
+		}
+		if err != nil {
+			return jobs, err
+		}
+
+		var job CheckoutJob
+		var request string
+		var attempts int64
+		if err := row.Columns(&job.ID, &request, &job.Status, &attempts, &job.Error, &job.CreatedAt, &job.UpdatedAt); err != nil {
+			return jobs, err
+		}
+		job.Attempts = int(attempts)
+		if err := decodeCheckoutJobRequest(&job, request); err != nil {
+			return jobs, err
+		}
+
+		jobs = append(jobs, job)
+	}
+}
+
+func translateSpannerError(err error, resource string, id interface{}) error {
+	switch spanner.ErrCode(err) {
+	case codes.NotFound:
+		return &Error{Kind: ErrNotFound, Message: fmt.Sprintf("%s %v is not found", resource, id), Err: err}
+	case codes.AlreadyExists:
+		return &Error{Kind: ErrConflict, Message: fmt.Sprintf("%s %v already exists", resource, id), Err: err}
+	case codes.FailedPrecondition, codes.InvalidArgument, codes.OutOfRange:
+		return &Error{Kind: ErrValidation, Message: fmt.Sprintf("%s %v is invalid", resource, id), Err: err}
+	default:
+		return err
+	}
+}
+
+func scanSpannerProduct(row *spanner.Row, product *Product) error {
+	var id, weight, reviewCount, ratingTotal int64
+	if err := row.Columns(&id, &product.Name, &product.Price.Amount, &product.Price.Currency, &weight, &product.Image, &reviewCount, &ratingTotal); err != nil {
+		return err
+	}
+
+	product.ID = int(id)
+	product.Weight = int(weight)
+	product.Rating = Rating{Count: int(reviewCount), Total: int(ratingTotal)}
+
+	return nil
+}
+
+func scanSpannerCheckout(row *spanner.Row, checkout *Checkout) error {
+	var userID, quantity, subtotal, discount, tax, shipping, total int64
+	var productID, productPrice, productWeight spanner.NullInt64
+	var productName, productCurrency, productImage spanner.NullString
+	var currency string
+	address := &checkout.ShippingAddress
+	if err := row.Columns(&checkout.ID, &userID, &checkout.User.Name, &checkout.User.Email, &productID, &productName, &productPrice, &productCurrency, &productWeight, &productImage,
+		&checkout.Variant.SKU, &checkout.Variant.Name, &quantity, &checkout.CouponCode, &checkout.TaxRegion, &currency, &subtotal, &discount, &tax, &shipping, &total,
+		&checkout.Payment.Status, &checkout.Payment.ID, &checkout.Payment.Error,
+		&address.Name, &address.Line1, &address.Line2, &address.City, &address.PostalCode, &address.Country, &checkout.CreatedAt); err != nil {
+		return err
+	}
+
+	checkout.User.ID = int(userID)
+	checkout.Product = Product{
+		ID:     int(productID.Int64),
+		Name:   productName.StringVal,
+		Price:  money.New(productPrice.Int64, productCurrency.StringVal),
+		Weight: int(productWeight.Int64),
+		Image:  productImage.StringVal,
+	}
+	checkout.ProductQuantity = int(quantity)
+	checkout.Subtotal = money.New(subtotal, currency)
+	checkout.Discount = money.New(discount, currency)
+	checkout.Tax = money.New(tax, currency)
+	checkout.Shipping = money.New(shipping, currency)
+	checkout.Total = money.New(total, currency)
+
+	return nil
+}
+
+func scanSpannerCoupon(row *spanner.Row, coupon *Coupon) error {
+	var value, minOrder, maxUses, maxUsesPerUser, uses int64
+	var expiresAt spanner.NullTime
+	if err := row.Columns(&coupon.Code, &coupon.Kind, &value, &minOrder, &expiresAt, &maxUses, &maxUsesPerUser, &uses, &coupon.Active); err != nil {
+		return err
+	}
+
+	coupon.Value = int(value)
+	coupon.MinOrder = int(minOrder)
+	coupon.ExpiresAt = expiresAt.Time
+	coupon.MaxUses = int(maxUses)
+	coupon.MaxUsesPerUser = int(maxUsesPerUser)
+	coupon.Uses = int(uses)
+
+	return nil
+}
+
+// CreateReview checks the purchase and adds the rating to the product in a
+// read-write transaction, which locks the row of the product.
+func (dbh SpannerDatabaseHandler) CreateReview(review Review) (string, error) {
+	if err := review.Validate(); err != nil {
+		return "", err
+	}
+
+	uuidObj, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	reviewID := uuidObj.String()
+
+	_, err = dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		bought, err := querySpannerCount(ctx, txn, spanner.Statement{
+			SQL:    "SELECT COUNT(*) FROM checkouts WHERE user_id = @user_id AND product_id = @product_id AND payment_status = @status",
+			Params: map[string]interface{}{"user_id": int64(review.User.ID), "product_id": int64(review.ProductID), "status": PaymentPaid},
+		})
+		if err != nil {
+			return err
+		}
+		if bought == 0 {
+			return errProductNotBought()
+		}
+
+		reviewed, err := querySpannerCount(ctx, txn, spanner.Statement{
+			SQL:    "SELECT COUNT(*) FROM reviews WHERE product_id = @product_id AND user_id = @user_id",
+			Params: map[string]interface{}{"product_id": int64(review.ProductID), "user_id": int64(review.User.ID)},
+		})
+		if err != nil {
+			return err
+		}
+		if reviewed > 0 {
+			return errAlreadyReviewed()
+		}
+
+		ratingMutation, err := spannerProductRatingMutation(ctx, txn, review.ProductID, 1, review.Rating)
+		if err != nil {
+			return err
+		}
+
+		return txn.BufferWrite([]*spanner.Mutation{ratingMutation, spanner.Insert("reviews",
+			[]string{"id", "product_id", "user_id", "rating", "body", "status", "created_at"},
+			[]interface{}{reviewID, review.ProductID, review.User.ID, review.Rating, strings.TrimSpace(review.Body), ReviewVisible, spanner.CommitTimestamp})})
+	})
+	if err != nil {
+		return "", translateSpannerError(err, "review", reviewID)
+	}
+
+	return reviewID, nil
+}
+
+func (dbh SpannerDatabaseHandler) GetProductReviews(productID int, limit int) ([]Review, error) {
+	stmt := spanner.Statement{
+		SQL:    queryGetReviews + "WHERE reviews.product_id = @product_id AND reviews.status != @hidden ORDER BY reviews.created_at DESC, reviews.id LIMIT @limit",
+		Params: map[string]interface{}{"product_id": int64(productID), "hidden": ReviewHidden, "limit": int64(limit)},
+	}
+
+	return scanSpannerReviews(dbh.Client.Single().Query(context.Background(), stmt))
+}
+
+func (dbh SpannerDatabaseHandler) GetReviews(status string, limit int) ([]Review, error) {
+	stmt := spanner.Statement{
+		SQL:    queryGetReviews + "ORDER BY reviews.created_at DESC, reviews.id LIMIT @limit",
+		Params: map[string]interface{}{"limit": int64(limit)},
+	}
+	if status != "" {
+		if err := validateReviewStatus(status); err != nil {
+			return nil, err
+		}
+		stmt.SQL = queryGetReviews + "WHERE reviews.status = @status ORDER BY reviews.created_at DESC, reviews.id LIMIT @limit"
+		stmt.Params["status"] = status
+	}
+
+	return scanSpannerReviews(dbh.Client.Single().Query(context.Background(), stmt))
+}
+
+// UpdateReviewStatus reads the review and the rating of its product in a
+// read-write transaction, so concurrent moderations change the rating once.
+func (dbh SpannerDatabaseHandler) UpdateReviewStatus(reviewID string, status string) error {
+	if err := validateReviewStatus(status); err != nil {
+		return err
+	}
+
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		row, err := txn.ReadRow(ctx, "reviews", spanner.Key{reviewID}, []string{"product_id", "rating", "status"})
+		if err != nil {
+			return err
+		}
+
+		var productID, rating int64
+		var current string
+		if err := row.Columns(&productID, &rating, &current); err != nil {
+			return err
+		}
+		if current == status {
+			return nil
+		}
+
+		mutations := []*spanner.Mutation{spanner.Update("reviews", []string{"id", "status"}, []interface{}{reviewID, status})}
+		if count, total := ratingChange(int(rating), current, status); count != 0 {
+			ratingMutation, err := spannerProductRatingMutation(ctx, txn, int(productID), count, total)
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, ratingMutation)
+		}
+
+		return txn.BufferWrite(mutations)
+	})
+	if err != nil {
+		return translateSpannerError(err, "review", reviewID)
+	}
+
+	return nil
+}
+
+// spannerProductRatingMutation reads the rating of the product in txn and
+// returns the update which adds count reviews with total stars to it.
+func spannerProductRatingMutation(ctx context.Context, txn *spanner.ReadWriteTransaction, productID int, count int, total int) (*spanner.Mutation, error) {
+	row, err := txn.ReadRow(ctx, "products", spanner.Key{productID}, []string{"review_count", "rating_total"})
+	if err != nil {
+		return nil, err
+	}
+
+	var reviewCount, ratingTotal int64
+	if err := row.Columns(&reviewCount, &ratingTotal); err != nil {
+		return nil, err
+	}
+
+	return spanner.Update("products", []string{"id", "review_count", "rating_total"},
+		[]interface{}{productID, reviewCount + int64(count), ratingTotal + int64(total)}), nil
+}
+
+func querySpannerCount(ctx context.Context, txn spannerQuerier, stmt spanner.Statement) (int64, error) {
+	iter := txn.Query(ctx, stmt)
+	defer iter.Stop()
+
+	row, err := iter.Next()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := row.Columns(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// scanSpannerReviews reads the rows of queryGetReviews.
+func scanSpannerReviews(iter *spanner.RowIterator) ([]Review, error) {
+	defer iter.Stop()
+
+	var reviews []Review
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return reviews, nil
+		}
+		if err != nil {
+			return reviews, err
+		}
+
+		var productID, userID, rating int64
+		var review Review
+		if err := row.Columns(&review.ID, &productID, &userID, &review.User.Name, &rating, &review.Body, &review.Status, &review.CreatedAt); err != nil {
+			return reviews, err
+		}
+		review.ProductID, review.User.ID, review.Rating = int(productID), int(userID), int(rating)
+
+		reviews = append(reviews, review)
+	}
+}
+
+// AddWishlistItem reads the product and the item in a read-write transaction
+// so that saving the product twice at the same time stores it once.
+func (dbh SpannerDatabaseHandler) AddWishlistItem(userID int, productID int) error {
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		if _, err := txn.ReadRow(ctx, "products", spanner.Key{productID}, []string{"id"}); err != nil {
+			return err
+		}
+
+		_, err := txn.ReadRow(ctx, "wishlist_items", spanner.Key{userID, productID}, []string{"created_at"})
+		if err == nil {
+			return nil
+		}
+		if spanner.ErrCode(err) != codes.NotFound {
+			return err
+		}
+
+		return txn.BufferWrite([]*spanner.Mutation{spanner.Insert("wishlist_items",
+			[]string{"user_id", "product_id", "created_at"},
+			[]interface{}{userID, productID, spanner.CommitTimestamp})})
+	})
+	if err != nil {
+		return translateSpannerError(err, "product", productID)
+	}
+
+	return nil
+}
+
+func (dbh SpannerDatabaseHandler) GetWishlist(userID int) ([]WishlistItem, error) {
+	var items []WishlistItem
+
+	stmt := spanner.Statement{
+		SQL: `SELECT products.id, products.name, products.price, products.currency, products.weight, products.image, products.review_count, products.rating_total, wishlist_items.created_at
+		FROM wishlist_items
+		JOIN products ON wishlist_items.product_id = products.id
+		WHERE wishlist_items.user_id = @user_id
+		ORDER BY wishlist_items.created_at DESC, products.id`,
+		Params: map[string]interface{}{"user_id": int64(userID)},
+	}
+	iter := dbh.Client.Single().Query(context.Background(), stmt)
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return items, nil
+		}
+		if err != nil {
+			return items, err
+		}
+
+		var id, weight, reviewCount, ratingTotal int64
+		var item WishlistItem
+		product := &item.Product
+		if err := row.Columns(&id, &product.Name, &product.Price.Amount, &product.Price.Currency, &weight, &product.Image, &reviewCount, &ratingTotal, &item.AddedAt); err != nil {
+			return items, err
+		}
+		product.ID = int(id)
+		product.Weight = int(weight)
+		product.Rating = Rating{Count: int(reviewCount), Total: int(ratingTotal)}
+
+		items = append(items, item)
+	}
+}
+
+func (dbh SpannerDatabaseHandler) RemoveWishlistItem(userID int, productID int) error {
+	stmt := spanner.Statement{
+		SQL:    "DELETE FROM wishlist_items WHERE user_id = @user_id AND product_id = @product_id",
+		Params: map[string]interface{}{"user_id": int64(userID), "product_id": int64(productID)},
+	}
+
+	var deleted int64
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) (err error) {
+		deleted, err = txn.Update(ctx, stmt)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return NewNotFoundError("wishlist item", productID)
+	}
+
+	return nil
+}
+
+// UpdateProductPrice reads the product in a read-write transaction, which
+// locks its row, and stores the price drop event with the new price.
+func (dbh SpannerDatabaseHandler) UpdateProductPrice(productID int, price int64) (PriceChange, error) {
+	var change PriceChange
+	if err := validateProductPrice(price); err != nil {
+		return change, err
+	}
+
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		change = PriceChange{}
+		row, err := txn.ReadRow(ctx, "products", spanner.Key{productID}, spannerProductColumns)
+		if err != nil {
+			return err
+		}
+		if err := scanSpannerProduct(row, &change.Product); err != nil {
+			return err
+		}
+		change.OldPrice = change.Product.Price
+		change.Product.Price.Amount = price
+
+		mutations := []*spanner.Mutation{spanner.Update("products", []string{"id", "price"}, []interface{}{productID, price})}
+		if change.Dropped() {
+			if change.Watchers, err = querySpannerWishlistWatchers(ctx, txn, productID); err != nil {
+				return err
+			}
+
+			event, err := newProductPriceDroppedEvent(change, time.Now())
+			if err != nil {
+				return err
+			}
+			mutations = append(mutations, spannerWebhookEventMutation(event))
+		}
+
+		return txn.BufferWrite(mutations)
+	})
+	if err != nil {
+		return change, translateSpannerError(err, "product", productID)
+	}
+
+	return change, nil
+}
+
+func querySpannerWishlistWatchers(ctx context.Context, txn *spanner.ReadWriteTransaction, productID int) ([]User, error) {
+	var users []User
+
+	stmt := spanner.Statement{
+		SQL: `SELECT users.id, users.name, users.email
+		FROM wishlist_items
+		JOIN users ON wishlist_items.user_id = users.id
+		WHERE wishlist_items.product_id = @product_id
+		ORDER BY users.id`,
+		Params: map[string]interface{}{"product_id": int64(productID)},
+	}
+	iter := txn.Query(ctx, stmt)
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return users, nil
+		}
+		if err != nil {
+			return users, err
+		}
+
+		var id int64
+		var user User
+		if err := row.Columns(&id, &user.Name, &user.Email); err != nil {
+			return users, err
+		}
+		user.ID = int(id)
+
+		users = append(users, user)
+	}
+}
+
+func (dbh SpannerDatabaseHandler) GetPurchaseStats() ([]PurchaseStat, error) {
+	var stats []PurchaseStat
+
+	stmt := spanner.Statement{
+		SQL: `SELECT user_id, product_id, SUM(product_quantity)
+		FROM checkouts
+		WHERE payment_status = @paid
+		GROUP BY user_id, product_id
+		ORDER BY user_id, product_id`,
+		Params: map[string]interface{}{"paid": PaymentPaid},
+	}
+	iter := dbh.Client.Single().Query(context.Background(), stmt)
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		var userID, productID, quantity int64
+		if err := row.Columns(&userID, &productID, &quantity); err != nil {
+			return stats, err
+		}
+
+		stats = append(stats, PurchaseStat{UserID: int(userID), ProductID: int(productID), Quantity: int(quantity)})
+	}
+}
+
+// CreateVariant reads the product in the read-write transaction so that a
+// missing product is reported as such.
+func (dbh SpannerDatabaseHandler) CreateVariant(variant Variant) error {
+	if err := variant.Validate(); err != nil {
+		return err
+	}
+
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		if _, err := txn.ReadRow(ctx, "products", spanner.Key{variant.ProductID}, []string{"id"}); err != nil {
+			return translateSpannerError(err, "product", variant.ProductID)
+		}
+
+		price := spanner.NullInt64{Int64: variant.Price.Amount, Valid: variant.Price.Amount > 0}
+		return txn.BufferWrite([]*spanner.Mutation{spanner.Insert("product_variants",
+			[]string{"product_id", "sku", "name", "price", "stock", "image"},
+			[]interface{}{variant.ProductID, variant.SKU, variant.Name, price, variant.Stock, variant.Image})})
+	})
+	if err != nil {
+		return translateSpannerError(err, "variant", variant.SKU)
+	}
+
+	return nil
+}
+
+func (dbh SpannerDatabaseHandler) GetVariants(productID int) ([]Variant, error) {
+	var variants []Variant
+
+	stmt := spanner.Statement{
+		SQL: `SELECT product_variants.sku, product_variants.name, IFNULL(product_variants.price, products.price), products.currency,
+		  product_variants.stock, IF(product_variants.image = '', products.image, product_variants.image)
+		FROM product_variants
+		JOIN products ON product_variants.product_id = products.id
+		WHERE product_variants.product_id = @product_id
+		ORDER BY product_variants.name, product_variants.sku`,
+		Params: map[string]interface{}{"product_id": int64(productID)},
+	}
+	iter := dbh.Client.Single().Query(context.Background(), stmt)
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			return variants, nil
+		}
+		if err != nil {
+			return variants, err
+		}
+
+		var stock int64
+		variant := Variant{ProductID: productID}
+		if err := row.Columns(&variant.SKU, &variant.Name, &variant.Price.Amount, &variant.Price.Currency, &stock, &variant.Image); err != nil {
+			return variants, err
+		}
+		variant.Stock = int(stock)
+
+		variants = append(variants, variant)
+	}
+}
+
+func (dbh SpannerDatabaseHandler) UpdateVariantStock(sku string, stock int) error {
+	if err := validateVariantStock(stock); err != nil {
+		return err
+	}
+
+	stmt := spanner.Statement{
+		SQL:    "UPDATE product_variants SET stock = @stock WHERE sku = @sku",
+		Params: map[string]interface{}{"stock": int64(stock), "sku": sku},
+	}
+
+	var updated int64
+	_, err := dbh.Client.ReadWriteTransaction(context.Background(), func(ctx context.Context, txn *spanner.ReadWriteTransaction) (err error) {
+		updated, err = txn.Update(ctx, stmt)
+		return err
+	})
+	if err != nil {
+		return translateSpannerError(err, "variant", sku)
+	}
+	if updated == 0 {
+		return NewNotFoundError("variant", sku)
+	}
+
+	return nil
+}
+
+// spannerCheckoutDay is the day of a checkout in UTC as YYYY-MM-DD. It is
+// only used to group the checkouts, which are selected by created_at so that
+// its index is used.
+const spannerCheckoutDay = "FORMAT_DATE('%F', DATE(checkouts.created_at, 'UTC'))"
+
+// GetSalesReport runs the aggregate queries in one read-only transaction so
+// that the parts of the report are consistent. The days are in UTC.
+func (dbh SpannerDatabaseHandler) GetSalesReport(from, to time.Time, currency string, limit int) (SalesReport, error) {
+	report, err := newSalesReport(from, to, currency)
+	if err != nil {
+		return report, err
+	}
+
+	ctx := context.Background()
+	txn := dbh.Client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	const day = spannerCheckoutDay
+	where := "WHERE checkouts.created_at >= @from AND checkouts.created_at < @to AND checkouts.payment_status = @paid AND checkouts.currency = @currency"
+	params := map[string]interface{}{"from": report.From, "to": report.To, "paid": PaymentPaid, "currency": currency}
+	totals := "COUNT(*), SUM(checkouts.product_quantity), SUM(checkouts.total)"
+	limitClause := ""
+	if limit > 0 {
+		limitClause = fmt.Sprintf("LIMIT %d", limit)
+	}
+
+	var date string
+	stmt := spanner.Statement{SQL: fmt.Sprintf("SELECT %s, %s FROM checkouts %s GROUP BY 1 ORDER BY 1", day, totals, where), Params: params}
+	if err := querySpannerSales(ctx, txn, stmt, currency, func(totals SalesTotals) error {
+		return report.addDay(date, totals)
+	}, &date); err != nil {
+		return report, err
+	}
+
+	var customers int64
+	stmt = spanner.Statement{SQL: "SELECT COUNT(DISTINCT checkouts.user_id) FROM checkouts " + where, Params: params}
+	if err := txn.Query(ctx, stmt).Do(func(row *spanner.Row) error {
+		return row.Columns(&customers)
+	}); err != nil {
+		return report, err
+	}
+	report.Customers = int(customers)
+
+	var productID int64
+	var productName string
+	stmt = spanner.Statement{SQL: fmt.Sprintf(`SELECT products.id, products.name, %s
+		FROM checkouts INNER JOIN products ON products.id = checkouts.product_id
+		%s
+		GROUP BY products.id, products.name
+		ORDER BY SUM(checkouts.total) DESC, products.id
+		%s`, totals, where, limitClause), Params: params}
+	if err := querySpannerSales(ctx, txn, stmt, currency, func(totals SalesTotals) error {
+		report.TopProducts = append(report.TopProducts, ProductSales{Product: Product{ID: int(productID), Name: productName}, SalesTotals: totals})
+		return nil
+	}, &productID, &productName); err != nil {
+		return report, err
+	}
+
+	var userID int64
+	var userName string
+	stmt = spanner.Statement{SQL: fmt.Sprintf(`SELECT users.id, users.name, %s
+		FROM checkouts INNER JOIN users ON users.id = checkouts.user_id
+		%s
+		GROUP BY users.id, users.name
+		ORDER BY SUM(checkouts.total) DESC, users.id
+		%s`, totals, where, limitClause), Params: params}
+	if err := querySpannerSales(ctx, txn, stmt, currency, func(totals SalesTotals) error {
+		report.TopCustomers = append(report.TopCustomers, CustomerSales{User: User{ID: int(userID), Name: userName}, SalesTotals: totals})
+		return nil
+	}, &userID, &userName); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// querySpannerSales is querySales of the production handler for Spanner.
+func querySpannerSales(ctx context.Context, txn *spanner.ReadOnlyTransaction, stmt spanner.Statement, currency string, add func(SalesTotals) error, dest ...interface{}) error {
+	return txn.Query(ctx, stmt).Do(func(row *spanner.Row) error {
+		var orders, units, revenue int64
+		if err := row.Columns(append(dest, &orders, &units, &revenue)...); err != nil {
+			return err
+		}
+
+		return add(SalesTotals{Orders: int(orders), Units: int(units), Revenue: money.New(revenue, currency)})
+	})
+}
+
+func (dbh SpannerDatabaseHandler) ExportCheckouts(filter CheckoutFilter, fn func(Checkout) error) error {
+	if err := filter.Validate(); err != nil {
+		return err
+	}
+
+	var conditions []string
+	params := map[string]interface{}{}
+	if filter.UserID != 0 {
+		conditions = append(conditions, "checkouts.user_id = @user_id")
+		params["user_id"] = int64(filter.UserID)
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "checkouts.created_at >= @from")
+		params["from"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "checkouts.created_at < @to")
+		params["to"] = filter.To
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	stmt := spanner.Statement{
+		SQL: fmt.Sprintf(`
+		SELECT
+		  checkouts.id,
+		  users.id,
+		  users.name,
+		  users.email,
+		  products.id,
+		  products.name,
+		  products.price,
+		  products.currency,
+		  products.weight,
+		  products.image,
+		  checkouts.variant_sku,
+		  checkouts.variant_name,
+		  checkouts.product_quantity,
+		  IFNULL(checkouts.coupon_code, ''),
+		  checkouts.tax_region,
+		  checkouts.currency,
+		  checkouts.subtotal,
+		  checkouts.discount,
+		  checkouts.tax,
+		  checkouts.shipping,
+		  checkouts.total,
+		  checkouts.payment_status,
+		  checkouts.payment_id,
+		  checkouts.payment_error,
+		  checkouts.shipping_name,
+		  checkouts.shipping_line1,
+		  checkouts.shipping_line2,
+		  checkouts.shipping_city,
+		  checkouts.shipping_postal_code,
+		  checkouts.shipping_country,
+		  checkouts.created_at
+		FROM checkouts
+		JOIN users ON checkouts.user_id = users.id
+		LEFT JOIN products ON checkouts.product_id = products.id
+		%s
+		ORDER BY checkouts.created_at, checkouts.id
+		`, where),
+		Params: params,
+	}
+
+	return dbh.Client.Single().Query(context.Background(), stmt).Do(func(row *spanner.Row) error {
+		var checkout Checkout
+		if err := scanSpannerCheckout(row, &checkout); err != nil {
+			return err
+		}
+
+		return fn(checkout)
+	})
+}
+
+// spannerBackupQueries are the queries of prodBackupQueries on Spanner.
+var spannerBackupQueries = []struct {
+	sql  string
+	scan func(row *spanner.Row) (BackupRecord, error)
+}{
+	{
+		"SELECT id, name, price, currency, weight, image, review_count, rating_total FROM products ORDER BY id",
+		func(row *spanner.Row) (BackupRecord, error) {
+			var product Product
+			err := scanSpannerProduct(row, &product)
+			return BackupRecord{Product: &product}, err
+		},
+	},
+	{
+		`SELECT product_variants.sku, product_variants.product_id, product_variants.name, IFNULL(product_variants.price, 0), products.currency,
+		  product_variants.stock, product_variants.image
+		FROM product_variants
+		JOIN products ON product_variants.product_id = products.id
+		ORDER BY product_variants.product_id, product_variants.sku`,
+		func(row *spanner.Row) (BackupRecord, error) {
+			var variant Variant
+			var productID, stock int64
+			err := row.Columns(&variant.SKU, &productID, &variant.Name, &variant.Price.Amount, &variant.Price.Currency, &stock, &variant.Image)
+			variant.ProductID = int(productID)
+			variant.Stock = int(stock)
+			return BackupRecord{Variant: &variant}, err
+		},
+	},
+	{
+		"SELECT id, name, email FROM users ORDER BY id",
+		func(row *spanner.Row) (BackupRecord, error) {
+			var user User
+			var id int64
+			err := row.Columns(&id, &user.Name, &user.Email)
+			user.ID = int(id)
+			return BackupRecord{User: &user}, err
+		},
+	},
+	{
+		"SELECT code, kind, value, min_order, expires_at, max_uses, max_uses_per_user, uses, active FROM coupons ORDER BY code",
+		func(row *spanner.Row) (BackupRecord, error) {
+			var coupon Coupon
+			err := scanSpannerCoupon(row, &coupon)
+			return BackupRecord{Coupon: &coupon}, err
+		},
+	},
+	{
+		`SELECT id, user_id, product_id, variant_sku, variant_name, product_quantity, IFNULL(coupon_code, ''), tax_region, currency,
+		  subtotal, discount, tax, shipping, total, payment_status, payment_id, payment_error,
+		  shipping_name, shipping_line1, shipping_line2, shipping_city, shipping_postal_code, shipping_country, created_at
+		FROM checkouts
+		ORDER BY created_at, id`,
+		func(row *spanner.Row) (BackupRecord, error) {
+			var checkout Checkout
+			err := scanSpannerBackupCheckout(row, &checkout)
+			return BackupRecord{Checkout: &checkout}, err
+		},
+	},
+}
+
+func scanSpannerBackupCheckout(row *spanner.Row, checkout *Checkout) error {
+	var userID, productID, quantity, subtotal, discount, tax, shipping, total int64
+	var currency string
+	address := &checkout.ShippingAddress
+	if err := row.Columns(&checkout.ID, &userID, &productID, &checkout.Variant.SKU, &checkout.Variant.Name, &quantity, &checkout.CouponCode, &checkout.TaxRegion, &currency,
+		&subtotal, &discount, &tax, &shipping, &total, &checkout.Payment.Status, &checkout.Payment.ID, &checkout.Payment.Error,
+		&address.Name, &address.Line1, &address.Line2, &address.City, &address.PostalCode, &address.Country, &checkout.CreatedAt); err != nil {
+		return err
+	}
+
+	checkout.User.ID = int(userID)
+	checkout.Product.ID = int(productID)
+	checkout.ProductQuantity = int(quantity)
+	checkout.Subtotal = money.New(subtotal, currency)
+	checkout.Discount = money.New(discount, currency)
+	checkout.Tax = money.New(tax, currency)
+	checkout.Shipping = money.New(shipping, currency)
+	checkout.Total = money.New(total, currency)
+
+	return nil
+}
+
+// Backup reads the tables in a read-only transaction so that the records are
+// a snapshot at its timestamp.
+func (dbh SpannerDatabaseHandler) Backup(fn func(BackupRecord) error) error {
+	ctx := context.Background()
+	txn := dbh.Client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	for _, table := range spannerBackupQueries {
+		scan := table.scan
+		if err := txn.Query(ctx, spanner.Statement{SQL: table.sql}).Do(func(row *spanner.Row) error {
+			record, err := scan(row)
+			if err != nil {
+				return err
+			}
+
+			return fn(record)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Restore deletes the rows in a commit and inserts the records in commits of
+// spannerMutationBatchSize mutations like InitDatabase, as a transaction is
+// limited in mutations. A failed restore keeps the records committed before.
+func (dbh SpannerDatabaseHandler) Restore(next func() (BackupRecord, error)) error {
+	ctx := context.Background()
+
+	var mutations []*spanner.Mutation
+	for _, table := range schemaTables {
+		mutations = append(mutations, spanner.Delete(table, spanner.AllKeys()))
+	}
+	if _, err := dbh.Client.Apply(ctx, mutations); err != nil {
+		return err
+	}
+
+	mutations = nil
+	for {
+		record, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		mutation, err := spannerRestoreMutation(record)
+		if err != nil {
+			return err
+		}
+		mutations = append(mutations, mutation)
+
+		if len(mutations) == spannerMutationBatchSize {
+			if _, err := dbh.Client.Apply(ctx, mutations); err != nil {
+				return err
+			}
+			mutations = nil
+		}
+	}
+
+	if len(mutations) > 0 {
+		if _, err := dbh.Client.Apply(ctx, mutations); err != nil {
+			return err
+		}
+	}
+
+	atomic.StoreInt64(dbh.initializedAt, time.Now().UnixNano())
+
+	return nil
+}
+
+func spannerRestoreMutation(record BackupRecord) (*spanner.Mutation, error) {
+	switch {
+	case record.Product != nil:
+		product := record.Product
+		return spanner.Insert("products",
+			[]string{"id", "name", "price", "currency", "weight", "image", "review_count", "rating_total"},
+			[]interface{}{product.ID, product.Name, product.Price.Amount, product.Price.Currency, product.Weight, product.Image, product.Rating.Count, product.Rating.Total}), nil
+	case record.Variant != nil:
+		variant := record.Variant
+		price := spanner.NullInt64{Int64: variant.Price.Amount, Valid: variant.Price.Amount > 0}
+		return spanner.Insert("product_variants",
+			[]string{"product_id", "sku", "name", "price", "stock", "image"},
+			[]interface{}{variant.ProductID, variant.SKU, variant.Name, price, variant.Stock, variant.Image}), nil
+	case record.User != nil:
+		user := record.User
+		return spanner.Insert("users", []string{"id", "name", "email"}, []interface{}{user.ID, user.Name, user.Email}), nil
+	case record.Coupon != nil:
+		coupon := record.Coupon
+		expiresAt := spanner.NullTime{Time: coupon.ExpiresAt, Valid: !coupon.ExpiresAt.IsZero()}
+		return spanner.Insert("coupons",
+			[]string{"code", "kind", "value", "min_order", "expires_at", "max_uses", "max_uses_per_user", "uses", "active"},
+			[]interface{}{coupon.Code, coupon.Kind, coupon.Value, coupon.MinOrder, expiresAt, coupon.MaxUses, coupon.MaxUsesPerUser, coupon.Uses, coupon.Active}), nil
+	case record.Checkout != nil:
+		checkout := record.Checkout
+		address := checkout.ShippingAddress
+		couponCode := spanner.NullString{StringVal: checkout.CouponCode, Valid: checkout.CouponCode != ""}
+		return spanner.Insert("checkouts",
+			[]string{
+				"user_id", "id", "product_id", "variant_sku", "variant_name", "product_quantity", "coupon_code", "tax_region", "currency",
+				"subtotal", "discount", "tax", "shipping", "total", "payment_status", "payment_id", "payment_error",
+				"shipping_name", "shipping_line1", "shipping_line2", "shipping_city", "shipping_postal_code", "shipping_country", "created_at",
+			},
+			[]interface{}{
+				checkout.User.ID, checkout.ID, checkout.Product.ID, checkout.Variant.SKU, checkout.Variant.Name, checkout.ProductQuantity, couponCode, checkout.TaxRegion, checkout.Total.Currency,
+				checkout.Subtotal.Amount, checkout.Discount.Amount, checkout.Tax.Amount, checkout.Shipping.Amount, checkout.Total.Amount,
+				checkout.Payment.Status, checkout.Payment.ID, checkout.Payment.Error,
+				address.Name, address.Line1, address.Line2, address.City, address.PostalCode, address.Country, checkout.CreatedAt,
+			}), nil
+	default:
+		return nil, NewValidationError("backup record is empty")
+	}
+}
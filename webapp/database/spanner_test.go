@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	dbadmin "cloud.google.com/go/spanner/admin/database/apiv1"
+	instance "cloud.google.com/go/spanner/admin/instance/apiv1"
+	databasepb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
+	instancepb "google.golang.org/genproto/googleapis/spanner/admin/instance/v1"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	testSpannerProject  = "test-project"
+	testSpannerInstance = "test-instance"
+)
+
+// newEmulatorSpannerDatabaseHandler creates a fresh database on the Spanner
+// emulator. Start the emulator with `make start-spanner-emulator` and set
+// SPANNER_EMULATOR_HOST=localhost:9010 to run the tests.
+func newEmulatorSpannerDatabaseHandler(t *testing.T) SpannerDatabaseHandler {
+	if os.Getenv("SPANNER_EMULATOR_HOST") == "" {
+		t.Skip("SPANNER_EMULATOR_HOST is not set")
+	}
+
+	ctx := context.Background()
+
+	instanceClient, err := instance.NewInstanceAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instanceClient.Close()
+
+	op, err := instanceClient.CreateInstance(ctx, &instancepb.CreateInstanceRequest{
+		Parent:     fmt.Sprintf("projects/%s", testSpannerProject),
+		InstanceId: testSpannerInstance,
+		Instance: &instancepb.Instance{
+			Config:      fmt.Sprintf("projects/%s/instanceConfigs/emulator-config", testSpannerProject),
+			DisplayName: testSpannerInstance,
+			NodeCount:   1,
+		},
+	})
+	if err == nil {
+		_, err = op.Wait(ctx)
+	}
+	if err != nil && spanner.ErrCode(err) != codes.AlreadyExists {
+		t.Fatal(err)
+	}
+
+	adminClient, err := dbadmin.NewDatabaseAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adminClient.Close()
+
+	databaseID := fmt.Sprintf("scstore-%d", time.Now().UnixNano()%1000000000)
+	dbOp, err := adminClient.CreateDatabase(ctx, &databasepb.CreateDatabaseRequest{
+		Parent:          fmt.Sprintf("projects/%s/instances/%s", testSpannerProject, testSpannerInstance),
+		CreateStatement: fmt.Sprintf("CREATE DATABASE `%s`", databaseID),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbOp.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := spanner.NewClient(ctx, fmt.Sprintf("projects/%s/instances/%s/databases/%s", testSpannerProject, testSpannerInstance, databaseID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	return NewSpannerDatabaseHandler(client)
+}
+
+func TestSpannerDatabaseHandler(t *testing.T) {
+	testDatabaseHandlerBehaviour(t, newEmulatorSpannerDatabaseHandler(t))
+}
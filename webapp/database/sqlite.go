@@ -0,0 +1,403 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteDatabaseHandler runs the production queries on SQLite which accepts
+// the same $1 placeholders. Only the schema and the day of the checkouts
+// differ, and the checkout updates are published in this process as SQLite
+// has no pg_notify.
+type SQLiteDatabaseHandler struct {
+	ProdDatabaseHandler
+}
+
+func NewSQLiteDatabaseHandler(db *sql.DB) SQLiteDatabaseHandler {
+	return SQLiteDatabaseHandler{ProdDatabaseHandler: ProdDatabaseHandler{DB: db, checkoutDay: "date(checkouts.created_at)"}}
+}
+
+// OpenSQLiteDBConn opens the database file in WAL mode. Transactions take
+// the write lock when they begin, so that two checkouts redeeming a coupon
+// can't both read before either writes. The pool has a single connection,
+// as concurrent connections may still fail with SQLITE_BUSY instead of
+// waiting for the lock, so the queries wait for each other in the pool.
+// Timestamps are stored in the SQLite format so that
+// created_at is read back as time.Time. They are compared as text, so the
+// checkouts are written in UTC like the ranges of the sales report.
+func OpenSQLiteDBConn(path string) (*sql.DB, error) {
+	params := url.Values{}
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "synchronous(NORMAL)")
+	params.Add("_time_format", "sqlite")
+	params.Add("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?%s", path, params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// sqliteCheckoutsColumns are the columns of checkouts, which the migrations
+// create the table with again as SQLite can't change their constraints.
+const sqliteCheckoutsColumns = `
+		id TEXT NOT NULL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users (id),
+		product_id INTEGER NOT NULL REFERENCES products (id),
+		variant_sku TEXT NOT NULL,
+		variant_name TEXT NOT NULL,
+		product_quantity INTEGER NOT NULL CHECK (product_quantity > 0),
+		coupon_code TEXT REFERENCES coupons (code),
+		tax_region TEXT NOT NULL,
+		currency TEXT NOT NULL,
+		subtotal INTEGER NOT NULL,
+		discount INTEGER NOT NULL CHECK (discount >= 0),
+		tax INTEGER NOT NULL CHECK (tax >= 0),
+		shipping INTEGER NOT NULL CHECK (shipping >= 0),
+		total INTEGER NOT NULL CHECK (total >= 0),
+		payment_status TEXT NOT NULL CHECK (payment_status IN ('pending', 'charging', 'paid', 'failed', 'refunding', 'refunded')),
+		payment_id TEXT NOT NULL,
+		payment_error TEXT NOT NULL,
+		shipping_name TEXT NOT NULL,
+		shipping_line1 TEXT NOT NULL,
+		shipping_line2 TEXT NOT NULL,
+		shipping_city TEXT NOT NULL,
+		shipping_postal_code TEXT NOT NULL,
+		shipping_country TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+`
+
+// sqliteSchema creates the tables and indexes which don't exist yet.
+var sqliteSchema = []string{
+	`
+	CREATE TABLE IF NOT EXISTS products (
+		id INTEGER NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		price INTEGER NOT NULL,
+		currency TEXT NOT NULL,
+		weight INTEGER NOT NULL,
+		image TEXT NOT NULL,
+		review_count INTEGER NOT NULL,
+		rating_total INTEGER NOT NULL
+	)
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		email TEXT NOT NULL
+	)
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS addresses (
+		id TEXT NOT NULL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users (id),
+		name TEXT NOT NULL,
+		line1 TEXT NOT NULL,
+		line2 TEXT NOT NULL,
+		city TEXT NOT NULL,
+		postal_code TEXT NOT NULL,
+		country TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)
+	`,
+	"CREATE INDEX IF NOT EXISTS addresses_user_id ON addresses(user_id)",
+	`
+	CREATE TABLE IF NOT EXISTS coupons (
+		code TEXT NOT NULL PRIMARY KEY,
+		kind TEXT NOT NULL CHECK (kind IN ('percent', 'fixed')),
+		value INTEGER NOT NULL CHECK (value > 0),
+		min_order INTEGER NOT NULL,
+		expires_at DATETIME,
+		max_uses INTEGER NOT NULL,
+		max_uses_per_user INTEGER NOT NULL,
+		uses INTEGER NOT NULL,
+		active BOOLEAN NOT NULL
+	)
+	`,
+	"CREATE TABLE IF NOT EXISTS checkouts (" + sqliteCheckoutsColumns + ")",
+	"CREATE INDEX IF NOT EXISTS checkouts_user_id ON checkouts(user_id)",
+	"CREATE INDEX IF NOT EXISTS checkouts_created_at ON checkouts(created_at)",
+	`
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT NOT NULL PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		event_types TEXT NOT NULL,
+		active BOOLEAN NOT NULL,
+		created_at DATETIME NOT NULL
+	)
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS webhook_events (
+		id TEXT NOT NULL PRIMARY KEY,
+		type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		dispatched_at DATETIME
+	)
+	`,
+	"CREATE INDEX IF NOT EXISTS webhook_events_dispatched_at ON webhook_events(dispatched_at, created_at)",
+	`
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT NOT NULL PRIMARY KEY,
+		webhook_id TEXT NOT NULL REFERENCES webhooks (id),
+		event_id TEXT NOT NULL REFERENCES webhook_events (id),
+		status TEXT NOT NULL CHECK (status IN ('pending', 'succeeded', 'failed')),
+		attempts INTEGER NOT NULL,
+		next_attempt_at DATETIME NOT NULL,
+		last_status_code INTEGER NOT NULL,
+		last_error TEXT NOT NULL,
+		updated_at DATETIME NOT NULL
+	)
+	`,
+	"CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at)",
+	`
+	CREATE TABLE IF NOT EXISTS checkout_jobs (
+		id TEXT NOT NULL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users (id),
+		request TEXT NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('queued', 'processing', 'done', 'failed')),
+		attempts INTEGER NOT NULL,
+		locked_until DATETIME NOT NULL,
+		error TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	)
+	`,
+	"CREATE INDEX IF NOT EXISTS checkout_jobs_due ON checkout_jobs(status, locked_until)",
+	`
+	CREATE TABLE IF NOT EXISTS reviews (
+		id TEXT NOT NULL PRIMARY KEY,
+		product_id INTEGER NOT NULL REFERENCES products (id),
+		user_id INTEGER NOT NULL REFERENCES users (id),
+		rating INTEGER NOT NULL CHECK (rating BETWEEN 1 AND 5),
+		body TEXT NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('visible', 'flagged', 'hidden')),
+		created_at DATETIME NOT NULL,
+		UNIQUE (product_id, user_id)
+	)
+	`,
+	"CREATE INDEX IF NOT EXISTS reviews_product_created_at ON reviews(product_id, created_at)",
+	"CREATE INDEX IF NOT EXISTS reviews_status_created_at ON reviews(status, created_at)",
+	`
+	CREATE TABLE IF NOT EXISTS wishlist_items (
+		user_id INTEGER NOT NULL REFERENCES users (id),
+		product_id INTEGER NOT NULL REFERENCES products (id),
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (user_id, product_id)
+	)
+	`,
+	"CREATE INDEX IF NOT EXISTS wishlist_items_product ON wishlist_items(product_id)",
+	`
+	CREATE TABLE IF NOT EXISTS product_variants (
+		sku TEXT NOT NULL PRIMARY KEY,
+		product_id INTEGER NOT NULL REFERENCES products (id),
+		name TEXT NOT NULL,
+		price INTEGER CHECK (price > 0),
+		stock INTEGER NOT NULL CHECK (stock >= 0),
+		image TEXT NOT NULL
+	)
+	`,
+	"CREATE INDEX IF NOT EXISTS product_variants_product ON product_variants(product_id, name)",
+}
+
+// sqliteMigrations change the tables of the older versions of the schema, in
+// the order of their versions.
+var sqliteMigrations = []schemaMigration{
+	{2, "products", "currency", []string{"ALTER TABLE products ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD'"}},
+	{2, "products", "weight", []string{"ALTER TABLE products ADD COLUMN weight INTEGER NOT NULL DEFAULT 0"}},
+	{2, "products", "review_count", []string{"ALTER TABLE products ADD COLUMN review_count INTEGER NOT NULL DEFAULT 0"}},
+	{2, "products", "rating_total", []string{"ALTER TABLE products ADD COLUMN rating_total INTEGER NOT NULL DEFAULT 0"}},
+	{2, "users", "email", []string{"ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "variant_sku", []string{"ALTER TABLE checkouts ADD COLUMN variant_sku TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "variant_name", []string{"ALTER TABLE checkouts ADD COLUMN variant_name TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "coupon_code", []string{"ALTER TABLE checkouts ADD COLUMN coupon_code TEXT"}},
+	{2, "checkouts", "tax_region", []string{"ALTER TABLE checkouts ADD COLUMN tax_region TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "currency", []string{"ALTER TABLE checkouts ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD'"}},
+	{2, "checkouts", "subtotal", []string{"ALTER TABLE checkouts ADD COLUMN subtotal INTEGER NOT NULL DEFAULT 0"}},
+	{2, "checkouts", "discount", []string{"ALTER TABLE checkouts ADD COLUMN discount INTEGER NOT NULL DEFAULT 0"}},
+	{2, "checkouts", "tax", []string{"ALTER TABLE checkouts ADD COLUMN tax INTEGER NOT NULL DEFAULT 0"}},
+	{2, "checkouts", "shipping", []string{"ALTER TABLE checkouts ADD COLUMN shipping INTEGER NOT NULL DEFAULT 0"}},
+	{2, "checkouts", "total", []string{"ALTER TABLE checkouts ADD COLUMN total INTEGER NOT NULL DEFAULT 0"}},
+	{2, "checkouts", "payment_status", []string{"ALTER TABLE checkouts ADD COLUMN payment_status TEXT NOT NULL DEFAULT 'paid'"}},
+	{2, "checkouts", "payment_id", []string{"ALTER TABLE checkouts ADD COLUMN payment_id TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "payment_error", []string{"ALTER TABLE checkouts ADD COLUMN payment_error TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_name", []string{"ALTER TABLE checkouts ADD COLUMN shipping_name TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_line1", []string{"ALTER TABLE checkouts ADD COLUMN shipping_line1 TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_line2", []string{"ALTER TABLE checkouts ADD COLUMN shipping_line2 TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_city", []string{"ALTER TABLE checkouts ADD COLUMN shipping_city TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_postal_code", []string{"ALTER TABLE checkouts ADD COLUMN shipping_postal_code TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "shipping_country", []string{"ALTER TABLE checkouts ADD COLUMN shipping_country TEXT NOT NULL DEFAULT ''"}},
+	{2, "checkouts", "", []string{"CREATE INDEX IF NOT EXISTS checkouts_created_at ON checkouts(created_at)"}},
+	// The table is created again for the new payment statuses, which also
+	// gives the constraints to the columns added above.
+	{3, "checkouts", "", []string{
+		"CREATE TABLE checkouts_v3 (" + sqliteCheckoutsColumns + ")",
+		"INSERT INTO checkouts_v3 (" + strings.Join(schemaColumns["checkouts"], ", ") + ") SELECT " + strings.Join(schemaColumns["checkouts"], ", ") + " FROM checkouts",
+		"DROP TABLE checkouts",
+		"ALTER TABLE checkouts_v3 RENAME TO checkouts",
+		"CREATE INDEX checkouts_user_id ON checkouts(user_id)",
+		"CREATE INDEX checkouts_created_at ON checkouts(created_at)",
+	}},
+}
+
+// InitDatabase drops the tables, creates them again and loads initdata.json
+// in a transaction.
+func (dbh SQLiteDatabaseHandler) InitDatabase() error {
+	data, err := readInitData()
+	if err != nil {
+		return err
+	}
+
+	tx, err := dbh.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range schemaTables {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DROP TABLE IF EXISTS schema_version"); err != nil {
+		return err
+	}
+
+	if err := migrateSQLite(tx); err != nil {
+		return err
+	}
+
+	if err := seedInitData(tx, data); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MigrateDatabase applies the migrations and creates the missing tables and
+// indexes in a transaction.
+func (dbh SQLiteDatabaseHandler) MigrateDatabase() error {
+	tx, err := dbh.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := migrateSQLite(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// migrateSQLite is MigrateDatabase of the production handler, whose version
+// is recorded once as the transaction applies the migrations all or none.
+// The missing tables are created before the migrations, as the tables
+// created again by them reference the others.
+func migrateSQLite(tx *sql.Tx) error {
+	version, err := readSQLiteSchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if err := checkSchemaVersion(version); err != nil {
+		return err
+	}
+
+	existing := make(map[string]map[string]bool)
+	for _, table := range schemaTables {
+		exists, err := sqliteTableExists(tx, table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if existing[table], err = querySchemaColumns(tx, table); err != nil {
+			return err
+		}
+	}
+	if err := checkSchemaColumns(version, sqliteMigrations, func(table string) (map[string]bool, error) {
+		return existing[table], nil
+	}); err != nil {
+		return err
+	}
+
+	for _, query := range sqliteSchema {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	for _, migration := range sqliteMigrations {
+		columns := existing[migration.table]
+		if migration.version <= version || columns == nil || (migration.column != "" && columns[migration.column]) {
+			continue
+		}
+
+		for _, statement := range migration.statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("failed to migrate the schema to version %d: %w", migration.version, err)
+			}
+		}
+	}
+
+	_, err = tx.Exec("UPDATE schema_version SET version = $1 WHERE id = 1", schemaVersion)
+	return err
+}
+
+// readSQLiteSchemaVersion is readSchemaVersion of the production handler.
+func readSQLiteSchemaVersion(tx *sql.Tx) (int, error) {
+	exists, err := sqliteTableExists(tx, "schema_version")
+	if err != nil {
+		return 0, err
+	}
+	if exists {
+		var version int
+		err := tx.QueryRow("SELECT version FROM schema_version WHERE id = 1").Scan(&version)
+		return version, err
+	}
+
+	version := schemaVersion
+	for _, table := range schemaTables {
+		exists, err := sqliteTableExists(tx, table)
+		if err != nil {
+			return version, err
+		}
+		if exists {
+			version = 1
+			break
+		}
+	}
+
+	if _, err := tx.Exec("CREATE TABLE schema_version (id INTEGER NOT NULL PRIMARY KEY, version INTEGER NOT NULL)"); err != nil {
+		return version, err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version VALUES (1, $1)", version); err != nil {
+		return version, err
+	}
+
+	return version, nil
+}
+
+func sqliteTableExists(tx *sql.Tx, table string) (bool, error) {
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1", table).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteDatabaseHandler(t *testing.T) {
+	db, err := OpenSQLiteDBConn(filepath.Join(t.TempDir(), "scstore.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var journalMode string
+	assert.Nil(t, db.QueryRow("PRAGMA journal_mode").Scan(&journalMode))
+	assert.Equal(t, "wal", journalMode)
+
+	testDatabaseHandlerBehaviour(t, NewSQLiteDatabaseHandler(db))
+}
+
+func TestSQLiteMigrateDatabase(t *testing.T) {
+	db, err := OpenSQLiteDBConn(filepath.Join(t.TempDir(), "scstore.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	dbh := NewSQLiteDatabaseHandler(db)
+	readVersion := func() int {
+		var version int
+		assert.Nil(t, db.QueryRow("SELECT version FROM schema_version").Scan(&version))
+		return version
+	}
+	indexExists := func() bool {
+		var count int
+		assert.Nil(t, db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'checkouts_created_at'").Scan(&count))
+		return count > 0
+	}
+
+	// A new database is created at the last version.
+	assert.Nil(t, dbh.MigrateDatabase())
+	assert.Equal(t, schemaVersion, readVersion())
+	assert.True(t, indexExists())
+
+	// A database created before the versions is at version 1. Its checkouts
+	// are kept when the table is created again for the payment statuses.
+	inWebappDir(t, dbh.SeedDatabase)
+	checkoutID, err := dbh.CreateCheckout(CheckoutRequest{UserID: 2, ProductID: 1, ProductQuantity: 1})
+	assert.Nil(t, err)
+	_, err = db.Exec("DROP TABLE schema_version")
+	assert.Nil(t, err)
+	_, err = db.Exec("DROP INDEX checkouts_created_at")
+	assert.Nil(t, err)
+	assert.Nil(t, dbh.MigrateDatabase())
+	assert.Equal(t, schemaVersion, readVersion())
+	assert.True(t, indexExists())
+	assert.Nil(t, dbh.UpdateCheckoutPayment(checkoutID, Payment{Status: PaymentCharging}))
+	checkout, err := dbh.GetCheckout(checkoutID)
+	assert.Nil(t, err)
+	assert.Equal(t, PaymentCharging, checkout.Payment.Status)
+
+	// A newer app migrated the database.
+	_, err = db.Exec("UPDATE schema_version SET version = $1", schemaVersion+1)
+	assert.Nil(t, err)
+	assert.ErrorContains(t, dbh.MigrateDatabase(), "deploy a newer app")
+
+	// The tables of the first release get the columns added since, and keep
+	// their rows.
+	dropTables := func() {
+		for _, table := range append(schemaTables, "schema_version") {
+			_, err := db.Exec("DROP TABLE IF EXISTS " + table)
+			assert.Nil(t, err)
+		}
+	}
+	dropTables()
+	for _, query := range []string{
+		"CREATE TABLE products (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL, price INTEGER NOT NULL, image TEXT NOT NULL)",
+		"CREATE TABLE users (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL)",
+		"CREATE TABLE checkouts (id TEXT NOT NULL PRIMARY KEY, user_id INTEGER, product_id INTEGER, product_quantity INTEGER, created_at DATETIME)",
+		"INSERT INTO products VALUES (1, 'Product00001', 35000, '/assets/images/product00001.jpg')",
+		"INSERT INTO users VALUES (2, 'user00002')",
+		"INSERT INTO checkouts VALUES ('00000000-0000-0000-0000-000000000001', 2, 1, 3, '2022-05-01 10:00:00')",
+	} {
+		_, err = db.Exec(query)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, dbh.MigrateDatabase())
+	assert.Equal(t, schemaVersion, readVersion())
+	assert.True(t, indexExists())
+	product, err := dbh.GetProduct(1)
+	assert.Nil(t, err)
+	assert.Equal(t, Product{ID: 1, Name: "Product00001", Price: money.New(35000, "USD"), Image: "/assets/images/product00001.jpg"}, product)
+	checkout, err = dbh.GetCheckout("00000000-0000-0000-0000-000000000001")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, checkout.ProductQuantity)
+	assert.Equal(t, PaymentPaid, checkout.Payment.Status)
+
+	// A table changed outside of the app fails before any migration.
+	dropTables()
+	for _, query := range []string{
+		"CREATE TABLE products (id INTEGER NOT NULL PRIMARY KEY, name TEXT NOT NULL, price INTEGER NOT NULL, image TEXT NOT NULL)",
+		"CREATE TABLE coupons (code TEXT NOT NULL PRIMARY KEY)",
+	} {
+		_, err = db.Exec(query)
+		assert.Nil(t, err)
+	}
+	assert.ErrorContains(t, dbh.MigrateDatabase(), "the table coupons has no column kind")
+	_, err = db.Exec("SELECT currency FROM products")
+	assert.ErrorContains(t, err, "no such column")
+}
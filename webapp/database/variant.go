@@ -0,0 +1,86 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+// Variant is a version of a product like a strap colour or a size, with its
+// own SKU and stock. Price and Image are those of the product unless the
+// variant overrides them. Orders of a variant are orders of its product.
+type Variant struct {
+	SKU       string
+	ProductID int
+	Name      string
+	Price     money.Money
+	Stock     int
+	Image     string
+}
+
+// InStock reports whether the variant can be ordered.
+func (variant Variant) InStock() bool {
+	return variant.Stock > 0
+}
+
+var variantSKUPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,40}$`)
+
+// Validate checks a new variant and trims its fields. A zero price and an
+// empty image keep those of the product.
+func (variant *Variant) Validate() error {
+	variant.SKU = strings.TrimSpace(variant.SKU)
+	variant.Name = strings.TrimSpace(variant.Name)
+	variant.Image = strings.TrimSpace(variant.Image)
+
+	fields := map[string]string{}
+	if !variantSKUPattern.MatchString(variant.SKU) {
+		fields["sku"] = "SKU must be 1 to 40 letters, digits, - or _."
+	}
+	if length := utf8.RuneCountInString(variant.Name); length == 0 || length > 50 {
+		fields["name"] = "Name must be 1 to 50 characters."
+	}
+	if variant.Price.Amount < 0 {
+		fields["price"] = "Price must be more than 0."
+	}
+	if variant.Stock < 0 {
+		fields["stock"] = errVariantStockMessage
+	}
+	if variant.Image != "" && (!strings.HasPrefix(variant.Image, "/") || len(variant.Image) > 100) {
+		fields["image"] = "Image must be a path like /assets/images/product00001.jpg."
+	}
+	if len(fields) > 0 {
+		return NewFieldValidationError(fields)
+	}
+
+	return nil
+}
+
+const errVariantStockMessage = "Stock must be 0 or more."
+
+func validateVariantStock(stock int) error {
+	if stock < 0 {
+		return NewFieldValidationError(map[string]string{"stock": errVariantStockMessage})
+	}
+
+	return nil
+}
+
+func errVariantNotExist() error {
+	return NewFieldValidationError(map[string]string{"variant_sku": "The variant does not exist."})
+}
+
+func errVariantRequired() error {
+	return NewFieldValidationError(map[string]string{"variant_sku": "Choose a variant."})
+}
+
+// errOutOfStock is returned when fewer units than ordered are left.
+func errOutOfStock(stock int) error {
+	if stock <= 0 {
+		return NewFieldValidationError(map[string]string{"variant_sku": "This variant is out of stock."})
+	}
+
+	return NewFieldValidationError(map[string]string{"variant_sku": fmt.Sprintf("Only %d left in stock.", stock)})
+}
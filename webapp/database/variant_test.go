@@ -0,0 +1,33 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVariantValidate(t *testing.T) {
+	variant := Variant{SKU: " P1-BLACK ", ProductID: 1, Name: "Black strap", Stock: 3}
+	assert.Nil(t, variant.Validate())
+	assert.Equal(t, "P1-BLACK", variant.SKU)
+
+	variant = Variant{SKU: "P1 BLACK", ProductID: 1, Name: "", Price: money.New(-1, "USD"), Stock: -1, Image: "black.jpg"}
+	err := variant.Validate()
+	assert.ErrorIs(t, err, ErrValidation)
+
+	var dbErr *Error
+	assert.ErrorAs(t, err, &dbErr)
+	assert.Equal(t, 5, len(dbErr.Fields))
+	for _, field := range []string{"sku", "name", "price", "stock", "image"} {
+		assert.Contains(t, dbErr.Fields, field)
+	}
+}
+
+func TestErrOutOfStock(t *testing.T) {
+	var dbErr *Error
+	assert.ErrorAs(t, errOutOfStock(0), &dbErr)
+	assert.Equal(t, "This variant is out of stock.", dbErr.Fields["variant_sku"])
+	assert.ErrorAs(t, errOutOfStock(2), &dbErr)
+	assert.Equal(t, "Only 2 left in stock.", dbErr.Fields["variant_sku"])
+}
@@ -0,0 +1,255 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// The events delivered to the webhooks.
+const (
+	WebhookEventCheckoutCreated       = "checkout.created"
+	WebhookEventCheckoutStatusChanged = "checkout.status_changed"
+	WebhookEventProductPriceDropped   = "product.price_dropped"
+)
+
+// WebhookEventTypes lists the events a webhook can subscribe to.
+var WebhookEventTypes = []string{WebhookEventCheckoutCreated, WebhookEventCheckoutStatusChanged, WebhookEventProductPriceDropped}
+
+// The states of a delivery of an event to a webhook.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// deliveryErrorMaxLength keeps the errors of the deliveries in the column.
+const deliveryErrorMaxLength = 200
+
+// Webhook is a subscription of a service to the events of the shop. The
+// events are signed with Secret. Deactivated webhooks get no new events
+// and are kept for their delivery log.
+type Webhook struct {
+	ID         string
+	URL        string
+	Secret     string
+	EventTypes []string
+	Active     bool
+	CreatedAt  time.Time
+}
+
+// Validate checks the fields of a webhook entered by an admin.
+func (webhook Webhook) Validate() error {
+	fields := make(map[string]string)
+
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(webhook.URL) > 500 {
+		fields["url"] = "URL must be an http or https URL of at most 500 characters."
+	}
+
+	if length := utf8.RuneCountInString(webhook.Secret); length < 16 || length > 100 {
+		fields["secret"] = "Secret must be 16 to 100 characters."
+	}
+
+	if len(webhook.EventTypes) == 0 {
+		fields["event_types"] = "Choose at least one event."
+	}
+	for _, eventType := range webhook.EventTypes {
+		if !isWebhookEventType(eventType) {
+			fields["event_types"] = "Event " + eventType + " does not exist."
+		}
+	}
+
+	if len(fields) > 0 {
+		return NewFieldValidationError(fields)
+	}
+
+	return nil
+}
+
+// Subscribes reports whether the webhook gets the events of the type.
+func (webhook Webhook) Subscribes(eventType string) bool {
+	for _, t := range webhook.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isWebhookEventType(eventType string) bool {
+	for _, t := range WebhookEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// joinEventTypes and splitEventTypes convert the event types to the column.
+func joinEventTypes(eventTypes []string) string {
+	return strings.Join(eventTypes, ",")
+}
+
+func splitEventTypes(column string) []string {
+	if column == "" {
+		return nil
+	}
+
+	return strings.Split(column, ",")
+}
+
+// WebhookEvent is an event in the outbox. It is stored in the transaction
+// of the change it is about, so it is delivered even when the app stops
+// right after the change. Payload is the JSON body sent to the webhooks.
+type WebhookEvent struct {
+	ID        string
+	Type      string
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+// WebhookDelivery is the delivery of an event to a webhook. The webhooks
+// may get an event more than once, so they should ignore the event IDs they
+// have already handled.
+type WebhookDelivery struct {
+	ID             string
+	Webhook        Webhook
+	Event          WebhookEvent
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode int
+	LastError      string
+	UpdatedAt      time.Time
+}
+
+// WebhookAttempt is the result of a try to deliver an event.
+type WebhookAttempt struct {
+	Succeeded  bool
+	StatusCode int
+	Error      string
+	// NextAttemptAt is when a failed delivery is tried again. The delivery
+	// is given up when it is zero.
+	NextAttemptAt time.Time
+}
+
+func (attempt WebhookAttempt) status() string {
+	switch {
+	case attempt.Succeeded:
+		return DeliverySucceeded
+	case attempt.NextAttemptAt.IsZero():
+		return DeliveryFailed
+	default:
+		return DeliveryPending
+	}
+}
+
+// errorMessage returns the error cut to the length of the column.
+func (attempt WebhookAttempt) errorMessage() string {
+	if len(attempt.Error) > deliveryErrorMaxLength {
+		return strings.ToValidUTF8(attempt.Error[:deliveryErrorMaxLength], "")
+	}
+
+	return attempt.Error
+}
+
+// webhookEventPayload is the JSON body of the events.
+type webhookEventPayload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type checkoutCreatedData struct {
+	CheckoutID      string          `json:"checkout_id"`
+	UserID          int             `json:"user_id"`
+	ProductID       int             `json:"product_id"`
+	VariantSKU      string          `json:"variant_sku,omitempty"`
+	ProductQuantity int             `json:"product_quantity"`
+	CouponCode      string          `json:"coupon_code,omitempty"`
+	Currency        string          `json:"currency"`
+	Subtotal        int64           `json:"subtotal"`
+	Discount        int64           `json:"discount"`
+	Tax             int64           `json:"tax"`
+	Shipping        int64           `json:"shipping"`
+	Total           int64           `json:"total"`
+	PaymentStatus   string          `json:"payment_status"`
+	ShippingAddress *webhookAddress `json:"shipping_address,omitempty"`
+}
+
+type webhookAddress struct {
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+type checkoutStatusChangedData struct {
+	CheckoutID    string `json:"checkout_id"`
+	PaymentStatus string `json:"payment_status"`
+}
+
+func newWebhookEvent(eventType string, data interface{}, now time.Time) (WebhookEvent, error) {
+	uuidObj, err := uuid.NewRandom()
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	event := WebhookEvent{ID: uuidObj.String(), Type: eventType, CreatedAt: now.UTC()}
+	event.Payload, err = json.Marshal(webhookEventPayload{ID: event.ID, Type: eventType, CreatedAt: event.CreatedAt, Data: data})
+	if err != nil {
+		return WebhookEvent{}, err
+	}
+
+	return event, nil
+}
+
+// newCheckoutCreatedEvent returns the event of a new order. The amounts are
+// in the minor units of the currency.
+func newCheckoutCreatedEvent(checkoutID string, order CheckoutRequest, amounts checkoutAmounts, now time.Time) (WebhookEvent, error) {
+	data := checkoutCreatedData{
+		CheckoutID:      checkoutID,
+		UserID:          order.UserID,
+		ProductID:       order.ProductID,
+		VariantSKU:      order.VariantSKU,
+		ProductQuantity: order.ProductQuantity,
+		CouponCode:      order.CouponCode,
+		Currency:        amounts.Total.Currency,
+		Subtotal:        amounts.Subtotal.Amount,
+		Discount:        amounts.Discount.Amount,
+		Tax:             amounts.Tax.Amount,
+		Shipping:        amounts.Shipping.Amount,
+		Total:           amounts.Total.Amount,
+		PaymentStatus:   PaymentPending,
+	}
+	if address := order.ShippingAddress; !address.IsZero() {
+		data.ShippingAddress = &webhookAddress{Name: address.Name, Line1: address.Line1, Line2: address.Line2,
+			City: address.City, PostalCode: address.PostalCode, Country: address.Country}
+	}
+
+	return newWebhookEvent(WebhookEventCheckoutCreated, data, now)
+}
+
+func newCheckoutStatusChangedEvent(checkoutID string, payment Payment, now time.Time) (WebhookEvent, error) {
+	return newWebhookEvent(WebhookEventCheckoutStatusChanged, checkoutStatusChangedData{CheckoutID: checkoutID, PaymentStatus: payment.Status}, now)
+}
+
+// insertWebhookEvent is shared by the SQL backends. It stores the event in
+// the outbox within the transaction of the change.
+func insertWebhookEvent(tx *sql.Tx, event WebhookEvent) error {
+	query := "INSERT INTO webhook_events (id, type, payload, created_at) VALUES ($1, $2, $3, $4)"
+	_, err := tx.Exec(query, event.ID, event.Type, string(event.Payload), event.CreatedAt)
+
+	return err
+}
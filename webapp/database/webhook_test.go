@@ -0,0 +1,36 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookValidate(t *testing.T) {
+	webhook := Webhook{URL: "https://example.com/hooks", Secret: "0123456789abcdef", EventTypes: []string{WebhookEventCheckoutCreated}}
+	assert.Nil(t, webhook.Validate())
+
+	err := Webhook{URL: "mailto:admin@example.com", Secret: "short", EventTypes: []string{"checkout.deleted"}}.Validate()
+	assert.ErrorIs(t, err, ErrValidation)
+
+	var dbErr *Error
+	assert.ErrorAs(t, err, &dbErr)
+	assert.Equal(t, 3, len(dbErr.Fields))
+	assert.Contains(t, dbErr.Fields, "url")
+	assert.Contains(t, dbErr.Fields, "secret")
+	assert.Contains(t, dbErr.Fields, "event_types")
+
+	err = Webhook{URL: "https://example.com/hooks", Secret: "0123456789abcdef"}.Validate()
+	assert.ErrorAs(t, err, &dbErr)
+	assert.Contains(t, dbErr.Fields, "event_types")
+}
+
+func TestWebhookAttemptStatus(t *testing.T) {
+	assert.Equal(t, DeliverySucceeded, WebhookAttempt{Succeeded: true, StatusCode: 200}.status())
+	assert.Equal(t, DeliveryPending, WebhookAttempt{StatusCode: 500, NextAttemptAt: time.Now()}.status())
+	assert.Equal(t, DeliveryFailed, WebhookAttempt{StatusCode: 500}.status())
+
+	assert.Equal(t, deliveryErrorMaxLength, len(WebhookAttempt{Error: strings.Repeat("x", 300)}.errorMessage()))
+}
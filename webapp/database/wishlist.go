@@ -0,0 +1,64 @@
+package database
+
+import (
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+// WishlistItem is a product a user has saved to buy later. A product is on
+// a wishlist once.
+type WishlistItem struct {
+	Product Product
+	AddedAt time.Time
+}
+
+// PriceChange is the result of a change of the price of a product. Product
+// has the new price.
+type PriceChange struct {
+	Product  Product
+	OldPrice money.Money
+	// Watchers are the users with the product on their wishlists. They are
+	// set only when the price dropped.
+	Watchers []User
+}
+
+// Dropped reports whether the product got cheaper.
+func (change PriceChange) Dropped() bool {
+	return change.Product.Price.Amount < change.OldPrice.Amount
+}
+
+// validateProductPrice checks a price in minor units entered by an admin.
+func validateProductPrice(price int64) error {
+	if price <= 0 {
+		return NewFieldValidationError(map[string]string{"price": "Price must be more than 0."})
+	}
+
+	return nil
+}
+
+type productPriceDroppedData struct {
+	ProductID int    `json:"product_id"`
+	Currency  string `json:"currency"`
+	OldPrice  int64  `json:"old_price"`
+	NewPrice  int64  `json:"new_price"`
+	// WishlistUserIDs are the users with the product on their wishlists.
+	WishlistUserIDs []int `json:"wishlist_user_ids"`
+}
+
+// newProductPriceDroppedEvent returns the event of a price drop. The prices
+// are in the minor units of the currency.
+func newProductPriceDroppedEvent(change PriceChange, now time.Time) (WebhookEvent, error) {
+	data := productPriceDroppedData{
+		ProductID:       change.Product.ID,
+		Currency:        change.Product.Price.Currency,
+		OldPrice:        change.OldPrice.Amount,
+		NewPrice:        change.Product.Price.Amount,
+		WishlistUserIDs: []int{},
+	}
+	for _, user := range change.Watchers {
+		data.WishlistUserIDs = append(data.WishlistUserIDs, user.ID)
+	}
+
+	return newWebhookEvent(WebhookEventProductPriceDropped, data, now)
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceChangeDropped(t *testing.T) {
+	product := Product{ID: 1, Price: money.New(9000, "USD")}
+
+	assert.True(t, PriceChange{Product: product, OldPrice: money.New(10000, "USD")}.Dropped())
+	assert.False(t, PriceChange{Product: product, OldPrice: money.New(9000, "USD")}.Dropped())
+	assert.False(t, PriceChange{Product: product, OldPrice: money.New(8000, "USD")}.Dropped())
+}
+
+func TestValidateProductPrice(t *testing.T) {
+	assert.Nil(t, validateProductPrice(1))
+	assert.ErrorIs(t, validateProductPrice(0), ErrValidation)
+	assert.ErrorIs(t, validateProductPrice(-100), ErrValidation)
+}
+
+func TestNewProductPriceDroppedEvent(t *testing.T) {
+	change := PriceChange{
+		Product:  Product{ID: 1, Price: money.New(9000, "USD")},
+		OldPrice: money.New(10000, "USD"),
+		Watchers: []User{{ID: 2, Name: "scstore"}, {ID: 5, Name: "user00005"}},
+	}
+	event, err := newProductPriceDroppedEvent(change, time.Date(2022, 5, 1, 9, 0, 0, 0, time.UTC))
+	assert.Nil(t, err)
+	assert.Equal(t, WebhookEventProductPriceDropped, event.Type)
+
+	var payload map[string]interface{}
+	assert.Nil(t, json.Unmarshal(event.Payload, &payload))
+	assert.Equal(t, map[string]interface{}{
+		"product_id":        float64(1),
+		"currency":          "USD",
+		"old_price":         float64(10000),
+		"new_price":         float64(9000),
+		"wishlist_user_ids": []interface{}{float64(2), float64(5)},
+	}, payload["data"])
+
+	// A drop of a product on no wishlist has an empty list rather than null.
+	change.Watchers = nil
+	event, err = newProductPriceDroppedEvent(change, time.Now())
+	assert.Nil(t, err)
+	assert.Contains(t, string(event.Payload), `"wishlist_user_ids":[]`)
+}
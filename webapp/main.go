@@ -1,44 +1,171 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
 
+	"cloud.google.com/go/spanner"
 	_ "github.com/lib/pq"
 
-	"github.com/mittz/role-play-webapp/webapp/app"
 	"github.com/mittz/role-play-webapp/webapp/database"
 	"github.com/mittz/role-play-webapp/webapp/utils"
 )
 
 func main() {
-	dbInfo := fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=disable",
-		utils.GetEnvDBHostname(),
-		utils.GetEnvDBPort(),
-		utils.GetEnvDBUsername(),
-		utils.GetEnvDBName(),
-		utils.GetEnvDBPassword(),
-	)
-
-	db, err := sql.Open("postgres", dbInfo)
-	defer db.Close()
+	logger, err := utils.NewLogger(os.Stdout, utils.GetEnvLogLevel(), utils.GetEnvLogFormat())
 	if err != nil {
 		log.Fatal(err)
 	}
+	// The standard log package writes through this logger as well.
+	slog.SetDefault(logger)
 
-	dbHandler, err := database.NewDatabaseHandler("production", db)
-	if err != nil {
+	if err := runCommand(os.Args[1:], os.Stdout); err != nil {
 		log.Fatal(err)
 	}
+}
+
+// openDatabaseHandler connects to the database of the environment and returns
+// its handler with a function to close the connection.
+func openDatabaseHandler(environment string) (database.DatabaseHandler, func(), error) {
+	switch environment {
+	case "spanner":
+		client, err := spanner.NewClient(context.Background(), utils.GetEnvSpannerDatabase())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dbHandler, err := database.NewDatabaseHandler(environment, client)
+		if err != nil {
+			client.Close()
+			return nil, nil, err
+		}
+
+		return dbHandler, client.Close, nil
+	case "sqlite":
+		db, err := database.OpenSQLiteDBConn(utils.GetEnvSQLitePath())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dbHandler, err := database.NewDatabaseHandler(environment, db)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+
+		return dbHandler, func() { db.Close() }, nil
+	case "production":
+		connConfig := newConnConfig()
+		db, err := database.OpenDBConn("postgres", connConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		database.MonitorDBStats(db, utils.GetEnvDBStatsInterval())
+
+		dbHandler, err := newDatabaseHandler(db, connConfig)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+
+		// The updates of the orders are notified on the primary, so every
+		// instance listens there whichever database it reads from.
+		stopListening, err := database.ListenCheckoutUpdates(connConfig)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+
+		return dbHandler, func() {
+			stopListening()
+			db.Close()
+		}, nil
+	default:
+		return nil, nil, fmt.Errorf("DB_ENVIRONMENT: %s is not supported", environment)
+	}
+}
+
+// checkDatabaseConfig validates the configuration of the database of the
+// environment without connecting to it.
+func checkDatabaseConfig(environment string) error {
+	switch environment {
+	case "spanner", "sqlite":
+		return nil
+	case "production":
+		connConfig := newConnConfig()
+		if _, err := connConfig.DSN(); err != nil {
+			return err
+		}
+
+		_, err := newReplicaConfigs(connConfig)
+		return err
+	default:
+		return fmt.Errorf("DB_ENVIRONMENT: %s is not supported", environment)
+	}
+}
+
+// newConnConfig returns the configuration of the primary database of the DB_
+// environment variables.
+func newConnConfig() database.ConnConfig {
+	return database.ConnConfig{
+		Hostname:        utils.GetEnvDBHostname(),
+		Port:            utils.GetEnvDBPort(),
+		Username:        utils.GetEnvDBUsername(),
+		Password:        utils.GetEnvDBPassword(),
+		Name:            utils.GetEnvDBName(),
+		SSLMode:         utils.GetEnvDBSSLMode(),
+		SSLRootCert:     utils.GetEnvDBSSLRootCert(),
+		SSLCert:         utils.GetEnvDBSSLCert(),
+		SSLKey:          utils.GetEnvDBSSLKey(),
+		MaxOpenConns:    utils.GetEnvDBMaxOpenConns(),
+		MaxIdleConns:    utils.GetEnvDBMaxIdleConns(),
+		ConnMaxLifetime: utils.GetEnvDBConnMaxLifetime(),
+		ConnectRetries:  utils.GetEnvDBConnectRetries(),
+		ConnectBackoff:  utils.GetEnvDBConnectBackoff(),
+	}
+}
+
+// newReplicaConfigs returns the configurations of DB_REPLICA_HOSTNAMES, which
+// are those of the primary with another host and port.
+func newReplicaConfigs(connConfig database.ConnConfig) ([]database.ConnConfig, error) {
+	var replicaConfigs []database.ConnConfig
+	for _, hostname := range utils.GetEnvDBReplicaHostnames() {
+		replicaConfig := connConfig
+		replicaConfig.Hostname = hostname
+		if host, port, err := net.SplitHostPort(hostname); err == nil {
+			replicaConfig.Hostname = host
+			if replicaConfig.Port, err = strconv.Atoi(port); err != nil {
+				return nil, fmt.Errorf("replica port of %s should be integer: %v", hostname, err)
+			}
+		}
+		replicaConfigs = append(replicaConfigs, replicaConfig)
+	}
+
+	return replicaConfigs, nil
+}
 
-	if err := dbHandler.InitDatabase(); err != nil {
-		log.Fatalf("Failed to init database: %v", err)
+// newDatabaseHandler routes reads to the replicas when DB_REPLICA_HOSTNAMES is set.
+func newDatabaseHandler(db *sql.DB, connConfig database.ConnConfig) (database.DatabaseHandler, error) {
+	replicaConfigs, err := newReplicaConfigs(connConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(replicaConfigs) == 0 {
+		return database.NewDatabaseHandler("production", db)
 	}
 
-	const assetsDir = "./app/assets"
-	const templatesDirMatch = "./app/templates/*"
+	dbHandler, err := database.OpenReplicaDatabaseHandler("postgres", db, replicaConfigs, utils.GetEnvDBReplicaReadYourWrites())
+	if err != nil {
+		return nil, err
+	}
+	dbHandler.StartHealthCheck(utils.GetEnvDBReplicaHealthCheckInterval())
 
-	router := app.SetupRouter(dbHandler, assetsDir, templatesDirMatch)
-	router.Run(":8080")
+	return dbHandler, nil
 }
@@ -1,10 +1,62 @@
 package main
 
 import (
+	"bytes"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
+
+func TestRunCommand(t *testing.T) {
+	var out bytes.Buffer
+	assert.Nil(t, runCommand([]string{"help"}, &out))
+	for _, c := range commands {
+		assert.Contains(t, out.String(), c.name)
+	}
+
+	out.Reset()
+	assert.ErrorContains(t, runCommand([]string{"drop"}, &out), `unknown command "drop"`)
+	assert.Contains(t, out.String(), "usage:")
+
+	out.Reset()
+	assert.Nil(t, runCommand([]string{"serve", "-h"}, &out), "help is not an error")
+	assert.Contains(t, out.String(), "-addr")
+
+	out.Reset()
+	assert.ErrorContains(t, runCommand([]string{"-port", "8080"}, &out), "flag provided but not defined: -port")
+
+	for _, args := range [][]string{{"backup"}, {"restore", "a.jsonl", "b.jsonl"}, {"migrate", "now"}} {
+		assert.ErrorContains(t, runCommand(args, &out), "arguments, but")
+	}
+
+	assert.ErrorContains(t, runCommand([]string{"init"}, &out), "run it with -force")
+}
+
+func TestPrintVersion(t *testing.T) {
+	var out bytes.Buffer
+	assert.Nil(t, runCommand([]string{"version"}, &out))
+	assert.Contains(t, out.String(), " dev "+runtime.Version())
+}
+
+func TestBackupAndRestoreDatabase(t *testing.T) {
+	dbHandler := database.NewDevDatabaseHandler(nil)
+	path := filepath.Join(t.TempDir(), "scstore.jsonl")
+
+	assert.Nil(t, backupDatabase(dbHandler, path))
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(entries), "the temporary file is renamed")
+
+	assert.Nil(t, restoreDatabase(dbHandler, path))
+
+	assert.Nil(t, os.WriteFile(path, []byte(`{"format":"scstore-backup","version":1}`+"\n"), 0600))
+	assert.ErrorContains(t, restoreDatabase(dbHandler, path), "can't be restored: the archive is truncated")
+}
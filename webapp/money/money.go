@@ -0,0 +1,172 @@
+// Package money represents amounts as integer minor units of a currency so
+// that prices, discounts and taxes add up exactly. Only rates and percentages
+// are floating point and their results are rounded to the minor unit.
+package money
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type currency struct {
+	Symbol   string
+	Exponent int
+}
+
+// currencies lists the currencies the shop can charge or display.
+var currencies = map[string]currency{
+	"USD": {Symbol: "$", Exponent: 2},
+	"EUR": {Symbol: "€", Exponent: 2},
+	"GBP": {Symbol: "£", Exponent: 2},
+	"CAD": {Symbol: "CA$", Exponent: 2},
+	"AUD": {Symbol: "A$", Exponent: 2},
+	"JPY": {Symbol: "¥", Exponent: 0},
+}
+
+// Money is an amount in the minor units of its currency, like cents for USD.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+func New(amount int64, currencyCode string) Money {
+	return Money{Amount: amount, Currency: currencyCode}
+}
+
+// IsSupported reports whether the currency is known to this package.
+func IsSupported(currencyCode string) bool {
+	_, ok := currencies[currencyCode]
+	return ok
+}
+
+func exponent(currencyCode string) int {
+	if c, ok := currencies[currencyCode]; ok {
+		return c.Exponent
+	}
+
+	return 2
+}
+
+func (m Money) Add(other Money) Money {
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}
+}
+
+func (m Money) Sub(other Money) Money {
+	return Money{Amount: m.Amount - other.Amount, Currency: m.Currency}
+}
+
+func (m Money) Mul(n int) Money {
+	return Money{Amount: m.Amount * int64(n), Currency: m.Currency}
+}
+
+// Percent returns rate percent of m rounded half away from zero to the minor unit.
+func (m Money) Percent(rate float64) Money {
+	return Money{Amount: int64(math.Round(float64(m.Amount) * rate / 100)), Currency: m.Currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Decimal returns the amount in major units without the symbol, like 3.50.
+func (m Money) Decimal() string {
+	exp := exponent(m.Currency)
+	if exp == 0 {
+		return strconv.FormatInt(m.Amount, 10)
+	}
+
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	unit := int64(math.Pow10(exp))
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, exp, amount%unit)
+}
+
+// String formats the amount with the symbol of its currency, like $3.50.
+func (m Money) String() string {
+	decimal := m.Decimal()
+	sign := ""
+	if strings.HasPrefix(decimal, "-") {
+		sign = "-"
+		decimal = decimal[1:]
+	}
+
+	if c, ok := currencies[m.Currency]; ok {
+		return sign + c.Symbol + decimal
+	}
+
+	return sign + decimal + " " + m.Currency
+}
+
+// Parse reads an amount in major units like "3.50" into the minor units of the currency.
+func Parse(value string, currencyCode string) (Money, error) {
+	value = strings.TrimSpace(value)
+	exp := exponent(currencyCode)
+
+	whole, fraction, hasFraction := strings.Cut(value, ".")
+	if whole == "" || (hasFraction && (fraction == "" || len(fraction) > exp)) {
+		return Money{}, fmt.Errorf("%q is not an amount in %s", value, currencyCode)
+	}
+
+	amount, err := strconv.ParseInt(whole+fraction+strings.Repeat("0", exp-len(fraction)), 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%q is not an amount in %s", value, currencyCode)
+	}
+
+	return Money{Amount: amount, Currency: currencyCode}, nil
+}
+
+// Rates converts amounts between currencies for display. Each rate is the
+// value of one major unit of the base currency in that currency.
+type Rates struct {
+	Base  string
+	Rates map[string]float64
+}
+
+func (r Rates) rate(currencyCode string) (float64, bool) {
+	if currencyCode == r.Base {
+		return 1, true
+	}
+
+	rate, ok := r.Rates[currencyCode]
+	return rate, ok && rate > 0
+}
+
+// Convert returns m in the currency. It reports false when a rate is missing.
+func (r Rates) Convert(m Money, currencyCode string) (Money, bool) {
+	if m.Currency == currencyCode {
+		return m, true
+	}
+
+	from, ok := r.rate(m.Currency)
+	if !ok {
+		return Money{}, false
+	}
+	to, ok := r.rate(currencyCode)
+	if !ok {
+		return Money{}, false
+	}
+
+	major := float64(m.Amount) / math.Pow10(exponent(m.Currency)) / from * to
+	return Money{Amount: int64(math.Round(major * math.Pow10(exponent(currencyCode)))), Currency: currencyCode}, true
+}
+
+// Currencies returns the base currency and the currencies with a rate.
+func (r Rates) Currencies() []string {
+	var others []string
+	for code := range r.Rates {
+		if code != r.Base {
+			others = append(others, code)
+		}
+	}
+	sort.Strings(others)
+
+	return append([]string{r.Base}, others...)
+}
@@ -0,0 +1,79 @@
+package money
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestString(t *testing.T) {
+	assert.Equal(t, "$3.50", New(350, "USD").String())
+	assert.Equal(t, "$0.05", New(5, "USD").String())
+	assert.Equal(t, "-$12.00", New(-1200, "USD").String())
+	assert.Equal(t, "€1234.56", New(123456, "EUR").String())
+	assert.Equal(t, "¥350", New(350, "JPY").String())
+	assert.Equal(t, "1.00 XYZ", New(100, "XYZ").String())
+}
+
+func TestArithmetic(t *testing.T) {
+	price := New(350, "USD")
+
+	assert.Equal(t, New(1050, "USD"), price.Mul(3))
+	assert.Equal(t, New(1400, "USD"), price.Mul(3).Add(New(350, "USD")))
+	assert.Equal(t, New(700, "USD"), price.Mul(3).Sub(New(350, "USD")))
+	assert.Equal(t, New(93, "USD"), New(1050, "USD").Percent(8.875))
+	assert.Equal(t, New(105, "USD"), New(1050, "USD").Percent(10))
+	assert.True(t, New(0, "USD").IsZero())
+}
+
+func TestParse(t *testing.T) {
+	m, err := Parse("3.50", "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, New(350, "USD"), m)
+
+	m, err = Parse("3.5", "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, New(350, "USD"), m)
+
+	m, err = Parse("12", "USD")
+	assert.Nil(t, err)
+	assert.Equal(t, New(1200, "USD"), m)
+
+	m, err = Parse("350", "JPY")
+	assert.Nil(t, err)
+	assert.Equal(t, New(350, "JPY"), m)
+
+	for _, value := range []string{"", "abc", "3.505", "3.", ".5", "3.5", "1,000"} {
+		if value == "3.5" {
+			_, err = Parse(value, "JPY")
+		} else {
+			_, err = Parse(value, "USD")
+		}
+		assert.NotNil(t, err, value)
+	}
+}
+
+func TestRatesConvert(t *testing.T) {
+	rates := Rates{Base: "USD", Rates: map[string]float64{"EUR": 0.9, "JPY": 150}}
+
+	m, ok := rates.Convert(New(1000, "USD"), "EUR")
+	assert.True(t, ok)
+	assert.Equal(t, New(900, "EUR"), m)
+
+	m, ok = rates.Convert(New(1000, "USD"), "JPY")
+	assert.True(t, ok)
+	assert.Equal(t, New(1500, "JPY"), m)
+
+	m, ok = rates.Convert(New(900, "EUR"), "JPY")
+	assert.True(t, ok)
+	assert.Equal(t, New(1500, "JPY"), m)
+
+	m, ok = rates.Convert(New(1000, "USD"), "USD")
+	assert.True(t, ok)
+	assert.Equal(t, New(1000, "USD"), m)
+
+	_, ok = rates.Convert(New(1000, "USD"), "GBP")
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"USD", "EUR", "JPY"}, rates.Currencies())
+}
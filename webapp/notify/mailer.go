@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogMailer writes a line per message to the log instead of sending it.
+type LogMailer struct {
+	logger *slog.Logger
+}
+
+func NewLogMailer(logger *slog.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.logger.Info("email", "to", msg.To, "subject", msg.Subject)
+	return nil
+}
+
+// FileMailer appends the messages to a file in the mbox format, so that they
+// can be read with a mail client during development.
+type FileMailer struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+func NewFileMailer(path string, from string) *FileMailer {
+	return &FileMailer{path: path, from: from}
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := writeMbox(file, m.from, msg, time.Now()); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func writeMbox(w io.Writer, from string, msg Message, date time.Time) error {
+	if _, err := fmt.Fprintf(w, "From %s %s\n", envelopeAddress(from), date.UTC().Format(time.ANSIC)); err != nil {
+		return err
+	}
+	// Lines starting with "From " would start a new message in the file.
+	message := bytes.ReplaceAll(formatMessage(from, msg, date), []byte("\nFrom "), []byte("\n>From "))
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
@@ -0,0 +1,37 @@
+package notify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.mbox")
+	m := NewFileMailer(path, "The Watch Shop <noreply@example.com>")
+
+	assert.Nil(t, m.Send(context.Background(), Message{To: "customer@example.com", Subject: "First", Body: "Hi,\nFrom now on\n"}))
+	assert.Nil(t, m.Send(context.Background(), Message{To: "customer@example.com", Subject: "Second", Body: "Bye"}))
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "From noreply@example.com "))
+	assert.Contains(t, string(data), "Subject: First\n")
+	assert.Contains(t, string(data), "\n>From now on\n")
+}
+
+func TestFormatMessage(t *testing.T) {
+	date := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg := string(formatMessage("shop@example.com", Message{To: "customer@example.com\r\nBcc: other@example.com", Subject: "Größe", Body: "Hi\r\n"}, date))
+
+	assert.Contains(t, msg, "From: shop@example.com\n")
+	assert.Contains(t, msg, "To: customer@example.com Bcc: other@example.com\n")
+	assert.Contains(t, msg, "Subject: =?utf-8?q?Gr=C3=B6=C3=9Fe?=\n")
+	assert.Contains(t, msg, "Date: Tue, 02 Jan 2024 03:04:05 +0000\n")
+	assert.True(t, strings.HasSuffix(msg, "\n\nHi\n"))
+}
@@ -0,0 +1,159 @@
+// Package notify delivers emails to the customers.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Notifier.Notify when the queue has no room.
+var ErrQueueFull = errors.New("notification queue is full")
+
+// Message is an email. Body is plain text.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers a message. It is called by one goroutine per worker of
+// the Notifier, so it has to be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Options of a Notifier.
+type Options struct {
+	// Workers is the number of messages sent at the same time.
+	Workers int
+	// QueueSize is the number of messages waiting to be sent over which new
+	// messages are dropped.
+	QueueSize int
+	// MaxAttempts is the number of tries of a message before it is dropped.
+	MaxAttempts int
+	// Backoff is the wait after the first failed try. It doubles after each
+	// following failure.
+	Backoff time.Duration
+	// Timeout limits every try.
+	Timeout time.Duration
+}
+
+// Notifier sends messages in the background so that a slow or unavailable
+// mail server never delays the request which triggered them. Failed messages
+// are retried with an exponential backoff.
+type Notifier struct {
+	mailer  Mailer
+	options Options
+	logger  *slog.Logger
+	queue   chan Message
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewNotifier starts the workers which send the messages with mailer.
+func NewNotifier(mailer Mailer, options Options, logger *slog.Logger) *Notifier {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	if options.MaxAttempts < 1 {
+		options.MaxAttempts = 1
+	}
+
+	n := &Notifier{
+		mailer:  mailer,
+		options: options,
+		logger:  logger,
+		queue:   make(chan Message, options.QueueSize),
+		done:    make(chan struct{}),
+	}
+
+	n.wg.Add(options.Workers)
+	for i := 0; i < options.Workers; i++ {
+		go n.work()
+	}
+
+	return n
+}
+
+// Notify queues the message without waiting for it to be sent.
+func (n *Notifier) Notify(msg Message) error {
+	select {
+	case n.queue <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops taking new messages and waits for the queued ones to be sent
+// or to run out of attempts.
+func (n *Notifier) Close() {
+	close(n.queue)
+	n.wg.Wait()
+}
+
+// Stop stops the workers without waiting for the queued messages. A
+// message waiting for its next try is dropped.
+func (n *Notifier) Stop() {
+	close(n.done)
+	n.wg.Wait()
+}
+
+func (n *Notifier) work() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case msg, ok := <-n.queue:
+			if !ok {
+				return
+			}
+			n.deliver(msg)
+		case <-n.done:
+			return
+		}
+	}
+}
+
+// deliver tries to send the message up to MaxAttempts times.
+func (n *Notifier) deliver(msg Message) {
+	backoff := n.options.Backoff
+	for attempt := 1; ; attempt++ {
+		err := n.send(msg)
+		if err == nil {
+			return
+		}
+
+		if attempt >= n.options.MaxAttempts {
+			n.logger.Error("notification dropped", "to", msg.To, "subject", msg.Subject, "attempts", attempt, "error", err.Error())
+			return
+		}
+		n.logger.Warn("notification failed, retrying", "to", msg.To, "subject", msg.Subject, "attempt", attempt, "backoff", backoff.String(), "error", err.Error())
+
+		select {
+		case <-time.After(backoff):
+		case <-n.done:
+			return
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) send(msg Message) error {
+	ctx := context.Background()
+	if n.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.options.Timeout)
+		defer cancel()
+	}
+
+	if err := n.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("send to %s: %w", msg.To, err)
+	}
+
+	return nil
+}
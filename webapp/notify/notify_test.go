@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// flakyMailer fails the first failures tries.
+type flakyMailer struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	sent     []Message
+}
+
+func (m *flakyMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls++
+	if m.calls <= m.failures {
+		return errors.New("connection refused")
+	}
+	m.sent = append(m.sent, msg)
+
+	return nil
+}
+
+func TestNotifierRetries(t *testing.T) {
+	mailer := &flakyMailer{failures: 2}
+	n := NewNotifier(mailer, Options{QueueSize: 1, MaxAttempts: 3, Backoff: time.Millisecond}, testLogger)
+
+	assert.Nil(t, n.Notify(Message{To: "customer@example.com", Subject: "Hello"}))
+	n.Close()
+
+	assert.Equal(t, 3, mailer.calls)
+	assert.Equal(t, []Message{{To: "customer@example.com", Subject: "Hello"}}, mailer.sent)
+}
+
+func TestNotifierDropsAfterMaxAttempts(t *testing.T) {
+	mailer := &flakyMailer{failures: 10}
+	n := NewNotifier(mailer, Options{QueueSize: 1, MaxAttempts: 2, Backoff: time.Millisecond}, testLogger)
+
+	assert.Nil(t, n.Notify(Message{To: "customer@example.com"}))
+	n.Close()
+
+	assert.Equal(t, 2, mailer.calls)
+	assert.Empty(t, mailer.sent)
+}
+
+// blockingMailer waits until release is closed.
+type blockingMailer struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingMailer) Send(ctx context.Context, msg Message) error {
+	m.started <- struct{}{}
+	select {
+	case <-m.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestNotifierDoesNotBlock(t *testing.T) {
+	mailer := &blockingMailer{started: make(chan struct{}, 2), release: make(chan struct{})}
+	n := NewNotifier(mailer, Options{Workers: 1, QueueSize: 1}, testLogger)
+
+	// The worker is busy with the first message and the second one fills
+	// the queue, so the third one is dropped instead of waiting.
+	assert.Nil(t, n.Notify(Message{Subject: "1"}))
+	<-mailer.started
+	assert.Nil(t, n.Notify(Message{Subject: "2"}))
+	assert.ErrorIs(t, n.Notify(Message{Subject: "3"}), ErrQueueFull)
+
+	close(mailer.release)
+	n.Close()
+}
+
+func TestNotifierTimeout(t *testing.T) {
+	mailer := &blockingMailer{started: make(chan struct{}, 2), release: make(chan struct{})}
+	n := NewNotifier(mailer, Options{QueueSize: 1, MaxAttempts: 2, Timeout: time.Millisecond}, testLogger)
+
+	assert.Nil(t, n.Notify(Message{Subject: "1"}))
+	n.Close()
+
+	assert.Equal(t, 2, len(mailer.started))
+}
@@ -0,0 +1,90 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveSMTP answers one session of an SMTP client and returns the commands
+// and the data it received.
+func serveSMTP(listener net.Listener) <-chan []string {
+	received := make(chan []string, 1)
+	go func() {
+		var lines []string
+		defer func() { received <- lines }()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 OK")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250 localhost")
+			case line == "DATA":
+				inData = true
+				reply("354 Go ahead")
+			case line == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	return received
+}
+
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := serveSMTP(listener)
+
+	m := &SMTPMailer{Addr: listener.Addr().String(), From: "The Watch Shop <noreply@example.com>"}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = m.Send(ctx, Message{To: "Customer <customer@example.com>", Subject: "Your order", Body: "Thank you!\n"})
+	assert.Nil(t, err)
+
+	lines := <-received
+	assert.Contains(t, lines, "MAIL FROM:<noreply@example.com>")
+	assert.Contains(t, lines, "RCPT TO:<customer@example.com>")
+	assert.Contains(t, lines, "Subject: Your order")
+	assert.Contains(t, lines, "Thank you!")
+}
+
+func TestSMTPMailerErrors(t *testing.T) {
+	m := &SMTPMailer{Addr: "localhost", From: "noreply@example.com"}
+	assert.NotNil(t, m.Send(context.Background(), Message{To: "customer@example.com"}))
+
+	m = &SMTPMailer{Addr: "127.0.0.1:25", From: "noreply@example.com"}
+	assert.NotNil(t, m.Send(context.Background(), Message{To: "not an address"}))
+}
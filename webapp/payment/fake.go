@@ -0,0 +1,108 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+// The behaviours of FakeGateway.
+const (
+	FakeModeSucceed = "succeed"
+	FakeModeDecline = "decline"
+	FakeModeTimeout = "timeout"
+)
+
+// FakeGateway is an in-memory provider for local runs and tests. Depending on
+// its mode, every authorization succeeds, is declined or hangs until the
+// context is done.
+type FakeGateway struct {
+	mode   string
+	secret []byte
+
+	mu             sync.Mutex
+	authorizations map[string]*fakeAuthorization
+}
+
+type fakeAuthorization struct {
+	amount   money.Money
+	captured bool
+	refunded money.Money
+}
+
+func NewFakeGateway(mode string, webhookSecret []byte) (*FakeGateway, error) {
+	switch mode {
+	case FakeModeSucceed, FakeModeDecline, FakeModeTimeout:
+	default:
+		return nil, fmt.Errorf("fake payment mode should be %s, %s or %s, but %s", FakeModeSucceed, FakeModeDecline, FakeModeTimeout, mode)
+	}
+
+	return &FakeGateway{mode: mode, secret: webhookSecret, authorizations: make(map[string]*fakeAuthorization)}, nil
+}
+
+func (g *FakeGateway) Authorize(ctx context.Context, req AuthorizeRequest) (Authorization, error) {
+	switch g.mode {
+	case FakeModeDecline:
+		return Authorization{}, fmt.Errorf("%w: the card was declined", ErrDeclined)
+	case FakeModeTimeout:
+		<-ctx.Done()
+		return Authorization{}, fmt.Errorf("%w: %v", ErrTimeout, ctx.Err())
+	}
+
+	if req.Amount.Amount < 0 {
+		return Authorization{}, fmt.Errorf("%w: invalid amount %s", ErrDeclined, req.Amount)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	id := "fake_" + uuid.NewString()
+	g.authorizations[id] = &fakeAuthorization{amount: req.Amount, refunded: money.New(0, req.Amount.Currency)}
+
+	return Authorization{ID: id}, nil
+}
+
+func (g *FakeGateway) Capture(ctx context.Context, authorizationID string, amount money.Money) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	auth, ok := g.authorizations[authorizationID]
+	if !ok {
+		return fmt.Errorf("authorization %s is not found", authorizationID)
+	}
+	if amount.Currency != auth.amount.Currency || amount.Amount > auth.amount.Amount {
+		return fmt.Errorf("%w: %s is more than authorized %s", ErrDeclined, amount, auth.amount)
+	}
+
+	auth.captured = true
+	auth.amount = amount
+
+	return nil
+}
+
+func (g *FakeGateway) Refund(ctx context.Context, authorizationID string, amount money.Money) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	auth, ok := g.authorizations[authorizationID]
+	if !ok {
+		return fmt.Errorf("authorization %s is not found", authorizationID)
+	}
+	if !auth.captured {
+		return fmt.Errorf("authorization %s is not captured", authorizationID)
+	}
+	if amount.Currency != auth.amount.Currency || auth.refunded.Add(amount).Amount > auth.amount.Amount {
+		return fmt.Errorf("refund of %s is more than captured %s", amount, auth.amount)
+	}
+
+	auth.refunded = auth.refunded.Add(amount)
+
+	return nil
+}
+
+func (g *FakeGateway) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	return verifyWebhook(g.secret, payload, signature)
+}
@@ -0,0 +1,79 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFakeGateway(t *testing.T) {
+	_, err := NewFakeGateway("unknown", nil)
+	assert.NotNil(t, err)
+}
+
+func TestFakeGatewaySucceed(t *testing.T) {
+	g, err := NewFakeGateway(FakeModeSucceed, nil)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	auth, err := g.Authorize(ctx, AuthorizeRequest{Reference: "checkout-1", Amount: money.New(1000, "USD")})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, auth.ID)
+
+	// Only a captured payment can be refunded, up to the captured amount.
+	assert.NotNil(t, g.Refund(ctx, auth.ID, money.New(1000, "USD")))
+	assert.ErrorIs(t, g.Capture(ctx, auth.ID, money.New(1001, "USD")), ErrDeclined)
+	assert.Nil(t, g.Capture(ctx, auth.ID, money.New(1000, "USD")))
+	assert.Nil(t, g.Refund(ctx, auth.ID, money.New(600, "USD")))
+	assert.NotNil(t, g.Refund(ctx, auth.ID, money.New(600, "USD")))
+	assert.Nil(t, g.Refund(ctx, auth.ID, money.New(400, "USD")))
+
+	assert.NotNil(t, g.Capture(ctx, "unknown", money.New(1000, "USD")))
+}
+
+func TestFakeGatewayDecline(t *testing.T) {
+	g, err := NewFakeGateway(FakeModeDecline, nil)
+	assert.Nil(t, err)
+
+	_, err = g.Authorize(context.Background(), AuthorizeRequest{Reference: "checkout-1", Amount: money.New(1000, "USD")})
+	assert.ErrorIs(t, err, ErrDeclined)
+}
+
+func TestFakeGatewayTimeout(t *testing.T) {
+	g, err := NewFakeGateway(FakeModeTimeout, nil)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = g.Authorize(ctx, AuthorizeRequest{Reference: "checkout-1", Amount: money.New(1000, "USD")})
+	assert.ErrorIs(t, err, ErrTimeout)
+}
+
+func TestFakeGatewayVerifyWebhook(t *testing.T) {
+	secret := []byte("secret")
+	g, err := NewFakeGateway(FakeModeSucceed, secret)
+	assert.Nil(t, err)
+
+	payload, _ := json.Marshal(Event{Type: EventRefunded, AuthorizationID: "fake_1", Reference: "checkout-1"})
+
+	event, err := g.VerifyWebhook(payload, Sign(secret, payload))
+	assert.Nil(t, err)
+	assert.Equal(t, Event{Type: EventRefunded, AuthorizationID: "fake_1", Reference: "checkout-1"}, event)
+
+	_, err = g.VerifyWebhook(payload, Sign([]byte("other"), payload))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, err = g.VerifyWebhook([]byte("not json"), Sign(secret, []byte("not json")))
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidSignature)
+
+	// Webhooks are rejected without a secret.
+	g, _ = NewFakeGateway(FakeModeSucceed, nil)
+	_, err = g.VerifyWebhook(payload, Sign(nil, payload))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+}
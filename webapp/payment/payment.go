@@ -0,0 +1,82 @@
+// Package payment abstracts the payment provider which charges the orders.
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/mittz/role-play-webapp/webapp/money"
+)
+
+// Errors returned by every Gateway. Check them with errors.Is.
+var (
+	ErrDeclined         = errors.New("payment declined")
+	ErrTimeout          = errors.New("payment provider timed out")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// Gateway charges orders in two steps. Authorize reserves the amount on the
+// customer's card and Capture takes it, so that an order which fails to be
+// stored can be released without charging the customer.
+type Gateway interface {
+	Authorize(ctx context.Context, req AuthorizeRequest) (Authorization, error)
+	Capture(ctx context.Context, authorizationID string, amount money.Money) error
+	Refund(ctx context.Context, authorizationID string, amount money.Money) error
+	// VerifyWebhook checks the signature of a notification sent by the
+	// provider and returns its event.
+	VerifyWebhook(payload []byte, signature string) (Event, error)
+}
+
+// AuthorizeRequest is the payment of an order. Reference is the checkout ID
+// which is sent back in the webhook events.
+type AuthorizeRequest struct {
+	Reference string
+	Amount    money.Money
+}
+
+type Authorization struct {
+	ID string
+}
+
+// The types of webhook events.
+const (
+	EventCaptured = "payment.captured"
+	EventFailed   = "payment.failed"
+	EventRefunded = "payment.refunded"
+)
+
+// Event is a notification from the provider about a payment.
+type Event struct {
+	Type            string `json:"type"`
+	AuthorizationID string `json:"authorization_id"`
+	Reference       string `json:"reference"`
+	Message         string `json:"message,omitempty"`
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the payload which providers
+// send in the signature header of their webhooks.
+func Sign(secret []byte, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyWebhook checks an HMAC signed JSON event.
+func verifyWebhook(secret []byte, payload []byte, signature string) (Event, error) {
+	if len(secret) == 0 || !hmac.Equal([]byte(signature), []byte(Sign(secret, payload))) {
+		return Event{}, ErrInvalidSignature
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return Event{}, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+
+	return event, nil
+}
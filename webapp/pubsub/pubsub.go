@@ -0,0 +1,100 @@
+// Package pubsub fans out messages to the subscribers of a topic within the
+// process.
+package pubsub
+
+import "sync"
+
+// Broker delivers the messages published to a topic to its subscribers.
+// Publishing never blocks: a subscriber whose buffer is full is closed, so
+// that a slow client can't hold up the others. The subscriber then has to
+// subscribe again and catch up on its own.
+type Broker[T any] struct {
+	buffer int
+
+	mu     sync.Mutex
+	topics map[string]map[*Subscription[T]]struct{}
+}
+
+// NewBroker returns a broker which buffers buffer messages per subscriber.
+func NewBroker[T any](buffer int) *Broker[T] {
+	if buffer < 1 {
+		buffer = 1
+	}
+
+	return &Broker[T]{buffer: buffer, topics: make(map[string]map[*Subscription[T]]struct{})}
+}
+
+// Subscription gets the messages of a topic from C until it is closed.
+type Subscription[T any] struct {
+	// C is closed when the subscription is closed.
+	C <-chan T
+
+	c      chan T
+	broker *Broker[T]
+	topic  string
+}
+
+// Subscribe starts receiving the messages published to topic from now on.
+func (b *Broker[T]) Subscribe(topic string) *Subscription[T] {
+	c := make(chan T, b.buffer)
+	sub := &Subscription[T]{C: c, c: c, broker: b, topic: topic}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	subs, ok := b.topics[topic]
+	if !ok {
+		subs = make(map[*Subscription[T]]struct{})
+		b.topics[topic] = subs
+	}
+	subs[sub] = struct{}{}
+
+	return sub
+}
+
+// Publish sends the message to the current subscribers of topic.
+func (b *Broker[T]) Publish(topic string, message T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.topics[topic] {
+		select {
+		case sub.c <- message:
+		default:
+			b.remove(sub)
+		}
+	}
+}
+
+// Subscribers returns the number of subscribers of topic.
+func (b *Broker[T]) Subscribers(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.topics[topic])
+}
+
+// Close stops the subscription and closes C. It can be called more than once.
+func (sub *Subscription[T]) Close() {
+	sub.broker.mu.Lock()
+	defer sub.broker.mu.Unlock()
+
+	sub.broker.remove(sub)
+}
+
+// remove closes the subscription unless it is already closed. b.mu is held.
+func (b *Broker[T]) remove(sub *Subscription[T]) {
+	subs, ok := b.topics[sub.topic]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.topics, sub.topic)
+	}
+	close(sub.c)
+}
@@ -0,0 +1,50 @@
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerPublish(t *testing.T) {
+	b := NewBroker[string](2)
+	sub1 := b.Subscribe("checkout/1")
+	sub2 := b.Subscribe("checkout/1")
+	other := b.Subscribe("checkout/2")
+	defer other.Close()
+
+	b.Publish("checkout/1", "paid")
+	b.Publish("checkout/3", "paid")
+
+	assert.Equal(t, "paid", <-sub1.C)
+	assert.Equal(t, "paid", <-sub2.C)
+	assert.Empty(t, other.C)
+
+	sub1.Close()
+	sub1.Close()
+	_, ok := <-sub1.C
+	assert.False(t, ok)
+	assert.Equal(t, 1, b.Subscribers("checkout/1"))
+
+	sub2.Close()
+	assert.Equal(t, 0, b.Subscribers("checkout/1"))
+}
+
+func TestBrokerClosesSlowSubscriber(t *testing.T) {
+	b := NewBroker[int](1)
+	slow := b.Subscribe("user/2")
+	fast := b.Subscribe("user/2")
+	defer fast.Close()
+
+	b.Publish("user/2", 1)
+	assert.Equal(t, 1, <-fast.C)
+	// The buffer of slow is full, so it is dropped instead of blocking.
+	b.Publish("user/2", 2)
+	assert.Equal(t, 2, <-fast.C)
+
+	assert.Equal(t, 1, <-slow.C)
+	_, ok := <-slow.C
+	assert.False(t, ok)
+	assert.Equal(t, 1, b.Subscribers("user/2"))
+	slow.Close()
+}
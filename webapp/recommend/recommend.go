@@ -0,0 +1,148 @@
+// Package recommend computes the products bought together and the best
+// sellers from the paid checkouts.
+package recommend
+
+import (
+	"sort"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+)
+
+// Snapshot is the recommendations computed from the purchase history at one
+// point in time. It is never changed once built, so it can be read by any
+// number of requests at the same time.
+type Snapshot struct {
+	products map[int]database.Product
+	// bestSellers are the sold products, the most units first.
+	bestSellers []int
+	// bestRated are all the products, the best rated first. They fill the
+	// lists when too few products were sold.
+	bestRated []int
+	// alsoBought are the products bought by the buyers of each product, the
+	// most common buyers first.
+	alsoBought map[int][]int
+}
+
+// Build computes the recommendations of the catalog. Two products are bought
+// together when at least minBuyers users bought both of them, so that a
+// single order doesn't make a recommendation. Products which are no longer
+// in the catalog are left out.
+func Build(products []database.Product, stats []database.PurchaseStat, minBuyers int) *Snapshot {
+	if minBuyers < 1 {
+		minBuyers = 1
+	}
+
+	s := &Snapshot{products: make(map[int]database.Product), alsoBought: make(map[int][]int)}
+	for _, product := range products {
+		s.products[product.ID] = product
+		s.bestRated = append(s.bestRated, product.ID)
+	}
+	sort.SliceStable(s.bestRated, func(i, j int) bool {
+		a, b := s.products[s.bestRated[i]].Rating, s.products[s.bestRated[j]].Rating
+		if a.Average() != b.Average() {
+			return a.Average() > b.Average()
+		}
+		return a.Count > b.Count
+	})
+
+	units := make(map[int]int)
+	buyers := make(map[int]int)
+	bought := make(map[int][]int)
+	for _, stat := range stats {
+		if _, ok := s.products[stat.ProductID]; !ok || stat.Quantity <= 0 {
+			continue
+		}
+		units[stat.ProductID] += stat.Quantity
+		buyers[stat.ProductID]++
+		bought[stat.UserID] = append(bought[stat.UserID], stat.ProductID)
+	}
+
+	for productID := range units {
+		s.bestSellers = append(s.bestSellers, productID)
+	}
+	sort.Slice(s.bestSellers, func(i, j int) bool {
+		a, b := s.bestSellers[i], s.bestSellers[j]
+		if units[a] != units[b] {
+			return units[a] > units[b]
+		}
+		if buyers[a] != buyers[b] {
+			return buyers[a] > buyers[b]
+		}
+		return a < b
+	})
+
+	// together counts the users who bought both products of every pair.
+	together := make(map[[2]int]int)
+	for _, productIDs := range bought {
+		for _, a := range productIDs {
+			for _, b := range productIDs {
+				if a != b {
+					together[[2]int{a, b}]++
+				}
+			}
+		}
+	}
+
+	for pair, count := range together {
+		if count >= minBuyers {
+			s.alsoBought[pair[0]] = append(s.alsoBought[pair[0]], pair[1])
+		}
+	}
+	for productID, productIDs := range s.alsoBought {
+		sort.Slice(productIDs, func(i, j int) bool {
+			a, b := productIDs[i], productIDs[j]
+			if together[[2]int{productID, a}] != together[[2]int{productID, b}] {
+				return together[[2]int{productID, a}] > together[[2]int{productID, b}]
+			}
+			if units[a] != units[b] {
+				return units[a] > units[b]
+			}
+			return a < b
+		})
+	}
+
+	return s
+}
+
+// AlsoBought returns up to n products bought by the buyers of the product.
+// It is empty when the product isn't bought often enough with others.
+func (s *Snapshot) AlsoBought(productID int, n int) []database.Product {
+	return s.take(n, nil, s.alsoBought[productID])
+}
+
+// BestSellers returns up to n products, the most sold first, leaving out the
+// excluded ones.
+func (s *Snapshot) BestSellers(n int, exclude ...int) []database.Product {
+	return s.take(n, exclude, s.bestSellers)
+}
+
+// Popular returns up to n products like BestSellers, but fills the list
+// with the best rated products when too few products were sold.
+func (s *Snapshot) Popular(n int, exclude ...int) []database.Product {
+	return s.take(n, exclude, s.bestSellers, s.bestRated)
+}
+
+// take returns the first n products of the lists in order, each at most
+// once and none of the excluded ones.
+func (s *Snapshot) take(n int, exclude []int, lists ...[]int) []database.Product {
+	seen := make(map[int]bool)
+	for _, productID := range exclude {
+		seen[productID] = true
+	}
+
+	var products []database.Product
+	for _, list := range lists {
+		for _, productID := range list {
+			if len(products) >= n {
+				return products
+			}
+			if seen[productID] {
+				continue
+			}
+			seen[productID] = true
+			products = append(products, s.products[productID])
+		}
+	}
+
+	return products
+}
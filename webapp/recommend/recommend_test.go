@@ -0,0 +1,77 @@
+package recommend
+
+import (
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+var testProducts = []database.Product{
+	{ID: 1, Name: "product1", Rating: database.Rating{Count: 1, Total: 3}},
+	{ID: 2, Name: "product2"},
+	{ID: 3, Name: "product3", Rating: database.Rating{Count: 2, Total: 10}},
+	{ID: 4, Name: "product4"},
+}
+
+func productIDs(products []database.Product) []int {
+	var ids []int
+	for _, product := range products {
+		ids = append(ids, product.ID)
+	}
+	return ids
+}
+
+func TestSnapshotAlsoBought(t *testing.T) {
+	stats := []database.PurchaseStat{
+		{UserID: 1, ProductID: 1, Quantity: 1},
+		{UserID: 1, ProductID: 2, Quantity: 1},
+		{UserID: 1, ProductID: 4, Quantity: 1},
+		{UserID: 2, ProductID: 1, Quantity: 3},
+		{UserID: 2, ProductID: 2, Quantity: 1},
+		{UserID: 3, ProductID: 1, Quantity: 1},
+		{UserID: 3, ProductID: 4, Quantity: 1},
+		{UserID: 4, ProductID: 2, Quantity: 1},
+		{UserID: 4, ProductID: 3, Quantity: 1},
+		// Products no longer in the catalog are left out.
+		{UserID: 4, ProductID: 9, Quantity: 1},
+	}
+
+	s := Build(testProducts, stats, 1)
+	assert.Equal(t, []int{2, 4}, productIDs(s.AlsoBought(1, 5)))
+	assert.Equal(t, []int{2}, productIDs(s.AlsoBought(1, 1)))
+	assert.Equal(t, []int{1, 4, 3}, productIDs(s.AlsoBought(2, 5)))
+	assert.Empty(t, s.AlsoBought(9, 5))
+
+	// A pair bought by a single user is not enough with a minimum of 2.
+	s = Build(testProducts, stats, 2)
+	assert.Equal(t, []int{2, 4}, productIDs(s.AlsoBought(1, 5)))
+	assert.Equal(t, []int{1}, productIDs(s.AlsoBought(2, 5)))
+	assert.Empty(t, s.AlsoBought(3, 5))
+}
+
+func TestSnapshotBestSellers(t *testing.T) {
+	stats := []database.PurchaseStat{
+		{UserID: 1, ProductID: 2, Quantity: 1},
+		{UserID: 1, ProductID: 4, Quantity: 5},
+		{UserID: 2, ProductID: 2, Quantity: 1},
+	}
+
+	s := Build(testProducts, stats, 1)
+	assert.Equal(t, []int{4, 2}, productIDs(s.BestSellers(5)))
+	assert.Equal(t, []int{2}, productIDs(s.BestSellers(5, 4)))
+
+	// The best rated products fill the list.
+	assert.Equal(t, []int{4, 2, 3, 1}, productIDs(s.Popular(5)))
+	assert.Equal(t, []int{2, 3}, productIDs(s.Popular(2, 4)))
+}
+
+func TestSnapshotWithoutPurchases(t *testing.T) {
+	s := Build(testProducts, nil, 2)
+	assert.Empty(t, s.BestSellers(5))
+	assert.Empty(t, s.AlsoBought(1, 5))
+	assert.Equal(t, []int{3, 1, 2}, productIDs(s.Popular(3)))
+
+	s = Build(nil, nil, 2)
+	assert.Empty(t, s.Popular(5))
+}
@@ -0,0 +1,80 @@
+package recommend
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+)
+
+// Store is the part of the database the recommendations are computed from.
+type Store interface {
+	GetProducts() ([]database.Product, error)
+	GetPurchaseStats() ([]database.PurchaseStat, error)
+}
+
+// Options of a Refresher.
+type Options struct {
+	// Interval is the wait between two refreshes.
+	Interval time.Duration
+	// MinBuyers is the number of users who must have bought two products
+	// for them to be recommended together.
+	MinBuyers int
+}
+
+// Refresher recomputes the recommendations from the purchase history
+// periodically, so that the pages read them without going to the database.
+// Every instance of the app refreshes its own.
+type Refresher struct {
+	store    Store
+	options  Options
+	logger   *slog.Logger
+	snapshot atomic.Pointer[Snapshot]
+}
+
+func NewRefresher(store Store, options Options, logger *slog.Logger) *Refresher {
+	r := &Refresher{store: store, options: options, logger: logger}
+	r.snapshot.Store(Build(nil, nil, options.MinBuyers))
+
+	return r
+}
+
+// Snapshot returns the latest recommendations. They are empty until the
+// first refresh succeeds.
+func (r *Refresher) Snapshot() *Snapshot {
+	return r.snapshot.Load()
+}
+
+// Run refreshes the recommendations until ctx is done. A failed refresh
+// keeps the previous recommendations.
+func (r *Refresher) Run(ctx context.Context) {
+	for {
+		if err := r.RunOnce(); err != nil {
+			r.logger.Error("recommendations refresh failed", "error", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.options.Interval):
+		}
+	}
+}
+
+// RunOnce computes the recommendations from the current purchase history.
+func (r *Refresher) RunOnce() error {
+	products, err := r.store.GetProducts()
+	if err != nil {
+		return err
+	}
+
+	stats, err := r.store.GetPurchaseStats()
+	if err != nil {
+		return err
+	}
+
+	r.snapshot.Store(Build(products, stats, r.options.MinBuyers))
+	return nil
+}
@@ -0,0 +1,41 @@
+package recommend
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeStore returns its purchases or fails with err.
+type fakeStore struct {
+	stats []database.PurchaseStat
+	err   error
+}
+
+func (s *fakeStore) GetProducts() ([]database.Product, error) {
+	return testProducts, nil
+}
+
+func (s *fakeStore) GetPurchaseStats() ([]database.PurchaseStat, error) {
+	return s.stats, s.err
+}
+
+func TestRefresherRunOnce(t *testing.T) {
+	store := &fakeStore{stats: []database.PurchaseStat{{UserID: 1, ProductID: 2, Quantity: 1}}}
+	r := NewRefresher(store, Options{MinBuyers: 1}, testLogger)
+	assert.Empty(t, r.Snapshot().Popular(5))
+
+	assert.Nil(t, r.RunOnce())
+	assert.Equal(t, []int{2}, productIDs(r.Snapshot().BestSellers(5)))
+
+	// A failed refresh keeps the previous recommendations.
+	store.err = errors.New("connection refused")
+	assert.NotNil(t, r.RunOnce())
+	assert.Equal(t, []int{2}, productIDs(r.Snapshot().BestSellers(5)))
+}
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "info", "json")
+	assert.Nil(t, err)
+
+	logger.Debug("hidden")
+	logger.Info("shown", "request_id", "abc")
+
+	var line map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "shown", line["msg"])
+	assert.Equal(t, "abc", line["request_id"])
+
+	buf.Reset()
+	logger, err = NewLogger(&buf, "debug", "text")
+	assert.Nil(t, err)
+	logger.Debug("shown")
+	assert.Contains(t, buf.String(), "level=DEBUG msg=shown")
+
+	_, err = NewLogger(&buf, "verbose", "json")
+	assert.NotNil(t, err)
+
+	_, err = NewLogger(&buf, "info", "xml")
+	assert.NotNil(t, err)
+}
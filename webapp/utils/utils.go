@@ -4,8 +4,24 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// GetEnvDBEnvironment returns the database backend: production (PostgreSQL), sqlite or spanner.
+func GetEnvDBEnvironment() string {
+	return getEnv("DB_ENVIRONMENT", "production")
+}
+
+// GetEnvSpannerDatabase returns the database like projects/PROJECT/instances/INSTANCE/databases/DATABASE.
+func GetEnvSpannerDatabase() string {
+	return getEnv("SPANNER_DATABASE", "projects/scstore/instances/scstore/databases/scstore")
+}
+
+func GetEnvSQLitePath() string {
+	return getEnv("SQLITE_PATH", "scstore.db")
+}
+
 func GetEnvDBHostname() string {
 	return getEnv("DB_HOSTNAME", "scstore-database")
 }
@@ -32,6 +48,390 @@ func GetEnvDBName() string {
 	return getEnv("DB_NAME", "scstore")
 }
 
+func GetEnvDBSSLMode() string {
+	return getEnv("DB_SSLMODE", "disable")
+}
+
+func GetEnvDBSSLRootCert() string {
+	return getEnv("DB_SSLROOTCERT", "")
+}
+
+func GetEnvDBSSLCert() string {
+	return getEnv("DB_SSLCERT", "")
+}
+
+func GetEnvDBSSLKey() string {
+	return getEnv("DB_SSLKEY", "")
+}
+
+func GetEnvDBMaxOpenConns() int {
+	return getEnvInt("DB_MAX_OPEN_CONNS", 25)
+}
+
+func GetEnvDBMaxIdleConns() int {
+	return getEnvInt("DB_MAX_IDLE_CONNS", 25)
+}
+
+func GetEnvDBConnMaxLifetime() time.Duration {
+	return getEnvDuration("DB_CONN_MAX_LIFETIME", 5*time.Minute)
+}
+
+func GetEnvDBConnectRetries() int {
+	return getEnvInt("DB_CONNECT_RETRIES", 10)
+}
+
+func GetEnvDBConnectBackoff() time.Duration {
+	return getEnvDuration("DB_CONNECT_BACKOFF", 1*time.Second)
+}
+
+func GetEnvDBStatsInterval() time.Duration {
+	return getEnvDuration("DB_STATS_INTERVAL", 10*time.Second)
+}
+
+// GetEnvDBReplicaHostnames returns the replica hostnames given as a comma
+// separated list like "replica1,replica2:5433".
+func GetEnvDBReplicaHostnames() []string {
+	var hostnames []string
+	for _, hostname := range strings.Split(getEnv("DB_REPLICA_HOSTNAMES", ""), ",") {
+		if hostname = strings.TrimSpace(hostname); hostname != "" {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+
+	return hostnames
+}
+
+func GetEnvDBReplicaReadYourWrites() time.Duration {
+	return getEnvDuration("DB_REPLICA_READ_YOUR_WRITES", 5*time.Second)
+}
+
+func GetEnvDBReplicaHealthCheckInterval() time.Duration {
+	return getEnvDuration("DB_REPLICA_HEALTH_CHECK_INTERVAL", 5*time.Second)
+}
+
+// GetEnvCheckoutMaxQuantity returns the maximum quantity of a product per checkout.
+func GetEnvCheckoutMaxQuantity() int {
+	return getEnvInt("CHECKOUT_MAX_QUANTITY", 1000)
+}
+
+func GetEnvLogLevel() string {
+	return getEnv("LOG_LEVEL", "info")
+}
+
+// GetEnvLogFormat returns json or text.
+func GetEnvLogFormat() string {
+	return getEnv("LOG_FORMAT", "json")
+}
+
+// GetEnvDBSlowQueryThreshold returns the duration over which database calls are logged as warnings.
+func GetEnvDBSlowQueryThreshold() time.Duration {
+	return getEnvDuration("DB_SLOW_QUERY_THRESHOLD", 200*time.Millisecond)
+}
+
+// GetEnvCSRFSecret returns the key to sign CSRF tokens. Set the same value on
+// every instance behind a load balancer.
+func GetEnvCSRFSecret() string {
+	return getEnv("CSRF_SECRET", "")
+}
+
+// GetEnvCSRFStrict returns whether CSRF tokens are required from non-browser clients too.
+func GetEnvCSRFStrict() bool {
+	return getEnvBool("CSRF_STRICT", false)
+}
+
+// GetEnvAdminUsername returns the username of the admin pages.
+func GetEnvAdminUsername() string {
+	return getEnv("ADMIN_USERNAME", "admin")
+}
+
+// GetEnvAdminPassword returns the password of the admin pages. The admin
+// pages are disabled when it is empty.
+func GetEnvAdminPassword() string {
+	return getEnv("ADMIN_PASSWORD", "")
+}
+
+func GetEnvContentSecurityPolicy() string {
+	return getEnv("SECURITY_CONTENT_SECURITY_POLICY", strings.Join([]string{
+		"default-src 'self'",
+		"style-src 'self' https://stackpath.bootstrapcdn.com https://fonts.googleapis.com",
+		"font-src 'self' https://fonts.gstatic.com",
+		"img-src 'self' data:",
+		"script-src 'self'",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+	}, "; "))
+}
+
+func GetEnvFrameOptions() string {
+	return getEnv("SECURITY_FRAME_OPTIONS", "DENY")
+}
+
+// GetEnvHSTSMaxAge returns max-age of Strict-Transport-Security in seconds. 0 disables it.
+func GetEnvHSTSMaxAge() int {
+	return getEnvInt("SECURITY_HSTS_MAX_AGE", 31536000)
+}
+
+// GetEnvTrustedProxies returns the addresses or CIDRs of the proxies whose
+// X-Forwarded-For is trusted, given as a comma separated list like
+// "130.211.0.0/22,35.191.0.0/16". Without them the client IP is the address
+// of the connection.
+func GetEnvTrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(getEnv("TRUSTED_PROXIES", ""), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+
+	return proxies
+}
+
+// GetEnvRateLimitKey returns what clients are told apart by. Only ip is
+// supported until the users sign in.
+func GetEnvRateLimitKey() string {
+	return getEnv("RATE_LIMIT_KEY", "ip")
+}
+
+// GetEnvRateLimitRPS returns the requests per second per client for pages
+// without their own limit. 0 disables it.
+func GetEnvRateLimitRPS() float64 {
+	return getEnvFloat("RATE_LIMIT_RPS", 0)
+}
+
+func GetEnvRateLimitBurst() int {
+	return getEnvInt("RATE_LIMIT_BURST", 20)
+}
+
+func GetEnvRateLimitCheckoutRPS() float64 {
+	return getEnvFloat("RATE_LIMIT_CHECKOUT_RPS", 0)
+}
+
+func GetEnvRateLimitCheckoutBurst() int {
+	return getEnvInt("RATE_LIMIT_CHECKOUT_BURST", 5)
+}
+
+func GetEnvRateLimitAdminRPS() float64 {
+	return getEnvFloat("RATE_LIMIT_ADMIN_RPS", 0)
+}
+
+func GetEnvRateLimitAdminBurst() int {
+	return getEnvInt("RATE_LIMIT_ADMIN_BURST", 1)
+}
+
+// GetEnvMaxInFlightRequests returns the number of concurrent requests over
+// which requests are answered with 503. 0 disables it.
+func GetEnvMaxInFlightRequests() int {
+	return getEnvInt("MAX_IN_FLIGHT_REQUESTS", 1000)
+}
+
+// GetEnvStoreCurrency returns the currency of the catalog and the orders.
+func GetEnvStoreCurrency() string {
+	return getEnv("STORE_CURRENCY", "USD")
+}
+
+// GetEnvCurrencyRates returns the rates of the display currencies per one
+// unit of the store currency, given like "EUR=0.92,JPY=150".
+func GetEnvCurrencyRates() map[string]float64 {
+	return getEnvFloatMap("CURRENCY_RATES", "")
+}
+
+// GetEnvTaxRates returns the tax rate in percent per region, given like
+// "US-CA=7.25,US-NY=8.875,DE=19".
+func GetEnvTaxRates() map[string]float64 {
+	return getEnvFloatMap("TAX_RATES", "")
+}
+
+// GetEnvDefaultTaxRegion returns the region preselected on the checkout form.
+// Orders are not taxed when it is empty and no region is chosen.
+func GetEnvDefaultTaxRegion() string {
+	return getEnv("DEFAULT_TAX_REGION", "")
+}
+
+// GetEnvShippingRates returns the shipping rate per country, given like
+// "US=flat:5.00:50.00,DE=weight:2.50,*=flat:15.00". Each rate is its kind,
+// the amount in the store currency and the optional order amount over which
+// shipping is free. The rate of * applies to the other countries.
+func GetEnvShippingRates() map[string]string {
+	return getEnvStringMap("SHIPPING_RATES", "")
+}
+
+// GetEnvPaymentProvider returns the payment provider which charges the orders.
+// Only the built-in fake provider is available.
+func GetEnvPaymentProvider() string {
+	return getEnv("PAYMENT_PROVIDER", "fake")
+}
+
+// GetEnvPaymentFakeMode returns whether the fake provider lets every payment
+// succeed, decline or time out.
+func GetEnvPaymentFakeMode() string {
+	return getEnv("PAYMENT_FAKE_MODE", "succeed")
+}
+
+// GetEnvPaymentTimeout returns how long a checkout waits for the payment provider.
+func GetEnvPaymentTimeout() time.Duration {
+	return getEnvDuration("PAYMENT_TIMEOUT", 10*time.Second)
+}
+
+// GetEnvPaymentWebhookSecret returns the key the provider signs its webhooks
+// with. Webhooks are rejected when it is empty.
+func GetEnvPaymentWebhookSecret() string {
+	return getEnv("PAYMENT_WEBHOOK_SECRET", "")
+}
+
+// GetEnvMailer returns where the emails to the customers go: log writes a
+// line per email, file appends them to MAIL_FILE_PATH and smtp sends them.
+func GetEnvMailer() string {
+	return getEnv("MAILER", "log")
+}
+
+func GetEnvMailFrom() string {
+	return getEnv("MAIL_FROM", "The Watch Shop <noreply@example.com>")
+}
+
+func GetEnvMailFilePath() string {
+	return getEnv("MAIL_FILE_PATH", "mail.mbox")
+}
+
+// GetEnvSMTPAddr returns the SMTP server like smtp.example.com:587.
+func GetEnvSMTPAddr() string {
+	return getEnv("SMTP_ADDR", "localhost:25")
+}
+
+func GetEnvSMTPUsername() string {
+	return getEnv("SMTP_USERNAME", "")
+}
+
+func GetEnvSMTPPassword() string {
+	return getEnv("SMTP_PASSWORD", "")
+}
+
+// GetEnvMailWorkers returns the number of emails sent at the same time.
+func GetEnvMailWorkers() int {
+	return getEnvInt("MAIL_WORKERS", 2)
+}
+
+// GetEnvMailQueueSize returns the number of emails waiting to be sent over
+// which new emails are dropped.
+func GetEnvMailQueueSize() int {
+	return getEnvInt("MAIL_QUEUE_SIZE", 1000)
+}
+
+func GetEnvMailMaxAttempts() int {
+	return getEnvInt("MAIL_MAX_ATTEMPTS", 5)
+}
+
+// GetEnvMailRetryBackoff returns the wait after the first failed try of an
+// email. It doubles after each following failure.
+func GetEnvMailRetryBackoff() time.Duration {
+	return getEnvDuration("MAIL_RETRY_BACKOFF", time.Second)
+}
+
+func GetEnvMailTimeout() time.Duration {
+	return getEnvDuration("MAIL_TIMEOUT", 10*time.Second)
+}
+
+// GetEnvWebhookPollInterval returns the wait between two rounds of the
+// webhook dispatcher when there was nothing to send.
+func GetEnvWebhookPollInterval() time.Duration {
+	return getEnvDuration("WEBHOOK_POLL_INTERVAL", time.Second)
+}
+
+func GetEnvWebhookTimeout() time.Duration {
+	return getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+}
+
+func GetEnvWebhookMaxAttempts() int {
+	return getEnvInt("WEBHOOK_MAX_ATTEMPTS", 10)
+}
+
+// GetEnvWebhookRetryBackoff returns the wait after the first failed try of a
+// delivery. It doubles after each following failure up to
+// WEBHOOK_MAX_BACKOFF.
+func GetEnvWebhookRetryBackoff() time.Duration {
+	return getEnvDuration("WEBHOOK_RETRY_BACKOFF", 10*time.Second)
+}
+
+func GetEnvWebhookMaxBackoff() time.Duration {
+	return getEnvDuration("WEBHOOK_MAX_BACKOFF", time.Hour)
+}
+
+// GetEnvWebhookBatchSize returns the number of events and deliveries the
+// dispatcher handles per round.
+func GetEnvWebhookBatchSize() int {
+	return getEnvInt("WEBHOOK_BATCH_SIZE", 20)
+}
+
+// GetEnvWebhookAllowedHosts returns the hosts which the webhooks may reach
+// even in the private network, given as a comma separated list like
+// "hooks.internal,10.0.0.5".
+func GetEnvWebhookAllowedHosts() []string {
+	var hosts []string
+	for _, host := range strings.Split(getEnv("WEBHOOK_ALLOWED_HOSTS", ""), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+
+	return hosts
+}
+
+// GetEnvCheckoutAsync returns whether the checkouts are accepted into the
+// checkout queue and processed by the workers instead of during the request.
+func GetEnvCheckoutAsync() bool {
+	return getEnvBool("CHECKOUT_ASYNC", false)
+}
+
+// GetEnvCheckoutQueueSize returns the number of waiting checkouts over which
+// new checkouts are rejected. 0 means no limit.
+func GetEnvCheckoutQueueSize() int {
+	return getEnvInt("CHECKOUT_QUEUE_SIZE", 1000)
+}
+
+// GetEnvCheckoutWorkers returns the number of checkouts processed at the
+// same time by each instance.
+func GetEnvCheckoutWorkers() int {
+	return getEnvInt("CHECKOUT_WORKERS", 4)
+}
+
+func GetEnvCheckoutPollInterval() time.Duration {
+	return getEnvDuration("CHECKOUT_POLL_INTERVAL", 200*time.Millisecond)
+}
+
+// GetEnvCheckoutJobTimeout returns the time limit of processing a checkout.
+// Another worker takes over a checkout which is not done after twice this
+// time.
+func GetEnvCheckoutJobTimeout() time.Duration {
+	return getEnvDuration("CHECKOUT_JOB_TIMEOUT", 30*time.Second)
+}
+
+func GetEnvCheckoutMaxAttempts() int {
+	return getEnvInt("CHECKOUT_MAX_ATTEMPTS", 3)
+}
+
+// GetEnvCheckoutEventsKeepAlive returns the interval of the comments sent on
+// idle order update streams so that proxies don't close them.
+func GetEnvCheckoutEventsKeepAlive() time.Duration {
+	return getEnvDuration("CHECKOUT_EVENTS_KEEPALIVE", 15*time.Second)
+}
+
+func GetEnvCheckoutEventsMaxStreams() int {
+	return getEnvInt("CHECKOUT_EVENTS_MAX_STREAMS", 1000)
+}
+
+// GetEnvRecommendationsRefreshInterval returns how often the recommendations
+// are computed again from the paid checkouts.
+func GetEnvRecommendationsRefreshInterval() time.Duration {
+	return getEnvDuration("RECOMMENDATIONS_REFRESH_INTERVAL", 5*time.Minute)
+}
+
+// GetEnvRecommendationsMinBuyers returns the number of users who must have
+// bought two products for them to be recommended together.
+func GetEnvRecommendationsMinBuyers() int {
+	return getEnvInt("RECOMMENDATIONS_MIN_BUYERS", 2)
+}
+
 func getEnv(key, defaultVal string) string {
 	if value, ok := os.LookupEnv(key); ok {
 		return value
@@ -39,3 +439,78 @@ func getEnv(key, defaultVal string) string {
 
 	return defaultVal
 }
+
+func getEnvInt(key string, defaultVal int) int {
+	val := getEnv(key, strconv.Itoa(defaultVal))
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		log.Fatalf("%s should be integer, but %s: %v", key, val, err)
+	}
+
+	return i
+}
+
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	val := getEnv(key, defaultVal.String())
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatalf("%s should be duration like 30s, but %s: %v", key, val, err)
+	}
+
+	return d
+}
+
+func getEnvBool(key string, defaultVal bool) bool {
+	val := getEnv(key, strconv.FormatBool(defaultVal))
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		log.Fatalf("%s should be true or false, but %s: %v", key, val, err)
+	}
+
+	return b
+}
+
+func getEnvFloat(key string, defaultVal float64) float64 {
+	val := getEnv(key, strconv.FormatFloat(defaultVal, 'f', -1, 64))
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		log.Fatalf("%s should be number, but %s: %v", key, val, err)
+	}
+
+	return f
+}
+
+func getEnvStringMap(key string, defaultVal string) map[string]string {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(getEnv(key, defaultVal), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		name, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			log.Fatalf("%s should be a list like A=x,B=y, but %s", key, pair)
+		}
+		m[strings.TrimSpace(name)] = strings.TrimSpace(val)
+	}
+
+	return m
+}
+
+func getEnvFloatMap(key string, defaultVal string) map[string]float64 {
+	m := make(map[string]float64)
+	for _, pair := range strings.Split(getEnv(key, defaultVal), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		name, val, ok := strings.Cut(pair, "=")
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if !ok || err != nil || f < 0 {
+			log.Fatalf("%s should be a list like A=1.5,B=2, but %s", key, pair)
+		}
+		m[strings.TrimSpace(name)] = f
+	}
+
+	return m
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -15,3 +16,55 @@ func TestGetEnv(t *testing.T) {
 	env = getEnv("TEST_DUMMY_ENV", "defaultVal")
 	assert.Equal(t, "newVal", env)
 }
+
+func TestGetEnvInt(t *testing.T) {
+	assert.Equal(t, 10, getEnvInt("TEST_DUMMY_INT_ENV", 10))
+
+	os.Setenv("TEST_DUMMY_INT_ENV", "20")
+	assert.Equal(t, 20, getEnvInt("TEST_DUMMY_INT_ENV", 10))
+}
+
+func TestGetEnvDuration(t *testing.T) {
+	assert.Equal(t, 3*time.Second, getEnvDuration("TEST_DUMMY_DURATION_ENV", 3*time.Second))
+
+	os.Setenv("TEST_DUMMY_DURATION_ENV", "1m")
+	assert.Equal(t, time.Minute, getEnvDuration("TEST_DUMMY_DURATION_ENV", 3*time.Second))
+}
+
+func TestGetEnvDBReplicaHostnames(t *testing.T) {
+	assert.Nil(t, GetEnvDBReplicaHostnames())
+
+	os.Setenv("DB_REPLICA_HOSTNAMES", "replica1, replica2:5433,")
+	defer os.Unsetenv("DB_REPLICA_HOSTNAMES")
+	assert.Equal(t, []string{"replica1", "replica2:5433"}, GetEnvDBReplicaHostnames())
+}
+
+func TestGetEnvBool(t *testing.T) {
+	assert.False(t, getEnvBool("TEST_DUMMY_BOOL_ENV", false))
+
+	os.Setenv("TEST_DUMMY_BOOL_ENV", "true")
+	assert.True(t, getEnvBool("TEST_DUMMY_BOOL_ENV", false))
+}
+
+func TestGetEnvFloat(t *testing.T) {
+	assert.Equal(t, 0.5, getEnvFloat("TEST_DUMMY_FLOAT_ENV", 0.5))
+
+	os.Setenv("TEST_DUMMY_FLOAT_ENV", "2.5")
+	assert.Equal(t, 2.5, getEnvFloat("TEST_DUMMY_FLOAT_ENV", 0.5))
+}
+
+func TestGetEnvFloatMap(t *testing.T) {
+	assert.Equal(t, map[string]float64{}, getEnvFloatMap("TEST_DUMMY_FLOAT_MAP_ENV", ""))
+
+	os.Setenv("TEST_DUMMY_FLOAT_MAP_ENV", "US-CA=7.25, DE=19,")
+	defer os.Unsetenv("TEST_DUMMY_FLOAT_MAP_ENV")
+	assert.Equal(t, map[string]float64{"US-CA": 7.25, "DE": 19}, getEnvFloatMap("TEST_DUMMY_FLOAT_MAP_ENV", ""))
+}
+
+func TestGetEnvStringMap(t *testing.T) {
+	assert.Equal(t, map[string]string{}, getEnvStringMap("TEST_DUMMY_STRING_MAP_ENV", ""))
+
+	os.Setenv("TEST_DUMMY_STRING_MAP_ENV", "US=flat:5.00:50.00, *=weight:2.50,")
+	defer os.Unsetenv("TEST_DUMMY_STRING_MAP_ENV")
+	assert.Equal(t, map[string]string{"US": "flat:5.00:50.00", "*": "weight:2.50"}, getEnvStringMap("TEST_DUMMY_STRING_MAP_ENV", ""))
+}
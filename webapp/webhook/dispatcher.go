@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+)
+
+// Store is the part of the database the dispatcher works with.
+type Store interface {
+	DispatchWebhookEvents(limit int) (int, error)
+	ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]database.WebhookDelivery, error)
+	RecordWebhookAttempt(deliveryID string, attempt database.WebhookAttempt) error
+}
+
+// Options of a Dispatcher.
+type Options struct {
+	// PollInterval is the wait between two rounds when there was nothing
+	// to send.
+	PollInterval time.Duration
+	// Timeout limits every request to a webhook.
+	Timeout time.Duration
+	// MaxAttempts is the number of tries of a delivery before it fails.
+	MaxAttempts int
+	// Backoff is the wait after the first failed try. It doubles after each
+	// following failure up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// BatchSize is the number of events and deliveries handled per round.
+	BatchSize int
+	// AllowedHosts may be reached even at addresses which are not public.
+	// The deliveries to the other hosts of the private network fail.
+	AllowedHosts []string
+}
+
+// Dispatcher turns the events of the outbox into deliveries and sends the
+// due deliveries. Several instances of the app can run a dispatcher each:
+// a claimed delivery is left to its dispatcher for twice the timeout, after
+// which another one takes it over.
+type Dispatcher struct {
+	store   Store
+	client  *http.Client
+	options Options
+	logger  *slog.Logger
+}
+
+func NewDispatcher(store Store, options Options, logger *slog.Logger) *Dispatcher {
+	if options.MaxAttempts < 1 {
+		options.MaxAttempts = 1
+	}
+	if options.BatchSize < 1 {
+		options.BatchSize = 1
+	}
+
+	// The proxy of the environment would connect to the hosts unchecked.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = NewGuard(options.AllowedHosts).DialContext
+
+	return &Dispatcher{
+		store:   store,
+		client:  &http.Client{Timeout: options.Timeout, Transport: transport},
+		options: options,
+		logger:  logger,
+	}
+}
+
+// Run sends the deliveries until ctx is done. Full batches are followed
+// right away by the next round.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		sent, err := d.RunOnce(ctx)
+		if err != nil {
+			d.logger.Error("webhook dispatch failed", "error", err.Error())
+		}
+
+		wait := d.options.PollInterval
+		if err == nil && sent >= d.options.BatchSize {
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RunOnce dispatches the new events, then sends the due deliveries at the
+// same time and records their attempts. It returns the number of deliveries
+// it sent.
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	if _, err := d.store.DispatchWebhookEvents(d.options.BatchSize); err != nil {
+		return 0, err
+	}
+
+	deliveries, err := d.store.ClaimWebhookDeliveries(time.Now(), d.lease(), d.options.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func(delivery database.WebhookDelivery) {
+			defer wg.Done()
+			d.deliver(ctx, delivery)
+		}(delivery)
+	}
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+// lease is how long a claimed delivery is left to this dispatcher.
+func (d *Dispatcher) lease() time.Duration {
+	if d.options.Timeout <= 0 {
+		return time.Minute
+	}
+
+	return 2 * d.options.Timeout
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, delivery database.WebhookDelivery) {
+	attempt := delivery.Attempts + 1
+	logger := d.logger.With("delivery_id", delivery.ID, "webhook_id", delivery.Webhook.ID, "event_id", delivery.Event.ID, "attempt", attempt)
+
+	statusCode, err := Send(ctx, d.client, delivery)
+	result := database.WebhookAttempt{Succeeded: err == nil, StatusCode: statusCode}
+	switch {
+	case err == nil:
+	case attempt >= d.options.MaxAttempts:
+		result.Error = err.Error()
+		logger.Error("webhook delivery failed", "error", err.Error())
+	default:
+		result.Error = err.Error()
+		result.NextAttemptAt = time.Now().Add(Backoff(attempt, d.options.Backoff, d.options.MaxBackoff))
+		logger.Warn("webhook delivery failed, retrying", "next_attempt_at", result.NextAttemptAt, "error", err.Error())
+	}
+
+	if err := d.store.RecordWebhookAttempt(delivery.ID, result); err != nil {
+		logger.Error("webhook attempt not recorded", "error", err.Error())
+	}
+}
@@ -0,0 +1,106 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// fakeStore hands out its deliveries once and keeps the attempts.
+type fakeStore struct {
+	mu         sync.Mutex
+	deliveries []database.WebhookDelivery
+	dispatched int
+	attempts   map[string]database.WebhookAttempt
+}
+
+func (s *fakeStore) DispatchWebhookEvents(limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dispatched++
+	return 0, nil
+}
+
+func (s *fakeStore) ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) ([]database.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := s.deliveries
+	s.deliveries = nil
+	return deliveries, nil
+}
+
+func (s *fakeStore) RecordWebhookAttempt(deliveryID string, attempt database.WebhookAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts[deliveryID] = attempt
+	return nil
+}
+
+func TestDispatcherRunOnce(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+
+	succeeded := testDelivery(ok.URL)
+	retried := testDelivery(unavailable.URL)
+	retried.ID = "delivery2"
+	failed := testDelivery(unavailable.URL)
+	failed.ID, failed.Attempts = "delivery3", 2
+
+	store := &fakeStore{deliveries: []database.WebhookDelivery{succeeded, retried, failed}, attempts: make(map[string]database.WebhookAttempt)}
+	d := NewDispatcher(store, Options{Timeout: time.Second, MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: time.Hour, BatchSize: 10, AllowedHosts: []string{"127.0.0.1"}}, testLogger)
+
+	start := time.Now()
+	sent, err := d.RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, sent)
+	assert.Equal(t, 1, store.dispatched)
+
+	assert.Equal(t, database.WebhookAttempt{Succeeded: true, StatusCode: http.StatusOK}, store.attempts[succeeded.ID])
+
+	attempt := store.attempts[retried.ID]
+	assert.False(t, attempt.Succeeded)
+	assert.Equal(t, http.StatusServiceUnavailable, attempt.StatusCode)
+	assert.NotEmpty(t, attempt.Error)
+	assert.WithinDuration(t, start.Add(time.Minute), attempt.NextAttemptAt, 5*time.Second)
+
+	// The last attempt is not retried.
+	attempt = store.attempts[failed.ID]
+	assert.False(t, attempt.Succeeded)
+	assert.True(t, attempt.NextAttemptAt.IsZero())
+}
+
+func TestDispatcherPrivateAddress(t *testing.T) {
+	var received bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	}))
+	defer server.Close()
+
+	// The test server listens on the loopback address, which is not allowed.
+	store := &fakeStore{deliveries: []database.WebhookDelivery{testDelivery(server.URL)}, attempts: make(map[string]database.WebhookAttempt)}
+	d := NewDispatcher(store, Options{Timeout: time.Second, MaxAttempts: 3, Backoff: time.Minute, MaxBackoff: time.Hour, BatchSize: 10}, testLogger)
+
+	_, err := d.RunOnce(context.Background())
+	assert.Nil(t, err)
+	assert.False(t, received)
+	attempt := store.attempts["delivery1"]
+	assert.False(t, attempt.Succeeded)
+	assert.Contains(t, attempt.Error, ErrForbiddenAddress.Error())
+}
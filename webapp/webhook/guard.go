@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for the webhooks whose host is an address
+// of the private network of the app, like the metadata server of the cloud.
+var ErrForbiddenAddress = errors.New("the address is not public")
+
+// sharedAddressSpace is used by carrier-grade NATs and inside some clouds.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Guard keeps the webhooks away from the private network of the app, which
+// an admin could otherwise probe through the delivery log. The loopback,
+// private, link-local and other non public addresses are refused unless the
+// host is allowed by name.
+type Guard struct {
+	allowedHosts map[string]bool
+	resolver     *net.Resolver
+	dialer       *net.Dialer
+}
+
+// NewGuard returns a guard which allows the hosts, given as names or
+// addresses, whatever their addresses are.
+func NewGuard(allowedHosts []string) *Guard {
+	allowed := make(map[string]bool)
+	for _, host := range allowedHosts {
+		allowed[strings.ToLower(host)] = true
+	}
+
+	return &Guard{
+		allowedHosts: allowed,
+		resolver:     net.DefaultResolver,
+		dialer:       &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+	}
+}
+
+// CheckURL fails with ErrForbiddenAddress when the host of the URL has an
+// address which is not public. It is checked again whenever a delivery
+// connects, as the addresses of a name may change.
+func (g *Guard) CheckURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	_, err = g.resolve(ctx, u.Hostname())
+	return err
+}
+
+// DialContext connects to one of the checked addresses of the host, so that
+// the name can't resolve to another address between the check and the
+// connection. It is meant for the Transport of the client of the webhooks.
+func (g *Guard) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := g.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if ips == nil {
+		return g.dialer.DialContext(ctx, network, addr)
+	}
+
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = g.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// resolve returns the addresses of the host, which are all public, or nil
+// for the allowed hosts.
+func (g *Guard) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	if g.allowedHosts[strings.ToLower(host)] {
+		return nil, nil
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := g.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%s has no address", host)
+	}
+	for _, ip := range ips {
+		if !isPublic(ip) {
+			return nil, fmt.Errorf("%s resolves to %s: %w", host, ip, ErrForbiddenAddress)
+		}
+	}
+
+	return ips, nil
+}
+
+// isPublic reports whether ip may be reached by the webhooks.
+func isPublic(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublic(t *testing.T) {
+	for _, tt := range []struct {
+		ip   string
+		want bool
+	}{
+		{"203.0.113.10", true},
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+	} {
+		assert.Equal(t, tt.want, isPublic(net.ParseIP(tt.ip)), tt.ip)
+	}
+}
+
+func TestGuardCheckURL(t *testing.T) {
+	guard := NewGuard([]string{"10.0.0.5", "Hooks.Internal"})
+	ctx := context.Background()
+
+	assert.Nil(t, guard.CheckURL(ctx, "https://203.0.113.10/hooks"))
+	assert.ErrorIs(t, guard.CheckURL(ctx, "http://127.0.0.1:8080/"), ErrForbiddenAddress)
+	assert.ErrorIs(t, guard.CheckURL(ctx, "http://[fe80::1]/"), ErrForbiddenAddress)
+	assert.ErrorIs(t, guard.CheckURL(ctx, "http://10.0.0.6/"), ErrForbiddenAddress)
+
+	// The allowed hosts are not resolved.
+	assert.Nil(t, guard.CheckURL(ctx, "http://10.0.0.5/"))
+	assert.Nil(t, guard.CheckURL(ctx, "http://hooks.internal/"))
+}
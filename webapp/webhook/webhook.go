@@ -0,0 +1,84 @@
+// Package webhook delivers the events of the outbox to the webhooks of the
+// admins.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+)
+
+// The headers of the requests to the webhooks. Receivers check the signature
+// with their secret and ignore the event IDs they have already handled.
+const (
+	SignatureHeaderName = "X-Webhook-Signature"
+	EventHeaderName     = "X-Webhook-Event"
+	EventIDHeaderName   = "X-Webhook-ID"
+)
+
+// responseBodyLimit is the part of the responses read before the connection
+// is reused.
+const responseBodyLimit = 4096
+
+// Sign returns the hex encoded HMAC-SHA256 of the payload which is sent in
+// the signature header.
+func Sign(secret []byte, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a payload. It is meant for the receivers.
+func Verify(secret []byte, payload []byte, signature string) bool {
+	return len(secret) > 0 && hmac.Equal([]byte(signature), []byte(Sign(secret, payload)))
+}
+
+// Send posts the event of the delivery to its webhook. It returns the status
+// code of the response, and an error unless the status is 2xx.
+func Send(ctx context.Context, client *http.Client, delivery database.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(delivery.Event.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "scstore-webhook")
+	req.Header.Set(SignatureHeaderName, Sign([]byte(delivery.Webhook.Secret), delivery.Event.Payload))
+	req.Header.Set(EventHeaderName, delivery.Event.Type)
+	req.Header.Set(EventIDHeaderName, delivery.Event.ID)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, responseBodyLimit))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Backoff returns the wait after the attempt of a delivery. It starts at
+// backoff and doubles after each attempt up to max.
+func Backoff(attempt int, backoff time.Duration, max time.Duration) time.Duration {
+	wait := backoff
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if max > 0 && wait > max {
+		wait = max
+	}
+
+	return wait
+}
@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mittz/role-play-webapp/webapp/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func testDelivery(url string) database.WebhookDelivery {
+	return database.WebhookDelivery{
+		ID:      "delivery1",
+		Webhook: database.Webhook{ID: "webhook1", URL: url, Secret: "0123456789abcdef"},
+		Event:   database.WebhookEvent{ID: "event1", Type: database.WebhookEventCheckoutCreated, Payload: []byte(`{"id":"event1"}`)},
+	}
+}
+
+func TestSend(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	statusCode, err := Send(context.Background(), server.Client(), testDelivery(server.URL))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, statusCode)
+	assert.Equal(t, `{"id":"event1"}`, string(body))
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, database.WebhookEventCheckoutCreated, header.Get(EventHeaderName))
+	assert.Equal(t, "event1", header.Get(EventIDHeaderName))
+	assert.True(t, Verify([]byte("0123456789abcdef"), body, header.Get(SignatureHeaderName)))
+	assert.False(t, Verify([]byte("another secret"), body, header.Get(SignatureHeaderName)))
+}
+
+func TestSendFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+
+	statusCode, err := Send(context.Background(), server.Client(), testDelivery(server.URL))
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+
+	server.Close()
+	statusCode, err = Send(context.Background(), server.Client(), testDelivery(server.URL))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, statusCode)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 10*time.Second, Backoff(1, 10*time.Second, time.Hour))
+	assert.Equal(t, 20*time.Second, Backoff(2, 10*time.Second, time.Hour))
+	assert.Equal(t, 80*time.Second, Backoff(4, 10*time.Second, time.Hour))
+	assert.Equal(t, time.Hour, Backoff(20, 10*time.Second, time.Hour))
+}